@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// writeAuditEntries opens a fresh audit log, records n entries and closes it again,
+// returning the lines written
+func writeAuditEntries(t *testing.T, n int) []string {
+	t.Helper()
+	path := dataPath(auditLogFile)
+	t.Cleanup(func() {
+		os.Remove(path)
+		os.Remove(dataPath(auditHeadFile))
+	})
+	os.Remove(path)
+	os.Remove(dataPath(auditHeadFile))
+
+	openAuditLog()
+	for i := 0; i < n; i++ {
+		if err := recordAudit(AuditEntry{Action: "test.entry", Target: strings.Repeat("x", i+1)}); err != nil {
+			t.Fatalf("recordAudit: %v", err)
+		}
+	}
+	auditMutex.Lock()
+	auditFile.Close()
+	auditFile = nil
+	auditMutex.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// rewriteAuditLog replaces the audit log with lines
+func rewriteAuditLog(t *testing.T, lines []string) {
+	t.Helper()
+	text := strings.Join(lines, "")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if err := os.WriteFile(dataPath(auditLogFile), []byte(text), 0600); err != nil {
+		t.Fatalf("rewrite audit log: %v", err)
+	}
+}
+
+// TestAuditChainDetectsTampering checks that an untouched log verifies and that an edited
+// or deleted line is reported where it happened
+func TestAuditChainDetectsTampering(t *testing.T) {
+	lines := writeAuditEntries(t, 5)
+	path := dataPath(auditLogFile)
+
+	if result, _, lastSeq := verifyAuditFile(path, -1); !result.Valid || result.Entries != 5 || lastSeq != 5 {
+		t.Fatalf("untouched log: %+v, last sequence %d; want 5 valid entries", result, lastSeq)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		lines   []string
+		message string
+	}{
+		{
+			name:    "edited",
+			lines:   append(append(append([]string{}, lines[:2]...), strings.Replace(lines[2], `"test.entry"`, `"test.edited"`, 1)), lines[3:]...),
+			message: "has been modified",
+		},
+		{
+			name:    "deleted",
+			lines:   append(append([]string{}, lines[:2]...), lines[3:]...),
+			message: "entries were removed or reordered",
+		},
+	} {
+		rewriteAuditLog(t, tc.lines)
+		result, _, _ := verifyAuditFile(path, -1)
+		if result.Valid || result.BrokenAt != 3 || !strings.Contains(result.Message, tc.message) {
+			t.Errorf("%s line: %+v; want broken at line 3 with %q", tc.name, result, tc.message)
+		}
+	}
+}
+
+// TestAuditHeadDetectsTruncation checks that a log cut short still chains on its own, and
+// that the anchor in audit.head shows the missing entries
+func TestAuditHeadDetectsTruncation(t *testing.T) {
+	lines := writeAuditEntries(t, 5)
+	path := dataPath(auditLogFile)
+
+	head, err := readAuditHead()
+	if err != nil || head == nil || head.Sequence != 5 {
+		t.Fatalf("audit.head = %+v (%v); want sequence 5", head, err)
+	}
+	if result, _, _ := verifyAuditFile(path, -1, *head); !result.Valid {
+		t.Fatalf("untouched log with anchor: %+v", result)
+	}
+
+	rewriteAuditLog(t, lines[:3])
+	if result, _, _ := verifyAuditFile(path, -1); !result.Valid {
+		t.Fatalf("truncated log without anchor: %+v; the chain alone cannot see truncation", result)
+	}
+	result, _, _ := verifyAuditFile(path, -1, *head)
+	if result.Valid || !strings.Contains(result.Message, "removed from the end") {
+		t.Errorf("truncated log with anchor: %+v; want truncation reported", result)
+	}
+
+	os.Remove(path)
+	if result, _, _ := verifyAuditFile(path, -1, *head); result.Valid || !strings.Contains(result.Message, "was deleted") {
+		t.Errorf("deleted log with anchor: %+v; want deletion reported", result)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// withUsers replaces the user store, sessions and login failures for one test
+func withUsers(t *testing.T, accounts map[string]string) {
+	t.Helper()
+	savedUsers, savedSessions, savedFailures := users, sessions, loginFailures
+	t.Cleanup(func() {
+		users, sessions, loginFailures = savedUsers, savedSessions, savedFailures
+		os.Remove(dataPath(usersFile))
+	})
+	users = make(map[string]*User)
+	sessions = make(map[string]*Session)
+	loginFailures = make(map[string][]time.Time)
+
+	for username, role := range accounts {
+		hash, err := bcrypt.GenerateFromPassword([]byte(username+"-password"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("hash password: %v", err)
+		}
+		users[username] = &User{Username: username, PasswordHash: string(hash), Role: role, Source: UserSourceLocal}
+	}
+}
+
+// login posts a sign-in and returns the response
+func login(username, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	body := `{"username": "` + username + `", "password": "` + password + `"}`
+	handleLogin(w, httptest.NewRequest("POST", "/api/auth/login", strings.NewReader(body)))
+	return w
+}
+
+// sessionRequest returns a request carrying the session cookie set by a sign-in
+func sessionRequest(t *testing.T, signIn *httptest.ResponseRecorder) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/apply/execute", nil)
+	for _, cookie := range signIn.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			r.AddCookie(cookie)
+			return r
+		}
+	}
+	t.Fatalf("sign-in set no session cookie")
+	return nil
+}
+
+// TestLoginChecksPassword checks that the right password signs in and a wrong password
+// or unknown username does not
+func TestLoginChecksPassword(t *testing.T) {
+	withUsers(t, map[string]string{"alice": RoleOperator})
+
+	if w := login("alice", "alice-password"); w.Code != http.StatusOK || sessionUser(sessionRequest(t, w)) == nil {
+		t.Errorf("correct password: HTTP %d, want 200 and a session", w.Code)
+	}
+	if w := login("Alice", "alice-password"); w.Code != http.StatusOK {
+		t.Errorf("username in another case: HTTP %d, want 200", w.Code)
+	}
+	if w := login("alice", "wrong-password"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("wrong password: HTTP %d with %d cookies, want 401 and none", w.Code, len(w.Result().Cookies()))
+	}
+	if w := login("mallory", "alice-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown username: HTTP %d, want 401", w.Code)
+	}
+
+	users["alice"].Disabled = true
+	if w := login("alice", "alice-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("disabled account: HTTP %d, want 401", w.Code)
+	}
+}
+
+// TestLoginLocksOutAfterRepeatedFailures checks that an account refuses even the right
+// password once it has too many recent failures, and accepts it again when they expire
+func TestLoginLocksOutAfterRepeatedFailures(t *testing.T) {
+	withUsers(t, map[string]string{"alice": RoleOperator})
+
+	for i := 0; i < maxLoginFailures; i++ {
+		if w := login("alice", "wrong-password"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: HTTP %d, want 401", i+1, w.Code)
+		}
+	}
+	if w := login("alice", "alice-password"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("after %d failures: HTTP %d, want 429", maxLoginFailures, w.Code)
+	}
+
+	old := time.Now().Add(-loginLockoutDuration)
+	for i := range loginFailures["alice"] {
+		loginFailures["alice"][i] = old
+	}
+	if w := login("alice", "alice-password"); w.Code != http.StatusOK {
+		t.Errorf("after the lockout window: HTTP %d, want 200", w.Code)
+	}
+	if _, ok := loginFailures["alice"]; ok {
+		t.Errorf("a successful sign-in kept the failure count")
+	}
+}
+
+// TestSessionExpires checks that a session is refused and forgotten once it expires
+func TestSessionExpires(t *testing.T) {
+	withUsers(t, map[string]string{"alice": RoleOperator})
+
+	r := sessionRequest(t, login("alice", "alice-password"))
+	if sessionUser(r) == nil {
+		t.Fatalf("new session not accepted")
+	}
+	cookie, _ := r.Cookie(sessionCookieName)
+	sessions[cookie.Value].ExpiresAt = time.Now().Add(-time.Second)
+
+	if user := sessionUser(r); user != nil {
+		t.Errorf("expired session accepted for %s", user.Username)
+	}
+	if _, ok := sessions[cookie.Value]; ok {
+		t.Errorf("expired session not removed")
+	}
+}
+
+// TestRequireRoleOnOperatorRoute checks each role against a route that needs an operator
+func TestRequireRoleOnOperatorRoute(t *testing.T) {
+	withUsers(t, map[string]string{"vera": RoleViewer, "olga": RoleOperator, "adam": RoleAdmin})
+
+	route := requireRole(RoleOperator, func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r) == nil {
+			t.Errorf("handler ran without the user attached")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	route(w, httptest.NewRequest("POST", "/api/apply/execute", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no session: HTTP %d, want 401", w.Code)
+	}
+
+	for _, tc := range []struct {
+		username string
+		want     int
+	}{
+		{"vera", http.StatusForbidden},
+		{"olga", http.StatusNoContent},
+		{"adam", http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		route(w, sessionRequest(t, login(tc.username, tc.username+"-password")))
+		if w.Code != tc.want {
+			t.Errorf("%s (%s): HTTP %d, want %d", tc.username, users[tc.username].Role, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBackupGateByAge checks that the gate accepts a verified backup inside the policy
+// window and refuses an older or unverified one, also after the history is reloaded as
+// it is at startup
+func TestBackupGateByAge(t *testing.T) {
+	savedGate := config.BackupGate
+	t.Cleanup(func() {
+		config.BackupGate = savedGate
+		backupHistory = nil
+		os.Remove(dataPath(backupsFile))
+	})
+	config.BackupGate = BackupGateConfig{Required: true, MaxAgeHours: 24}
+	p := &Profile{Name: "Primary"}
+
+	backupHistory = nil
+	if _, err := checkBackupGate(p, nil, "alice"); err == nil {
+		t.Fatalf("gate passed without any backup")
+	}
+
+	recordBackup(&BackupRecord{ID: "old", Profile: "Primary", Verified: true, EndTime: time.Now().Add(-25 * time.Hour)})
+	recordBackup(&BackupRecord{ID: "failed", Profile: "Primary", Verified: false, EndTime: time.Now()})
+	recordBackup(&BackupRecord{ID: "other", Profile: "Secondary", Verified: true, EndTime: time.Now()})
+	_, err := checkBackupGate(p, nil, "alice")
+	if err == nil || !strings.Contains(err.Error(), "more than 24 hours ago") {
+		t.Fatalf("backup 25 hours old: %v, want refused by age", err)
+	}
+
+	recordBackup(&BackupRecord{ID: "fresh", Profile: "Primary", Verified: true, EndTime: time.Now().Add(-23 * time.Hour)})
+
+	// A restart forgets the history in memory; backups.json brings it back
+	backupHistory = nil
+	loadBackupHistory()
+	if len(backupHistory) != 4 {
+		t.Fatalf("reloaded %d backup records, want 4", len(backupHistory))
+	}
+	evidence, err := checkBackupGate(p, nil, "alice")
+	if err != nil || evidence == nil || evidence.Backup == nil || evidence.Backup.ID != "fresh" {
+		t.Fatalf("backup 23 hours old after a restart: %+v, %v; want it accepted", evidence, err)
+	}
+
+	config.BackupGate.MaxAgeHours = 12
+	if _, err := checkBackupGate(p, nil, "alice"); err == nil {
+		t.Errorf("backup 23 hours old passed a 12 hour policy")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestCaseTextTruncatesOnRunes checks that long host names are cut to the Case API limits
+// by characters, never in the middle of one
+func TestCaseTextTruncatesOnRunes(t *testing.T) {
+	p := &Profile{Name: "Primary"}
+	host := HostAnalysis{HostID: 42, HostName: strings.Repeat("サーバー", 4000)}
+
+	name := caseName(host)
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) != maxCaseName {
+		t.Errorf("case name is %d characters, valid UTF-8 %v; want %d", utf8.RuneCountInString(name), utf8.ValidString(name), maxCaseName)
+	}
+	summary := caseSummary(p, host)
+	if !utf8.ValidString(summary) || utf8.RuneCountInString(summary) != maxCaseSummary {
+		t.Errorf("case summary is %d characters, valid UTF-8 %v; want %d", utf8.RuneCountInString(summary), utf8.ValidString(summary), maxCaseSummary)
+	}
+
+	host.HostName = "web01"
+	if name := caseName(host); name != "LRCleaner: web01 has stopped sending logs" {
+		t.Errorf("case name = %q", name)
+	}
+	if summary := caseSummary(p, host); !strings.HasPrefix(summary, "Host web01 (ID 42) in deployment Primary") {
+		t.Errorf("case summary = %q", summary)
+	}
+}
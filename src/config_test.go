@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/gorilla/mux"
+)
+
+// postConfig sends a settings form to handleConfig and returns the status code
+func postConfig(t *testing.T, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleConfig(w, httptest.NewRequest("POST", "/api/config", strings.NewReader(body)))
+	return w.Code
+}
+
+// TestConfigUpdateIsAllOrNothing checks that a request with one invalid section changes
+// neither the running configuration nor config.json, and that a valid one changes both
+func TestConfigUpdateIsAllOrNothing(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved; os.Remove(configPath) })
+	config = defaultConfig()
+	if err := saveConfigFile(); err != nil {
+		t.Fatalf("saveConfigFile: %v", err)
+	}
+	onDisk, _ := os.ReadFile(configPath)
+	maxNameLength := config.Retirement.MaxNameLength
+
+	code := postConfig(t, `{"hostname": "xm.example.com",
+		"retirement": {"nameTemplate": "{name} [RETIRED]", "maxNameLength": 90},
+		"email": {"enabled": true, "host": "", "port": 25, "security": "starttls", "from": "a@example.com"}}`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("invalid email settings: HTTP %d, want 400", code)
+	}
+	if config.Retirement.MaxNameLength != maxNameLength || config.Profiles[0].Hostname == "xm.example.com" {
+		t.Errorf("a rejected request changed the running configuration: %+v", config.Retirement)
+	}
+	if now, _ := os.ReadFile(configPath); string(now) != string(onDisk) {
+		t.Errorf("a rejected request rewrote config.json")
+	}
+
+	code = postConfig(t, `{"hostname": "xm.example.com", "retirement": {"nameTemplate": "{name} [RETIRED]", "maxNameLength": 90}}`)
+	if code != http.StatusOK {
+		t.Fatalf("valid settings: HTTP %d, want 200", code)
+	}
+	if config.Retirement.MaxNameLength != 90 || config.Profiles[0].Hostname != "xm.example.com" {
+		t.Errorf("running configuration not updated: %+v, %s", config.Retirement, config.Profiles[0].Hostname)
+	}
+	if now, _ := os.ReadFile(configPath); !strings.Contains(string(now), `"maxNameLength": 90`) {
+		t.Errorf("config.json not updated:\n%s", now)
+	}
+}
+
+// TestDatabaseSettingsRollBackOnCredentialStoreFailure checks that database settings are
+// saved with their password, and that a password the credential store refuses leaves
+// config.json and the running profile as they were
+func TestDatabaseSettingsRollBackOnCredentialStoreFailure(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved; os.Remove(configPath) })
+	config = defaultConfig()
+	name := config.Profiles[0].Name
+
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("PUT", "/api/profiles/"+name+"/database", strings.NewReader(body)), map[string]string{"name": name})
+		handleProfileDatabase(w, r)
+		return w.Code
+	}
+	const settings = `{"server": "%s", "authMode": "sql", "username": "lrcleaner", "password": "pw",
+		"backup": {"databases": ["LogRhythmEMDB"], "directory": "D:\\Backups"}}`
+
+	if code := put(fmt.Sprintf(settings, "sql1.example.com")); code != http.StatusOK {
+		t.Fatalf("save: HTTP %d, want 200", code)
+	}
+	key := config.Profiles[0].Database.KeyringKey
+	t.Cleanup(func() { DeleteDBPassword(key) })
+	if password, err := GetDBPassword(key); err != nil || password != "pw" || config.Profiles[0].Database.Server != "sql1.example.com" {
+		t.Fatalf("after save: server %q, password %q (%v)", config.Profiles[0].Database.Server, password, err)
+	}
+	onDisk, _ := os.ReadFile(configPath)
+
+	working := keyringConfig
+	keyringConfig.AllowedBackends = []keyring.BackendType{keyring.PassBackend} // Not available here
+	code := put(fmt.Sprintf(settings, "sql2.example.com"))
+	keyringConfig = working
+	if code != http.StatusInternalServerError {
+		t.Fatalf("credential store failure: HTTP %d, want 500", code)
+	}
+	if config.Profiles[0].Database.Server != "sql1.example.com" {
+		t.Errorf("running profile kept server %q after the password was refused", config.Profiles[0].Database.Server)
+	}
+	if now, _ := os.ReadFile(configPath); string(now) != string(onDisk) {
+		t.Errorf("config.json not restored after the password was refused")
+	}
+}
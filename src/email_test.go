@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/csv"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server on 127.0.0.1. It always advertises AUTH PLAIN,
+// offers STARTTLS when startTLS is set, and keeps every command and message it gets.
+type smtpStandIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	caBundle  string // Certificate the server presents, for EmailConfig.CABundle
+	startTLS  bool
+
+	mu       sync.Mutex
+	commands []smtpCommand
+	messages []smtpMessage
+}
+
+type smtpCommand struct {
+	Verb string
+	Arg  string
+	TLS  bool // Sent over the upgraded connection
+}
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+func newSMTPStandIn(t *testing.T, startTLS bool) *smtpStandIn {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "smtp.crt"), filepath.Join(dir, "smtp.key")
+	if err := generateSelfSignedCertificate(certFile, keyFile); err != nil {
+		t.Fatalf("generateSelfSignedCertificate: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpStandIn{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		caBundle:  certFile,
+		startTLS:  startTLS,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// settings returns email settings that send through the stand-in with STARTTLS
+func (s *smtpStandIn) settings(username string) EmailConfig {
+	return EmailConfig{
+		Enabled:  true,
+		Host:     "127.0.0.1",
+		Port:     s.listener.Addr().(*net.TCPAddr).Port,
+		Security: SMTPStartTLS,
+		Username: username,
+		From:     "LRCleaner <lrcleaner@example.com>",
+		CABundle: s.caBundle,
+	}
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	tp := textproto.NewConn(conn)
+	secure := false
+	var message smtpMessage
+
+	tp.PrintfLine("220 127.0.0.1 ESMTP stand-in")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		s.mu.Lock()
+		s.commands = append(s.commands, smtpCommand{Verb: verb, Arg: arg, TLS: secure})
+		s.mu.Unlock()
+
+		switch verb {
+		case "EHLO":
+			extensions := []string{"127.0.0.1"}
+			if s.startTLS && !secure {
+				extensions = append(extensions, "STARTTLS")
+			}
+			extensions = append(extensions, "AUTH PLAIN")
+			for i, extension := range extensions {
+				separator := "-"
+				if i == len(extensions)-1 {
+					separator = " "
+				}
+				tp.PrintfLine("250%s%s", separator, extension)
+			}
+		case "STARTTLS":
+			if !s.startTLS || secure {
+				tp.PrintfLine("502 5.5.1 STARTTLS not offered")
+				continue
+			}
+			tp.PrintfLine("220 2.0.0 Ready to start TLS")
+			upgraded := tls.Server(conn, s.tlsConfig)
+			if err := upgraded.Handshake(); err != nil {
+				return
+			}
+			conn, tp, secure = upgraded, textproto.NewConn(upgraded), true
+		case "AUTH":
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			message = smtpMessage{From: smtpPath(arg, "FROM:")}
+			tp.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			message.To = append(message.To, smtpPath(arg, "TO:"))
+			tp.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			if message.Data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			tp.PrintfLine("250 2.0.0 Queued")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// smtpPath returns the address in a MAIL FROM:<...> or RCPT TO:<...> argument
+func smtpPath(arg, prefix string) string {
+	arg = strings.TrimPrefix(arg, prefix)
+	return strings.TrimSuffix(strings.TrimPrefix(arg, "<"), ">")
+}
+
+// verbs lists the commands the server got, in order
+func (s *smtpStandIn) verbs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var verbs []string
+	for _, command := range s.commands {
+		verbs = append(verbs, command.Verb)
+	}
+	return verbs
+}
+
+func TestSendEmailRefusesServerWithoutSTARTTLS(t *testing.T) {
+	server := newSMTPStandIn(t, false)
+	message, err := buildEmail("lrcleaner@example.com", []string{"ops@example.com"}, "Test", "Hello", nil)
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+
+	err = sendEmail(server.settings("lrcleaner"), "pw", []string{"ops@example.com"}, message)
+	if err == nil || !strings.Contains(err.Error(), "does not offer STARTTLS") {
+		t.Fatalf("sendEmail = %v, want a STARTTLS error", err)
+	}
+	for _, verb := range server.verbs() {
+		if verb == "AUTH" || verb == "MAIL" || verb == "RCPT" || verb == "DATA" {
+			t.Errorf("server got %s without TLS; commands: %v", verb, server.verbs())
+		}
+	}
+	if len(server.messages) != 0 {
+		t.Errorf("server accepted %d messages", len(server.messages))
+	}
+}
+
+func TestSendEmailSignsInOnlyAfterSTARTTLS(t *testing.T) {
+	server := newSMTPStandIn(t, true)
+	to := []string{"Ops <ops@example.com>", "dba@example.com"}
+	message, err := buildEmail("lrcleaner@example.com", to, "Test", "Hello", nil)
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+
+	if err := sendEmail(server.settings("lrcleaner"), "pw", to, message); err != nil {
+		t.Fatalf("sendEmail: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	upgraded := false
+	authenticated := false
+	for _, command := range server.commands {
+		switch command.Verb {
+		case "STARTTLS":
+			upgraded = true
+		case "AUTH":
+			if !command.TLS || !upgraded {
+				t.Errorf("AUTH sent before STARTTLS")
+			}
+			credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command.Arg, "PLAIN "))
+			if err != nil || string(credentials) != "\x00lrcleaner\x00pw" {
+				t.Errorf("AUTH %q, want PLAIN for lrcleaner", command.Arg)
+			}
+			authenticated = true
+		case "MAIL", "RCPT", "DATA":
+			if !command.TLS {
+				t.Errorf("%s sent without TLS", command.Verb)
+			}
+		}
+	}
+	if !upgraded || !authenticated {
+		t.Errorf("STARTTLS %v, AUTH %v; want both", upgraded, authenticated)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("server got %d messages, want 1", len(server.messages))
+	}
+	got := server.messages[0]
+	if got.From != "lrcleaner@example.com" || !slices.Equal(got.To, []string{"ops@example.com", "dba@example.com"}) {
+		t.Errorf("envelope from %q to %v", got.From, got.To)
+	}
+}
+
+func TestSendEmailChecksServerCertificate(t *testing.T) {
+	server := newSMTPStandIn(t, true)
+	settings := server.settings("lrcleaner")
+	settings.CABundle = ""
+	message, _ := buildEmail("lrcleaner@example.com", []string{"ops@example.com"}, "Test", "Hello", nil)
+
+	if err := sendEmail(settings, "pw", []string{"ops@example.com"}, message); err == nil || !strings.Contains(err.Error(), "STARTTLS failed") {
+		t.Fatalf("sendEmail = %v, want the untrusted certificate refused", err)
+	}
+	for _, verb := range server.verbs() {
+		if verb == "AUTH" {
+			t.Errorf("password sent to an untrusted server")
+		}
+	}
+}
+
+func TestEmailRecipients(t *testing.T) {
+	settings := EmailConfig{Recipients: []EmailRoute{
+		{Event: EventRetirementCompleted, To: []string{"ops@example.com", "Audit@example.com"}},
+		{Profile: "Primary", To: []string{"audit@example.com", "dba@example.com"}, Attachments: []string{AttachPDF}},
+		{Event: EventAnalysisCompleted, To: []string{"analysts@example.com"}, Attachments: []string{AttachCSV}},
+		{Event: EventRetirementCompleted, Profile: "Secondary", To: []string{"secondary@example.com"}},
+		{To: []string{"everything@example.com", "OPS@example.com"}},
+	}}
+
+	for _, test := range []struct {
+		event, profile string
+		to             []string
+		attachments    []string
+	}{
+		{EventRetirementCompleted, "Primary",
+			[]string{"ops@example.com", "Audit@example.com", "dba@example.com", "everything@example.com"}, []string{AttachPDF}},
+		{EventRetirementCompleted, "Secondary",
+			[]string{"ops@example.com", "Audit@example.com", "secondary@example.com", "everything@example.com"}, nil},
+		{EventAnalysisCompleted, "Secondary", []string{"analysts@example.com", "everything@example.com", "OPS@example.com"}, []string{AttachCSV}},
+		{EventBackupFailed, "Primary", []string{"audit@example.com", "dba@example.com", "everything@example.com", "OPS@example.com"}, []string{AttachPDF}},
+	} {
+		to, attachments := emailRecipients(settings, WebhookEvent{Event: test.event, Profile: test.profile})
+		if !slices.Equal(to, test.to) {
+			t.Errorf("%s on %s: to = %v, want %v", test.event, test.profile, to, test.to)
+		}
+		var got []string
+		for attachment := range attachments {
+			got = append(got, attachment)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, test.attachments) {
+			t.Errorf("%s on %s: attachments = %v, want %v", test.event, test.profile, got, test.attachments)
+		}
+	}
+}
+
+// TestEventEmail sends a retirement notification end to end and reads it back as a
+// recipient's mail client would
+func TestEventEmail(t *testing.T) {
+	server := newSMTPStandIn(t, true)
+	saved := config.Email
+	t.Cleanup(func() { config.Email = saved })
+	config.Email = server.settings("")
+	config.Email.Recipients = []EmailRoute{
+		{Event: EventRetirementCompleted, To: []string{"ops@example.com"}, Attachments: []string{AttachCSV}},
+		{Profile: "Primary", To: []string{"OPS@example.com", "dba@example.com"}, Attachments: []string{AttachPDF}},
+		{Event: EventAnalysisCompleted, To: []string{"analysts@example.com"}},
+		{Profile: "Secondary", To: []string{"secondary@example.com"}},
+	}
+
+	now := time.Now()
+	job := &JobStatus{
+		ID:      "emailjob",
+		Status:  "completed",
+		Profile: "Primary",
+		Results: []AnalysisResult{{ID: 7, HostID: 3, HostName: "web01", Name: "web01 Syslog",
+			LogSourceType: "Syslog - Linux Host", MaxLogDate: "2024-01-02T03:04:05Z", PingResult: "Failure", Status: StatusStaleUnreachable}},
+		RetirementRecords: []RetirementRecord{{LogSourceID: 7, HostID: 3, HostName: "web01", OriginalName: "web01 Syslog",
+			RetiredName: "Retired web01 Syslog", OriginalStatus: "Active", RetiredStatus: "Retired", ChangeTicket: "CHG-7", Timestamp: now}},
+		StartTime: now,
+	}
+	jobsMutex.Lock()
+	jobs[job.ID] = job
+	jobsMutex.Unlock()
+	t.Cleanup(func() {
+		jobsMutex.Lock()
+		delete(jobs, job.ID)
+		jobsMutex.Unlock()
+	})
+
+	sendEventEmail(WebhookEvent{
+		ID:        "event1",
+		Event:     EventRetirementCompleted,
+		Timestamp: now.UTC(),
+		Profile:   "Primary",
+		JobID:     job.ID,
+		Summary:   "Retired 1 log source",
+		Data:      map[string]interface{}{"changeTicket": "CHG-7"},
+	})
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 1 {
+		t.Fatalf("server got %d messages, want 1; commands: %v", len(server.messages), server.commands)
+	}
+	sent := server.messages[0]
+	if want := []string{"ops@example.com", "dba@example.com"}; !slices.Equal(sent.To, want) {
+		t.Errorf("RCPT = %v, want %v", sent.To, want)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sent.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil ||
+		subject != "[LRCleaner] Retirement completed - Primary" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if to, err := msg.Header.AddressList("To"); err != nil || len(to) != 2 {
+		t.Errorf("To = %q (%v)", msg.Header.Get("To"), err)
+	}
+	if _, err := msg.Header.AddressList("From"); err != nil {
+		t.Errorf("From = %q: %v", msg.Header.Get("From"), err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := reader.NextPart()
+	if err != nil {
+		t.Fatalf("text part: %v", err)
+	}
+	body, _ := io.ReadAll(text) // multipart decodes quoted-printable itself
+	if !strings.Contains(string(body), "Retired 1 log source") || !strings.Contains(string(body), "Change ticket: CHG-7") {
+		t.Errorf("text part = %q", body)
+	}
+
+	attachments := map[string][]byte{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("attachment part: %v", err)
+		}
+		if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition != "attachment" {
+			t.Errorf("%s has Content-Disposition %q", part.FileName(), part.Header.Get("Content-Disposition"))
+		}
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatalf("%s is not base64: %v", part.FileName(), err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		attachments[contentType+" "+part.FileName()] = data
+	}
+
+	var csvFiles, pdfFiles int
+	for name, data := range attachments {
+		switch {
+		case strings.HasPrefix(name, "text/csv LRCleaner_") && strings.HasSuffix(name, "_emailjob.csv"):
+			rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			if err != nil || len(rows) < 2 {
+				t.Errorf("%s: %d rows (%v), want a header and data", name, len(rows), err)
+			}
+			csvFiles++
+		case name == "application/pdf LRCleaner_Report_Primary_emailjob.pdf":
+			if !bytes.HasPrefix(data, []byte("%PDF-")) {
+				t.Errorf("%s does not start with a PDF header", name)
+			}
+			pdfFiles++
+		default:
+			t.Errorf("unexpected attachment %s", name)
+		}
+	}
+	if csvFiles == 0 || pdfFiles != 1 {
+		t.Errorf("got %d CSV and %d PDF attachments, want CSV sheets and one PDF", csvFiles, pdfFiles)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+// TestWriteExportCSVQuotesAndNeutralisesFormulas checks that awkward names come back
+// unchanged through a CSV reader and that text a spreadsheet would run is neutralised
+func TestWriteExportCSVQuotesAndNeutralisesFormulas(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"plain", "web01", "web01"},
+		{"comma", "web01, rack 4", "web01, rack 4"},
+		{"quotes", `the "old" DC`, `the "old" DC`},
+		{"newline", "line one\nline two", "line one\nline two"},
+		{"equals", "=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"plus", "+1+1", "'+1+1"},
+		{"minus", "-2+3", "'-2+3"},
+		{"at", "@SUM(A1)", "'@SUM(A1)"},
+		{"tab", "\t=1", "'\t=1"},
+		{"formula after a comma", "=1,=2", "'=1,=2"},
+		{"equals inside", "a=b", "a=b"},
+		{"negative number", -5, "-5"},
+		{"bool", true, "Yes"},
+	}
+
+	sheet := exportSheet{Columns: []exportColumn{{"name", "Name"}, {"value", "Value"}}}
+	for _, tc := range tests {
+		sheet.Rows = append(sheet.Rows, []interface{}{tc.name, tc.value})
+	}
+	var buf bytes.Buffer
+	if err := writeExportCSV(&buf, sheet); err != nil {
+		t.Fatalf("writeExportCSV: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if len(records) != len(tests)+1 || records[0][0] != "Name" || records[0][1] != "Value" {
+		t.Fatalf("got %d records with header %q, want %d and the column headers", len(records), records[0], len(tests)+1)
+	}
+	for i, tc := range tests {
+		if got := records[i+1][1]; got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestWriteXLSXIsReadable checks that the workbook is a zip of well-formed XML with one
+// worksheet per sheet, and that text is stored as inline strings a spreadsheet never runs
+func TestWriteXLSXIsReadable(t *testing.T) {
+	sheets := []exportSheet{
+		{Key: "logSources", Title: "Log Sources", Columns: []exportColumn{{"name", "Name"}, {"id", "ID"}, {"recommended", "Recommended"}},
+			Rows: [][]interface{}{{"=cmd|' /C calc'!A0", 42, true}, {"R&D <lab>", 7, false}}},
+		{Key: "hosts", Title: "Hosts", Columns: []exportColumn{{"hostName", "HostName"}}, Rows: [][]interface{}{{"web01"}}},
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, sheets); err != nil {
+		t.Fatalf("writeXLSX: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("workbook is not a zip: %v", err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = data
+
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed XML: %v", f.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook has no %s", name)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	data, ok := parts["xl/worksheets/sheet1.xml"]
+	if !ok {
+		t.Fatalf("workbook has no first worksheet")
+	}
+	if err := xml.Unmarshal(data, &sheet); err != nil {
+		t.Fatalf("parse worksheet: %v", err)
+	}
+	if len(sheet.Rows) != 3 {
+		t.Fatalf("worksheet has %d rows, want a header and 2 rows", len(sheet.Rows))
+	}
+	cells := sheet.Rows[1].Cells
+	if cells[0].Ref != "A2" || cells[0].Type != "inlineStr" || cells[0].Inline != "=cmd|' /C calc'!A0" {
+		t.Errorf("text cell = %+v, want the formula text as an inline string", cells[0])
+	}
+	if cells[1].Type != "" || cells[1].Value != "42" {
+		t.Errorf("number cell = %+v, want 42", cells[1])
+	}
+	if cells[2].Type != "b" || cells[2].Value != "1" {
+		t.Errorf("bool cell = %+v, want true", cells[2])
+	}
+	if got := sheet.Rows[2].Cells[0].Inline; got != "R&D <lab>" {
+		t.Errorf("escaped text = %q, want %q", got, "R&D <lab>")
+	}
+	if _, ok := parts["xl/worksheets/sheet2.xml"]; !ok {
+		t.Errorf("workbook has no second worksheet")
+	}
+}
@@ -1,35 +1,69 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/zlib"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	_ "crypto/sha512"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"embed"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
 	"net"
 	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	texttemplate "text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/99designs/keyring"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	_ "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Embedded web files
@@ -39,10 +73,21 @@ var webFiles embed.FS
 
 // Configuration structure
 type Config struct {
-	Hostname           string         `json:"hostname"`
-	Port               int            `json:"port"`
-	ExcludedLogSources []string       `json:"excludedLogSources"`
-	Rollback           RollbackConfig `json:"rollback"`
+	SchemaVersion  int              `json:"schemaVersion"` // See currentConfigSchema
+	Profiles       []Profile        `json:"profiles"`
+	DefaultProfile string           `json:"defaultProfile"`
+	Exclusions     []ExclusionRule  `json:"exclusions"`
+	Protected      []ProtectedEntry `json:"protected"`
+	Rollback       RollbackConfig   `json:"rollback"`
+	Retirement     RetirementConfig `json:"retirement"`
+	BackupGate     BackupGateConfig `json:"backupGate"`
+	Auth           AuthConfig       `json:"auth"`
+	Server         ServerConfig     `json:"server"`
+	APITLS         APITLSConfig     `json:"apiTls"`
+	Reports        ReportsConfig    `json:"reports"`
+	Webhooks       []Webhook        `json:"webhooks"`
+	Email          EmailConfig      `json:"email"`
+	Syslog         SyslogConfig     `json:"syslog"`
 	// APIKey is now stored securely in OS credential store
 }
 
@@ -53,14 +98,23 @@ type LogSource struct {
 	RecordStatus      string        `json:"recordStatus"`
 	MaxLogDate        string        `json:"maxLogDate"`
 	Host              Host          `json:"host"`
+	Entity            Entity        `json:"entity"`
 	LogSourceType     LogSourceType `json:"logSourceType"`
 	SystemMonitorID   interface{}   `json:"systemMonitorId"`   // Collection host ID
 	SystemMonitorName string        `json:"systemMonitorName"` // Collection host name
 	Recommended       bool          `json:"recommended"`
+	Status            string        `json:"status,omitempty"`       // Analysis outcome, set once the log source is analysed
+	StatusReason      string        `json:"statusReason,omitempty"` // Why it has that status
 }
 
 type Host struct {
-	ID   interface{} `json:"id"` // Can be string or number
+	ID          interface{}      `json:"id"` // Can be string or number
+	Name        string           `json:"name"`
+	Identifiers []HostIdentifier `json:"hostIdentifiers,omitempty"` // Filled in by the EMDB data source
+}
+
+type Entity struct {
+	ID   interface{} `json:"id"`
 	Name string      `json:"name"`
 }
 
@@ -69,13 +123,44 @@ type LogSourceType struct {
 }
 
 type AnalysisResult struct {
-	ID            interface{} `json:"id"`     // Can be string or number
-	HostID        interface{} `json:"hostId"` // Can be string or number
-	HostName      string      `json:"hostName"`
-	Name          string      `json:"name"`          // Log source name
-	LogSourceType string      `json:"logSourceType"` // Log source type name
-	MaxLogDate    string      `json:"maxLogDate"`
-	PingResult    string      `json:"pingResult"`
+	Profile           string           `json:"profile,omitempty"` // Deployment the log source belongs to
+	ID                interface{}      `json:"id"`                // Can be string or number
+	HostID            interface{}      `json:"hostId"`            // Can be string or number
+	HostName          string           `json:"hostName"`
+	HostIdentifiers   []HostIdentifier `json:"hostIdentifiers,omitempty"`
+	Name              string           `json:"name"`          // Log source name
+	LogSourceType     string           `json:"logSourceType"` // Log source type name
+	MaxLogDate        string           `json:"maxLogDate"`
+	PingResult        string           `json:"pingResult"`
+	RecordStatus      string           `json:"recordStatus"`
+	Entity            string           `json:"entity"`
+	SystemMonitorID   interface{}      `json:"systemMonitorId"`
+	SystemMonitorName string           `json:"systemMonitorName"`
+	Recommended       bool             `json:"recommended"` // Stale-Unreachable: a retirement candidate
+	Status            string           `json:"status"`      // Analysis outcome, one of the Status constants
+	StatusReason      string           `json:"statusReason"`
+}
+
+// newAnalysisResult describes a classified log source and the ping result of its host
+func newAnalysisResult(p *Profile, ls LogSource, pingResult string) AnalysisResult {
+	return AnalysisResult{
+		Profile:           p.Name,
+		ID:                ls.ID,
+		HostID:            ls.Host.ID,
+		HostName:          ls.Host.Name,
+		HostIdentifiers:   ls.Host.Identifiers,
+		Name:              ls.Name,
+		LogSourceType:     ls.LogSourceType.Name,
+		MaxLogDate:        ls.MaxLogDate,
+		PingResult:        pingResult,
+		RecordStatus:      ls.RecordStatus,
+		Entity:            ls.Entity.Name,
+		SystemMonitorID:   ls.SystemMonitorID,
+		SystemMonitorName: ls.SystemMonitorName,
+		Recommended:       ls.Recommended,
+		Status:            ls.Status,
+		StatusReason:      ls.StatusReason,
+	}
 }
 
 type HostAnalysis struct {
@@ -85,7 +170,13 @@ type HostAnalysis struct {
 	MaxLogDate     string      `json:"maxLogDate"`
 	PingResult     string      `json:"pingResult"`
 	Recommended    bool        `json:"recommended"`
+	Status         string      `json:"status"` // Analysis outcome, one of the Status constants
+	StatusReason   string      `json:"statusReason"`
 	LogSources     []LogSource `json:"logSources"`
+	CaseID         string      `json:"caseId,omitempty"`     // LogRhythm case opened for troubleshooting the host
+	CaseNumber     int         `json:"caseNumber,omitempty"` // Case number shown in the LogRhythm Web Console
+	// Stale log sources an exclusion rule keeps out of retirement
+	Excluded []LogSource `json:"excludedLogSources,omitempty"`
 }
 
 type CollectionHostAnalysis struct {
@@ -98,11 +189,16 @@ type CollectionHostAnalysis struct {
 }
 
 type ApplyRequest struct {
-	SelectedHosts []string `json:"selectedHosts"`
+	Profile       string             `json:"profile"`
+	SelectedHosts []string           `json:"selectedHosts"`
+	ChangeTicket  string             `json:"changeTicket"`
+	Justification string             `json:"justification"`
+	Attestation   *BackupAttestation `json:"backupAttestation,omitempty"` // Backup taken outside LRCleaner
 }
 
 type BackupRequest struct {
-	Password string `json:"password"`
+	Profile  string `json:"profile"`
+	Password string `json:"password"` // One-off SQL password; the stored one is used when empty
 	Location string `json:"location"`
 }
 
@@ -114,16 +210,35 @@ type RetirementRecord struct {
 	RetiredName    string      `json:"retiredName"`
 	OriginalStatus string      `json:"originalStatus"`
 	RetiredStatus  string      `json:"retiredStatus"`
+	ChangeTicket   string      `json:"changeTicket"`
+	Justification  string      `json:"justification"`
 	Timestamp      time.Time   `json:"timestamp"`
+	// Agent that collected the log source
+	SystemMonitorID   interface{} `json:"systemMonitorId,omitempty"`
+	SystemMonitorName string      `json:"systemMonitorName,omitempty"`
+}
+
+// RetirementFailure is a change the execution layer refused or the API rejected
+type RetirementFailure struct {
+	Kind      string    `json:"kind"` // logsource, host or agent
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	HostName  string    `json:"hostName,omitempty"`
+	Error     string    `json:"error"`
+	Protected bool      `json:"protected,omitempty"` // Refused by the protected objects list
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // Rollback data structures
 type RollbackData struct {
 	ID            string    `json:"id"`
 	Timestamp     time.Time `json:"timestamp"`
-	OperationType string    `json:"operationType"` // "retirement", "host_retirement", etc.
-	User          string    `json:"user"`          // Who performed the operation
-	Description   string    `json:"description"`   // Human-readable description
+	OperationType string    `json:"operationType"`     // "retirement", "host_retirement", etc.
+	User          string    `json:"user"`              // Who performed the operation
+	Profile       string    `json:"profile,omitempty"` // Deployment profile the operation ran against
+	Description   string    `json:"description"`       // Human-readable description
+	ChangeTicket  string    `json:"changeTicket"`      // Change ticket authorising the operation
+	Justification string    `json:"justification"`     // Why the operation was performed
 
 	// Log Source Changes
 	LogSourceChanges []LogSourceRollback `json:"logSourceChanges"`
@@ -135,9 +250,15 @@ type RollbackData struct {
 	SystemMonitorChanges []SystemMonitorRollback `json:"systemMonitorChanges"`
 
 	// Metadata
-	JobID          string `json:"jobId"`
-	BackupLocation string `json:"backupLocation,omitempty"`
-	Checksum       string `json:"checksum"` // For integrity verification
+	JobID          string       `json:"jobId"`
+	BackupLocation string       `json:"backupLocation,omitempty"` // LogRhythmEMDB backup taken before the operation
+	BackupID       string       `json:"backupId,omitempty"`
+	BackupFiles    []BackupFile `json:"backupFiles,omitempty"`
+	// Operator's statement of a backup taken outside LRCleaner, when there was no verified one
+	BackupAttestation *BackupAttestation `json:"backupAttestation,omitempty"`
+	Checksum          string             `json:"checksum"` // For integrity verification
+
+	filePath string // Where the rollback point is stored; not serialised
 }
 
 type LogSourceRollback struct {
@@ -149,6 +270,8 @@ type LogSourceRollback struct {
 	CurrentName     string      `json:"currentName"`
 	CurrentStatus   string      `json:"currentStatus"`
 	SystemMonitorID interface{} `json:"systemMonitorId,omitempty"`
+	// Nil when the description was not captured (rollback points from older versions)
+	OriginalShortDescription *string `json:"originalShortDescription,omitempty"`
 }
 
 type HostRollback struct {
@@ -160,6 +283,8 @@ type HostRollback struct {
 	RetiredIdentifiers  []HostIdentifier `json:"retiredIdentifiers"` // Only identifiers that were actually retired
 	CurrentName         string           `json:"currentName"`
 	CurrentStatus       string           `json:"currentStatus"`
+	// Nil when the description was not captured (rollback points from older versions)
+	OriginalShortDescription *string `json:"originalShortDescription,omitempty"`
 }
 
 type SystemMonitorRollback struct {
@@ -185,6 +310,31 @@ type RollbackConfig struct {
 	ChecksumAlgorithm string `json:"checksumAlgorithm"`
 }
 
+// RetirementConfig controls how retired log sources and hosts are renamed
+type RetirementConfig struct {
+	NameTemplate  string `json:"nameTemplate"`  // e.g. "{name} [RETIRED {date} {ticket}]"
+	MaxNameLength int    `json:"maxNameLength"` // Longest name LogRhythm will accept
+}
+
+// RetirementNaming holds the details of a retirement used to rename and annotate objects
+type RetirementNaming struct {
+	Date          time.Time
+	Operator      string
+	Ticket        string
+	Justification string
+	JobID         string
+	SourceIP      string // Where the request came from, for the audit log
+	Profile       string // Deployment profile the retirement runs against
+	Backup        *BackupEvidence
+}
+
+// NameChange records the exact name and description of an object before and after retirement
+type NameChange struct {
+	Original            string
+	Retired             string
+	OriginalDescription *string
+}
+
 type JobStatus struct {
 	ID                     string                   `json:"id"`
 	Status                 string                   `json:"status"`
@@ -194,22 +344,49 @@ type JobStatus struct {
 	HostAnalysis           []HostAnalysis           `json:"hostAnalysis,omitempty"`
 	CollectionHostAnalysis []CollectionHostAnalysis `json:"collectionHostAnalysis,omitempty"`
 	RetirementRecords      []RetirementRecord       `json:"retirementRecords,omitempty"`
+	Failures               []RetirementFailure      `json:"failures,omitempty"`    // Refused or failed retirement changes
+	Profile                string                   `json:"profile,omitempty"`     // Deployment profile the job ran against
+	Profiles               []string                 `json:"profiles,omitempty"`    // Deployments of a cross-deployment analysis
+	Deployments            []DeploymentSummary      `json:"deployments,omitempty"` // Per-deployment outcome of a cross-deployment analysis
+	DataSource             string                   `json:"dataSource,omitempty"`  // Where analysis read log sources: api or emdb
+	StartedBy              string                   `json:"startedBy,omitempty"`
+	ChangeTicket           string                   `json:"changeTicket,omitempty"`
+	Justification          string                   `json:"justification,omitempty"`
+	Backup                 *BackupEvidence          `json:"backup,omitempty"`        // Backup a retirement was allowed to run on
+	RollbackID             string                   `json:"rollbackId,omitempty"`    // Rollback point recorded by a retirement
+	RetiredHosts           []string                 `json:"retiredHosts,omitempty"`  // IDs of host records a retirement retired
+	RetiredAgents          []string                 `json:"retiredAgents,omitempty"` // IDs of System Monitor agents a retirement retired
 	Error                  string                   `json:"error,omitempty"`
+	StatusCounts           *StatusCounts            `json:"statusCounts,omitempty"` // Log sources and hosts an analysis gave each status
 	StartTime              time.Time                `json:"startTime"`
 	EndTime                *time.Time               `json:"endTime,omitempty"`
 }
 
+// DeploymentSummary is one deployment's share of a cross-deployment analysis
+type DeploymentSummary struct {
+	Profile     string `json:"profile"`
+	Hostname    string `json:"hostname"`
+	DataSource  string `json:"dataSource"` // api or emdb
+	Status      string `json:"status"`     // running, completed or error
+	Error       string `json:"error,omitempty"`
+	LogSources  int    `json:"logSources"`  // Log sources fetched from the deployment
+	Stale       int    `json:"stale"`       // Log sources with no logs since the selected date
+	Hosts       int    `json:"hosts"`       // Distinct hosts with stale log sources
+	Reachable   int    `json:"reachable"`   // Of those hosts, how many answered a ping
+	Unreachable int    `json:"unreachable"` // and how many did not
+}
+
 // Global variables
 var (
+	configPath            = configFileName
 	config                *Config
+	configMutex           sync.RWMutex // Guards lists that jobs read while admins edit them, and config.json writes
 	httpClient            *http.Client
 	removedIdentifiersMap = make(map[string][]HostIdentifier) // Track removed identifiers by host ID
 	jobs                  = make(map[string]*JobStatus)
 	jobsMutex             sync.RWMutex
 	upgrader              = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all origins for local development
-		},
+		CheckOrigin: checkSameOrigin,
 	}
 	// WebSocket connection management
 	wsConnections = make(map[*websocket.Conn]bool)
@@ -223,31 +400,37 @@ var (
 const (
 	credentialService = "LRCleaner"
 	credentialKey     = "api_key"
+	oidcSecretKey     = "oidc_client_secret"
+	smtpPasswordKey   = "smtp_password"
+	dbCredentialKey   = "db_password" // Prefix of each deployment's SQL Server password entry
 )
 
+// keyringConfig selects the credential store; tests point it at a file keyring
+var keyringConfig = keyring.Config{
+	ServiceName: credentialService,
+	AllowedBackends: []keyring.BackendType{
+		keyring.WinCredBackend,       // Windows Credential Manager
+		keyring.KeychainBackend,      // macOS Keychain
+		keyring.SecretServiceBackend, // Linux Secret Service
+		keyring.PassBackend,          // Linux Pass
+		keyring.FileBackend,          // Fallback to file
+	},
+}
+
 // getKeyring returns a configured keyring instance
 func getKeyring() (keyring.Keyring, error) {
-	return keyring.Open(keyring.Config{
-		ServiceName: credentialService,
-		AllowedBackends: []keyring.BackendType{
-			keyring.WinCredBackend,       // Windows Credential Manager
-			keyring.KeychainBackend,      // macOS Keychain
-			keyring.SecretServiceBackend, // Linux Secret Service
-			keyring.PassBackend,          // Linux Pass
-			keyring.FileBackend,          // Fallback to file
-		},
-	})
+	return keyring.Open(keyringConfig)
 }
 
-// StoreAPIKey stores the API key securely in the OS credential store
-func StoreAPIKey(apiKey string) error {
+// StoreAPIKey stores a deployment's API key securely in the OS credential store under key
+func StoreAPIKey(key, apiKey string) error {
 	ring, err := getKeyring()
 	if err != nil {
 		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
 	err = ring.Set(keyring.Item{
-		Key:  credentialKey,
+		Key:  key,
 		Data: []byte(apiKey),
 	})
 	if err != nil {
@@ -258,14 +441,19 @@ func StoreAPIKey(apiKey string) error {
 	return nil
 }
 
-// GetAPIKey retrieves the API key from the OS credential store
-func GetAPIKey() (string, error) {
+// GetAPIKey retrieves a deployment's API key from the OS credential store
+func GetAPIKey(key string) (string, error) {
+	// A key supplied through LRCLEANER_API_KEY takes precedence over the credential store
+	if apiKey, ok := envAPIKeys[key]; ok {
+		return apiKey, nil
+	}
+
 	ring, err := getKeyring()
 	if err != nil {
 		return "", fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
-	item, err := ring.Get(credentialKey)
+	item, err := ring.Get(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to retrieve API key: %v", err)
 	}
@@ -273,14 +461,14 @@ func GetAPIKey() (string, error) {
 	return string(item.Data), nil
 }
 
-// DeleteAPIKey removes the API key from the OS credential store
-func DeleteAPIKey() error {
+// DeleteAPIKey removes a deployment's API key from the OS credential store
+func DeleteAPIKey(key string) error {
 	ring, err := getKeyring()
 	if err != nil {
 		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
-	err = ring.Remove(credentialKey)
+	err = ring.Remove(key)
 	if err != nil {
 		return fmt.Errorf("failed to delete API key: %v", err)
 	}
@@ -290,14 +478,14 @@ func DeleteAPIKey() error {
 }
 
 // HasAPIKey checks if an API key is stored in the credential store
-func HasAPIKey() bool {
-	_, err := GetAPIKey()
+func HasAPIKey(key string) bool {
+	_, err := GetAPIKey(key)
 	return err == nil
 }
 
 // GetConfigAPIKey gets the API key from the credential store for use in API calls
-func GetConfigAPIKey() string {
-	apiKey, err := GetAPIKey()
+func GetConfigAPIKey(key string) string {
+	apiKey, err := GetAPIKey(key)
 	if err != nil {
 		log.Printf("Warning: Failed to get API key from credential store: %v", err)
 		return ""
@@ -305,886 +493,8947 @@ func GetConfigAPIKey() string {
 	return apiKey
 }
 
-func findAvailablePort() int {
-	fmt.Println("LRCleaner - LogRhythm Log Source Management Tool")
-	fmt.Println("================================================")
-	fmt.Println()
-
-	// Check for command line argument first
-	if len(os.Args) > 1 {
-		if port, err := strconv.Atoi(os.Args[1]); err == nil && port > 0 && port <= 65535 {
-			if isPortAvailable(port) {
-				fmt.Printf("Using port %d from command line argument\n", port)
-				return port
-			} else {
-				fmt.Printf("Port %d from command line is not available, finding alternative...\n", port)
-			}
-		}
+// StoreOIDCClientSecret stores the SSO client secret in the OS credential store
+func StoreOIDCClientSecret(secret string) error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
-	// Try default port 8080 first
-	if isPortAvailable(8080) {
-		fmt.Println("Using default port 8080")
-		return 8080
+	err = ring.Set(keyring.Item{
+		Key:  oidcSecretKey,
+		Data: []byte(secret),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store OIDC client secret: %v", err)
 	}
 
-	// If 8080 is not available, find an available port starting from 8000
-	fmt.Println("Port 8080 is in use, searching for available port...")
+	log.Println("OIDC client secret stored securely in OS credential store")
+	return nil
+}
 
-	for port := 8000; port <= 8443; port++ {
-		if isPortAvailable(port) {
-			fmt.Printf("Found available port: %d\n", port)
-			return port
-		}
+// GetOIDCClientSecret retrieves the SSO client secret; public clients have none
+func GetOIDCClientSecret() string {
+	ring, err := getKeyring()
+	if err != nil {
+		return ""
 	}
 
-	// This should never happen, but just in case
-	fmt.Println("No available ports found, using 8080 anyway")
-	return 8080
+	item, err := ring.Get(oidcSecretKey)
+	if err != nil {
+		return ""
+	}
+	return string(item.Data)
 }
 
-func isPortAvailable(port int) bool {
-	address := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", address)
+// DeleteOIDCClientSecret removes the SSO client secret from the OS credential store
+func DeleteOIDCClientSecret() error {
+	ring, err := getKeyring()
 	if err != nil {
-		return false
+		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
-	listener.Close()
-	return true
+
+	if err := ring.Remove(oidcSecretKey); err != nil && err != keyring.ErrKeyNotFound {
+		return fmt.Errorf("failed to delete OIDC client secret: %v", err)
+	}
+	return nil
 }
 
-func openBrowser(url string) {
-	// Wait a moment for the server to start
-	time.Sleep(2 * time.Second)
+// StoreSMTPPassword stores the mail server password in the OS credential store
+func StoreSMTPPassword(password string) error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-	var err error
+	err = ring.Set(keyring.Item{
+		Key:  smtpPasswordKey,
+		Data: []byte(password),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store SMTP password: %v", err)
+	}
 
-	switch runtime.GOOS {
-	case "linux":
-		err = exec.Command("xdg-open", url).Start()
-	case "windows":
-		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
-	case "darwin":
-		err = exec.Command("open", url).Start()
-	default:
-		fmt.Printf("Please open your browser and navigate to: %s\n", url)
-		return
+	log.Println("SMTP password stored securely in OS credential store")
+	return nil
+}
+
+// GetSMTPPassword retrieves the mail server password; relays without sign-in have none
+func GetSMTPPassword() string {
+	ring, err := getKeyring()
+	if err != nil {
+		return ""
 	}
 
+	item, err := ring.Get(smtpPasswordKey)
 	if err != nil {
-		fmt.Printf("Could not automatically open browser. Please navigate to: %s\n", url)
-	} else {
-		fmt.Printf("Opening browser to: %s\n", url)
+		return ""
 	}
+	return string(item.Data)
 }
 
-func loadRollbackFiles() {
-	rollbackDir := config.Rollback.BackupLocation
-	if rollbackDir == "" {
-		rollbackDir = "./rollback/"
+// DeleteSMTPPassword removes the mail server password from the OS credential store
+func DeleteSMTPPassword() error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
-	// Check if rollback directory exists
-	if _, err := os.Stat(rollbackDir); os.IsNotExist(err) {
-		log.Printf("Rollback directory does not exist: %s", rollbackDir)
-		return
+	if err := ring.Remove(smtpPasswordKey); err != nil && err != keyring.ErrKeyNotFound {
+		return fmt.Errorf("failed to delete SMTP password: %v", err)
 	}
+	return nil
+}
 
-	// Read all JSON files in the rollback directory
-	files, err := filepath.Glob(filepath.Join(rollbackDir, "*.json"))
+// StoreDBPassword stores a deployment's SQL Server password in the OS credential store under key
+func StoreDBPassword(key, password string) error {
+	ring, err := getKeyring()
 	if err != nil {
-		log.Printf("Error reading rollback directory: %v", err)
-		return
+		return fmt.Errorf("failed to initialize keyring: %v", err)
 	}
 
-	log.Printf("Found %d rollback files to load", len(files))
+	err = ring.Set(keyring.Item{
+		Key:  key,
+		Data: []byte(password),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store database password: %v", err)
+	}
 
-	loadedCount := 0
-	for _, file := range files {
-		// Read the file
-		data, err := os.ReadFile(file)
-		if err != nil {
-			log.Printf("Error reading rollback file %s: %v", file, err)
-			continue
-		}
+	log.Println("Database password stored securely in OS credential store")
+	return nil
+}
 
-		// Parse the rollback data
-		var rollbackData RollbackData
-		if err := json.Unmarshal(data, &rollbackData); err != nil {
-			log.Printf("Error parsing rollback file %s: %v", file, err)
-			continue
-		}
+// GetDBPassword retrieves a deployment's SQL Server password from the OS credential store
+func GetDBPassword(key string) (string, error) {
+	ring, err := getKeyring()
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-		// Verify checksum if present
-		if rollbackData.Checksum != "" {
-			expectedChecksum := calculateChecksum(data)
-			if rollbackData.Checksum != expectedChecksum {
-				log.Printf("Checksum mismatch for rollback file %s, skipping", file)
-				continue
-			}
-		}
+	item, err := ring.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve database password: %v", err)
+	}
+	return string(item.Data), nil
+}
 
-		// Store in memory
-		rollbackMutex.Lock()
-		rollbackHistory[rollbackData.ID] = &rollbackData
-		rollbackMutex.Unlock()
+// DeleteDBPassword removes a deployment's SQL Server password from the OS credential store
+func DeleteDBPassword(key string) error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-		loadedCount++
-		log.Printf("Loaded rollback file: %s (ID: %s)", file, rollbackData.ID)
+	if err := ring.Remove(key); err != nil && err != keyring.ErrKeyNotFound {
+		return fmt.Errorf("failed to delete database password: %v", err)
 	}
+	return nil
+}
 
-	log.Printf("Successfully loaded %d rollback files", loadedCount)
+// HasDBPassword checks if a database password is stored in the credential store
+func HasDBPassword(key string) bool {
+	_, err := GetDBPassword(key)
+	return err == nil
 }
 
-func main() {
-	// Initialize configuration
-	config = loadConfig()
+// StoreWebhookSecret stores a webhook's HMAC signing secret in the OS credential store
+func StoreWebhookSecret(id, secret string) error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-	// Find available port (tries 8080 first, then 8000-8443)
-	port := findAvailablePort()
+	err = ring.Set(keyring.Item{
+		Key:  webhookSecretPrefix + id,
+		Data: []byte(secret),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store webhook secret: %v", err)
+	}
+	return nil
+}
 
-	// Load existing rollback files
-	loadRollbackFiles()
+// GetWebhookSecret retrieves a webhook's signing secret, or "" when it has none
+func GetWebhookSecret(id string) (string, error) {
+	ring, err := getKeyring()
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-	// Setup HTTP client with custom transport
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Timeout: 30 * time.Second,
+	item, err := ring.Get(webhookSecretPrefix + id)
+	if err == keyring.ErrKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve webhook secret: %v", err)
 	}
+	return string(item.Data), nil
+}
 
-	// Setup routes
-	router := mux.NewRouter()
+// DeleteWebhookSecret removes a webhook's signing secret from the OS credential store
+func DeleteWebhookSecret(id string) error {
+	ring, err := getKeyring()
+	if err != nil {
+		return fmt.Errorf("failed to initialize keyring: %v", err)
+	}
 
-	// Static files (embedded web UI)
-	staticFS, _ := fs.Sub(webFiles, "web/static")
-	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
-	router.HandleFunc("/", serveIndex)
+	if err := ring.Remove(webhookSecretPrefix + id); err != nil && err != keyring.ErrKeyNotFound {
+		return fmt.Errorf("failed to delete webhook secret: %v", err)
+	}
+	return nil
+}
 
-	// API routes
-	api := router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/config", handleConfig).Methods("GET", "POST")
-	api.HandleFunc("/test-connection", handleTestConnection).Methods("POST")
-	api.HandleFunc("/test", handleTestMode).Methods("POST")
-	api.HandleFunc("/backup", handleBackup).Methods("POST")
-	api.HandleFunc("/apply", handleApplyMode).Methods("POST")
-	api.HandleFunc("/apply/execute", handleExecuteApply).Methods("POST")
-	api.HandleFunc("/collection-hosts/retire", handleRetireCollectionHosts).Methods("POST")
-	api.HandleFunc("/export/{jobId}", handleExport).Methods("GET")
-	api.HandleFunc("/export/pdf/{jobId}", handleExportPDF).Methods("GET")
-	api.HandleFunc("/jobs/{jobId}", handleJobStatus).Methods("GET")
-	api.HandleFunc("/ws", handleWebSocket)
+// HasWebhookSecret checks if a webhook signs its deliveries
+func HasWebhookSecret(id string) bool {
+	secret, err := GetWebhookSecret(id)
+	return err == nil && secret != ""
+}
 
-	// API Key management routes
-	api.HandleFunc("/api-key", handleAPIKey).Methods("GET", "POST", "DELETE")
-	api.HandleFunc("/api-key/value", handleAPIKeyValue).Methods("GET")
+// Authentication - local users with bcrypt passwords and in-memory sessions
 
-	// Rollback API routes
-	api.HandleFunc("/rollback/history", handleRollbackHistory).Methods("GET")
-	api.HandleFunc("/rollback/{rollbackId}", handleRollbackDetails).Methods("GET")
-	api.HandleFunc("/rollback/{rollbackId}/execute", handleExecuteRollback).Methods("POST")
-	api.HandleFunc("/rollback/{rollbackId}", handleDeleteRollback).Methods("DELETE")
+// Roles in increasing order of privilege
+const (
+	RoleViewer   = "viewer"   // Run analysis and export results
+	RoleOperator = "operator" // Retire and roll back
+	RoleAdmin    = "admin"    // Manage configuration, API key and users
+
+	usersFile             = "users.json"
+	sessionCookieName     = "lrcleaner_session"
+	defaultSessionTimeout = 480 // minutes
+	maxLoginFailures      = 5
+	loginLockoutDuration  = 5 * time.Minute
+)
 
-	// Start server
-	server := &http.Server{
-		Addr:    ":" + strconv.Itoa(port),
-		Handler: router,
-	}
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
 
-	// Start server in goroutine
-	go func() {
-		url := fmt.Sprintf("http://localhost:%d", port)
-		fmt.Printf("LRCleaner starting on %s\n", url)
+// User sources
+const (
+	UserSourceLocal = "local"
+	UserSourceOIDC  = "oidc"
+)
 
-		// Open browser automatically
-		go openBrowser(url)
+// User is an LRCleaner account, either local or provisioned by single sign-on
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	Role         string    `json:"role"`
+	Source       string    `json:"source,omitempty"` // Empty for local accounts created before SSO existed
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastLogin    time.Time `json:"lastLogin,omitempty"`
+}
 
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
-		}
-	}()
+// Session is an authenticated browser session
+type Session struct {
+	Token     string
+	Username  string
+	ExpiresAt time.Time
+}
 
-	// Wait for interrupt signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+// AuthConfig controls web UI authentication
+type AuthConfig struct {
+	SessionTimeoutMinutes int        `json:"sessionTimeoutMinutes"`
+	OIDC                  OIDCConfig `json:"oidc"`
+}
 
-	// Handle shutdown gracefully
-	go func() {
-		<-quit
-		fmt.Println("\n🛑 Shutdown signal received. Gracefully shutting down LRCleaner...")
-		fmt.Println("   - Closing WebSocket connections...")
-		fmt.Println("   - Stopping HTTP server...")
-		fmt.Println("   - Please wait...")
+type sessionContextKey struct{}
 
-		// Close all WebSocket connections
-		wsMutex.Lock()
-		for conn := range wsConnections {
-			conn.Close()
-		}
-		wsConnections = make(map[*websocket.Conn]bool)
-		wsMutex.Unlock()
+var (
+	users         = make(map[string]*User)
+	usersMutex    sync.RWMutex
+	sessions      = make(map[string]*Session)
+	sessionsMutex sync.Mutex
+	loginFailures = make(map[string][]time.Time)
+)
 
-		// Graceful shutdown with timeout
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+// loadUsers reads the local user store, creating an initial admin account on first run
+func loadUsers() {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
-		if err := server.Shutdown(ctx); err != nil {
-			fmt.Printf("⚠️  Server forced to shutdown: %v\n", err)
-		} else {
-			fmt.Println("✅ LRCleaner stopped gracefully")
+	path := dataPath(usersFile)
+	if data, err := os.ReadFile(path); err == nil {
+		var stored []*User
+		if err := json.Unmarshal(data, &stored); err != nil {
+			log.Fatalf("Failed to parse %s: %v", path, err)
 		}
+		for _, user := range stored {
+			users[strings.ToLower(user.Username)] = user
+		}
+		log.Printf("Loaded %d local users from %s", len(users), path)
+		return
+	}
 
-		os.Exit(0)
-	}()
+	// No user store yet - create an admin with a random password and show it once
+	password := randomToken(12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash initial admin password: %v", err)
+	}
+	users["admin"] = &User{
+		Username:     "admin",
+		PasswordHash: string(hash),
+		Role:         RoleAdmin,
+		Source:       UserSourceLocal,
+		CreatedAt:    time.Now(),
+	}
+	if err := saveUsersLocked(); err != nil {
+		log.Fatalf("Failed to create %s: %v", path, err)
+	}
 
-	// Keep the main goroutine alive
-	select {}
+	fmt.Println("Created initial administrator account:")
+	fmt.Printf("   Username: admin\n   Password: %s\n", password)
+	fmt.Println("   Change this password after signing in.")
+	fmt.Println()
 }
 
-func loadConfig() *Config {
-	config := &Config{
-		Hostname: "localhost",
-		Port:     8501,
-		ExcludedLogSources: []string{
-			"Open Collector",
-			"Echo",
-			"AI Engine",
-			"LogRhythm System",
-		},
-		Rollback: RollbackConfig{
-			Enabled:           true,
-			RetentionDays:     30,
-			MaxRollbackPoints: 10,
-			AutoBackup:        true,
-			BackupLocation:    "./rollback/",
-			ChecksumAlgorithm: "sha256",
-		},
+// saveUsersLocked writes the user store; usersMutex must be held
+func saveUsersLocked() error {
+	stored := make([]*User, 0, len(users))
+	for _, user := range users {
+		stored = append(stored, user)
 	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Username < stored[j].Username })
 
-	// Try to load from file
-	if data, err := os.ReadFile("config.json"); err == nil {
-		// Create a temporary struct to handle legacy config with API key
-		type LegacyConfig struct {
-			Hostname           string         `json:"hostname"`
-			APIKey             string         `json:"apiKey"`
-			Port               int            `json:"port"`
-			ExcludedLogSources []string       `json:"excludedLogSources"`
-			Rollback           RollbackConfig `json:"rollback"`
-		}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dataPath(usersFile), data, 0600)
+}
 
-		var legacyConfig LegacyConfig
-		if err := json.Unmarshal(data, &legacyConfig); err == nil {
-			// Migrate API key to credential store if it exists in legacy config
-			if legacyConfig.APIKey != "" {
-				if err := StoreAPIKey(legacyConfig.APIKey); err != nil {
-					log.Printf("Warning: Failed to migrate API key to credential store: %v", err)
-				} else {
-					log.Println("API key migrated from config.json to OS credential store")
-					// Remove API key from config file
-					legacyConfig.APIKey = ""
-					if updatedData, err := json.MarshalIndent(legacyConfig, "", "  "); err == nil {
-						os.WriteFile("config.json", updatedData, 0644)
-					}
-				}
-			}
+// getUser returns a copy of the named user, or nil if it does not exist
+func getUser(username string) *User {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
 
-			// Copy non-sensitive fields
-			config.Hostname = legacyConfig.Hostname
-			config.Port = legacyConfig.Port
-			config.ExcludedLogSources = legacyConfig.ExcludedLogSources
-			config.Rollback = legacyConfig.Rollback
-		} else {
-			log.Printf("Warning: Failed to parse config.json: %v", err)
-		}
+	user, ok := users[strings.ToLower(username)]
+	if !ok {
+		return nil
 	}
+	userCopy := *user
+	return &userCopy
+}
 
-	return config
+// userSource reports where an account comes from
+func userSource(user *User) string {
+	if user.Source == "" {
+		return UserSourceLocal
+	}
+	return user.Source
 }
 
-func serveIndex(w http.ResponseWriter, r *http.Request) {
-	data, err := webFiles.ReadFile("web/index.html")
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+// validateRole checks that role is one of the known roles
+func validateRole(role string) error {
+	if _, ok := roleRank[role]; !ok {
+		return fmt.Errorf("role must be one of viewer, operator or admin")
 	}
-	w.Header().Set("Content-Type", "text/html")
-	w.Write(data)
+	return nil
 }
 
-// ConfigResponse represents the response structure for config API
-type ConfigResponse struct {
-	Hostname           string         `json:"hostname"`
-	Port               int            `json:"port"`
-	ExcludedLogSources []string       `json:"excludedLogSources"`
-	Rollback           RollbackConfig `json:"rollback"`
-	HasAPIKey          bool           `json:"hasApiKey"`
+// validatePassword enforces the minimum password policy
+func validatePassword(password string) error {
+	if len(password) < 12 {
+		return fmt.Errorf("password must be at least 12 characters")
+	}
+	if len(password) > 72 {
+		return fmt.Errorf("password must be at most 72 bytes") // bcrypt limit
+	}
+	return nil
 }
 
-func handleConfig(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		w.Header().Set("Content-Type", "application/json")
-		response := ConfigResponse{
-			Hostname:           config.Hostname,
-			Port:               config.Port,
-			ExcludedLogSources: config.ExcludedLogSources,
-			Rollback:           config.Rollback,
-			HasAPIKey:          HasAPIKey(),
-		}
-		json.NewEncoder(w).Encode(response)
-	case "POST":
-		var requestData struct {
-			Hostname string `json:"hostname"`
-			Port     int    `json:"port"`
-			APIKey   string `json:"apiKey"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
+// randomToken returns n random bytes encoded as hex
+func randomToken(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("Failed to read random bytes: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
 
-		// Update config with new values
-		config.Hostname = requestData.Hostname
-		config.Port = requestData.Port
+// sessionTimeout returns how long a session stays valid without activity
+func sessionTimeout() time.Duration {
+	minutes := config.Auth.SessionTimeoutMinutes
+	if minutes <= 0 {
+		minutes = defaultSessionTimeout
+	}
+	return time.Duration(minutes) * time.Minute
+}
 
-		// Save API key to credential store if provided and not already stored
-		if requestData.APIKey != "" && requestData.APIKey != "***STORED***" {
-			if err := StoreAPIKey(requestData.APIKey); err != nil {
-				log.Printf("Error storing API key: %v", err)
-				http.Error(w, "Failed to store API key", http.StatusInternalServerError)
-				return
-			}
-		}
+// createSession starts a session for username and sets the session cookie
+func createSession(w http.ResponseWriter, username string) *Session {
+	session := &Session{
+		Token:     randomToken(32),
+		Username:  username,
+		ExpiresAt: time.Now().Add(sessionTimeout()),
+	}
+
+	sessionsMutex.Lock()
+	sessions[session.Token] = session
+	sessionsMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.Token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return session
+}
 
-		// Save config to file (without API key)
-		data, err := json.MarshalIndent(config, "", "  ")
-		if err != nil {
-			log.Printf("Error marshaling config: %v", err)
-			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
-			return
-		}
-		if err := os.WriteFile("config.json", data, 0644); err != nil {
-			log.Printf("Error writing config file: %v", err)
-			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
-			return
-		}
+// destroySessions removes every session belonging to username
+func destroySessions(username string) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	for token, session := range sessions {
+		if strings.EqualFold(session.Username, username) {
+			delete(sessions, token)
+		}
 	}
 }
 
-func handleAPIKey(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case "GET":
-		// Check if API key exists
-		hasKey := HasAPIKey()
-		response := map[string]interface{}{
-			"hasApiKey": hasKey,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+// sessionUser resolves the session cookie on r to an enabled user, extending the session
+func sessionUser(r *http.Request) *User {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
 
-	case "POST":
-		// Store API key
-		var request struct {
-			APIKey string `json:"apiKey"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
+	sessionsMutex.Lock()
+	session, ok := sessions[cookie.Value]
+	if ok && time.Now().After(session.ExpiresAt) {
+		delete(sessions, cookie.Value)
+		ok = false
+	}
+	if ok {
+		session.ExpiresAt = time.Now().Add(sessionTimeout())
+	}
+	sessionsMutex.Unlock()
 
-		if request.APIKey == "" {
-			http.Error(w, "API key is required", http.StatusBadRequest)
+	if !ok {
+		return nil
+	}
+
+	// Look the user up on every request so role changes and disabling take effect immediately
+	user := getUser(session.Username)
+	if user == nil || user.Disabled {
+		return nil
+	}
+	return user
+}
+
+// requireRole wraps a handler so it only runs for signed-in users with at least the given role
+func requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := sessionUser(r)
+		if user == nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
 			return
 		}
-
-		if err := StoreAPIKey(request.APIKey); err != nil {
-			log.Printf("Error storing API key: %v", err)
-			http.Error(w, "Failed to store API key", http.StatusInternalServerError)
+		if roleRank[user.Role] < roleRank[role] {
+			log.Printf("Access denied: %s (%s) attempted %s %s", user.Username, user.Role, r.Method, r.URL.Path)
+			http.Error(w, "You do not have permission to perform this action", http.StatusForbidden)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		ctx := context.WithValue(r.Context(), sessionContextKey{}, user)
+		next(w, r.WithContext(ctx))
+	}
+}
 
-	case "DELETE":
-		// Remove API key
-		if err := DeleteAPIKey(); err != nil {
-			log.Printf("Error deleting API key: %v", err)
-			http.Error(w, "Failed to delete API key", http.StatusInternalServerError)
-			return
-		}
+// currentUser returns the user attached to the request by requireRole
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(sessionContextKey{}).(*User)
+	return user
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+// currentUsername returns the signed-in username, or "system" when there is none
+func currentUsername(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return user.Username
 	}
+	return "system"
 }
 
-func handleAPIKeyValue(w http.ResponseWriter, r *http.Request) {
-	// Get the actual API key value
-	apiKey, err := GetAPIKey()
+// checkSameOrigin rejects cross-site WebSocket upgrades
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // Non-browser clients don't send an Origin header
+	}
+	u, err := url.Parse(origin)
 	if err != nil {
-		log.Printf("Error retrieving API key: %v", err)
-		http.Error(w, "Failed to retrieve API key", http.StatusInternalServerError)
-		return
+		return false
 	}
+	return strings.EqualFold(u.Host, r.Host)
+}
 
-	if apiKey == "" {
-		http.Error(w, "No API key found", http.StatusNotFound)
-		return
-	}
+// loginLocked reports whether username has too many recent failed logins
+func loginLocked(username string) bool {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"apiKey": apiKey,
-	})
+	key := strings.ToLower(username)
+	recent := recentLoginFailures(loginFailures[key])
+	if len(recent) == 0 {
+		delete(loginFailures, key)
+	} else {
+		loginFailures[key] = recent
+	}
+	return len(recent) >= maxLoginFailures
 }
 
-func handleTestConnection(w http.ResponseWriter, r *http.Request) {
-	var testRequest struct {
-		Hostname string `json:"hostname"`
-		Port     int    `json:"port"`
-		APIKey   string `json:"apiKey,omitempty"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&testRequest); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
+// recordLoginFailure counts a failed login for username. Names are whatever a client
+// submits, so failures older than the lockout window are dropped for every name here to
+// keep unauthenticated requests from growing the map without limit.
+func recordLoginFailure(username string) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
-	// Get API key from credential store if not provided in request
-	apiKey := testRequest.APIKey
-	if apiKey == "" {
-		var err error
-		apiKey, err = GetAPIKey()
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": false,
-				"error":   "No API key found. Please store an API key first.",
-			})
-			return
+	for name, times := range loginFailures {
+		if recent := recentLoginFailures(times); len(recent) == 0 {
+			delete(loginFailures, name)
+		} else {
+			loginFailures[name] = recent
 		}
 	}
+	key := strings.ToLower(username)
+	loginFailures[key] = append(loginFailures[key], time.Now())
+}
 
-	// Validate required fields
-	if testRequest.Hostname == "" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   "Hostname is required",
-		})
-		return
+// recentLoginFailures keeps the failures still inside the lockout window
+func recentLoginFailures(times []time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range times {
+		if time.Since(t) < loginLockoutDuration {
+			recent = append(recent, t)
+		}
 	}
+	return recent
+}
 
-	// Test the connection by making a simple API call
-	port := testRequest.Port
-	if port == 0 {
-		port = 8501 // Default port
+// dummyPasswordHash is compared against when a sign-in names no local password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(randomToken(16)), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash stand-in password: %v", err)
 	}
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?count=1&offset=0",
-		testRequest.Hostname, port)
+	return hash
+})
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to create request: %v", err),
-		})
+// Authentication API Handlers
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	failure := requestAudit(r, "auth.login", request.Username)
+	failure.User = request.Username
+	failure.Result = auditResultFailure
 
-	// Create a temporary client for testing
-	testClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Timeout: 10 * time.Second,
+	if loginLocked(request.Username) {
+		failure.Message = "Account temporarily locked"
+		logAudit(failure)
+		http.Error(w, "Too many failed sign-in attempts. Try again later.", http.StatusTooManyRequests)
+		return
 	}
 
-	resp, err := testClient.Do(req)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Connection failed: %v", err),
-		})
+	// Compare against a stand-in hash when there is no password to check, so the response
+	// takes as long for an unknown username as for a wrong password
+	hash := dummyPasswordHash()
+	user := getUser(request.Username)
+	if user != nil && user.PasswordHash != "" {
+		hash = []byte(user.PasswordHash)
+	}
+	passwordOK := bcrypt.CompareHashAndPassword(hash, []byte(request.Password)) == nil
+	if user == nil || user.Disabled || user.Source == UserSourceOIDC || !passwordOK {
+		recordLoginFailure(request.Username)
+		log.Printf("Failed sign-in for %q from %s", request.Username, r.RemoteAddr)
+		failure.Message = "Invalid username or password"
+		logAudit(failure)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"message": "Connection successful! LogRhythm API is accessible.",
-		})
-	} else {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("API returned status code: %d", resp.StatusCode),
-		})
+	usersMutex.Lock()
+	if stored, ok := users[strings.ToLower(user.Username)]; ok {
+		stored.LastLogin = time.Now()
+		if err := saveUsersLocked(); err != nil {
+			log.Printf("Error saving users: %v", err)
+		}
 	}
+	delete(loginFailures, strings.ToLower(user.Username))
+	usersMutex.Unlock()
+
+	createSession(w, user.Username)
+	log.Printf("User %s signed in from %s", user.Username, r.RemoteAddr)
+	success := requestAudit(r, "auth.login", user.Username)
+	success.User = user.Username
+	success.Message = "Signed in as " + user.Role
+	logAudit(success)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"username": user.Username,
+		"role":     user.Role,
+	})
 }
 
-func handleTestMode(w http.ResponseWriter, r *http.Request) {
-	log.Println("Test mode request received")
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if user := sessionUser(r); user != nil {
+		entry := requestAudit(r, "auth.logout", user.Username)
+		entry.User = user.Username
+		logAudit(entry)
+	}
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		sessionsMutex.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMutex.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func handleCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"username": user.Username,
+		"role":     user.Role,
+		"source":   userSource(user),
+	})
+}
 
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		Date string `json:"date"`
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
 	}
-
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		log.Printf("Error decoding test mode request: %v", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Test mode request - Date: %s", request.Date)
+	user := currentUser(r)
+	if user.Source == UserSourceOIDC {
+		http.Error(w, "Your password is managed by your single sign-on provider", http.StatusBadRequest)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(request.CurrentPassword)) != nil {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err := validatePassword(request.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Parse date
-	selectedDate, err := time.Parse("2006-01-02", request.Date)
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Error parsing date: %v", err)
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	// Create job
-	jobID := fmt.Sprintf("test_%d", time.Now().Unix())
-	job := &JobStatus{
-		ID:        jobID,
-		Status:    "running",
-		Progress:  0,
-		Message:   "Starting analysis...",
-		StartTime: time.Now(),
+	usersMutex.Lock()
+	users[strings.ToLower(user.Username)].PasswordHash = string(hash)
+	err = saveUsersLocked()
+	usersMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving users: %v", err)
+		http.Error(w, "Failed to save password", http.StatusInternalServerError)
+		return
 	}
 
-	log.Printf("Created test job: %s", jobID)
+	// Sign out other sessions and start a fresh one for this browser
+	destroySessions(user.Username)
+	createSession(w, user.Username)
+	logAudit(requestAudit(r, "auth.password.change", user.Username))
 
-	jobsMutex.Lock()
-	jobs[jobID] = job
-	jobsMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
 
-	// Start analysis in background
-	log.Printf("Starting background analysis for job: %s", jobID)
-	go analyzeLogSources(jobID, selectedDate)
+// User Management API Handlers
+
+func handleListUsers(w http.ResponseWriter, r *http.Request) {
+	usersMutex.RLock()
+	list := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		list = append(list, map[string]interface{}{
+			"username":  user.Username,
+			"role":      user.Role,
+			"source":    userSource(user),
+			"disabled":  user.Disabled,
+			"createdAt": user.CreatedAt,
+			"lastLogin": user.LastLogin,
+		})
+	}
+	usersMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["username"].(string) < list[j]["username"].(string)
+	})
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
-	log.Printf("Test mode response sent - JobID: %s", jobID)
+	json.NewEncoder(w).Encode(list)
 }
 
-func handleBackup(w http.ResponseWriter, r *http.Request) {
-	var req BackupRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	if req.Password == "" {
-		http.Error(w, "Password is required", http.StatusBadRequest)
+	request.Username = strings.TrimSpace(request.Username)
+	if request.Username == "" || strings.ContainsAny(request.Username, " \t/\\") {
+		http.Error(w, "Username is required and may not contain spaces or slashes", http.StatusBadRequest)
+		return
+	}
+	if err := validateRole(request.Role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validatePassword(request.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Perform SQL backup
-	success, err := performSQLBackup(req.Password, req.Location)
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("Backup error: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		})
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": success,
-		"message": "Database backup completed successfully",
-	})
-}
-
-func performSQLBackup(password, location string) (bool, error) {
-	// SQL Server connection string
-	// Assuming LogRhythmEMDB is on localhost with default instance
-	connectionString := fmt.Sprintf("server=localhost;user id=logrhythmadmin;password=%s;database=LogRhythmEMDB;encrypt=disable", password)
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
 
-	// Open database connection
-	db, err := sql.Open("mssql", connectionString)
-	if err != nil {
-		return false, fmt.Errorf("failed to connect to database: %v", err)
+	key := strings.ToLower(request.Username)
+	if _, exists := users[key]; exists {
+		http.Error(w, "User already exists", http.StatusConflict)
+		return
 	}
-	defer db.Close()
-
-	// Test connection
-	if err := db.Ping(); err != nil {
-		return false, fmt.Errorf("failed to ping database: %v", err)
+	users[key] = &User{
+		Username:     request.Username,
+		PasswordHash: string(hash),
+		Role:         request.Role,
+		Source:       UserSourceLocal,
+		CreatedAt:    time.Now(),
+	}
+	if err := saveUsersLocked(); err != nil {
+		delete(users, key)
+		log.Printf("Error saving users: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
 	}
 
-	// Create backup filename with timestamp
-	timestamp := time.Now().Format("20060102_150405")
-	backupFile := fmt.Sprintf("%s\\LogRhythmEMDB_backup_%s.bak", location, timestamp)
-
-	// Execute backup command
-	backupQuery := fmt.Sprintf("BACKUP DATABASE [LogRhythmEMDB] TO DISK = '%s' WITH FORMAT, INIT, NAME = 'LogRhythmEMDB Full Backup', SKIP, NOREWIND, NOUNLOAD, STATS = 10", backupFile)
+	log.Printf("User %s created user %s with role %s", currentUsername(r), request.Username, request.Role)
+	entry := requestAudit(r, "user.create", request.Username)
+	entry.After = auditValue(map[string]interface{}{"username": request.Username, "role": request.Role})
+	logAudit(entry)
 
-	_, err = db.Exec(backupQuery)
-	if err != nil {
-		return false, fmt.Errorf("failed to execute backup: %v", err)
-	}
-
-	log.Printf("Database backup completed successfully: %s", backupFile)
-	return true, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleApplyMode(w http.ResponseWriter, r *http.Request) {
+func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
 	var request struct {
-		Date string `json:"date"`
+		Role     *string `json:"role,omitempty"`
+		Password *string `json:"password,omitempty"`
+		Disabled *bool   `json:"disabled,omitempty"`
 	}
-
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Parse date
-	selectedDate, err := time.Parse("2006-01-02", request.Date)
-	if err != nil {
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
-		return
+	if request.Role != nil {
+		if err := validateRole(*request.Role); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-
-	// Create job
-	jobID := fmt.Sprintf("apply_%d", time.Now().Unix())
-	job := &JobStatus{
-		ID:        jobID,
-		Status:    "running",
-		Progress:  0,
-		Message:   "Analyzing hosts for retirement...",
-		StartTime: time.Now(),
+	var hash []byte
+	if request.Password != nil {
+		if err := validatePassword(*request.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(*request.Password), bcrypt.DefaultCost); err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	jobsMutex.Lock()
-	jobs[jobID] = job
-	jobsMutex.Unlock()
-
-	// Start analysis in background
-	go analyzeHostsForRetirement(jobID, selectedDate)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
-}
-
-func handleExecuteApply(w http.ResponseWriter, r *http.Request) {
-	var request ApplyRequest
-
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	usersMutex.Lock()
+	user, exists := users[strings.ToLower(username)]
+	if !exists {
+		usersMutex.Unlock()
+		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-
-	if len(request.SelectedHosts) == 0 {
-		http.Error(w, "No hosts selected", http.StatusBadRequest)
+	if hash != nil && user.Source == UserSourceOIDC {
+		usersMutex.Unlock()
+		http.Error(w, "Single sign-on users have no local password", http.StatusBadRequest)
 		return
 	}
 
-	// Create job
-	jobID := fmt.Sprintf("execute_%d", time.Now().Unix())
-	job := &JobStatus{
-		ID:        jobID,
-		Status:    "running",
-		Progress:  0,
-		Message:   "Starting retirement process...",
-		StartTime: time.Now(),
+	// Never leave the deployment without an enabled administrator
+	demoting := (request.Role != nil && *request.Role != RoleAdmin) || (request.Disabled != nil && *request.Disabled)
+	if user.Role == RoleAdmin && demoting && countEnabledAdminsLocked() <= 1 {
+		usersMutex.Unlock()
+		http.Error(w, "Cannot remove the last enabled administrator", http.StatusBadRequest)
+		return
 	}
 
-	jobsMutex.Lock()
-	jobs[jobID] = job
-	jobsMutex.Unlock()
+	entry := requestAudit(r, "user.update", user.Username)
+	entry.Before = auditValue(map[string]interface{}{"role": user.Role, "disabled": user.Disabled})
+	if request.Role != nil {
+		user.Role = *request.Role
+	}
+	if request.Disabled != nil {
+		user.Disabled = *request.Disabled
+	}
+	if hash != nil {
+		user.PasswordHash = string(hash)
+	}
+	entry.After = auditValue(map[string]interface{}{"role": user.Role, "disabled": user.Disabled, "passwordReset": hash != nil})
+	err := saveUsersLocked()
+	usersMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving users: %v", err)
+		http.Error(w, "Failed to save user", http.StatusInternalServerError)
+		return
+	}
 
-	// Start retirement in background
-	go executeRetirement(jobID, request.SelectedHosts)
+	if hash != nil || (request.Disabled != nil && *request.Disabled) {
+		destroySessions(username)
+	}
+	log.Printf("User %s updated user %s", currentUsername(r), username)
+	logAudit(entry)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleRetireCollectionHosts(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		SelectedCollectionHosts []string `json:"selectedCollectionHosts"`
-	}
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
 
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if strings.EqualFold(username, currentUsername(r)) {
+		http.Error(w, "You cannot delete your own account", http.StatusBadRequest)
 		return
 	}
 
-	if len(request.SelectedCollectionHosts) == 0 {
-		http.Error(w, "No collection hosts selected", http.StatusBadRequest)
+	usersMutex.Lock()
+	key := strings.ToLower(username)
+	user, exists := users[key]
+	if !exists {
+		usersMutex.Unlock()
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.Role == RoleAdmin && !user.Disabled && countEnabledAdminsLocked() <= 1 {
+		usersMutex.Unlock()
+		http.Error(w, "Cannot remove the last enabled administrator", http.StatusBadRequest)
+		return
+	}
+	entry := requestAudit(r, "user.delete", user.Username)
+	entry.Before = auditValue(map[string]interface{}{"role": user.Role, "disabled": user.Disabled, "source": userSource(user)})
+	delete(users, key)
+	err := saveUsersLocked()
+	usersMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving users: %v", err)
+		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
 
-	// For now, just log the selected collection hosts
-	// In a real implementation, you would retire the collection hosts here
-	log.Printf("Collection hosts selected for retirement: %v", request.SelectedCollectionHosts)
+	destroySessions(username)
+	log.Printf("User %s deleted user %s", currentUsername(r), username)
+	logAudit(entry)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": fmt.Sprintf("Collection host retirement initiated for %d hosts", len(request.SelectedCollectionHosts)),
-	})
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
-func handleExportPDF(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	jobID := vars["jobId"]
-
-	jobsMutex.RLock()
-	job, exists := jobs[jobID]
-	jobsMutex.RUnlock()
-
-	if !exists {
-		http.Error(w, "Job not found", http.StatusNotFound)
-		return
+// countEnabledAdminsLocked counts enabled admin accounts; usersMutex must be held
+func countEnabledAdminsLocked() int {
+	count := 0
+	for _, user := range users {
+		if user.Role == RoleAdmin && !user.Disabled {
+			count++
+		}
 	}
+	return count
+}
 
-	if len(job.RetirementRecords) == 0 {
-		http.Error(w, "No retirement records to export", http.StatusBadRequest)
-		return
-	}
+// Single Sign-On - OpenID Connect authorization code flow with PKCE
 
-	// Generate text report content
-	reportContent := generateTextReport(job)
+const (
+	oidcStateCookieName  = "lrcleaner_oidc_state"
+	oidcLoginTimeout     = 10 * time.Minute
+	oidcClockSkew        = 2 * time.Minute
+	oidcCallbackPath     = "/api/auth/oidc/callback"
+	defaultUsernameClaim = "preferred_username"
+	defaultGroupsClaim   = "groups"
+)
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Report_%s.txt\"", jobID))
-	w.Write(reportContent)
+// OIDCConfig configures sign-in through an OpenID Connect identity provider.
+// The client secret, if the provider issues one, lives in the OS credential store.
+type OIDCConfig struct {
+	Enabled       bool              `json:"enabled"`
+	DisplayName   string            `json:"displayName,omitempty"` // Label on the sign-in button
+	IssuerURL     string            `json:"issuerUrl"`
+	ClientID      string            `json:"clientId"`
+	RedirectURL   string            `json:"redirectUrl,omitempty"` // Defaults to <this server>/api/auth/oidc/callback
+	Scopes        []string          `json:"scopes,omitempty"`
+	UsernameClaim string            `json:"usernameClaim,omitempty"`
+	GroupsClaim   string            `json:"groupsClaim,omitempty"`
+	RoleMappings  map[string]string `json:"roleMappings"`          // IdP group -> viewer, operator or admin
+	DefaultRole   string            `json:"defaultRole,omitempty"` // Role for users in no mapped group; empty denies access
 }
 
-func handleExport(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	jobID := vars["jobId"]
-
-	log.Printf("Export request for job ID: %s", jobID)
+// oidcProvider holds the discovered endpoints and signing keys of the identity provider
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
 
-	jobsMutex.RLock()
-	job, exists := jobs[jobID]
-	jobsMutex.RUnlock()
+	keys map[string]crypto.PublicKey
+}
 
-	if !exists {
-		log.Printf("Job not found: %s", jobID)
-		http.Error(w, "Job not found", http.StatusNotFound)
-		return
-	}
+// oidcLogin is an authorization request waiting for the provider to call back
+type oidcLogin struct {
+	Verifier    string
+	Nonce       string
+	RedirectURL string
+	ExpiresAt   time.Time
+}
 
-	log.Printf("Job found: %s", jobID)
-	log.Printf("Results count: %d", len(job.Results))
-	log.Printf("HostAnalysis count: %d", len(job.HostAnalysis))
-	log.Printf("CollectionHostAnalysis count: %d", len(job.CollectionHostAnalysis))
+var (
+	oidcHTTPClient = &http.Client{Timeout: 15 * time.Second}
+	oidcMutex      sync.Mutex
+	oidcCache      *oidcProvider
+	oidcCacheKey   string
+	oidcLogins     = make(map[string]*oidcLogin)
+)
 
-	// Check which results field has data
-	var resultsToExport []AnalysisResult
-	if len(job.Results) > 0 {
-		resultsToExport = job.Results
-		log.Printf("Using job.Results for export")
-	} else if len(job.HostAnalysis) > 0 {
-		// Convert HostAnalysis to AnalysisResult format
-		log.Printf("Converting HostAnalysis to export format")
-		for _, host := range job.HostAnalysis {
-			// For host analysis, we need to get the log sources from each host
-			for _, logSource := range host.LogSources {
-				resultsToExport = append(resultsToExport, AnalysisResult{
-					ID:            logSource.ID,
-					HostID:        host.HostID,
-					HostName:      host.HostName,
-					Name:          logSource.Name,
-					LogSourceType: logSource.LogSourceType.Name,
-					MaxLogDate:    host.MaxLogDate,
-					PingResult:    host.PingResult,
-				})
-			}
+// validateOIDCConfig checks an SSO configuration before it is saved or used
+func validateOIDCConfig(cfg OIDCConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	issuer, err := url.Parse(cfg.IssuerURL)
+	if err != nil || issuer.Host == "" || (issuer.Scheme != "https" && issuer.Scheme != "http") {
+		return fmt.Errorf("issuer URL must be an absolute http(s) URL")
+	}
+	if cfg.ClientID == "" {
+		return fmt.Errorf("client ID is required")
+	}
+	if cfg.RedirectURL != "" {
+		if u, err := url.Parse(cfg.RedirectURL); err != nil || u.Host == "" {
+			return fmt.Errorf("redirect URL must be an absolute URL")
+		}
+	}
+	for group, role := range cfg.RoleMappings {
+		if strings.TrimSpace(group) == "" {
+			return fmt.Errorf("role mappings must name a group")
+		}
+		if err := validateRole(role); err != nil {
+			return fmt.Errorf("group %q: %v", group, err)
+		}
+	}
+	if cfg.DefaultRole != "" {
+		if err := validateRole(cfg.DefaultRole); err != nil {
+			return fmt.Errorf("default role: %v", err)
 		}
-	} else {
-		log.Printf("No results to export for job: %s", jobID)
-		http.Error(w, "No results to export", http.StatusBadRequest)
-		return
 	}
+	if len(cfg.RoleMappings) == 0 && cfg.DefaultRole == "" {
+		return fmt.Errorf("at least one group role mapping or a default role is required")
+	}
+	return nil
+}
 
-	if len(resultsToExport) == 0 {
-		log.Printf("No results to export for job: %s", jobID)
-		http.Error(w, "No results to export", http.StatusBadRequest)
-		return
+// getOIDCProvider returns the provider metadata, running discovery on first use
+func getOIDCProvider(cfg OIDCConfig) (*oidcProvider, error) {
+	issuer := strings.TrimSuffix(cfg.IssuerURL, "/")
+
+	oidcMutex.Lock()
+	if oidcCache != nil && oidcCacheKey == issuer {
+		provider := oidcCache
+		oidcMutex.Unlock()
+		return provider, nil
 	}
+	oidcMutex.Unlock()
 
-	// Generate CSV with all log source details
-	csv := "LogSourceID,HostID,HostName,LogSourceName,LogSourceType,MaxLogDate,PingResult\n"
-	for _, result := range resultsToExport {
-		csv += fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s\n",
-			idToString(result.ID),
-			idToString(result.HostID),
-			result.HostName,
-			result.Name,
-			result.LogSourceType,
-			result.MaxLogDate,
-			result.PingResult)
+	resp, err := oidcHTTPClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch provider metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("provider metadata request returned HTTP %d", resp.StatusCode)
 	}
 
-	log.Printf("Generated CSV for job %s, length: %d bytes", jobID, len(csv))
-	previewLength := 200
-	if len(csv) < previewLength {
-		previewLength = len(csv)
+	var provider oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("failed to parse provider metadata: %v", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider reports issuer %q, expected %q", provider.Issuer, cfg.IssuerURL)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("provider metadata is missing required endpoints")
+	}
+	if err := provider.refreshKeys(); err != nil {
+		return nil, err
 	}
-	log.Printf("CSV preview: %s", csv[:previewLength])
 
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Results_%s.csv\"", jobID))
-	w.Write([]byte(csv))
+	oidcMutex.Lock()
+	oidcCache = &provider
+	oidcCacheKey = issuer
+	oidcMutex.Unlock()
+
+	log.Printf("Discovered OIDC provider %s", provider.Issuer)
+	return &provider, nil
 }
 
-func handleJobStatus(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	jobID := vars["jobId"]
+// refreshKeys downloads the provider's current signing keys
+func (p *oidcProvider) refreshKeys() error {
+	resp, err := oidcHTTPClient.Get(p.JWKSURI)
+	if err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("signing key request returned HTTP %d", resp.StatusCode)
+	}
 
-	jobsMutex.RLock()
-	job, exists := jobs[jobID]
-	jobsMutex.RUnlock()
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to parse signing keys: %v", err)
+	}
 
-	if !exists {
-		http.Error(w, "Job not found", http.StatusNotFound)
-		return
+	keys := make(map[string]crypto.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch key.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(key.X)
+			y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[key.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("provider published no usable signing keys")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(job)
+	oidcMutex.Lock()
+	p.keys = keys
+	oidcMutex.Unlock()
+	return nil
 }
 
-// Broadcast job update to all WebSocket connections
-func broadcastJobUpdate(job *JobStatus) {
-	wsMutex.RLock()
-	defer wsMutex.RUnlock()
-
-	log.Printf("Broadcasting job update for job %s to %d WebSocket connections", job.ID, len(wsConnections))
-
-	for conn := range wsConnections {
-		if err := conn.WriteJSON(job); err != nil {
-			log.Printf("Error broadcasting job update via WebSocket: %v", err)
-			// Remove failed connection
-			delete(wsConnections, conn)
-			conn.Close()
-		} else {
-			log.Printf("Successfully broadcasted job update to WebSocket connection")
+// signingKey returns the key with the given ID, refetching once in case the provider rotated keys
+func (p *oidcProvider) signingKey(kid string) (crypto.PublicKey, error) {
+	lookup := func() crypto.PublicKey {
+		oidcMutex.Lock()
+		defer oidcMutex.Unlock()
+		if key, ok := p.keys[kid]; ok {
+			return key
 		}
+		if kid == "" && len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key
+			}
+		}
+		return nil
+	}
+
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	if err := p.refreshKeys(); err != nil {
+		return nil, err
 	}
+	if key := lookup(); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
 }
 
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+// verifyIDToken checks the ID token signature and standard claims and returns its claims
+func verifyIDToken(provider *oidcProvider, cfg OIDCConfig, rawToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ID token is not a signed JWT")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid ID token header")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token signature encoding")
+	}
+	key, err := provider.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token payload")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(claimsJSON))
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token payload")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(provider.Issuer, "/") {
+		return nil, fmt.Errorf("ID token issued by %q", iss)
+	}
+	audienceOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audienceOK = aud == cfg.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == cfg.ClientID {
+				audienceOK = true
+			}
+		}
+	}
+	if !audienceOK {
+		return nil, fmt.Errorf("ID token was not issued for this client")
+	}
+	exp, err := numericClaim(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(time.Unix(exp, 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// verifyJWTSignature checks a JWS signature for the RSA and ECDSA algorithms providers use
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signingInput))
+	digest := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		default:
+			return fmt.Errorf("algorithm %q does not match RSA signing key", alg)
+		}
+		if err != nil {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			return fmt.Errorf("algorithm %q does not match EC signing key", alg)
+		}
+		if len(signature) == 0 || len(signature)%2 != 0 {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+		size := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:size])
+		sv := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, sv) {
+			return fmt.Errorf("ID token signature is invalid")
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type")
+	}
+	return nil
+}
+
+// numericClaim reads a NumericDate claim such as exp or iat
+func numericClaim(claims map[string]interface{}, name string) (int64, error) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("ID token is missing %s", name)
+	}
+	value, err := number.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("ID token has invalid %s", name)
+	}
+	return int64(value), nil
+}
+
+// stringListClaim reads a claim that may be a single string or an array of strings
+func stringListClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// mapOIDCRole picks the most privileged role granted by the user's groups
+func mapOIDCRole(cfg OIDCConfig, groups []string) string {
+	role := cfg.DefaultRole
+	for _, group := range groups {
+		for mappedGroup, mappedRole := range cfg.RoleMappings {
+			if strings.EqualFold(group, mappedGroup) && roleRank[mappedRole] > roleRank[role] {
+				role = mappedRole
+			}
+		}
+	}
+	return role
+}
+
+// provisionOIDCUser creates or refreshes the account for an SSO user so sessions, audit
+// records and rollback points carry the identity provider's username
+func provisionOIDCUser(username, role string) (*User, error) {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+
+	key := strings.ToLower(username)
+	user, exists := users[key]
+	if exists && user.Source != UserSourceOIDC {
+		return nil, fmt.Errorf("a local account named %s already exists", username)
+	}
+	if exists && user.Disabled {
+		return nil, fmt.Errorf("account %s is disabled", username)
+	}
+	if !exists {
+		user = &User{Username: username, Source: UserSourceOIDC, CreatedAt: time.Now()}
+		users[key] = user
+	}
+	user.Role = role
+	user.LastLogin = time.Now()
+
+	if err := saveUsersLocked(); err != nil {
+		return nil, fmt.Errorf("failed to save user: %v", err)
+	}
+	userCopy := *user
+	return &userCopy, nil
+}
+
+// oidcRedirectURL returns the callback URL registered with the provider
+func oidcRedirectURL(cfg OIDCConfig, r *http.Request) string {
+	if cfg.RedirectURL != "" {
+		return cfg.RedirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallbackPath
+}
+
+// oidcFailure sends the browser back to the sign-in screen with an error message
+func oidcFailure(w http.ResponseWriter, r *http.Request, message string) {
+	log.Printf("SSO sign-in failed from %s: %s", r.RemoteAddr, message)
+	entry := requestAudit(r, "auth.sso.login", "")
+	entry.Result = auditResultFailure
+	entry.Message = message
+	logAudit(entry)
+	http.Redirect(w, r, "/?sso_error="+url.QueryEscape(message), http.StatusFound)
+}
+
+// SSO API Handlers
+
+func handleAuthProviders(w http.ResponseWriter, r *http.Request) {
+	oidc := config.Auth.OIDC
+	displayName := oidc.DisplayName
+	if displayName == "" {
+		displayName = "Single Sign-On"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"local": true,
+		"oidc": map[string]interface{}{
+			"enabled":     oidc.Enabled,
+			"displayName": displayName,
+		},
+	})
+}
+
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Auth.OIDC
+	if !cfg.Enabled {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	provider, err := getOIDCProvider(cfg)
+	if err != nil {
+		oidcFailure(w, r, fmt.Sprintf("Identity provider unavailable: %v", err))
+		return
+	}
+
+	state := randomToken(16)
+	login := &oidcLogin{
+		Verifier:    randomToken(32),
+		Nonce:       randomToken(16),
+		RedirectURL: oidcRedirectURL(cfg, r),
+		ExpiresAt:   time.Now().Add(oidcLoginTimeout),
+	}
+
+	oidcMutex.Lock()
+	for key, pending := range oidcLogins {
+		if time.Now().After(pending.ExpiresAt) {
+			delete(oidcLogins, key)
+		}
+	}
+	oidcLogins[state] = login
+	oidcMutex.Unlock()
+
+	// Bind the state to this browser; Lax so it survives the provider's redirect back
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", cfg.ClientID)
+	params.Set("redirect_uri", login.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", login.Nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+params.Encode(), http.StatusFound)
+}
+
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Auth.OIDC
+	if !cfg.Enabled {
+		http.Error(w, "Single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookieName)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Path: "/api/auth/oidc/", MaxAge: -1, HttpOnly: true, Secure: secureCookies()})
+	if err != nil || state == "" || cookie.Value != state {
+		oidcFailure(w, r, "Sign-in request did not start in this browser. Please try again.")
+		return
+	}
+
+	oidcMutex.Lock()
+	login, ok := oidcLogins[state]
+	delete(oidcLogins, state)
+	oidcMutex.Unlock()
+	if !ok || time.Now().After(login.ExpiresAt) {
+		oidcFailure(w, r, "Sign-in request expired. Please try again.")
+		return
+	}
+
+	if errCode := query.Get("error"); errCode != "" {
+		oidcFailure(w, r, fmt.Sprintf("Identity provider returned %s: %s", errCode, query.Get("error_description")))
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		oidcFailure(w, r, "Identity provider did not return an authorization code")
+		return
+	}
+
+	provider, err := getOIDCProvider(cfg)
+	if err != nil {
+		oidcFailure(w, r, fmt.Sprintf("Identity provider unavailable: %v", err))
+		return
+	}
+
+	// Exchange the code, proving possession of the PKCE verifier
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", login.RedirectURL)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("code_verifier", login.Verifier)
+	tokenReq, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		oidcFailure(w, r, "Failed to build token request")
+		return
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	if secret := GetOIDCClientSecret(); secret != "" {
+		tokenReq.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(secret))
+	}
+
+	resp, err := oidcHTTPClient.Do(tokenReq)
+	if err != nil {
+		oidcFailure(w, r, fmt.Sprintf("Token request failed: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		oidcFailure(w, r, fmt.Sprintf("Token endpoint returned HTTP %d", resp.StatusCode))
+		return
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.IDToken == "" {
+		oidcFailure(w, r, fmt.Sprintf("Token request rejected: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription))
+		return
+	}
+
+	claims, err := verifyIDToken(provider, cfg, tokenResponse.IDToken, login.Nonce)
+	if err != nil {
+		oidcFailure(w, r, err.Error())
+		return
+	}
+
+	usernameClaim := cfg.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = defaultUsernameClaim
+	}
+	username := ""
+	for _, claim := range []string{usernameClaim, "email", "sub"} {
+		if value, _ := claims[claim].(string); strings.TrimSpace(value) != "" {
+			username = strings.TrimSpace(value)
+			break
+		}
+	}
+	if username == "" {
+		oidcFailure(w, r, "ID token has no usable username claim")
+		return
+	}
+
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = defaultGroupsClaim
+	}
+	groups := stringListClaim(claims, groupsClaim)
+	role := mapOIDCRole(cfg, groups)
+	if role == "" {
+		oidcFailure(w, r, fmt.Sprintf("%s is not in any group that grants access to LRCleaner", username))
+		return
+	}
+
+	user, err := provisionOIDCUser(username, role)
+	if err != nil {
+		oidcFailure(w, r, err.Error())
+		return
+	}
+
+	createSession(w, user.Username)
+	entry := requestAudit(r, "auth.sso.login", user.Username)
+	entry.User = user.Username
+	entry.Message = fmt.Sprintf("Signed in as %s (groups: %s)", user.Role, strings.Join(groups, ", "))
+	logAudit(entry)
+	log.Printf("User %s signed in via SSO as %s from %s (groups: %s)", user.Username, user.Role, r.RemoteAddr, strings.Join(groups, ", "))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// Audit Log - append-only, hash-chained record of every action
+
+const (
+	auditLogFile       = "audit.log"
+	auditHeadFile      = "audit.head" // Sequence and hash of the newest entry, kept outside the log
+	defaultAuditLimit  = 500
+	auditResultSuccess = "success"
+	auditResultFailure = "failure"
+)
+
+// AuditEntry is one line of the audit log. Hash covers every other field including
+// PrevHash, so editing, removing or reordering lines breaks the chain.
+type AuditEntry struct {
+	Sequence  int64           `json:"sequence"`
+	Timestamp time.Time       `json:"timestamp"`
+	User      string          `json:"user"`
+	SourceIP  string          `json:"sourceIp,omitempty"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	Profile   string          `json:"profile,omitempty"` // Deployment profile the action ran against
+	Ticket    string          `json:"ticket,omitempty"`
+	JobID     string          `json:"jobId,omitempty"`
+	Result    string          `json:"result"`
+	Message   string          `json:"message,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditVerification is the outcome of checking the hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Entries  int64  `json:"entries"`
+	BrokenAt int64  `json:"brokenAt,omitempty"` // Line number of the first bad entry
+	Message  string `json:"message"`
+}
+
+// auditAnchor is the sequence and hash of an entry recorded outside audit.log, in
+// audit.head or by the SIEM receiving the syslog feed. The chain alone cannot show that
+// its tail was cut off or that the whole file was replaced; an anchor can.
+type auditAnchor struct {
+	Sequence int64  `json:"sequence"`
+	Hash     string `json:"hash"`
+	Source   string `json:"-"` // Where the anchor came from, for messages
+}
+
+var (
+	auditMutex    sync.Mutex
+	auditFile     *os.File
+	auditLastHash string
+	auditNextSeq  int64 = 1
+
+	// Newest entry written by this process or found in audit.head
+	auditHead auditAnchor
+)
+
+// openAuditLog verifies the existing log against audit.head and opens it for appending.
+// A failure is recorded as the first new entry, so it reaches the SIEM and stays in the
+// chain after audit.head moves on.
+func openAuditLog() {
+	auditMutex.Lock()
+	head, err := readAuditHead()
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	var anchors []auditAnchor
+	if head != nil {
+		anchors = append(anchors, *head)
+	}
+	path := dataPath(auditLogFile)
+	verification, lastHash, lastSeq := verifyAuditFile(path, -1, anchors...)
+	if !verification.Valid {
+		log.Printf("WARNING: audit log failed verification: %s", verification.Message)
+	}
+	auditLastHash = lastHash
+	auditNextSeq = lastSeq + 1
+	auditHead = auditAnchor{Sequence: lastSeq, Hash: lastHash, Source: auditHeadFile}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open audit log %s: %v", path, err)
+	}
+	auditFile = file
+	auditMutex.Unlock()
+	log.Printf("Audit log %s opened with %d entries", path, verification.Entries)
+
+	if !verification.Valid {
+		logAudit(AuditEntry{
+			Action:  "audit.verify",
+			Target:  auditLogFile,
+			Result:  auditResultFailure,
+			Message: "Verification at startup failed: " + verification.Message,
+		})
+	}
+}
+
+// readAuditHead returns the anchor in audit.head, or nil when there is none yet
+func readAuditHead() (*auditAnchor, error) {
+	data, err := os.ReadFile(dataPath(auditHeadFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", auditHeadFile, err)
+	}
+	var head auditAnchor
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %v", auditHeadFile, err)
+	}
+	head.Source = auditHeadFile
+	return &head, nil
+}
+
+// writeAuditHead replaces audit.head with the newest entry, writing a temporary file
+// first so a crash never leaves it half written
+func writeAuditHead(entry AuditEntry) error {
+	data, _ := json.Marshal(auditAnchor{Sequence: entry.Sequence, Hash: entry.Hash})
+	path := dataPath(auditHeadFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", auditHeadFile, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", auditHeadFile, err)
+	}
+	return nil
+}
+
+// auditHash computes the chain hash of an entry; the Hash field itself is excluded
+func auditHash(entry AuditEntry) string {
+	entry.Hash = ""
+	data, _ := json.Marshal(entry)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditValue encodes a before/after snapshot for an audit entry
+func auditValue(value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// clientIP returns the address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestAudit starts an audit entry attributed to the user and address behind r
+func requestAudit(r *http.Request, action, target string) AuditEntry {
+	user := "anonymous"
+	if signedIn := currentUser(r); signedIn != nil {
+		user = signedIn.Username
+	}
+	return AuditEntry{
+		User:     user,
+		SourceIP: clientIP(r),
+		Action:   action,
+		Target:   target,
+		Result:   auditResultSuccess,
+	}
+}
+
+// namingAudit starts an audit entry for a background retirement step
+func namingAudit(naming RetirementNaming, action, target string) AuditEntry {
+	return AuditEntry{
+		User:     naming.Operator,
+		SourceIP: naming.SourceIP,
+		Action:   action,
+		Target:   target,
+		Profile:  naming.Profile,
+		Ticket:   naming.Ticket,
+		JobID:    naming.JobID,
+		Result:   auditResultSuccess,
+	}
+}
+
+// recordAudit chains and appends an entry to the audit log, then queues it for syslog
+func recordAudit(entry AuditEntry) error {
+	entry, err := appendAudit(entry)
+	if err != nil {
+		return err
+	}
+	forwardSyslog(entry)
+	return nil
+}
+
+// appendAudit chains and writes an entry under the audit lock, returning it as written
+func appendAudit(entry AuditEntry) (AuditEntry, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	if auditFile == nil {
+		return entry, fmt.Errorf("audit log is not open")
+	}
+
+	entry.Sequence = auditNextSeq
+	entry.Timestamp = time.Now()
+	if entry.User == "" {
+		entry.User = "system"
+	}
+	if entry.Result == "" {
+		entry.Result = auditResultSuccess
+	}
+	entry.PrevHash = auditLastHash
+	entry.Hash = auditHash(entry)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return entry, fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+	if _, err := auditFile.Write(append(data, '\n')); err != nil {
+		log.Printf("ERROR: failed to write audit entry %s: %v", entry.Action, err)
+		return entry, fmt.Errorf("failed to write audit log: %v", err)
+	}
+	if err := auditFile.Sync(); err != nil {
+		log.Printf("ERROR: failed to sync audit log: %v", err)
+		return entry, fmt.Errorf("failed to write audit log: %v", err)
+	}
+
+	auditLastHash = entry.Hash
+	auditNextSeq++
+	auditHead = auditAnchor{Sequence: entry.Sequence, Hash: entry.Hash, Source: auditHeadFile}
+	if err := writeAuditHead(entry); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	return entry, nil
+}
+
+// logAudit records an entry where a failed write should not stop the operation
+func logAudit(entry AuditEntry) {
+	if err := recordAudit(entry); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+}
+
+// verifyAuditFile walks the chain, returning the verification result and the last good hash
+// and sequence. Only the first size bytes are read when size is not negative. Each anchor's
+// entry must be in the log with the anchored hash.
+func verifyAuditFile(path string, size int64, anchors ...auditAnchor) (AuditVerification, string, int64) {
+	result := AuditVerification{Valid: true, Message: "Audit log is intact"}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		for _, anchor := range anchors {
+			if anchor.Sequence > 0 {
+				return AuditVerification{Message: fmt.Sprintf("Audit log is missing, but %s records sequence %d; the log was deleted", anchor.Source, anchor.Sequence)}, "", 0
+			}
+		}
+		result.Message = "Audit log is empty"
+		return result, "", 0
+	}
+	if err != nil {
+		return AuditVerification{Message: fmt.Sprintf("Cannot read audit log: %v", err)}, "", 0
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if size >= 0 {
+		reader = io.LimitReader(file, size)
+	}
+
+	var lastHash string
+	var lastSeq int64
+	line := int64(0)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line++
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return AuditVerification{Entries: line - 1, BrokenAt: line,
+				Message: fmt.Sprintf("Line %d is not a valid audit entry", line)}, lastHash, lastSeq
+		}
+		if entry.PrevHash != lastHash || entry.Sequence != lastSeq+1 {
+			return AuditVerification{Entries: line - 1, BrokenAt: line,
+				Message: fmt.Sprintf("Line %d does not follow the previous entry; entries were removed or reordered", line)}, lastHash, lastSeq
+		}
+		if auditHash(entry) != entry.Hash {
+			return AuditVerification{Entries: line - 1, BrokenAt: line,
+				Message: fmt.Sprintf("Line %d (sequence %d) has been modified", line, entry.Sequence)}, lastHash, lastSeq
+		}
+		for _, anchor := range anchors {
+			if anchor.Sequence == entry.Sequence && anchor.Hash != entry.Hash {
+				return AuditVerification{Entries: line - 1, BrokenAt: line,
+					Message: fmt.Sprintf("Line %d (sequence %d) does not match the hash in %s; the log was rewritten", line, entry.Sequence, anchor.Source)}, lastHash, lastSeq
+			}
+		}
+		lastHash = entry.Hash
+		lastSeq = entry.Sequence
+	}
+	if err := scanner.Err(); err != nil {
+		return AuditVerification{Entries: line, Message: fmt.Sprintf("Cannot read audit log: %v", err)}, lastHash, lastSeq
+	}
+	for _, anchor := range anchors {
+		if anchor.Sequence > lastSeq {
+			return AuditVerification{Entries: line,
+				Message: fmt.Sprintf("Audit log ends at sequence %d, but %s records sequence %d; entries were removed from the end", lastSeq, anchor.Source, anchor.Sequence)}, lastHash, lastSeq
+		}
+	}
+
+	result.Entries = line
+	return result, lastHash, lastSeq
+}
+
+// auditFilter selects audit entries for the query and export APIs
+type auditFilter struct {
+	User   string
+	Action string
+	Ticket string
+	Text   string
+	From   time.Time
+	To     time.Time
+}
+
+// parseAuditFilter reads filters from the query string; dates are YYYY-MM-DD and inclusive
+func parseAuditFilter(r *http.Request) (auditFilter, error) {
+	query := r.URL.Query()
+	filter := auditFilter{
+		User:   strings.TrimSpace(query.Get("user")),
+		Action: strings.TrimSpace(query.Get("action")),
+		Ticket: strings.TrimSpace(query.Get("ticket")),
+		Text:   strings.ToLower(strings.TrimSpace(query.Get("q"))),
+	}
+	if from := query.Get("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date")
+		}
+		filter.From = t
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date")
+		}
+		filter.To = t.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (f auditFilter) matches(entry AuditEntry, line []byte) bool {
+	if f.User != "" && !strings.EqualFold(entry.User, f.User) {
+		return false
+	}
+	if f.Action != "" && !strings.HasPrefix(entry.Action, f.Action) {
+		return false
+	}
+	if f.Ticket != "" && !strings.EqualFold(entry.Ticket, f.Ticket) {
+		return false
+	}
+	if !f.From.IsZero() && entry.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Timestamp.Before(f.To) {
+		return false
+	}
+	if f.Text != "" && !strings.Contains(strings.ToLower(string(line)), f.Text) {
+		return false
+	}
+	return true
+}
+
+// readAuditEntries returns matching entries, newest first
+func readAuditEntries(filter auditFilter) ([]AuditEntry, error) {
+	file, err := os.Open(dataPath(auditLogFile))
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Reported by verification
+		}
+		if filter.matches(entry, scanner.Bytes()) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sequence > entries[j].Sequence })
+	return entries, nil
+}
+
+// Audit API Handlers
+
+func handleAuditQuery(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := readAuditEntries(filter)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+	total := len(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+		"total":   total,
+	})
+}
+
+func handleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	// An anchor taken from the SIEM also catches a log and audit.head replaced together
+	var anchors []auditAnchor
+	query := r.URL.Query()
+	if value := query.Get("sequence"); value != "" {
+		sequence, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || sequence <= 0 || query.Get("hash") == "" {
+			http.Error(w, "An anchor needs a positive sequence and its hash", http.StatusBadRequest)
+			return
+		}
+		anchors = append(anchors, auditAnchor{Sequence: sequence, Hash: strings.ToLower(query.Get("hash")), Source: "the supplied anchor"})
+	}
+
+	// Scan the entries written so far without holding up audited requests
+	auditMutex.Lock()
+	size := int64(-1)
+	if auditFile != nil {
+		if info, err := auditFile.Stat(); err == nil {
+			size = info.Size()
+		}
+	}
+	if auditHead.Sequence > 0 {
+		anchors = append(anchors, auditHead)
+	}
+	auditMutex.Unlock()
+
+	verification, _, _ := verifyAuditFile(dataPath(auditLogFile), size, anchors...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verification)
+}
+
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := readAuditEntries(filter)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		http.Error(w, "Failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	// Export in log order so the chain can be followed
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Sequence < entries[j].Sequence })
+
+	export := requestAudit(r, "audit.export", "")
+	export.Message = fmt.Sprintf("Exported %d entries as %s", len(entries), r.URL.Query().Get("format"))
+	logAudit(export)
+
+	stamp := time.Now().Format("20060102_150405")
+	switch r.URL.Query().Get("format") {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Audit_%s.json\"", stamp))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(entries)
+	case "csv", "":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Audit_%s.csv\"", stamp))
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"Sequence", "Timestamp", "User", "SourceIP", "Action", "Target", "Profile", "Ticket", "JobID", "Result", "Message", "Before", "After", "PrevHash", "Hash"})
+		for _, entry := range entries {
+			writer.Write([]string{
+				strconv.FormatInt(entry.Sequence, 10),
+				entry.Timestamp.Format(time.RFC3339),
+				entry.User,
+				entry.SourceIP,
+				entry.Action,
+				entry.Target,
+				entry.Profile,
+				entry.Ticket,
+				entry.JobID,
+				entry.Result,
+				entry.Message,
+				string(entry.Before),
+				string(entry.After),
+				entry.PrevHash,
+				entry.Hash,
+			})
+		}
+		writer.Flush()
+	default:
+		http.Error(w, "Format must be csv or json", http.StatusBadRequest)
+	}
+}
+
+// Deployment Profiles - named LogRhythm deployments, each with its own API key and rollback directory
+
+const defaultProfileName = "default"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Profile is one LogRhythm deployment LRCleaner can connect to
+type Profile struct {
+	Name        string         `json:"name"`
+	Hostname    string         `json:"hostname"`
+	Port        int            `json:"port"`
+	KeyringKey  string         `json:"keyringKey"` // Credential store entry holding this deployment's API key
+	RollbackDir string         `json:"rollbackDir"`
+	Database    DatabaseConfig `json:"database"` // SQL Server sign-in for database backups
+	Cases       CaseConfig     `json:"cases"`    // LogRhythm cases for hosts that need troubleshooting
+}
+
+// ProfileSummary is a profile as shown to the UI
+type ProfileSummary struct {
+	Profile
+	HasAPIKey     bool `json:"hasApiKey"`
+	HasDBPassword bool `json:"hasDbPassword"`
+	Default       bool `json:"default"`
+}
+
+// validateProfile checks a profile before it is saved
+func validateProfile(profile Profile) error {
+	if !profileNamePattern.MatchString(profile.Name) {
+		return fmt.Errorf("profile name must be 1-64 letters, digits, '.', '-' or '_'")
+	}
+	if strings.TrimSpace(profile.Hostname) == "" {
+		return fmt.Errorf("hostname is required")
+	}
+	if profile.Port < 1 || profile.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// findProfile returns a copy of the named profile, or the default profile when name is empty.
+// Jobs keep the copy so later edits do not change where a running job connects.
+func findProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	for _, profile := range config.Profiles {
+		if profile.Name == name {
+			found := profile
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("deployment profile %q not found", name)
+}
+
+// profileIndex returns the position of the named profile in config.Profiles, or -1
+func profileIndex(name string) int {
+	for i, profile := range config.Profiles {
+		if profile.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// rollbackDir is where rollback points for this deployment are stored
+func (p *Profile) rollbackDir() string {
+	return dataPath(p.configuredRollbackDir())
+}
+
+// configuredRollbackDir is the rollback directory as written in config.json, where a
+// relative path is relative to config.json
+func (p *Profile) configuredRollbackDir() string {
+	if p.RollbackDir != "" {
+		return p.RollbackDir
+	}
+	if config.Rollback.BackupLocation != "" {
+		return filepath.Join(config.Rollback.BackupLocation, p.Name)
+	}
+	return filepath.Join("./rollback/", p.Name)
+}
+
+// profileSummaries lists the configured profiles for the UI
+func profileSummaries() []ProfileSummary {
+	summaries := make([]ProfileSummary, 0, len(config.Profiles))
+	for _, profile := range config.Profiles {
+		summaries = append(summaries, ProfileSummary{
+			Profile:       profile,
+			HasAPIKey:     HasAPIKey(profile.KeyringKey),
+			HasDBPassword: HasDBPassword(profile.Database.KeyringKey),
+			Default:       profile.Name == config.DefaultProfile,
+		})
+	}
+	return summaries
+}
+
+// handleProfiles lists profiles or creates a new one
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"profiles":       profileSummaries(),
+			"defaultProfile": config.DefaultProfile,
+		})
+	case "POST":
+		var request struct {
+			Profile
+			APIKey  string `json:"apiKey"`
+			Default bool   `json:"default"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		profile := request.Profile
+		profile.Name = strings.TrimSpace(profile.Name)
+		profile.Hostname = strings.TrimSpace(profile.Hostname)
+		if err := validateProfile(profile); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if profileIndex(profile.Name) >= 0 {
+			http.Error(w, fmt.Sprintf("Profile %s already exists", profile.Name), http.StatusConflict)
+			return
+		}
+		profile.KeyringKey = credentialKey + "." + profile.Name
+		profile.Database = defaultDatabaseConfig(profile.Name)
+		profile.Cases = defaultCaseConfig()
+		if profile.RollbackDir == "" {
+			profile.RollbackDir = profile.configuredRollbackDir()
+		}
+		if request.APIKey != "" {
+			if err := StoreAPIKey(profile.KeyringKey, request.APIKey); err != nil {
+				log.Printf("Error storing API key: %v", err)
+				http.Error(w, "Failed to store API key", http.StatusInternalServerError)
+				return
+			}
+			entry := requestAudit(r, "apikey.store", profile.Hostname)
+			entry.Profile = profile.Name
+			logAudit(entry)
+		}
+
+		config.Profiles = append(config.Profiles, profile)
+		if request.Default {
+			config.DefaultProfile = profile.Name
+		}
+		if err := saveConfigFile(); err != nil {
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		entry := requestAudit(r, "profile.create", profile.Name)
+		entry.Profile = profile.Name
+		entry.After = auditValue(profile)
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// handleProfile updates or deletes one profile. The name and keyring entry never change.
+func handleProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	index := profileIndex(name)
+	if index < 0 {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	before := config.Profiles[index]
+
+	switch r.Method {
+	case "PUT":
+		var request struct {
+			Hostname    string `json:"hostname"`
+			Port        int    `json:"port"`
+			RollbackDir string `json:"rollbackDir"`
+			Default     bool   `json:"default"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		updated := before
+		updated.Hostname = strings.TrimSpace(request.Hostname)
+		updated.Port = request.Port
+		if request.RollbackDir != "" {
+			updated.RollbackDir = request.RollbackDir
+		}
+		if err := validateProfile(updated); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		config.Profiles[index] = updated
+		if request.Default {
+			config.DefaultProfile = name
+		}
+		if err := saveConfigFile(); err != nil {
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		entry := requestAudit(r, "profile.update", name)
+		entry.Profile = name
+		entry.Before = auditValue(before)
+		entry.After = auditValue(updated)
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	case "DELETE":
+		if len(config.Profiles) == 1 {
+			http.Error(w, "Cannot delete the only deployment profile", http.StatusConflict)
+			return
+		}
+		jobsMutex.RLock()
+		for _, job := range jobs {
+			if job.Profile == name && job.Status == "running" {
+				jobsMutex.RUnlock()
+				http.Error(w, fmt.Sprintf("Job %s is still running against this profile", job.ID), http.StatusConflict)
+				return
+			}
+		}
+		jobsMutex.RUnlock()
+
+		// Save a copy without the profile and its rules, and only then make it current
+		configMutex.Lock()
+		next := *config
+		next.Profiles = slices.Delete(slices.Clone(config.Profiles), index, index+1)
+		if next.DefaultProfile == name {
+			next.DefaultProfile = next.Profiles[0].Name
+		}
+		next.Exclusions = []ExclusionRule{}
+		for _, rule := range config.Exclusions {
+			if rule.Profile != name {
+				next.Exclusions = append(next.Exclusions, rule)
+			}
+		}
+		next.Protected = []ProtectedEntry{}
+		for _, entry := range config.Protected {
+			if entry.Profile != name {
+				next.Protected = append(next.Protected, entry)
+			}
+		}
+		next.Webhooks = []Webhook{}
+		var removedHooks []string
+		for _, hook := range config.Webhooks {
+			if hook.Profile != name {
+				next.Webhooks = append(next.Webhooks, hook)
+			} else {
+				removedHooks = append(removedHooks, hook.ID)
+			}
+		}
+		next.Email.Recipients = []EmailRoute{}
+		for _, route := range config.Email.Recipients {
+			if route.Profile != name {
+				next.Email.Recipients = append(next.Email.Recipients, route)
+			}
+		}
+		if err := writeConfigFileLocked(&next); err != nil {
+			configMutex.Unlock()
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+		config.Profiles = next.Profiles
+		config.DefaultProfile = next.DefaultProfile
+		config.Exclusions = next.Exclusions
+		config.Protected = next.Protected
+		config.Webhooks = next.Webhooks
+		config.Email.Recipients = next.Email.Recipients
+		configMutex.Unlock()
+
+		for _, id := range removedHooks {
+			if err := DeleteWebhookSecret(id); err != nil {
+				log.Printf("Error deleting webhook secret: %v", err)
+			}
+		}
+
+		// Rollback points stay on disk so they can still be reviewed or restored manually
+		if HasAPIKey(before.KeyringKey) {
+			if err := DeleteAPIKey(before.KeyringKey); err != nil {
+				log.Printf("Warning: Failed to remove API key for profile %s: %v", name, err)
+			}
+		}
+		if err := DeleteDBPassword(before.Database.KeyringKey); err != nil {
+			log.Printf("Warning: Failed to remove database password for profile %s: %v", name, err)
+		}
+
+		entry := requestAudit(r, "profile.delete", name)
+		entry.Profile = name
+		entry.Before = auditValue(before)
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// Exclusion rules - log sources analysis never offers for retirement
+
+// Fields an exclusion rule can match on
+const (
+	ExcludeByType   = "type"   // Log source type name
+	ExcludeByName   = "name"   // Log source name
+	ExcludeByHost   = "host"   // Host name or ID
+	ExcludeByAgent  = "agent"  // System monitor agent name or ID
+	ExcludeByEntity = "entity" // Entity name or ID
+	ExcludeByID     = "id"     // Log source ID
+)
+
+// How an exclusion rule or protected entry compares its pattern. All but regex ignore case.
+const (
+	MatchExact     = "exact"
+	MatchSubstring = "substring"
+	MatchGlob      = "glob"  // * and ? wildcards over the whole value
+	MatchRegex     = "regex" // Go regular expression; add (?i) to ignore case
+)
+
+// ExclusionRule keeps matching log sources out of analysis results. A rule without a
+// profile applies to every deployment; an expired rule no longer matches.
+type ExclusionRule struct {
+	ID        string     `json:"id"`
+	Profile   string     `json:"profile,omitempty"`
+	Field     string     `json:"field"`
+	Match     string     `json:"match"`
+	Pattern   string     `json:"pattern"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// defaultExclusionRules keep LogRhythm's own agents and echo test sources out of results
+func defaultExclusionRules() []ExclusionRule {
+	rules := []ExclusionRule{
+		{Field: ExcludeByType, Match: MatchGlob, Pattern: "LogRhythm*", Reason: "LogRhythm system monitor agents"},
+		{Field: ExcludeByHost, Match: MatchSubstring, Pattern: "echo", Reason: "Echo test hosts"},
+		{Field: ExcludeByName, Match: MatchSubstring, Pattern: "echo", Reason: "Echo test log sources"},
+		{Field: ExcludeByType, Match: MatchSubstring, Pattern: "Open Collector"},
+		{Field: ExcludeByType, Match: MatchSubstring, Pattern: "AI Engine"},
+	}
+	for i := range rules {
+		rules[i].ID = fmt.Sprintf("default-%d", i+1)
+	}
+	return rules
+}
+
+// exclusionPattern compiles a rule into the regular expression it matches with
+func exclusionPattern(rule ExclusionRule) (*regexp.Regexp, error) {
+	return matchPattern(rule.Match, rule.Pattern)
+}
+
+// matchPattern compiles a pattern in one of the match modes into a regular expression
+func matchPattern(match, pattern string) (*regexp.Regexp, error) {
+	switch match {
+	case MatchExact:
+		return regexp.Compile("(?i)^" + regexp.QuoteMeta(pattern) + "$")
+	case MatchSubstring:
+		return regexp.Compile("(?i)" + regexp.QuoteMeta(pattern))
+	case MatchGlob:
+		quoted := regexp.QuoteMeta(pattern)
+		quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+		quoted = strings.ReplaceAll(quoted, `\?`, ".")
+		return regexp.Compile("(?i)^" + quoted + "$")
+	case MatchRegex:
+		return regexp.Compile(pattern)
+	}
+	return nil, fmt.Errorf("match must be exact, substring, glob or regex")
+}
+
+// validateExclusionRule checks a rule before it is saved
+func validateExclusionRule(rule ExclusionRule) error {
+	switch rule.Field {
+	case ExcludeByType, ExcludeByName, ExcludeByHost, ExcludeByAgent, ExcludeByEntity, ExcludeByID:
+	default:
+		return fmt.Errorf("field must be type, name, host, agent, entity or id")
+	}
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if _, err := exclusionPattern(rule); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// exclusionValues returns the values of a log source a rule's field is compared with
+func exclusionValues(field string, ls LogSource) []string {
+	switch field {
+	case ExcludeByType:
+		return []string{ls.LogSourceType.Name}
+	case ExcludeByName:
+		return []string{ls.Name}
+	case ExcludeByHost:
+		return []string{ls.Host.Name, idToString(ls.Host.ID)}
+	case ExcludeByAgent:
+		return []string{ls.SystemMonitorName, idToString(ls.SystemMonitorID)}
+	case ExcludeByEntity:
+		return []string{ls.Entity.Name, idToString(ls.Entity.ID)}
+	case ExcludeByID:
+		return []string{idToString(ls.ID)}
+	}
+	return nil
+}
+
+// exclusionMatcher holds the compiled rules in effect for one deployment
+type exclusionMatcher struct {
+	rules    []ExclusionRule
+	patterns []*regexp.Regexp
+}
+
+// newExclusionMatcher compiles the unexpired rules that apply to the named profile
+func newExclusionMatcher(profile string) *exclusionMatcher {
+	m := &exclusionMatcher{}
+	now := time.Now()
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	for _, rule := range config.Exclusions {
+		if rule.Profile != "" && rule.Profile != profile {
+			continue
+		}
+		if rule.ExpiresAt != nil && !now.Before(*rule.ExpiresAt) {
+			continue
+		}
+		pattern, err := exclusionPattern(rule)
+		if err != nil {
+			continue
+		}
+		m.rules = append(m.rules, rule)
+		m.patterns = append(m.patterns, pattern)
+	}
+	return m
+}
+
+// match returns the first rule that excludes the log source, or nil
+func (m *exclusionMatcher) match(ls LogSource) *ExclusionRule {
+	for i, rule := range m.rules {
+		if ruleMatches(m.patterns[i], rule, ls) {
+			return &m.rules[i]
+		}
+	}
+	return nil
+}
+
+func ruleMatches(pattern *regexp.Regexp, rule ExclusionRule, ls LogSource) bool {
+	for _, value := range exclusionValues(rule.Field, ls) {
+		if value != "" && pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExclusionPreview is how many active log sources of a deployment a rule excludes
+type ExclusionPreview struct {
+	RuleID   string   `json:"ruleId,omitempty"`
+	Count    int      `json:"count"`
+	Expired  bool     `json:"expired,omitempty"`  // Expired rules exclude nothing
+	Examples []string `json:"examples,omitempty"` // First few matching log source names
+}
+
+// previewExclusions counts the active log sources each rule matches on its own
+func previewExclusions(logSources []LogSource, rules []ExclusionRule) []ExclusionPreview {
+	previews := make([]ExclusionPreview, len(rules))
+	now := time.Now()
+	for i, rule := range rules {
+		previews[i].RuleID = rule.ID
+		if rule.ExpiresAt != nil && !now.Before(*rule.ExpiresAt) {
+			previews[i].Expired = true
+			continue
+		}
+		pattern, err := exclusionPattern(rule)
+		if err != nil {
+			continue
+		}
+		for _, ls := range logSources {
+			if ls.RecordStatus == "Retired" || !ruleMatches(pattern, rule, ls) {
+				continue
+			}
+			previews[i].Count++
+			if len(previews[i].Examples) < 5 {
+				previews[i].Examples = append(previews[i].Examples, fmt.Sprintf("%s (%s)", ls.Name, ls.Host.Name))
+			}
+		}
+	}
+	return previews
+}
+
+// readExclusionRule decodes and validates a rule from a request body
+func readExclusionRule(r *http.Request) (ExclusionRule, error) {
+	var rule ExclusionRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		return rule, fmt.Errorf("Invalid JSON")
+	}
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Reason = strings.TrimSpace(rule.Reason)
+	if err := validateExclusionRule(rule); err != nil {
+		return rule, err
+	}
+	if rule.Profile != "" && profileIndex(rule.Profile) < 0 {
+		return rule, fmt.Errorf("deployment profile %q not found", rule.Profile)
+	}
+	return rule, nil
+}
+
+// exclusionIndex returns the position of the rule in config.Exclusions, or -1; configMutex
+// must be held
+func exclusionIndex(id string) int {
+	for i, rule := range config.Exclusions {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveExclusionsLocked writes config.json with rules as the exclusion list and makes them
+// current once the file is written; configMutex must be held
+func saveExclusionsLocked(rules []ExclusionRule) error {
+	next := *config
+	next.Exclusions = rules
+	if err := writeConfigFileLocked(&next); err != nil {
+		return err
+	}
+	config.Exclusions = rules
+	return nil
+}
+
+// handleExclusions lists exclusion rules (GET) or creates one (POST)
+func handleExclusions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		configMutex.RLock()
+		rules := slices.Clone(config.Exclusions)
+		configMutex.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+
+	case "POST":
+		rule, err := readExclusionRule(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		rule.ID = randomToken(8)
+		rule.CreatedBy = currentUsername(r)
+		rule.CreatedAt = &now
+
+		configMutex.Lock()
+		err = saveExclusionsLocked(append(slices.Clone(config.Exclusions), rule))
+		configMutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		entry := requestAudit(r, "exclusion.create", rule.ID)
+		entry.Profile = rule.Profile
+		entry.After = auditValue(rule)
+		entry.Message = rule.Reason
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// handleExclusion updates or deletes one exclusion rule
+func handleExclusion(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var rule ExclusionRule
+	if r.Method == "PUT" {
+		var err error
+		if rule, err = readExclusionRule(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	configMutex.Lock()
+	index := exclusionIndex(id)
+	if index < 0 {
+		configMutex.Unlock()
+		http.Error(w, "Exclusion rule not found", http.StatusNotFound)
+		return
+	}
+	before := config.Exclusions[index]
+	rules := slices.Clone(config.Exclusions)
+	if r.Method == "PUT" {
+		rule.ID = before.ID
+		rule.CreatedBy = before.CreatedBy
+		rule.CreatedAt = before.CreatedAt
+		rules[index] = rule
+	} else {
+		rules = slices.Delete(rules, index, index+1)
+	}
+	err := saveExclusionsLocked(rules)
+	configMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving config: %v", err)
+		http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		entry := requestAudit(r, "exclusion.update", id)
+		entry.Profile = rule.Profile
+		entry.Before = auditValue(before)
+		entry.After = auditValue(rule)
+		entry.Message = rule.Reason
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rule)
+
+	case "DELETE":
+		entry := requestAudit(r, "exclusion.delete", id)
+		entry.Profile = before.Profile
+		entry.Before = auditValue(before)
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// handleExclusionPreview counts the log sources each rule excludes in one deployment.
+// With a "rule" in the body only that draft rule is previewed.
+func handleExclusionPreview(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Profile string         `json:"profile"`
+		Rule    *ExclusionRule `json:"rule,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	p, err := findProfile(request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var rules []ExclusionRule
+	if request.Rule != nil {
+		if err := validateExclusionRule(*request.Rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules = []ExclusionRule{*request.Rule}
+	} else {
+		configMutex.RLock()
+		for _, rule := range config.Exclusions {
+			if rule.Profile == "" || rule.Profile == p.Name {
+				rules = append(rules, rule)
+			}
+		}
+		configMutex.RUnlock()
+	}
+
+	logSources, dataSource, err := getAllLogSources(p)
+	if err != nil {
+		log.Printf("Error getting log sources for exclusion preview: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to get log sources: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	active := 0
+	for _, ls := range logSources {
+		if ls.RecordStatus != "Retired" {
+			active++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"profile":    p.Name,
+		"dataSource": dataSource,
+		"logSources": active,
+		"rules":      previewExclusions(logSources, rules),
+	})
+}
+
+// Protected objects - log sources, hosts and agents retirement must never touch
+
+// Kinds of object a protected entry covers
+const (
+	ProtectLogSource = "logsource" // The log source itself
+	ProtectHost      = "host"      // The host, its log sources and its agents
+	ProtectAgent     = "agent"     // The System Monitor agent and the log sources it collects
+)
+
+// ProtectedEntry marks objects the execution layer refuses to retire, such as domain
+// controllers, SIEM infrastructure and crown-jewel assets. The pattern is compared with
+// the object's name and ID. An entry without a profile applies to every deployment.
+type ProtectedEntry struct {
+	ID        string     `json:"id"`
+	Profile   string     `json:"profile,omitempty"`
+	Kind      string     `json:"kind"`
+	Match     string     `json:"match"`
+	Pattern   string     `json:"pattern"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// validateProtectedEntry checks an entry before it is saved
+func validateProtectedEntry(entry ProtectedEntry) error {
+	switch entry.Kind {
+	case ProtectLogSource, ProtectHost, ProtectAgent:
+	default:
+		return fmt.Errorf("kind must be logsource, host or agent")
+	}
+	if strings.TrimSpace(entry.Pattern) == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	if strings.TrimSpace(entry.Reason) == "" {
+		return fmt.Errorf("reason is required")
+	}
+	if _, err := matchPattern(entry.Match, entry.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	return nil
+}
+
+// objectRef is the ID and name of an object as returned by the LogRhythm API
+type objectRef struct {
+	ID   string
+	Name string
+}
+
+// protectedTarget describes an object about to be changed, read from the API just before the change
+type protectedTarget struct {
+	Kind  string
+	Self  objectRef
+	Host  objectRef // Host the log source or agent belongs to
+	Agent objectRef // Agent collecting the log source
+}
+
+// ProtectionError is returned when a change is refused because the object is protected
+type ProtectionError struct {
+	Entry  ProtectedEntry
+	Target protectedTarget
+}
+
+func (e *ProtectionError) Error() string {
+	return fmt.Sprintf("refused to retire %s %q (ID %s): %s", protectedKindNames[e.Target.Kind], e.Target.Self.Name, e.Target.Self.ID, e.Reason())
+}
+
+// Reason names the entry that protects the target, and the host or agent it protects when
+// that is what covers the target
+func (e *ProtectionError) Reason() string {
+	reason := fmt.Sprintf("protected by entry %s (%s %q): %s", e.Entry.ID, e.Entry.Match, e.Entry.Pattern, e.Entry.Reason)
+	switch {
+	case e.Entry.Kind == ProtectHost && e.Target.Kind != ProtectHost:
+		reason = fmt.Sprintf("its host %q is %s", e.Target.Host.Name, reason)
+	case e.Entry.Kind == ProtectAgent && e.Target.Kind != ProtectAgent:
+		reason = fmt.Sprintf("its agent %q is %s", e.Target.Agent.Name, reason)
+	}
+	return reason
+}
+
+var protectedKindNames = map[string]string{ProtectLogSource: "log source", ProtectHost: "host", ProtectAgent: "agent"}
+
+// refOf reads the id and name of a nested API object such as "host": {"id": 1, "name": "DC01"}
+func refOf(object map[string]interface{}, key string) objectRef {
+	nested, _ := object[key].(map[string]interface{})
+	if nested == nil {
+		return objectRef{}
+	}
+	ref := objectRef{}
+	if id, ok := nested["id"]; ok && id != nil {
+		ref.ID = idToString(id)
+	}
+	ref.Name, _ = nested["name"].(string)
+	return ref
+}
+
+// selfRef reads the id and name of an API object
+func selfRef(object map[string]interface{}, id interface{}) objectRef {
+	name, _ := object["name"].(string)
+	return objectRef{ID: idToString(id), Name: name}
+}
+
+// logSourceTarget describes a log source from its API object
+func logSourceTarget(logSource map[string]interface{}, id interface{}) protectedTarget {
+	target := protectedTarget{Kind: ProtectLogSource, Self: selfRef(logSource, id), Host: refOf(logSource, "host")}
+	if agentID, ok := logSource["systemMonitorId"]; ok && agentID != nil {
+		target.Agent.ID = idToString(agentID)
+	}
+	target.Agent.Name, _ = logSource["systemMonitorName"].(string)
+	return target
+}
+
+// agentTarget describes a System Monitor agent from its API object
+func agentTarget(agent map[string]interface{}, id interface{}) protectedTarget {
+	target := protectedTarget{Kind: ProtectAgent, Self: selfRef(agent, id), Host: refOf(agent, "host")}
+	if target.Host.ID == "" {
+		if hostID, ok := agent["hostId"]; ok && hostID != nil {
+			target.Host.ID = idToString(hostID)
+		}
+		target.Host.Name, _ = agent["hostName"].(string)
+	}
+	return target
+}
+
+// checkProtected refuses changes to protected objects. There is no override: a protected
+// object has to be removed from the list before it can be retired. An entry whose pattern
+// no longer compiles refuses everything in its scope rather than silently protecting nothing.
+func checkProtected(p *Profile, target protectedTarget) error {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	for _, entry := range config.Protected {
+		if entry.Profile != "" && entry.Profile != p.Name {
+			continue
+		}
+
+		var refs []objectRef
+		switch entry.Kind {
+		case target.Kind:
+			refs = []objectRef{target.Self}
+		case ProtectHost:
+			refs = []objectRef{target.Host}
+		case ProtectAgent:
+			refs = []objectRef{target.Agent}
+		}
+		if len(refs) == 0 {
+			continue
+		}
+
+		pattern, err := matchPattern(entry.Match, entry.Pattern)
+		if err != nil {
+			return fmt.Errorf("refused to retire %s %s: protected entry %s is invalid (%v)", protectedKindNames[target.Kind], target.Self.ID, entry.ID, err)
+		}
+		for _, ref := range refs {
+			for _, value := range []string{ref.Name, ref.ID} {
+				if value != "" && pattern.MatchString(value) {
+					return &ProtectionError{Entry: entry, Target: target}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readProtectedEntry decodes and validates a protected entry from a request body
+func readProtectedEntry(r *http.Request) (ProtectedEntry, error) {
+	var entry ProtectedEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		return entry, fmt.Errorf("Invalid JSON")
+	}
+	entry.Pattern = strings.TrimSpace(entry.Pattern)
+	entry.Reason = strings.TrimSpace(entry.Reason)
+	if err := validateProtectedEntry(entry); err != nil {
+		return entry, err
+	}
+	if entry.Profile != "" && profileIndex(entry.Profile) < 0 {
+		return entry, fmt.Errorf("deployment profile %q not found", entry.Profile)
+	}
+	return entry, nil
+}
+
+// protectedIndex returns the position of the entry in config.Protected, or -1; configMutex
+// must be held
+func protectedIndex(id string) int {
+	for i, entry := range config.Protected {
+		if entry.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveProtectedLocked writes config.json with entries as the protected list and makes them
+// current once the file is written; configMutex must be held. Retirements check the list
+// under the read lock, so none sees an entry that was never saved.
+func saveProtectedLocked(entries []ProtectedEntry) error {
+	next := *config
+	next.Protected = entries
+	if err := writeConfigFileLocked(&next); err != nil {
+		return err
+	}
+	config.Protected = entries
+	return nil
+}
+
+// handleProtected lists protected entries (GET) or creates one (POST)
+func handleProtected(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		configMutex.RLock()
+		entries := slices.Clone(config.Protected)
+		configMutex.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	case "POST":
+		entry, err := readProtectedEntry(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		entry.ID = randomToken(8)
+		entry.CreatedBy = currentUsername(r)
+		entry.CreatedAt = &now
+
+		configMutex.Lock()
+		err = saveProtectedLocked(append(slices.Clone(config.Protected), entry))
+		configMutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		audit := requestAudit(r, "protection.create", entry.ID)
+		audit.Profile = entry.Profile
+		audit.After = auditValue(entry)
+		audit.Message = entry.Reason
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+	}
+}
+
+// handleProtectedEntry updates or deletes one protected entry
+func handleProtectedEntry(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var entry ProtectedEntry
+	if r.Method == "PUT" {
+		var err error
+		if entry, err = readProtectedEntry(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	configMutex.Lock()
+	index := protectedIndex(id)
+	if index < 0 {
+		configMutex.Unlock()
+		http.Error(w, "Protected entry not found", http.StatusNotFound)
+		return
+	}
+	before := config.Protected[index]
+	entries := slices.Clone(config.Protected)
+	if r.Method == "PUT" {
+		entry.ID = before.ID
+		entry.CreatedBy = before.CreatedBy
+		entry.CreatedAt = before.CreatedAt
+		entries[index] = entry
+	} else {
+		entries = slices.Delete(entries, index, index+1)
+	}
+	err := saveProtectedLocked(entries)
+	configMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving config: %v", err)
+		http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		audit := requestAudit(r, "protection.update", id)
+		audit.Profile = entry.Profile
+		audit.Before = auditValue(before)
+		audit.After = auditValue(entry)
+		audit.Message = entry.Reason
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+
+	case "DELETE":
+		audit := requestAudit(r, "protection.delete", id)
+		audit.Profile = before.Profile
+		audit.Before = auditValue(before)
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// LogRhythm API TLS - certificate verification, pinning and client certificates
+
+// API TLS verification modes
+const (
+	APITLSInsecure = "insecure" // No verification; the behaviour of earlier versions
+	APITLSPinned   = "pinned"   // Trust-on-first-use: the server certificate must match a confirmed fingerprint
+	APITLSStrict   = "strict"   // Full chain and hostname verification against system roots plus the CA bundle
+)
+
+// APITLSConfig controls how LRCleaner verifies the LogRhythm API server
+type APITLSConfig struct {
+	Mode               string   `json:"mode"`
+	CABundle           string   `json:"caBundle,omitempty"`           // PEM file of additional trusted CAs
+	PinnedFingerprints []string `json:"pinnedFingerprints,omitempty"` // SHA-256 of trusted server certificates
+	ClientCertFile     string   `json:"clientCertFile,omitempty"`     // PEM client certificate for mutual TLS
+	ClientKeyFile      string   `json:"clientKeyFile,omitempty"`
+}
+
+// CertificateDetails describes one certificate in a presented chain
+type CertificateDetails struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	SerialHex   string    `json:"serial"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	IsCA        bool      `json:"isCA"`
+}
+
+// apiTLSState is the loaded form of APITLSConfig used during handshakes
+type apiTLSState struct {
+	cfg        APITLSConfig
+	roots      *x509.CertPool
+	clientCert *tls.Certificate
+}
+
+var (
+	apiTLSMutex   sync.RWMutex
+	apiTLS        = &apiTLSState{cfg: APITLSConfig{Mode: APITLSInsecure}}
+	apiTransports []*http.Transport
+)
+
+// validateAPITLSConfig checks API TLS settings before they are saved
+func validateAPITLSConfig(cfg APITLSConfig) error {
+	switch cfg.Mode {
+	case APITLSInsecure, APITLSPinned, APITLSStrict:
+	default:
+		return fmt.Errorf("mode must be insecure, pinned or strict")
+	}
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return fmt.Errorf("client certificate and key files must be supplied together")
+	}
+	for _, fingerprint := range cfg.PinnedFingerprints {
+		if _, err := normalizeFingerprint(fingerprint); err != nil {
+			return err
+		}
+	}
+	_, err := loadAPITLSState(cfg)
+	return err
+}
+
+// normalizeFingerprint accepts SHA-256 fingerprints with or without separators
+func normalizeFingerprint(fingerprint string) (string, error) {
+	cleaned := strings.ToUpper(strings.NewReplacer(":", "", " ", "", "-", "").Replace(fingerprint))
+	raw, err := hex.DecodeString(cleaned)
+	if err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("%q is not a SHA-256 fingerprint", fingerprint)
+	}
+	return formatFingerprint(raw), nil
+}
+
+// loadAPITLSState reads the CA bundle and client certificate named in cfg
+func loadAPITLSState(cfg APITLSConfig) (*apiTLSState, error) {
+	state := &apiTLSState{cfg: cfg}
+
+	if cfg.Mode == APITLSStrict {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool() // Windows before Go 1.18 and some minimal systems
+		}
+		if cfg.CABundle != "" {
+			pemData, err := os.ReadFile(cfg.CABundle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+			}
+			if !roots.AppendCertsFromPEM(pemData) {
+				return nil, fmt.Errorf("CA bundle %s contains no certificates", cfg.CABundle)
+			}
+		}
+		state.roots = roots
+	}
+
+	if cfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		state.clientCert = &cert
+	}
+
+	pins := make([]string, 0, len(cfg.PinnedFingerprints))
+	for _, fingerprint := range cfg.PinnedFingerprints {
+		normalized, err := normalizeFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, normalized)
+	}
+	state.cfg.PinnedFingerprints = pins
+	return state, nil
+}
+
+// applyAPITLSConfig activates new settings and drops connections made under the old ones
+func applyAPITLSConfig(cfg APITLSConfig) error {
+	state, err := loadAPITLSState(cfg)
+	if err != nil {
+		return err
+	}
+	activateAPITLSState(state)
+	return nil
+}
+
+// activateAPITLSState makes settings loaded by loadAPITLSState current. Handlers load and
+// check them first and activate them once config.json holds them.
+func activateAPITLSState(state *apiTLSState) {
+	apiTLSMutex.Lock()
+	apiTLS = state
+	transports := apiTransports
+	apiTLSMutex.Unlock()
+
+	for _, transport := range transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// newAPIClient returns an HTTP client for the LogRhythm API that verifies the
+// server according to the current APITLSConfig
+func newAPIClient(timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			// Verification happens in VerifyConnection so settings can change without a restart
+			InsecureSkipVerify: true,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				apiTLSMutex.RLock()
+				state := apiTLS
+				apiTLSMutex.RUnlock()
+				return verifyAPIChain(state, cs.PeerCertificates, cs.ServerName)
+			},
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				apiTLSMutex.RLock()
+				defer apiTLSMutex.RUnlock()
+				if apiTLS.clientCert == nil {
+					return &tls.Certificate{}, nil // No client certificate configured
+				}
+				return apiTLS.clientCert, nil
+			},
+		},
+	}
+
+	apiTLSMutex.Lock()
+	apiTransports = append(apiTransports, transport)
+	apiTLSMutex.Unlock()
+
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// untrustedCertificateError means a pinned-mode server presented a certificate nobody has confirmed yet
+type untrustedCertificateError struct {
+	Fingerprint string
+}
+
+func (e *untrustedCertificateError) Error() string {
+	return fmt.Sprintf("LogRhythm API certificate %s is not trusted yet; confirm it with Test Connection in Settings", e.Fingerprint)
+}
+
+// verifyAPIChain applies the configured verification to a presented certificate chain
+func verifyAPIChain(state *apiTLSState, certs []*x509.Certificate, serverName string) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	leaf := certs[0]
+	sum := sha256.Sum256(leaf.Raw)
+	fingerprint := formatFingerprint(sum[:])
+
+	pinned := false
+	for _, pin := range state.cfg.PinnedFingerprints {
+		if pin == fingerprint {
+			pinned = true
+		}
+	}
+
+	switch state.cfg.Mode {
+	case APITLSInsecure:
+		return nil
+	case APITLSPinned:
+		if !pinned {
+			return &untrustedCertificateError{Fingerprint: fingerprint}
+		}
+		return nil
+	case APITLSStrict:
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         state.roots,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		}); err != nil {
+			return fmt.Errorf("certificate verification failed: %v", err)
+		}
+		// Pins narrow strict mode further when present
+		if len(state.cfg.PinnedFingerprints) > 0 && !pinned {
+			return fmt.Errorf("certificate %s does not match any pinned fingerprint", fingerprint)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown TLS mode %q", state.cfg.Mode)
+}
+
+// describeChain summarises a presented certificate chain for the connection test
+func describeChain(certs []*x509.Certificate) []CertificateDetails {
+	chain := make([]CertificateDetails, 0, len(certs))
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.Raw)
+		details := CertificateDetails{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			SerialHex:   strings.ToUpper(cert.SerialNumber.Text(16)),
+			NotBefore:   cert.NotBefore,
+			NotAfter:    cert.NotAfter,
+			DNSNames:    cert.DNSNames,
+			Fingerprint: formatFingerprint(sum[:]),
+			IsCA:        cert.IsCA,
+		}
+		for _, ip := range cert.IPAddresses {
+			details.IPAddresses = append(details.IPAddresses, ip.String())
+		}
+		chain = append(chain, details)
+	}
+	return chain
+}
+
+// inspectAPICertificate connects to the API without verification and checks the
+// presented chain against the current settings
+func inspectAPICertificate(hostname string, port int) (map[string]interface{}, error) {
+	apiTLSMutex.RLock()
+	state := apiTLS
+	apiTLSMutex.RUnlock()
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: true, ServerName: hostname}
+	if state.clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*state.clientCert}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(hostname, strconv.Itoa(port)), tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+
+	cs := conn.ConnectionState()
+	result := map[string]interface{}{
+		"mode":        state.cfg.Mode,
+		"tlsVersion":  tls.VersionName(cs.Version),
+		"cipherSuite": tls.CipherSuiteName(cs.CipherSuite),
+		"chain":       describeChain(cs.PeerCertificates),
+		"trusted":     true,
+		"errors":      []string{},
+	}
+	if len(cs.PeerCertificates) > 0 {
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		result["fingerprint"] = formatFingerprint(sum[:])
+	}
+
+	if err := verifyAPIChain(state, cs.PeerCertificates, hostname); err != nil {
+		result["trusted"] = false
+		result["errors"] = []string{err.Error()}
+		if _, ok := err.(*untrustedCertificateError); ok {
+			result["needsTrust"] = true
+		}
+	}
+	if state.cfg.Mode == APITLSInsecure {
+		result["errors"] = []string{"Certificate verification is disabled (insecure mode)"}
+	}
+	return result, nil
+}
+
+// handleTrustCertificate pins a fingerprint the admin confirmed after a connection test
+func handleTrustCertificate(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	fingerprint, err := normalizeFingerprint(request.Fingerprint)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The pin is only trusted once config.json holds it
+	configMutex.Lock()
+	before := config.APITLS
+	if slices.Contains(before.PinnedFingerprints, fingerprint) {
+		configMutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Certificate already trusted"})
+		return
+	}
+	updated := before
+	updated.PinnedFingerprints = append(slices.Clone(before.PinnedFingerprints), fingerprint)
+	state, err := loadAPITLSState(updated)
+	if err != nil {
+		configMutex.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	next := *config
+	next.APITLS = updated
+	if err = writeConfigFileLocked(&next); err == nil {
+		config.APITLS = updated
+		activateAPITLSState(state)
+	}
+	configMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving config: %v", err)
+		http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+
+	entry := requestAudit(r, "tls.trust", fingerprint)
+	entry.Message = "Pinned LogRhythm API certificate"
+	entry.Before = auditValue(before)
+	entry.After = auditValue(updated)
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Certificate trusted"})
+}
+
+// Web Server TLS - HTTPS with a generated self-signed or supplied certificate
+
+const (
+	generatedCertFile  = "lrcleaner-cert.pem"
+	generatedKeyFile   = "lrcleaner-key.pem"
+	generatedCertDays  = 397
+	certRenewalWindow  = 30 * 24 * time.Hour
+	defaultBindAddress = "" // All interfaces, as before TLS support
+)
+
+// ServerConfig controls how the LRCleaner web server listens
+type ServerConfig struct {
+	BindAddress string          `json:"bindAddress"` // "" for all interfaces, "127.0.0.1" for this machine only
+	TLS         ServerTLSConfig `json:"tls"`
+}
+
+// ServerTLSConfig selects HTTPS and its certificate. With no files given a
+// self-signed certificate is generated next to config.json.
+type ServerTLSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CertFile   string `json:"certFile,omitempty"`
+	KeyFile    string `json:"keyFile,omitempty"`
+	HSTSMaxAge int    `json:"hstsMaxAge"` // Seconds; 0 disables the Strict-Transport-Security header. Sent only with certFile
+}
+
+// ServerCertificateInfo describes the certificate the web server presents
+type ServerCertificateInfo struct {
+	Subject     string    `json:"subject"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	NotAfter    time.Time `json:"notAfter"`
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the DER certificate
+	SelfSigned  bool      `json:"selfSigned"`
+	CertFile    string    `json:"certFile"`
+}
+
+var serverCertificate *ServerCertificateInfo
+
+// validateServerConfig checks the listener settings before they are saved
+func validateServerConfig(sc ServerConfig) error {
+	if sc.BindAddress != "" && net.ParseIP(sc.BindAddress) == nil && sc.BindAddress != "localhost" {
+		return fmt.Errorf("bind address must be an IP address, localhost or empty for all interfaces")
+	}
+	if (sc.TLS.CertFile == "") != (sc.TLS.KeyFile == "") {
+		return fmt.Errorf("certificate and key files must be supplied together")
+	}
+	if sc.TLS.HSTSMaxAge < 0 {
+		return fmt.Errorf("HSTS max age cannot be negative")
+	}
+	return nil
+}
+
+// secureCookies reports whether cookies should carry the Secure flag. It follows the
+// running listener, not saved settings that only apply after a restart.
+func secureCookies() bool {
+	return serverCertificate != nil
+}
+
+// serverCertificatePaths returns the certificate and key to serve, generating a
+// self-signed pair next to config.json when none is supplied or it is about to expire
+func serverCertificatePaths() (string, string, error) {
+	tlsConfig := config.Server.TLS
+	if tlsConfig.CertFile != "" {
+		return tlsConfig.CertFile, tlsConfig.KeyFile, nil
+	}
+
+	certFile := dataPath(generatedCertFile)
+	keyFile := dataPath(generatedKeyFile)
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil &&
+			time.Until(leaf.NotAfter) > certRenewalWindow {
+			return certFile, keyFile, nil
+		}
+		log.Printf("Self-signed certificate %s is expiring, generating a new one", certFile)
+	}
+
+	if err := generateSelfSignedCertificate(certFile, keyFile); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// generateSelfSignedCertificate writes a new ECDSA certificate valid for this machine's names
+func generateSelfSignedCertificate(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	dnsNames := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	ipAddresses := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	if ip := net.ParseIP(config.Server.BindAddress); ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
+		ipAddresses = append(ipAddresses, ip)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: dnsNames[len(dnsNames)-1], Organization: []string{"LRCleaner"}},
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddresses,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(0, 0, generatedCertDays),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", keyFile, err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", certFile, err)
+	}
+
+	log.Printf("Generated self-signed certificate %s for %s", certFile, strings.Join(dnsNames, ", "))
+	return nil
+}
+
+// describeCertificate summarises the leaf certificate in certFile
+func describeCertificate(certFile, keyFile string) (*ServerCertificateInfo, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s: %v", certFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %v", certFile, err)
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	info := &ServerCertificateInfo{
+		Subject:     leaf.Subject.String(),
+		DNSNames:    leaf.DNSNames,
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: formatFingerprint(sum[:]),
+		SelfSigned:  leaf.Subject.String() == leaf.Issuer.String(),
+		CertFile:    certFile,
+	}
+	for _, ip := range leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+// formatFingerprint renders a digest as colon-separated upper-case hex
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// securityHeaders adds HSTS when the server is on HTTPS with a certificate the operator
+// supplied. HSTS covers every port of the host name, so it is never sent with the
+// generated certificate, which is usually served as localhost.
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && config.Server.TLS.CertFile != "" && config.Server.TLS.HSTSMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", config.Server.TLS.HSTSMaxAge))
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// browserURL is the address to open and print for the given scheme and port
+func browserURL(scheme string, port int) string {
+	host := "localhost"
+	if ip := net.ParseIP(config.Server.BindAddress); ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
+		host = config.Server.BindAddress
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func findAvailablePort() int {
+	fmt.Println("LRCleaner - LogRhythm Log Source Management Tool")
+	fmt.Println("================================================")
+	fmt.Println()
+
+	// Check for command line argument first
+	if flag.NArg() > 0 {
+		if port, err := strconv.Atoi(flag.Arg(0)); err == nil && port > 0 && port <= 65535 {
+			if isPortAvailable(port) {
+				fmt.Printf("Using port %d from command line argument\n", port)
+				return port
+			} else {
+				fmt.Printf("Port %d from command line is not available, finding alternative...\n", port)
+			}
+		}
+	}
+
+	// Try default port 8080 first
+	if isPortAvailable(8080) {
+		fmt.Println("Using default port 8080")
+		return 8080
+	}
+
+	// If 8080 is not available, find an available port starting from 8000
+	fmt.Println("Port 8080 is in use, searching for available port...")
+
+	for port := 8000; port <= 8443; port++ {
+		if isPortAvailable(port) {
+			fmt.Printf("Found available port: %d\n", port)
+			return port
+		}
+	}
+
+	// This should never happen, but just in case
+	fmt.Println("No available ports found, using 8080 anyway")
+	return 8080
+}
+
+func isPortAvailable(port int) bool {
+	address := net.JoinHostPort(config.Server.BindAddress, strconv.Itoa(port))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+func openBrowser(url string) {
+	// Wait a moment for the server to start
+	time.Sleep(2 * time.Second)
+
+	var err error
+
+	switch runtime.GOOS {
+	case "linux":
+		err = exec.Command("xdg-open", url).Start()
+	case "windows":
+		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		err = exec.Command("open", url).Start()
+	default:
+		fmt.Printf("Please open your browser and navigate to: %s\n", url)
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("Could not automatically open browser. Please navigate to: %s\n", url)
+	} else {
+		fmt.Printf("Opening browser to: %s\n", url)
+	}
+}
+
+// loadRollbackFiles loads the rollback points of every deployment profile
+func loadRollbackFiles() {
+	loaded := make(map[string]bool)
+	for i := range config.Profiles {
+		rollbackDir := filepath.Clean(config.Profiles[i].rollbackDir())
+		if loaded[rollbackDir] {
+			continue
+		}
+		loaded[rollbackDir] = true
+		loadProfileRollbackFiles(config.Profiles[i].Name, rollbackDir)
+	}
+}
+
+// loadProfileRollbackFiles loads rollback points from one directory. Points written before
+// profiles existed carry no profile and are attributed to the profile owning the directory.
+func loadProfileRollbackFiles(profile, rollbackDir string) {
+	// Check if rollback directory exists
+	if _, err := os.Stat(rollbackDir); os.IsNotExist(err) {
+		log.Printf("Rollback directory does not exist: %s", rollbackDir)
+		return
+	}
+
+	// Read all JSON files in the rollback directory
+	files, err := filepath.Glob(filepath.Join(rollbackDir, "*.json"))
+	if err != nil {
+		log.Printf("Error reading rollback directory: %v", err)
+		return
+	}
+
+	log.Printf("Found %d rollback files to load", len(files))
+
+	loadedCount := 0
+	for _, file := range files {
+		// Read the file
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("Error reading rollback file %s: %v", file, err)
+			continue
+		}
+
+		// Parse the rollback data
+		var rollbackData RollbackData
+		if err := json.Unmarshal(data, &rollbackData); err != nil {
+			log.Printf("Error parsing rollback file %s: %v", file, err)
+			continue
+		}
+
+		// Verify checksum if present (it covers the data with an empty checksum field)
+		if rollbackData.Checksum != "" {
+			unsigned := rollbackData
+			unsigned.Checksum = ""
+			unsignedData, _ := json.Marshal(unsigned)
+			expectedChecksum := calculateChecksum(unsignedData)
+			if rollbackData.Checksum != expectedChecksum {
+				log.Printf("Checksum mismatch for rollback file %s, skipping", file)
+				continue
+			}
+		}
+
+		if rollbackData.Profile == "" {
+			rollbackData.Profile = profile
+		}
+		rollbackData.filePath = file
+
+		// Store in memory
+		rollbackMutex.Lock()
+		rollbackHistory[rollbackData.ID] = &rollbackData
+		rollbackMutex.Unlock()
+
+		loadedCount++
+		log.Printf("Loaded rollback file: %s (ID: %s)", file, rollbackData.ID)
+	}
+
+	log.Printf("Successfully loaded %d rollback files", loadedCount)
+}
+
+func main() {
+	configFlag := flag.String("config", "", "path to config.json (default: $LRCLEANER_CONFIG, else next to the executable)")
+	checkConfig := flag.Bool("check-config", false, "validate config.json and the LRCLEANER_* environment, print any problems and exit")
+	htmlReport := flag.String("html-report", "", "write an offline HTML report of a job saved from GET /api/jobs/{jobId} and exit")
+	reportTemplate := flag.String("template", "", "report template for -html-report (default: reports.defaultTemplate)")
+	reportOutput := flag.String("output", "", "file for -html-report (default: the job file with the template's extension)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [port]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	configPath = resolveConfigPath(*configFlag)
+
+	if *checkConfig {
+		os.Exit(runConfigCheck())
+	}
+	if *htmlReport != "" {
+		os.Exit(runHTMLReport(*htmlReport, *reportTemplate, *reportOutput))
+	}
+
+	// Initialize configuration
+	var err error
+	config, err = loadConfig(true)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	for _, issue := range configIssues {
+		fmt.Printf("WARNING: %s: %s\n", configPath, issue)
+	}
+	if len(activeEnvOverrides) > 0 {
+		fmt.Printf("Settings from the environment: %s\n", strings.Join(activeEnvOverrides, ", "))
+	}
+
+	// Find available port (tries 8080 first, then 8000-8443)
+	port := findAvailablePort()
+
+	// Load existing rollback files
+	loadRollbackFiles()
+
+	// Load local user accounts
+	loadUsers()
+
+	// Load the LogRhythm cases opened for troubleshooting hosts
+	loadCaseLinks()
+
+	// Load the database backups the backup gate accepts
+	loadBackupHistory()
+
+	// Open the audit log before anything can change, forwarding it to syslog when configured
+	startSyslogForwarder()
+	openAuditLog()
+	logAudit(AuditEntry{Action: "system.start", Message: fmt.Sprintf("LRCleaner started on port %d", port)})
+
+	// Setup HTTP client for the LogRhythm API
+	if err := applyAPITLSConfig(config.APITLS); err != nil {
+		log.Fatalf("Invalid LogRhythm API TLS settings: %v", err)
+	}
+	if config.APITLS.Mode == APITLSInsecure {
+		fmt.Println("WARNING: LogRhythm API certificates are not verified (apiTls.mode is insecure).")
+	}
+	httpClient = newAPIClient(30 * time.Second)
+
+	// Setup routes
+	router := mux.NewRouter()
+
+	// Static files (embedded web UI)
+	staticFS, _ := fs.Sub(webFiles, "web/static")
+	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
+	router.HandleFunc("/", serveIndex)
+
+	// Authentication routes (login is the only unauthenticated API)
+	api := router.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/auth/login", handleLogin).Methods("POST")
+	api.HandleFunc("/auth/logout", handleLogout).Methods("POST")
+	api.HandleFunc("/auth/providers", handleAuthProviders).Methods("GET")
+	api.HandleFunc("/auth/oidc/login", handleOIDCLogin).Methods("GET")
+	api.HandleFunc("/auth/oidc/callback", handleOIDCCallback).Methods("GET")
+	api.HandleFunc("/auth/me", requireRole(RoleViewer, handleCurrentUser)).Methods("GET")
+	api.HandleFunc("/auth/password", requireRole(RoleViewer, handleChangePassword)).Methods("POST")
+
+	// API routes
+	api.HandleFunc("/config", requireRole(RoleViewer, handleConfig)).Methods("GET")
+	api.HandleFunc("/config", requireRole(RoleAdmin, handleConfig)).Methods("POST")
+	api.HandleFunc("/test-connection", requireRole(RoleAdmin, handleTestConnection)).Methods("POST")
+	api.HandleFunc("/profiles", requireRole(RoleViewer, handleProfiles)).Methods("GET")
+	api.HandleFunc("/profiles", requireRole(RoleAdmin, handleProfiles)).Methods("POST")
+	api.HandleFunc("/profiles/{name}", requireRole(RoleAdmin, handleProfile)).Methods("PUT", "DELETE")
+	api.HandleFunc("/profiles/{name}/database", requireRole(RoleAdmin, handleProfileDatabase)).Methods("PUT")
+	api.HandleFunc("/profiles/{name}/cases", requireRole(RoleAdmin, handleProfileCases)).Methods("PUT")
+	api.HandleFunc("/exclusions", requireRole(RoleViewer, handleExclusions)).Methods("GET")
+	api.HandleFunc("/exclusions", requireRole(RoleAdmin, handleExclusions)).Methods("POST")
+	api.HandleFunc("/exclusions/preview", requireRole(RoleViewer, handleExclusionPreview)).Methods("POST")
+	api.HandleFunc("/exclusions/{id}", requireRole(RoleAdmin, handleExclusion)).Methods("PUT", "DELETE")
+	api.HandleFunc("/protected", requireRole(RoleViewer, handleProtected)).Methods("GET")
+	api.HandleFunc("/protected", requireRole(RoleAdmin, handleProtected)).Methods("POST")
+	api.HandleFunc("/protected/{id}", requireRole(RoleAdmin, handleProtectedEntry)).Methods("PUT", "DELETE")
+	api.HandleFunc("/webhooks", requireRole(RoleAdmin, handleWebhooks)).Methods("GET", "POST")
+	api.HandleFunc("/webhooks/deliveries", requireRole(RoleAdmin, handleWebhookDeliveries)).Methods("GET")
+	api.HandleFunc("/webhooks/{id}", requireRole(RoleAdmin, handleWebhook)).Methods("PUT", "DELETE")
+	api.HandleFunc("/webhooks/{id}/test", requireRole(RoleAdmin, handleWebhookTest)).Methods("POST")
+	api.HandleFunc("/email/test", requireRole(RoleAdmin, handleEmailTest)).Methods("POST")
+	api.HandleFunc("/syslog/status", requireRole(RoleAdmin, handleSyslogStatus)).Methods("GET")
+	api.HandleFunc("/syslog/test", requireRole(RoleAdmin, handleSyslogTest)).Methods("POST")
+	api.HandleFunc("/tls/trust", requireRole(RoleAdmin, handleTrustCertificate)).Methods("POST")
+	api.HandleFunc("/test", requireRole(RoleViewer, handleTestMode)).Methods("POST")
+	api.HandleFunc("/backup", requireRole(RoleOperator, handleBackup)).Methods("POST")
+	api.HandleFunc("/backups", requireRole(RoleViewer, handleBackups)).Methods("GET")
+	api.HandleFunc("/backup/gate", requireRole(RoleViewer, handleBackupGate)).Methods("GET")
+	api.HandleFunc("/apply", requireRole(RoleViewer, handleApplyMode)).Methods("POST")
+	api.HandleFunc("/apply/execute", requireRole(RoleOperator, handleExecuteApply)).Methods("POST")
+	api.HandleFunc("/collection-hosts/retire", requireRole(RoleOperator, handleRetireCollectionHosts)).Methods("POST")
+	api.HandleFunc("/export/{jobId}", requireRole(RoleViewer, handleExport)).Methods("GET")
+	api.HandleFunc("/export/pdf/{jobId}", requireRole(RoleViewer, handleExportPDF)).Methods("GET")
+	api.HandleFunc("/export/html/{jobId}", requireRole(RoleViewer, handleExportHTML)).Methods("GET")
+	api.HandleFunc("/report-templates", requireRole(RoleViewer, handleReportTemplates)).Methods("GET")
+	api.HandleFunc("/jobs", requireRole(RoleViewer, handleJobList)).Methods("GET")
+	api.HandleFunc("/jobs/{jobId}", requireRole(RoleViewer, handleJobStatus)).Methods("GET")
+	api.HandleFunc("/jobs/{jobId}/cases", requireRole(RoleOperator, handleJobCases)).Methods("POST")
+	api.HandleFunc("/ws", requireRole(RoleViewer, handleWebSocket))
+
+	// API Key management routes
+	api.HandleFunc("/api-key", requireRole(RoleViewer, handleAPIKey)).Methods("GET")
+	api.HandleFunc("/api-key", requireRole(RoleAdmin, handleAPIKey)).Methods("POST", "DELETE")
+	api.HandleFunc("/api-key/value", requireRole(RoleAdmin, handleAPIKeyValue)).Methods("GET")
+
+	// Rollback API routes
+	api.HandleFunc("/rollback/history", requireRole(RoleViewer, handleRollbackHistory)).Methods("GET")
+	api.HandleFunc("/rollback/{rollbackId}", requireRole(RoleViewer, handleRollbackDetails)).Methods("GET")
+	api.HandleFunc("/rollback/{rollbackId}/execute", requireRole(RoleOperator, handleExecuteRollback)).Methods("POST")
+	api.HandleFunc("/rollback/{rollbackId}", requireRole(RoleAdmin, handleDeleteRollback)).Methods("DELETE")
+
+	// User management routes
+	api.HandleFunc("/users", requireRole(RoleAdmin, handleListUsers)).Methods("GET")
+	api.HandleFunc("/users", requireRole(RoleAdmin, handleCreateUser)).Methods("POST")
+	api.HandleFunc("/users/{username}", requireRole(RoleAdmin, handleUpdateUser)).Methods("PUT")
+	api.HandleFunc("/users/{username}", requireRole(RoleAdmin, handleDeleteUser)).Methods("DELETE")
+
+	// Audit log endpoints
+	api.HandleFunc("/audit", requireRole(RoleAdmin, handleAuditQuery)).Methods("GET")
+	api.HandleFunc("/audit/verify", requireRole(RoleAdmin, handleAuditVerify)).Methods("GET")
+	api.HandleFunc("/audit/export", requireRole(RoleAdmin, handleAuditExport)).Methods("GET")
+
+	// Start server
+	server := &http.Server{
+		Addr:    net.JoinHostPort(config.Server.BindAddress, strconv.Itoa(port)),
+		Handler: securityHeaders(router),
+	}
+
+	scheme := "http"
+	var certFile, keyFile string
+	if config.Server.TLS.Enabled {
+		var err error
+		certFile, keyFile, err = serverCertificatePaths()
+		if err != nil {
+			log.Fatalf("Failed to prepare TLS certificate: %v", err)
+		}
+		serverCertificate, err = describeCertificate(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		scheme = "https"
+
+		fmt.Printf("Serving HTTPS with certificate %s\n", certFile)
+		fmt.Printf("   SHA-256 fingerprint: %s\n", serverCertificate.Fingerprint)
+		if serverCertificate.SelfSigned {
+			fmt.Println("   The certificate is self-signed; confirm the fingerprint when your browser warns about it.")
+		}
+	} else {
+		fmt.Println("WARNING: TLS is disabled; the API key and passwords travel in clear text.")
+	}
+	if config.Server.BindAddress == "" {
+		fmt.Println("Listening on all network interfaces (set server.bindAddress to 127.0.0.1 for this machine only)")
+	}
+
+	// Start server in goroutine
+	go func() {
+		url := browserURL(scheme, port)
+		fmt.Printf("LRCleaner starting on %s\n", url)
+
+		// Open browser automatically
+		go openBrowser(url)
+
+		var err error
+		if scheme == "https" {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	// Handle shutdown gracefully
+	go func() {
+		<-quit
+		fmt.Println("\n🛑 Shutdown signal received. Gracefully shutting down LRCleaner...")
+		fmt.Println("   - Closing WebSocket connections...")
+		fmt.Println("   - Stopping HTTP server...")
+		fmt.Println("   - Please wait...")
+
+		// Close all WebSocket connections
+		wsMutex.Lock()
+		for conn := range wsConnections {
+			conn.Close()
+		}
+		wsConnections = make(map[*websocket.Conn]bool)
+		wsMutex.Unlock()
+
+		// Graceful shutdown with timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("⚠️  Server forced to shutdown: %v\n", err)
+		} else {
+			fmt.Println("✅ LRCleaner stopped gracefully")
+		}
+
+		os.Exit(0)
+	}()
+
+	// Keep the main goroutine alive
+	select {}
+}
+
+// Config file location, schema and environment overrides
+const (
+	configFileName = "config.json"
+	// currentConfigSchema is the schemaVersion this build writes. Files without a
+	// schemaVersion are version 0 and are migrated on load.
+	currentConfigSchema = 2
+	envPrefix           = "LRCLEANER_"
+)
+
+var (
+	// configIssues lists problems found in config.json that were corrected at load
+	configIssues []string
+	// configOnDisk is the configuration as last read from or written to config.json,
+	// before environment overrides
+	configOnDisk *Config
+	// activeEnvOverrides names the LRCLEANER_* variables applied at startup
+	activeEnvOverrides []string
+	// envAPIKeys holds API keys supplied through the environment, by keyring key
+	envAPIKeys = make(map[string]string)
+)
+
+// configMigrations upgrade a raw config.json one schema version at a time;
+// entry i turns version i into version i+1
+var configMigrations = []func(raw map[string]interface{}) error{
+	migrateConfigV0,
+	migrateConfigV1,
+}
+
+// migrateConfigV0 moves the single top-level deployment of configs written before
+// profiles into a default profile, and keeps configs written before certificate
+// verification existed unverified until an admin chooses a mode
+func migrateConfigV0(raw map[string]interface{}) error {
+	if profiles, _ := raw["profiles"].([]interface{}); len(profiles) == 0 {
+		profile := map[string]interface{}{
+			"name":               defaultProfileName,
+			"hostname":           raw["hostname"],
+			"port":               raw["port"],
+			"keyringKey":         credentialKey,
+			"excludedLogSources": raw["excludedLogSources"],
+		}
+		if rollback, ok := raw["rollback"].(map[string]interface{}); ok {
+			profile["rollbackDir"] = rollback["backupLocation"]
+		}
+		raw["profiles"] = []interface{}{profile}
+		raw["defaultProfile"] = defaultProfileName
+	}
+	delete(raw, "hostname")
+	delete(raw, "port")
+	delete(raw, "excludedLogSources")
+
+	if _, ok := raw["apiTls"]; !ok {
+		raw["apiTls"] = map[string]interface{}{"mode": APITLSInsecure}
+	}
+	return nil
+}
+
+// migrateConfigV1 turns the built-in LogRhythm and echo exclusions and each profile's
+// excluded log source types into exclusion rules
+func migrateConfigV1(raw map[string]interface{}) error {
+	var rules []interface{}
+	for _, rule := range defaultExclusionRules()[:3] {
+		rules = append(rules, map[string]interface{}{
+			"id": rule.ID, "field": rule.Field, "match": rule.Match, "pattern": rule.Pattern, "reason": rule.Reason,
+		})
+	}
+
+	profiles, _ := raw["profiles"].([]interface{})
+	for _, item := range profiles {
+		profile, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		types, _ := profile["excludedLogSources"].([]interface{})
+		for i, pattern := range types {
+			if pattern, ok := pattern.(string); ok && strings.TrimSpace(pattern) != "" {
+				rules = append(rules, map[string]interface{}{
+					"id":      fmt.Sprintf("%v-type-%d", profile["name"], i+1),
+					"profile": profile["name"],
+					"field":   ExcludeByType,
+					"match":   MatchSubstring,
+					"pattern": pattern,
+					"reason":  "Excluded log source type from an earlier version",
+				})
+			}
+		}
+		delete(profile, "excludedLogSources")
+	}
+
+	raw["exclusions"] = rules
+	return nil
+}
+
+// envOverride is one LRCLEANER_* variable. apply sets it on the loaded config; keep
+// copies the same setting from the on-disk config so saving never writes it to disk.
+type envOverride struct {
+	name  string
+	apply func(c *Config, value string) error
+	keep  func(dst, src *Config)
+}
+
+// envOverrides are applied in order; LRCLEANER_DEFAULT_PROFILE comes first so the
+// deployment settings after it apply to the chosen profile
+var envOverrides = []envOverride{
+	{"DEFAULT_PROFILE",
+		func(c *Config, v string) error {
+			for _, profile := range c.Profiles {
+				if profile.Name == v {
+					c.DefaultProfile = v
+					return nil
+				}
+			}
+			return fmt.Errorf("deployment profile %q not found", v)
+		},
+		func(dst, src *Config) { dst.DefaultProfile = src.DefaultProfile }},
+	{"HOSTNAME",
+		func(c *Config, v string) error { defaultProfileOf(c).Hostname = v; return nil },
+		func(dst, src *Config) { keepProfileField(dst, src, func(d, s *Profile) { d.Hostname = s.Hostname }) }},
+	{"PORT",
+		func(c *Config, v string) error {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("must be a port number between 1 and 65535")
+			}
+			defaultProfileOf(c).Port = port
+			return nil
+		},
+		func(dst, src *Config) { keepProfileField(dst, src, func(d, s *Profile) { d.Port = s.Port }) }},
+	{"API_KEY",
+		func(c *Config, v string) error { envAPIKeys[defaultProfileOf(c).KeyringKey] = v; return nil },
+		func(dst, src *Config) {}},
+	{"ROLLBACK_DIR",
+		func(c *Config, v string) error { c.Rollback.BackupLocation = v; return nil },
+		func(dst, src *Config) { dst.Rollback.BackupLocation = src.Rollback.BackupLocation }},
+	{"SESSION_TIMEOUT_MINUTES",
+		func(c *Config, v string) error {
+			minutes, err := strconv.Atoi(v)
+			if err != nil || minutes <= 0 {
+				return fmt.Errorf("must be a positive number of minutes")
+			}
+			c.Auth.SessionTimeoutMinutes = minutes
+			return nil
+		},
+		func(dst, src *Config) { dst.Auth.SessionTimeoutMinutes = src.Auth.SessionTimeoutMinutes }},
+	{"BIND_ADDRESS",
+		func(c *Config, v string) error { c.Server.BindAddress = v; return nil },
+		func(dst, src *Config) { dst.Server.BindAddress = src.Server.BindAddress }},
+	{"TLS_ENABLED",
+		func(c *Config, v string) error {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("must be true or false")
+			}
+			c.Server.TLS.Enabled = enabled
+			return nil
+		},
+		func(dst, src *Config) { dst.Server.TLS.Enabled = src.Server.TLS.Enabled }},
+	{"TLS_CERT_FILE",
+		func(c *Config, v string) error { c.Server.TLS.CertFile = v; return nil },
+		func(dst, src *Config) { dst.Server.TLS.CertFile = src.Server.TLS.CertFile }},
+	{"TLS_KEY_FILE",
+		func(c *Config, v string) error { c.Server.TLS.KeyFile = v; return nil },
+		func(dst, src *Config) { dst.Server.TLS.KeyFile = src.Server.TLS.KeyFile }},
+	{"BACKUP_GATE_REQUIRED",
+		func(c *Config, v string) error {
+			required, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("must be true or false")
+			}
+			c.BackupGate.Required = required
+			return nil
+		},
+		func(dst, src *Config) { dst.BackupGate.Required = src.BackupGate.Required }},
+	{"API_TLS_MODE",
+		func(c *Config, v string) error { c.APITLS.Mode = v; return nil },
+		func(dst, src *Config) { dst.APITLS.Mode = src.APITLS.Mode }},
+	{"API_CA_BUNDLE",
+		func(c *Config, v string) error { c.APITLS.CABundle = v; return nil },
+		func(dst, src *Config) { dst.APITLS.CABundle = src.APITLS.CABundle }},
+	{"REPORT_TEMPLATES_DIR",
+		func(c *Config, v string) error { c.Reports.TemplatesDir = v; return nil },
+		func(dst, src *Config) { dst.Reports.TemplatesDir = src.Reports.TemplatesDir }},
+}
+
+// defaultProfileOf returns the default profile of c for environment overrides
+func defaultProfileOf(c *Config) *Profile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == c.DefaultProfile {
+			return &c.Profiles[i]
+		}
+	}
+	return &c.Profiles[0]
+}
+
+// keepProfileField copies a default profile setting from src to the same profile in dst
+func keepProfileField(dst, src *Config, copyField func(d, s *Profile)) {
+	d := defaultProfileOf(dst)
+	for i := range src.Profiles {
+		if src.Profiles[i].Name == d.Name {
+			copyField(d, &src.Profiles[i])
+		}
+	}
+}
+
+// resolveConfigPath picks config.json from the -config flag, then LRCLEANER_CONFIG, then
+// the executable's directory. A config.json in the working directory is still used when
+// the executable's directory has none, as earlier versions read it from there.
+func resolveConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("Warning: Cannot locate the executable, reading %s from the working directory: %v", configFileName, err)
+		return configFileName
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	path := filepath.Join(filepath.Dir(exe), configFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(configFileName); err == nil {
+			log.Printf("Using %s from the working directory; move it next to the executable or pass -config", configFileName)
+			return configFileName
+		}
+	}
+	return path
+}
+
+// dataPath resolves a data file or directory against the directory holding config.json,
+// so users, the audit log, rollback points and the rest are found wherever LRCleaner is
+// started from. Absolute paths are returned unchanged.
+func dataPath(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(configPath), name)
+}
+
+// defaultConfig is the configuration of a new install
+func defaultConfig() *Config {
+	return &Config{
+		SchemaVersion: currentConfigSchema,
+		Profiles: []Profile{{
+			Name:        defaultProfileName,
+			Hostname:    "localhost",
+			Port:        8501,
+			KeyringKey:  credentialKey,
+			RollbackDir: "./rollback/",
+			Database:    defaultDatabaseConfig(defaultProfileName),
+			Cases:       defaultCaseConfig(),
+		}},
+		DefaultProfile: defaultProfileName,
+		Exclusions:     defaultExclusionRules(),
+		Protected:      []ProtectedEntry{},
+		Webhooks:       []Webhook{},
+		Email: EmailConfig{
+			Port:       587,
+			Security:   SMTPStartTLS,
+			Recipients: []EmailRoute{},
+		},
+		Syslog: SyslogConfig{
+			Port:       514,
+			Protocol:   SyslogUDP,
+			Format:     SyslogCEF,
+			Facility:   "local0",
+			Framing:    SyslogOctetCounting,
+			BufferSize: 10000,
+		},
+		Rollback: RollbackConfig{
+			Enabled:           true,
+			RetentionDays:     30,
+			MaxRollbackPoints: 10,
+			AutoBackup:        true,
+			BackupLocation:    "./rollback/",
+			ChecksumAlgorithm: "sha256",
+		},
+		Retirement: RetirementConfig{
+			NameTemplate:  defaultRetirementNameTemplate,
+			MaxNameLength: defaultMaxRetiredNameLength,
+		},
+		BackupGate: BackupGateConfig{
+			MaxAgeHours:      defaultBackupGateMaxAgeHours,
+			AllowAttestation: true,
+		},
+		Auth: AuthConfig{
+			SessionTimeoutMinutes: defaultSessionTimeout,
+		},
+		APITLS: APITLSConfig{
+			Mode: APITLSPinned,
+		},
+		Reports: ReportsConfig{
+			TemplatesDir: defaultReportTemplatesDir,
+		},
+		Server: ServerConfig{
+			BindAddress: defaultBindAddress,
+			TLS: ServerTLSConfig{
+				Enabled: true,
+			},
+		},
+	}
+}
+
+// copyConfig returns a deep copy of c
+func copyConfig(c *Config) *Config {
+	data, _ := json.Marshal(c)
+	var copied Config
+	json.Unmarshal(data, &copied)
+	return &copied
+}
+
+// jsonErrorPosition adds the line and column to JSON syntax and type errors
+func jsonErrorPosition(data []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Errorf("line %d, column %d: %v", line, column, err)
+}
+
+// sanitizeConfig checks every section of c, replaces invalid sections with their
+// defaults and returns a description of each problem
+func sanitizeConfig(c *Config) []string {
+	var issues []string
+	defaults := defaultConfig()
+
+	var profiles []Profile
+	seen := make(map[string]bool)
+	for i, profile := range c.Profiles {
+		err := validateProfile(profile)
+		switch {
+		case err != nil:
+		case profile.KeyringKey == "":
+			err = fmt.Errorf("keyringKey is required")
+		case seen[profile.Name]:
+			err = fmt.Errorf("duplicate profile name")
+		}
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("profiles[%d] %q: %v; profile ignored", i, profile.Name, err))
+			continue
+		}
+		if profile.Database.AuthMode == "" {
+			// Profiles saved before database credentials existed
+			profile.Database = defaultDatabaseConfig(profile.Name)
+		}
+		fillDatabaseDefaults(&profile.Database)
+		if err := validateDatabaseConfig(profile.Database); err != nil {
+			issues = append(issues, fmt.Sprintf("profiles[%d] %q database: %v; using defaults", i, profile.Name, err))
+			profile.Database = defaultDatabaseConfig(profile.Name)
+		}
+		if profile.Database.KeyringKey == "" {
+			profile.Database.KeyringKey = dbCredentialKey + "." + profile.Name
+		}
+		if profile.Cases.Priority == 0 {
+			// Profiles saved before LogRhythm cases existed
+			profile.Cases = defaultCaseConfig()
+		} else if err := validateCaseConfig(profile.Cases); err != nil {
+			issues = append(issues, fmt.Sprintf("profiles[%d] %q cases: %v; cases disabled", i, profile.Name, err))
+			profile.Cases = defaultCaseConfig()
+		}
+		seen[profile.Name] = true
+		profiles = append(profiles, profile)
+	}
+	if len(profiles) == 0 {
+		if len(c.Profiles) > 0 {
+			issues = append(issues, "no valid deployment profiles; using the default profile")
+		}
+		profiles = defaults.Profiles
+	}
+	c.Profiles = profiles
+	defaultFound := false
+	for _, profile := range c.Profiles {
+		defaultFound = defaultFound || profile.Name == c.DefaultProfile
+	}
+	if !defaultFound {
+		if c.DefaultProfile != "" {
+			issues = append(issues, fmt.Sprintf("defaultProfile %q not found; using %q", c.DefaultProfile, c.Profiles[0].Name))
+		}
+		c.DefaultProfile = c.Profiles[0].Name
+	}
+
+	var exclusions []ExclusionRule
+	for i, rule := range c.Exclusions {
+		err := validateExclusionRule(rule)
+		if err == nil && rule.ID == "" {
+			err = fmt.Errorf("id is required")
+		}
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("exclusions[%d] %q: %v; rule ignored", i, rule.ID, err))
+			continue
+		}
+		if rule.Profile != "" && !seen[rule.Profile] {
+			issues = append(issues, fmt.Sprintf("exclusions[%d] %q: deployment profile %q not found; rule ignored", i, rule.ID, rule.Profile))
+			continue
+		}
+		exclusions = append(exclusions, rule)
+	}
+	if exclusions == nil {
+		exclusions = []ExclusionRule{}
+	}
+	c.Exclusions = exclusions
+
+	// Invalid protected entries are kept so they refuse changes instead of protecting nothing
+	for i, entry := range c.Protected {
+		err := validateProtectedEntry(entry)
+		if err == nil && entry.ID == "" {
+			err = fmt.Errorf("id is required")
+		}
+		if err == nil && entry.Profile != "" && !seen[entry.Profile] {
+			err = fmt.Errorf("deployment profile %q not found", entry.Profile)
+		}
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("protected[%d] %q: %v", i, entry.ID, err))
+		}
+	}
+	if c.Protected == nil {
+		c.Protected = []ProtectedEntry{}
+	}
+
+	// Invalid webhooks are kept, switched off, so the settings page can fix them
+	for i, hook := range c.Webhooks {
+		if hook.ID == "" {
+			c.Webhooks[i].ID = randomToken(8)
+		}
+		if !hook.Enabled {
+			continue
+		}
+		err := validateWebhook(hook)
+		if err == nil && hook.Profile != "" && !seen[hook.Profile] {
+			err = fmt.Errorf("deployment profile %q not found", hook.Profile)
+		}
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("webhooks[%d] %q: %v; webhook disabled", i, c.Webhooks[i].ID, err))
+			c.Webhooks[i].Enabled = false
+		}
+	}
+	if c.Webhooks == nil {
+		c.Webhooks = []Webhook{}
+	}
+
+	// Configs written before email notifications existed keep them off
+	if c.Email.Port == 0 && c.Email.Security == "" {
+		c.Email = defaults.Email
+	} else if err := validateEmailConfig(c.Email); err != nil {
+		issues = append(issues, fmt.Sprintf("email: %v; email notifications disabled", err))
+		c.Email.Enabled = false
+	}
+	routes := []EmailRoute{}
+	for i, route := range c.Email.Recipients {
+		err := validateEmailRoute(route)
+		if err == nil && route.Profile != "" && !seen[route.Profile] {
+			err = fmt.Errorf("deployment profile %q not found", route.Profile)
+		}
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("email.recipients[%d]: %v; recipients ignored", i, err))
+			continue
+		}
+		routes = append(routes, route)
+	}
+	c.Email.Recipients = routes
+
+	// Configs written before syslog forwarding existed keep it off
+	if c.Syslog.Protocol == "" {
+		c.Syslog = defaults.Syslog
+	} else if err := validateSyslogConfig(c.Syslog); err != nil {
+		issues = append(issues, fmt.Sprintf("syslog: %v; syslog forwarding disabled", err))
+		c.Syslog.Enabled = false
+	}
+
+	if c.Rollback.RetentionDays < 0 || c.Rollback.MaxRollbackPoints < 0 || c.Rollback.ChecksumAlgorithm != "sha256" {
+		issues = append(issues, "rollback: retentionDays and maxRollbackPoints must not be negative and checksumAlgorithm must be sha256; using defaults")
+		c.Rollback = defaults.Rollback
+	}
+
+	// Configs written before templates existed keep the default naming
+	if c.Retirement.NameTemplate == "" {
+		c.Retirement = defaults.Retirement
+	} else if err := validateRetirementConfig(c.Retirement); err != nil {
+		issues = append(issues, fmt.Sprintf("retirement: %v; using the default naming", err))
+		c.Retirement = defaults.Retirement
+	}
+
+	// Configs written before the backup gate existed keep it off
+	if c.BackupGate.MaxAgeHours == 0 {
+		c.BackupGate = defaults.BackupGate
+	} else if err := validateBackupGateConfig(c.BackupGate); err != nil {
+		issues = append(issues, fmt.Sprintf("backupGate: %v; using the default policy", err))
+		c.BackupGate = defaults.BackupGate
+	}
+
+	if c.Auth.SessionTimeoutMinutes <= 0 {
+		c.Auth.SessionTimeoutMinutes = defaultSessionTimeout
+	}
+	if err := validateOIDCConfig(c.Auth.OIDC); err != nil {
+		issues = append(issues, fmt.Sprintf("auth.oidc: %v; single sign-on disabled", err))
+		c.Auth.OIDC.Enabled = false
+	}
+
+	if err := validateAPITLSConfig(c.APITLS); err != nil {
+		issues = append(issues, fmt.Sprintf("apiTls: %v; certificates will not be trusted until fixed", err))
+		c.APITLS = defaults.APITLS
+	}
+
+	if err := validateServerConfig(c.Server); err != nil {
+		issues = append(issues, fmt.Sprintf("server: %v; using defaults", err))
+		c.Server = defaults.Server
+	}
+
+	// Configs written before report templates existed use the default folder
+	if c.Reports.TemplatesDir == "" {
+		c.Reports.TemplatesDir = defaults.Reports.TemplatesDir
+	}
+	if err := validateReportsConfig(c.Reports); err != nil {
+		issues = append(issues, fmt.Sprintf("reports: %v; using the built-in template", err))
+		c.Reports.DefaultTemplate = ""
+	}
+
+	return issues
+}
+
+// loadConfig reads config.json, migrating older schema versions and applying LRCLEANER_*
+// environment overrides. Problems the loader can work around are recorded in
+// configIssues; an unreadable, malformed or newer file is an error rather than a silent
+// fallback to defaults. When persist is false nothing is written to disk.
+func loadConfig(persist bool) (*Config, error) {
+	config := defaultConfig()
+	configIssues = nil
+
+	data, err := os.ReadFile(configPath)
+	switch {
+	case os.IsNotExist(err):
+		log.Printf("No %s found, using defaults", configPath)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read %s: %v", configPath, err)
+	default:
+		var raw map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s is not valid JSON: %v", configPath, jsonErrorPosition(data, err))
+		}
+
+		version := 0
+		if v, ok := raw["schemaVersion"].(float64); ok {
+			version = int(v)
+		}
+		if version > currentConfigSchema {
+			return nil, fmt.Errorf("%s uses schema version %d but this LRCleaner supports up to %d; upgrade LRCleaner", configPath, version, currentConfigSchema)
+		}
+
+		// API keys were once kept in config.json; they now belong in the credential store
+		legacyAPIKey, _ := raw["apiKey"].(string)
+		delete(raw, "apiKey")
+
+		for v := version; v < currentConfigSchema; v++ {
+			if err := configMigrations[v](raw); err != nil {
+				return nil, fmt.Errorf("failed to migrate %s from schema version %d: %v", configPath, v, err)
+			}
+		}
+		raw["schemaVersion"] = currentConfigSchema
+
+		migrated, _ := json.Marshal(raw)
+		decoder := json.NewDecoder(bytes.NewReader(migrated))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			if !strings.HasPrefix(err.Error(), "json: unknown field") {
+				return nil, fmt.Errorf("invalid %s: %v", configPath, err)
+			}
+			configIssues = append(configIssues, strings.TrimPrefix(err.Error(), "json: ")+"; ignored")
+			config = defaultConfig()
+			if err := json.Unmarshal(migrated, config); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", configPath, err)
+			}
+		}
+		configIssues = append(configIssues, sanitizeConfig(config)...)
+
+		if persist && legacyAPIKey != "" {
+			if err := StoreAPIKey(credentialKey, legacyAPIKey); err != nil {
+				log.Printf("Warning: Failed to migrate API key to credential store: %v", err)
+				persist = false
+			} else {
+				log.Println("API key migrated from config.json to OS credential store")
+			}
+		}
+
+		if persist && (version < currentConfigSchema || legacyAPIKey != "") {
+			backup := fmt.Sprintf("%s.v%d.bak", configPath, version)
+			if err := os.WriteFile(backup, data, 0600); err != nil {
+				return nil, fmt.Errorf("failed to back up %s before migrating: %v", configPath, err)
+			}
+			updated, _ := json.MarshalIndent(config, "", "  ")
+			if err := os.WriteFile(configPath, updated, 0644); err != nil {
+				return nil, fmt.Errorf("failed to write migrated %s: %v", configPath, err)
+			}
+			log.Printf("Migrated %s from schema version %d to %d; the original is saved as %s", configPath, version, currentConfigSchema, backup)
+		}
+	}
+	configOnDisk = copyConfig(config)
+
+	activeEnvOverrides = nil
+	for _, override := range envOverrides {
+		value, ok := os.LookupEnv(envPrefix + override.name)
+		if !ok {
+			continue
+		}
+		if err := override.apply(config, value); err != nil {
+			return nil, fmt.Errorf("invalid %s%s: %v", envPrefix, override.name, err)
+		}
+		activeEnvOverrides = append(activeEnvOverrides, envPrefix+override.name)
+	}
+	if len(activeEnvOverrides) > 0 {
+		if issues := sanitizeConfig(config); len(issues) > 0 {
+			return nil, fmt.Errorf("environment overrides make the configuration invalid: %s", strings.Join(issues, "; "))
+		}
+	}
+
+	return config, nil
+}
+
+// runConfigCheck validates the configuration without changing anything on disk and
+// returns the process exit status
+func runConfigCheck() int {
+	checked, err := loadConfig(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%s: schema version %d\n", configPath, checked.SchemaVersion)
+	fmt.Printf("Deployment profiles: %d (default %s)\n", len(checked.Profiles), checked.DefaultProfile)
+	if len(activeEnvOverrides) > 0 {
+		fmt.Printf("Settings from the environment: %s\n", strings.Join(activeEnvOverrides, ", "))
+	}
+	if len(configIssues) == 0 {
+		fmt.Println("Configuration is valid")
+		return 0
+	}
+	for _, issue := range configIssues {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", issue)
+	}
+	return 1
+}
+
+func serveIndex(w http.ResponseWriter, r *http.Request) {
+	data, err := webFiles.ReadFile("web/index.html")
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Write(data)
+}
+
+// ConfigResponse represents the response structure for config API
+type ConfigResponse struct {
+	// Profile, Hostname, Port and HasAPIKey describe the requested profile
+	Profile        string           `json:"profile"`
+	Hostname       string           `json:"hostname"`
+	Port           int              `json:"port"`
+	Profiles       []ProfileSummary `json:"profiles"`
+	DefaultProfile string           `json:"defaultProfile"`
+	Rollback       RollbackConfig   `json:"rollback"`
+	Retirement     RetirementConfig `json:"retirement"`
+	BackupGate     BackupGateConfig `json:"backupGate"`
+	Auth           AuthConfig       `json:"auth"`
+	HasAPIKey      bool             `json:"hasApiKey"`
+	HasOIDCSecret  bool             `json:"hasOidcClientSecret"`
+	Server         ServerConfig     `json:"server"`
+	APITLS         APITLSConfig     `json:"apiTls"`
+	// ServerCertificate describes the certificate in use; nil when serving plain HTTP
+	ServerCertificate *ServerCertificateInfo `json:"serverCertificate,omitempty"`
+	ConfigPath        string                 `json:"configPath"`
+	SchemaVersion     int                    `json:"schemaVersion"`
+	ConfigIssues      []string               `json:"configIssues"` // Problems corrected when config.json was loaded
+	EnvOverrides      []string               `json:"envOverrides"` // LRCLEANER_* variables in effect
+	Email             EmailConfig            `json:"email"`
+	HasSMTPPassword   bool                   `json:"hasSmtpPassword"`
+	Syslog            SyslogConfig           `json:"syslog"`
+}
+
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		profile, err := findProfile(r.URL.Query().Get("profile"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		response := ConfigResponse{
+			Profile:           profile.Name,
+			Hostname:          profile.Hostname,
+			Port:              profile.Port,
+			Profiles:          profileSummaries(),
+			HasAPIKey:         HasAPIKey(profile.KeyringKey),
+			HasOIDCSecret:     GetOIDCClientSecret() != "",
+			HasSMTPPassword:   GetSMTPPassword() != "",
+			ServerCertificate: serverCertificate,
+			ConfigPath:        configPath,
+			ConfigIssues:      configIssues,
+			EnvOverrides:      activeEnvOverrides,
+		}
+		configMutex.RLock()
+		response.DefaultProfile = config.DefaultProfile
+		response.Rollback = config.Rollback
+		response.Retirement = config.Retirement
+		response.BackupGate = config.BackupGate
+		response.Auth = config.Auth
+		response.Email = config.Email
+		response.Syslog = config.Syslog
+		response.Server = config.Server
+		response.APITLS = config.APITLS
+		response.SchemaVersion = config.SchemaVersion
+		configMutex.RUnlock()
+		json.NewEncoder(w).Encode(response)
+	case "POST":
+		var requestData struct {
+			Profile    string            `json:"profile"` // Profile the hostname, port and API key belong to
+			Hostname   string            `json:"hostname"`
+			Port       int               `json:"port"`
+			APIKey     string            `json:"apiKey"`
+			Retirement *RetirementConfig `json:"retirement,omitempty"`
+			BackupGate *BackupGateConfig `json:"backupGate,omitempty"`
+			Auth       *AuthConfig       `json:"auth,omitempty"`
+			Server     *ServerConfig     `json:"server,omitempty"` // Applied at the next restart
+			APITLS     *APITLSConfig     `json:"apiTls,omitempty"`
+			Email      *EmailConfig      `json:"email,omitempty"`
+			Syslog     *SyslogConfig     `json:"syslog,omitempty"`
+			// OIDCClientSecret and SMTPPassword are stored in the credential store; "" leaves
+			// them unchanged, "-" removes them
+			OIDCClientSecret string `json:"oidcClientSecret,omitempty"`
+			SMTPPassword     string `json:"smtpPassword,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		// Every section is checked before anything changes, so a rejected request leaves
+		// the running configuration as it was
+		if requestData.Retirement != nil {
+			if err := validateRetirementConfig(*requestData.Retirement); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid retirement naming: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if requestData.BackupGate != nil {
+			if err := validateBackupGateConfig(*requestData.BackupGate); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid backup policy: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if requestData.Auth != nil {
+			if err := validateOIDCConfig(requestData.Auth.OIDC); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid single sign-on settings: %v", err), http.StatusBadRequest)
+				return
+			}
+			if requestData.Auth.SessionTimeoutMinutes <= 0 {
+				requestData.Auth.SessionTimeoutMinutes = defaultSessionTimeout
+			}
+		}
+
+		var apiTLSState *apiTLSState
+		if requestData.APITLS != nil {
+			err := validateAPITLSConfig(*requestData.APITLS)
+			if err == nil {
+				apiTLSState, err = loadAPITLSState(*requestData.APITLS)
+			}
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid LogRhythm API TLS settings: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if requestData.Server != nil {
+			if err := validateServerConfig(*requestData.Server); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid server settings: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if requestData.Email != nil {
+			email := requestData.Email
+			email.Host = strings.TrimSpace(email.Host)
+			email.From = strings.TrimSpace(email.From)
+			if email.Recipients == nil {
+				email.Recipients = []EmailRoute{}
+			}
+			if err := validateEmailConfig(*email); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid email settings: %v", err), http.StatusBadRequest)
+				return
+			}
+			for i, route := range email.Recipients {
+				if err := validateEmailRoute(route); err != nil {
+					http.Error(w, fmt.Sprintf("Invalid email recipients %d: %v", i+1, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		if requestData.Syslog != nil {
+			settings := requestData.Syslog
+			settings.Host = strings.TrimSpace(settings.Host)
+			settings.CABundle = strings.TrimSpace(settings.CABundle)
+			if err := validateSyslogConfig(*settings); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid syslog settings: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// The profile and the deployments email routes name are looked up, and the new
+		// settings written and made current, under the config lock
+		configMutex.Lock()
+		name := requestData.Profile
+		if name == "" {
+			name = config.DefaultProfile
+		}
+		index := profileIndex(name)
+		if index < 0 {
+			configMutex.Unlock()
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+
+		// Sub-forms such as naming or TLS settings post without connection details;
+		// only change the hostname and port when they are given
+		profile := config.Profiles[index]
+		if requestData.Hostname != "" {
+			profile.Hostname = strings.TrimSpace(requestData.Hostname)
+		}
+		if requestData.Port != 0 {
+			profile.Port = requestData.Port
+		}
+		if err := validateProfile(profile); err != nil {
+			configMutex.Unlock()
+			http.Error(w, fmt.Sprintf("Invalid connection settings: %v", err), http.StatusBadRequest)
+			return
+		}
+		if requestData.Email != nil {
+			for i, route := range requestData.Email.Recipients {
+				if route.Profile != "" && profileIndex(route.Profile) < 0 {
+					configMutex.Unlock()
+					http.Error(w, fmt.Sprintf("Invalid email recipients %d: deployment profile %q not found", i+1, route.Profile), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		next := *config
+		next.Profiles = slices.Clone(config.Profiles)
+		next.Profiles[index] = profile
+		if requestData.Retirement != nil {
+			next.Retirement = *requestData.Retirement
+		}
+		if requestData.BackupGate != nil {
+			next.BackupGate = *requestData.BackupGate
+		}
+		if requestData.Auth != nil {
+			next.Auth = *requestData.Auth
+		}
+		if requestData.APITLS != nil {
+			next.APITLS = *requestData.APITLS
+		}
+		if requestData.Server != nil {
+			next.Server = *requestData.Server
+		}
+		if requestData.Email != nil {
+			next.Email = *requestData.Email
+		}
+		if requestData.Syslog != nil {
+			next.Syslog = *requestData.Syslog
+		}
+
+		before := auditValue(config)
+		err := writeConfigFileLocked(&next)
+		if err == nil {
+			*config = next
+			if apiTLSState != nil {
+				activateAPITLSState(apiTLSState)
+			}
+		}
+		after := auditValue(config)
+		configMutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving config: %v", err)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		entry := requestAudit(r, "config.update", configPath)
+		entry.Before = before
+		entry.After = after
+		logAudit(entry)
+
+		if requestData.Auth != nil {
+			// Rediscover the provider in case the issuer changed
+			oidcMutex.Lock()
+			oidcCache = nil
+			oidcMutex.Unlock()
+		}
+
+		// Secrets change only once the settings that use them are saved
+		switch requestData.SMTPPassword {
+		case "":
+		case "-":
+			if err := DeleteSMTPPassword(); err != nil {
+				log.Printf("Error deleting SMTP password: %v", err)
+				http.Error(w, "Settings saved, but the SMTP password could not be removed", http.StatusInternalServerError)
+				return
+			}
+			logAudit(requestAudit(r, "email.password.delete", next.Email.Username))
+		default:
+			if err := StoreSMTPPassword(requestData.SMTPPassword); err != nil {
+				log.Printf("Error storing SMTP password: %v", err)
+				http.Error(w, "Settings saved, but the SMTP password could not be stored", http.StatusInternalServerError)
+				return
+			}
+			logAudit(requestAudit(r, "email.password.store", next.Email.Username))
+		}
+
+		switch requestData.OIDCClientSecret {
+		case "":
+		case "-":
+			if err := DeleteOIDCClientSecret(); err != nil {
+				log.Printf("Error deleting OIDC client secret: %v", err)
+				http.Error(w, "Settings saved, but the OIDC client secret could not be removed", http.StatusInternalServerError)
+				return
+			}
+			logAudit(requestAudit(r, "sso.secret.delete", next.Auth.OIDC.ClientID))
+		default:
+			if err := StoreOIDCClientSecret(requestData.OIDCClientSecret); err != nil {
+				log.Printf("Error storing OIDC client secret: %v", err)
+				http.Error(w, "Settings saved, but the OIDC client secret could not be stored", http.StatusInternalServerError)
+				return
+			}
+			logAudit(requestAudit(r, "sso.secret.store", next.Auth.OIDC.ClientID))
+		}
+
+		// Save API key to credential store if provided and not already stored
+		if requestData.APIKey != "" && requestData.APIKey != "***STORED***" {
+			if err := StoreAPIKey(profile.KeyringKey, requestData.APIKey); err != nil {
+				log.Printf("Error storing API key: %v", err)
+				http.Error(w, "Settings saved, but the API key could not be stored", http.StatusInternalServerError)
+				return
+			}
+			entry := requestAudit(r, "apikey.store", profile.Hostname)
+			entry.Profile = profile.Name
+			logAudit(entry)
+		}
+
+		// Reconnect to a changed collector and send anything buffered
+		wakeSyslogForwarder()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// saveConfigFile writes the current configuration (never the API key) to config.json.
+// Settings supplied through LRCLEANER_* variables keep their on-disk values.
+func saveConfigFile() error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	return writeConfigFileLocked(config)
+}
+
+// writeConfigFileLocked writes c to config.json; configMutex must be held. Handlers that
+// edit a list write a copy holding the new list and make it current only once this
+// succeeds, so a failed save leaves the running configuration unchanged.
+func writeConfigFileLocked(c *Config) error {
+	onDisk := copyConfig(c)
+	onDisk.SchemaVersion = currentConfigSchema
+	for _, override := range envOverrides {
+		for _, name := range activeEnvOverrides {
+			if name == envPrefix+override.name {
+				override.keep(onDisk, configOnDisk)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(onDisk, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", configPath, err)
+	}
+	configOnDisk = onDisk
+	return nil
+}
+
+// handleAPIKey manages the API key of the profile named by the "profile" query parameter
+func handleAPIKey(w http.ResponseWriter, r *http.Request) {
+	profile, err := findProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		// Check if API key exists
+		hasKey := HasAPIKey(profile.KeyringKey)
+		response := map[string]interface{}{
+			"hasApiKey": hasKey,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case "POST":
+		// Store API key
+		var request struct {
+			APIKey string `json:"apiKey"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if request.APIKey == "" {
+			http.Error(w, "API key is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := StoreAPIKey(profile.KeyringKey, request.APIKey); err != nil {
+			log.Printf("Error storing API key: %v", err)
+			http.Error(w, "Failed to store API key", http.StatusInternalServerError)
+			return
+		}
+		entry := requestAudit(r, "apikey.store", profile.Hostname)
+		entry.Profile = profile.Name
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+
+	case "DELETE":
+		// Remove API key
+		if err := DeleteAPIKey(profile.KeyringKey); err != nil {
+			log.Printf("Error deleting API key: %v", err)
+			http.Error(w, "Failed to delete API key", http.StatusInternalServerError)
+			return
+		}
+		entry := requestAudit(r, "apikey.delete", profile.Hostname)
+		entry.Profile = profile.Name
+		logAudit(entry)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+func handleAPIKeyValue(w http.ResponseWriter, r *http.Request) {
+	profile, err := findProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Get the actual API key value
+	apiKey, err := GetAPIKey(profile.KeyringKey)
+	if err != nil {
+		log.Printf("Error retrieving API key: %v", err)
+		http.Error(w, "Failed to retrieve API key", http.StatusInternalServerError)
+		return
+	}
+
+	if apiKey == "" {
+		http.Error(w, "No API key found", http.StatusNotFound)
+		return
+	}
+	entry := requestAudit(r, "apikey.reveal", profile.Hostname)
+	entry.Profile = profile.Name
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"apiKey": apiKey,
+	})
+}
+
+func handleTestConnection(w http.ResponseWriter, r *http.Request) {
+	var testRequest struct {
+		Profile  string `json:"profile"` // Whose stored API key to use when none is supplied
+		Hostname string `json:"hostname"`
+		Port     int    `json:"port"`
+		APIKey   string `json:"apiKey,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&testRequest); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	// Get API key from credential store if not provided in request
+	apiKey := testRequest.APIKey
+	if apiKey == "" {
+		profile, err := findProfile(testRequest.Profile)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		apiKey, err = GetAPIKey(profile.KeyringKey)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "No API key found. Please store an API key first.",
+			})
+			return
+		}
+	}
+
+	// Validate required fields
+	if testRequest.Hostname == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   "Hostname is required",
+		})
+		return
+	}
+
+	// Test the connection by making a simple API call
+	port := testRequest.Port
+	if port == 0 {
+		port = 8501 // Default port
+	}
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?count=1&offset=0",
+		testRequest.Hostname, port)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Failed to create request: %v", err),
+		})
+		return
+	}
+
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	// Report the certificate chain first so untrusted or invalid certificates can be reviewed
+	certificate, err := inspectAPICertificate(testRequest.Hostname, port)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Connection failed: %v", err),
+		})
+		return
+	}
+	if trusted, _ := certificate["trusted"].(bool); !trusted {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     false,
+			"error":       "The LogRhythm API certificate is not trusted",
+			"certificate": certificate,
+		})
+		return
+	}
+
+	// Create a temporary client for testing, verified the same way as analysis and retirement
+	testClient := newAPIClient(10 * time.Second)
+
+	resp, err := testClient.Do(req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     false,
+			"error":       fmt.Sprintf("Connection failed: %v", err),
+			"certificate": certificate,
+		})
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"message":     "Connection successful! LogRhythm API is accessible.",
+			"certificate": certificate,
+		})
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     false,
+			"error":       fmt.Sprintf("API returned status code: %d", resp.StatusCode),
+			"certificate": certificate,
+		})
+	}
+}
+
+func handleTestMode(w http.ResponseWriter, r *http.Request) {
+	log.Println("Test mode request received")
+
+	var request struct {
+		Date     string   `json:"date"`
+		Profile  string   `json:"profile"`
+		Profiles []string `json:"profiles"` // Several deployments to analyze in one job
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("Error decoding test mode request: %v", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Test mode request - Date: %s, Profile: %s", request.Date, request.Profile)
+
+	// Parse date
+	selectedDate, err := time.Parse("2006-01-02", request.Date)
+	if err != nil {
+		log.Printf("Error parsing date: %v", err)
+		http.Error(w, "Invalid date format", http.StatusBadRequest)
+		return
+	}
+
+	if len(request.Profiles) > 1 {
+		startDeploymentAnalysis(w, r, request.Profiles, request.Date, selectedDate)
+		return
+	}
+	if len(request.Profiles) == 1 {
+		request.Profile = request.Profiles[0]
+	}
+
+	p, err := findProfile(request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Create job
+	jobID := fmt.Sprintf("test_%d", time.Now().Unix())
+	job := &JobStatus{
+		ID:        jobID,
+		Status:    "running",
+		Progress:  0,
+		Message:   "Starting analysis...",
+		Profile:   p.Name,
+		StartedBy: currentUsername(r),
+		StartTime: time.Now(),
+	}
+
+	log.Printf("Created test job: %s", jobID)
+
+	jobsMutex.Lock()
+	jobs[jobID] = job
+	jobsMutex.Unlock()
+
+	entry := requestAudit(r, "analysis.start", "logsources")
+	entry.Profile = p.Name
+	entry.JobID = jobID
+	entry.Message = fmt.Sprintf("Log source analysis for activity since %s", request.Date)
+	logAudit(entry)
+
+	// Start analysis in background
+	log.Printf("Starting background analysis for job: %s", jobID)
+	go analyzeLogSources(p, jobID, selectedDate)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
+	log.Printf("Test mode response sent - JobID: %s", jobID)
+}
+
+// startDeploymentAnalysis starts a cross-deployment log source analysis job
+func startDeploymentAnalysis(w http.ResponseWriter, r *http.Request, names []string, date string, selectedDate time.Time) {
+	var profiles []*Profile
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		p, err := findProfile(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		profiles = append(profiles, p)
+	}
+
+	jobID := fmt.Sprintf("multi_%d", time.Now().Unix())
+	job := &JobStatus{
+		ID:        jobID,
+		Status:    "running",
+		Progress:  0,
+		Message:   "Starting cross-deployment analysis...",
+		StartedBy: currentUsername(r),
+		StartTime: time.Now(),
+	}
+	for _, p := range profiles {
+		job.Profiles = append(job.Profiles, p.Name)
+	}
+
+	jobsMutex.Lock()
+	jobs[jobID] = job
+	jobsMutex.Unlock()
+
+	entry := requestAudit(r, "analysis.start", "logsources")
+	entry.JobID = jobID
+	entry.Message = fmt.Sprintf("Log source analysis for activity since %s across deployments %s", date, strings.Join(job.Profiles, ", "))
+	logAudit(entry)
+
+	go analyzeDeployments(profiles, jobID, selectedDate)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
+}
+
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	var req BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	p, err := findProfile(req.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	password := req.Password
+	if p.Database.AuthMode == DBAuthSQL && password == "" {
+		password, err = GetDBPassword(p.Database.KeyringKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("No database password is stored for deployment %s; save one in Settings or enter it for this backup", p.Name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	directory := strings.TrimSpace(req.Location)
+	if directory == "" {
+		directory = p.Database.Backup.Directory
+	}
+	if err := validateBackupDirectory(directory); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Perform SQL backup
+	record := &BackupRecord{
+		ID:        randomToken(8),
+		Profile:   p.Name,
+		Server:    p.Database.serverName(),
+		StartedBy: currentUsername(r),
+		StartTime: time.Now(),
+	}
+	record.Files, err = performSQLBackup(p.Database, password, directory)
+	record.EndTime = time.Now()
+	record.Verified = err == nil
+	if err != nil {
+		record.Error = err.Error()
+	}
+	recordBackup(record)
+
+	entry := requestAudit(r, "backup.create", strings.Join(p.Database.Backup.Databases, ","))
+	entry.Profile = p.Name
+	entry.Message = fmt.Sprintf("Backup %s on %s to %s as %s", record.ID, record.Server, directory, p.Database.signIn())
+	entry.After = auditValue(record.Files)
+	if err != nil {
+		entry.Result = auditResultFailure
+		entry.Message += ": " + err.Error()
+	}
+	logAudit(entry)
+	if err != nil {
+		log.Printf("Backup error: %v", err)
+		notifyEvent(WebhookEvent{
+			Event:   EventBackupFailed,
+			Profile: p.Name,
+			User:    record.StartedBy,
+			Summary: fmt.Sprintf("Backup of %s on %s failed: %v", strings.Join(p.Database.Backup.Databases, ", "), record.Server, err),
+			Data:    map[string]interface{}{"backupId": record.ID, "error": err.Error()},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"backup":  record,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Backed up and verified %d databases", len(record.Files)),
+		"backup":  record,
+	})
+}
+
+// performSQLBackup backs up each configured database to directory on the SQL Server host
+// and checks every file with RESTORE VERIFYONLY. It stops at the first failure and
+// returns the files written so far.
+func performSQLBackup(db DatabaseConfig, password, directory string) ([]BackupFile, error) {
+	files := []BackupFile{}
+	// Backups of several databases run from master
+	connectionString, err := sqlConnectionURL(db, password, "master", false)
+	if err != nil {
+		return files, err
+	}
+
+	// Open database connection
+	conn, err := sql.Open("sqlserver", connectionString)
+	if err != nil {
+		return files, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	// Test connection
+	if err := conn.Ping(); err != nil {
+		return files, fmt.Errorf("failed to connect to SQL Server %s: %v", db.serverName(), err)
+	}
+
+	// Only fixed option keywords are written into the statement; names and paths are parameters
+	options := "FORMAT, INIT, SKIP, CHECKSUM, STATS = 10"
+	if db.Backup.CopyOnly {
+		options += ", COPY_ONLY"
+	}
+	if db.Backup.Compression {
+		options += ", COMPRESSION"
+	}
+	backupQuery := "BACKUP DATABASE @database TO DISK = @path WITH NAME = @name, " + options
+	verifyQuery := "RESTORE VERIFYONLY FROM DISK = @path WITH CHECKSUM"
+
+	timestamp := time.Now().Format("20060102_150405")
+	for _, database := range db.Backup.Databases {
+		file := BackupFile{
+			Database: database,
+			Path:     sqlServerPath(directory, fmt.Sprintf("%s_backup_%s.bak", database, timestamp)),
+		}
+
+		log.Printf("Backing up %s to %s...", database, file.Path)
+		_, err = conn.Exec(backupQuery,
+			sql.Named("database", database),
+			sql.Named("path", file.Path),
+			sql.Named("name", database+" Full Backup by LRCleaner"))
+		if err != nil {
+			return files, fmt.Errorf("failed to back up %s: %v", database, err)
+		}
+
+		log.Printf("Verifying %s...", file.Path)
+		if _, err := conn.Exec(verifyQuery, sql.Named("path", file.Path)); err != nil {
+			return append(files, file), fmt.Errorf("backup of %s written to %s failed verification: %v", database, file.Path, err)
+		}
+		file.Verified = true
+		files = append(files, file)
+		log.Printf("Database backup completed and verified: %s", file.Path)
+	}
+	return files, nil
+}
+
+// sqlServerPath joins a directory and file name with the separator the directory already
+// uses, since the path is resolved on the SQL Server host rather than here
+func sqlServerPath(directory, name string) string {
+	separator := `\`
+	if strings.Contains(directory, "/") && !strings.Contains(directory, `\`) {
+		separator = "/"
+	}
+	return strings.TrimRight(directory, `\/`) + separator + name
+}
+
+// validateBackupDirectory checks a backup folder before it is sent to SQL Server
+func validateBackupDirectory(directory string) error {
+	if strings.TrimSpace(directory) == "" {
+		return fmt.Errorf("backup directory is required")
+	}
+	if strings.ContainsAny(directory, "\x00\r\n") || utf8.RuneCountInString(directory) > 200 {
+		return fmt.Errorf("backup directory must be a single line of at most 200 characters")
+	}
+	return nil
+}
+
+// Database settings - where the LogRhythm databases live, how to sign in and how to back them up
+
+// SQL Server authentication modes
+const (
+	DBAuthSQL     = "sql"     // SQL Server login; the password is kept in the OS credential store
+	DBAuthWindows = "windows" // Integrated authentication as the Windows account LRCleaner runs under
+
+	DBEncryptRequired = "required" // TLS with the server certificate verified; the default
+	DBEncryptStrict   = "strict"   // TDS 8.0: TLS before any SQL Server traffic (SQL Server 2022)
+	DBEncryptDisabled = "disabled" // No TLS; the password crosses the network in clear text
+
+	defaultDBUsername       = "logrhythmadmin"
+	defaultDBServer         = "localhost"
+	defaultBackupDirectory  = `C:\LogRhythm\Backup`
+	emdbDatabase            = "LogRhythmEMDB"
+	maxBackupHistoryRecords = 50
+	backupsFile             = "backups.json" // Backup history, so the backup gate survives a restart
+)
+
+// Databases LRCleaner can back up. LogRhythmEMDB holds the objects retirement changes and
+// is always included.
+var backupDatabases = []string{emdbDatabase, "LogRhythm_Alarms", "LogRhythm_CMDB"}
+
+var sqlInstancePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$#]{0,15}$`)
+
+// DatabaseConfig is a deployment's SQL Server. The password never appears in config.json;
+// it is stored in the OS credential store under KeyringKey.
+type DatabaseConfig struct {
+	Server     string       `json:"server"`
+	Instance   string       `json:"instance,omitempty"` // Named instance; empty for the default instance
+	Port       int          `json:"port,omitempty"`     // 0 for 1433, or the SQL Server Browser with a named instance
+	AuthMode   string       `json:"authMode"`
+	Username   string       `json:"username,omitempty"` // SQL authentication only
+	KeyringKey string       `json:"keyringKey"`         // Credential store entry holding the SQL password
+	Backup     BackupConfig `json:"backup"`
+	// Encrypt is required, strict or disabled; empty means required. The certificate is
+	// checked against the system roots, or CAFile when given, unless TrustServerCertificate
+	// is set.
+	Encrypt                string `json:"encrypt,omitempty"`
+	TrustServerCertificate bool   `json:"trustServerCertificate,omitempty"`
+	CAFile                 string `json:"caFile,omitempty"` // PEM file with the certificate or CA of SQL Server
+	// AnalysisSource is where analysis reads log sources: the REST API or LogRhythmEMDB directly
+	AnalysisSource string `json:"analysisSource"`
+}
+
+// BackupConfig controls the database backup taken before retirement
+type BackupConfig struct {
+	Databases   []string `json:"databases"`   // LogRhythmEMDB plus optionally LogRhythm_Alarms and LogRhythm_CMDB
+	Directory   string   `json:"directory"`   // Folder on the SQL Server host the .bak files are written to
+	Compression bool     `json:"compression"` // Not available on SQL Server Express
+	CopyOnly    bool     `json:"copyOnly"`    // Leaves the regular backup chain untouched
+}
+
+// BackupFile is one database backup written by LRCleaner
+type BackupFile struct {
+	Database string `json:"database"`
+	Path     string `json:"path"`
+	Verified bool   `json:"verified"` // RESTORE VERIFYONLY succeeded
+}
+
+// BackupRecord is one run of the database backup
+type BackupRecord struct {
+	ID        string       `json:"id"`
+	Profile   string       `json:"profile"`
+	Server    string       `json:"server"`
+	Files     []BackupFile `json:"files"`
+	Verified  bool         `json:"verified"` // Every database was backed up and verified
+	Error     string       `json:"error,omitempty"`
+	StartedBy string       `json:"startedBy"`
+	StartTime time.Time    `json:"startTime"`
+	EndTime   time.Time    `json:"endTime"`
+}
+
+var (
+	backupHistory []*BackupRecord
+	backupMutex   sync.RWMutex
+)
+
+// recordBackup keeps a backup run so retirements can link to it, and saves the history
+func recordBackup(record *BackupRecord) {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+	backupHistory = append(backupHistory, record)
+	if len(backupHistory) > maxBackupHistoryRecords {
+		backupHistory = backupHistory[len(backupHistory)-maxBackupHistoryRecords:]
+	}
+	if err := saveBackupHistoryLocked(); err != nil {
+		log.Printf("WARNING: failed to save %s: %v; this backup is forgotten at the next restart", backupsFile, err)
+	}
+}
+
+// loadBackupHistory reads the backups taken before the last restart
+func loadBackupHistory() {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	data, err := os.ReadFile(dataPath(backupsFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	var stored []*BackupRecord
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		log.Printf("WARNING: failed to read %s: %v; earlier backups will not satisfy the backup gate", backupsFile, err)
+		return
+	}
+	backupHistory = stored
+	log.Printf("Loaded %d database backup records", len(backupHistory))
+}
+
+// saveBackupHistoryLocked writes backups.json; the caller holds backupMutex
+func saveBackupHistoryLocked() error {
+	data, err := json.MarshalIndent(backupHistory, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dataPath(backupsFile), data, 0600)
+}
+
+// latestVerifiedBackup returns the newest fully verified backup of a deployment, or nil
+func latestVerifiedBackup(profile string) *BackupRecord {
+	backupMutex.RLock()
+	defer backupMutex.RUnlock()
+	for i := len(backupHistory) - 1; i >= 0; i-- {
+		if record := backupHistory[i]; record.Profile == profile && record.Verified {
+			return record
+		}
+	}
+	return nil
+}
+
+// defaultDatabaseConfig signs in to the local default instance as logrhythmadmin and backs
+// up LogRhythmEMDB, as earlier versions always did
+func defaultDatabaseConfig(profile string) DatabaseConfig {
+	return DatabaseConfig{
+		Server:         defaultDBServer,
+		AnalysisSource: DataSourceAPI,
+		AuthMode:       DBAuthSQL,
+		Username:       defaultDBUsername,
+		KeyringKey:     dbCredentialKey + "." + profile,
+		Backup: BackupConfig{
+			Databases: []string{emdbDatabase},
+			Directory: defaultBackupDirectory,
+			CopyOnly:  true,
+		},
+	}
+}
+
+// fillDatabaseDefaults completes settings saved before the server and backup options existed
+func fillDatabaseDefaults(db *DatabaseConfig) {
+	if db.Server == "" {
+		db.Server = defaultDBServer
+	}
+	if db.AnalysisSource == "" {
+		db.AnalysisSource = DataSourceAPI
+	}
+	if len(db.Backup.Databases) == 0 {
+		db.Backup.Databases = []string{emdbDatabase}
+	}
+	if db.Backup.Directory == "" {
+		db.Backup.Directory = defaultBackupDirectory
+	}
+}
+
+// validateDatabaseConfig checks database settings before they are saved
+func validateDatabaseConfig(db DatabaseConfig) error {
+	if strings.TrimSpace(db.Server) == "" || strings.ContainsAny(db.Server, `\/;`) {
+		return fmt.Errorf("server must be a host name or IP address; put a named instance in instance")
+	}
+	if db.Instance != "" && !sqlInstancePattern.MatchString(db.Instance) {
+		return fmt.Errorf("instance must be a SQL Server instance name of at most 16 characters")
+	}
+	if db.Port < 0 || db.Port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535")
+	}
+	if db.AnalysisSource != DataSourceAPI && db.AnalysisSource != DataSourceEMDB {
+		return fmt.Errorf("analysisSource must be %s or %s", DataSourceAPI, DataSourceEMDB)
+	}
+	switch db.AuthMode {
+	case DBAuthSQL:
+		if strings.TrimSpace(db.Username) == "" {
+			return fmt.Errorf("username is required for SQL authentication")
+		}
+	case DBAuthWindows:
+	default:
+		return fmt.Errorf("authMode must be sql or windows")
+	}
+	switch db.Encrypt {
+	case "", DBEncryptRequired, DBEncryptStrict:
+		if db.TrustServerCertificate && db.CAFile != "" {
+			return fmt.Errorf("set either trustServerCertificate or caFile, not both")
+		}
+		if db.CAFile != "" {
+			if _, err := os.Stat(db.CAFile); err != nil {
+				return fmt.Errorf("caFile: %v", err)
+			}
+		}
+	case DBEncryptDisabled:
+	default:
+		return fmt.Errorf("encrypt must be %s, %s or %s", DBEncryptRequired, DBEncryptStrict, DBEncryptDisabled)
+	}
+
+	hasEMDB := false
+	seen := make(map[string]bool)
+	for _, database := range db.Backup.Databases {
+		known := false
+		for _, name := range backupDatabases {
+			known = known || database == name
+		}
+		if !known {
+			return fmt.Errorf("databases may only be %s", strings.Join(backupDatabases, ", "))
+		}
+		if seen[database] {
+			return fmt.Errorf("database %s is listed twice", database)
+		}
+		seen[database] = true
+		hasEMDB = hasEMDB || database == emdbDatabase
+	}
+	if !hasEMDB {
+		return fmt.Errorf("databases must include %s", emdbDatabase)
+	}
+	return validateBackupDirectory(db.Backup.Directory)
+}
+
+// serverName is the server as SQL Server tools write it, such as DBHOST\LOGRHYTHM,1433
+func (db DatabaseConfig) serverName() string {
+	name := db.Server
+	if db.Instance != "" {
+		name += `\` + db.Instance
+	}
+	if db.Port > 0 {
+		name += fmt.Sprintf(",%d", db.Port)
+	}
+	return name
+}
+
+// signIn describes the account used, for audit messages
+func (db DatabaseConfig) signIn() string {
+	if db.AuthMode == DBAuthWindows {
+		return "Windows integrated authentication"
+	}
+	return db.Username
+}
+
+// sqlConnectionURL builds the connection string for database with the driver's own URL
+// builder, so passwords containing ';', '=' or quotes reach SQL Server unchanged. readOnly
+// declares a read-only application intent.
+func sqlConnectionURL(db DatabaseConfig, password, database string, readOnly bool) (string, error) {
+	dsn := msdsn.Config{
+		Host:        db.Server,
+		Instance:    db.Instance,
+		Port:        uint64(db.Port),
+		Database:    database,
+		Encryption:  msdsn.EncryptionRequired,
+		DialTimeout: 15 * time.Second,
+	}
+	if db.Encrypt == DBEncryptDisabled {
+		dsn.Encryption = msdsn.EncryptionDisabled
+	}
+
+	switch db.AuthMode {
+	case DBAuthSQL:
+		if password == "" {
+			return "", fmt.Errorf("a password is required for SQL authentication")
+		}
+		dsn.User = db.Username
+		dsn.Password = password
+	case DBAuthWindows:
+		// With no user the driver signs in with the current Windows account (SSPI)
+		if runtime.GOOS != "windows" {
+			return "", fmt.Errorf("Windows integrated authentication is only available when LRCleaner runs on Windows")
+		}
+	default:
+		return "", fmt.Errorf("unknown database authentication mode %q", db.AuthMode)
+	}
+
+	// The URL builder writes only encrypt; the certificate options and the read-only
+	// application intent go in by hand
+	u := dsn.URL()
+	query := u.Query()
+	if readOnly {
+		query.Set(msdsn.ApplicationIntent, "ReadOnly")
+	}
+	if db.Encrypt != DBEncryptDisabled {
+		if db.Encrypt == DBEncryptStrict {
+			query.Set(msdsn.Encrypt, "strict")
+		}
+		if db.TrustServerCertificate {
+			query.Set(msdsn.TrustServerCertificate, "true")
+		}
+		if db.CAFile != "" {
+			query.Set(msdsn.Certificate, db.CAFile)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// handleProfileDatabase saves a deployment's database settings. A non-empty password is
+// stored in the credential store; clearPassword removes the stored one.
+func handleProfileDatabase(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	var request struct {
+		DatabaseConfig
+		Password      string `json:"password"`
+		ClearPassword bool   `json:"clearPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	updated := request.DatabaseConfig
+	updated.Server = strings.TrimSpace(updated.Server)
+	updated.Instance = strings.TrimSpace(updated.Instance)
+	updated.Username = strings.TrimSpace(updated.Username)
+	updated.Backup.Directory = strings.TrimSpace(updated.Backup.Directory)
+	updated.CAFile = strings.TrimSpace(updated.CAFile)
+	if updated.AuthMode == DBAuthWindows {
+		updated.Username = ""
+	}
+	if updated.AnalysisSource == "" {
+		updated.AnalysisSource = DataSourceAPI
+	}
+	if err := validateDatabaseConfig(updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	configMutex.Lock()
+	index := profileIndex(name)
+	if index < 0 {
+		configMutex.Unlock()
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	before := config.Profiles[index].Database
+	updated.KeyringKey = before.KeyringKey
+	profiles := slices.Clone(config.Profiles)
+	profiles[index].Database = updated
+	status, message, err := saveProfileDatabaseLocked(profiles, updated.KeyringKey, request.Password,
+		request.ClearPassword || updated.AuthMode == DBAuthWindows)
+	configMutex.Unlock()
+	if err != nil {
+		log.Printf("Error saving database settings of %s: %v", name, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	entry := requestAudit(r, "database.update", name)
+	entry.Profile = name
+	entry.Before = auditValue(before)
+	entry.After = auditValue(updated)
+	entry.Message = message
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"database":      updated,
+		"hasDbPassword": HasDBPassword(updated.KeyringKey),
+	})
+}
+
+// saveProfileDatabaseLocked writes config.json with profiles, makes them current and then
+// stores or removes the database password. When the credential store fails the previous
+// profiles are written back, so the file, the running configuration and the stored
+// password stay in step. configMutex must be held. It returns the status and message for
+// the response.
+func saveProfileDatabaseLocked(profiles []Profile, keyringKey, password string, clearPassword bool) (int, string, error) {
+	previous := config.Profiles
+	next := *config
+	next.Profiles = profiles
+	if err := writeConfigFileLocked(&next); err != nil {
+		return http.StatusInternalServerError, "Failed to save configuration", err
+	}
+	config.Profiles = profiles
+
+	var err error
+	message := ""
+	switch {
+	case password != "":
+		err = StoreDBPassword(keyringKey, password)
+		message = "Password stored"
+	case clearPassword:
+		err = DeleteDBPassword(keyringKey)
+		message = "Password removed"
+	}
+	if err == nil {
+		return http.StatusOK, message, nil
+	}
+
+	next.Profiles = previous
+	if restoreErr := writeConfigFileLocked(&next); restoreErr != nil {
+		log.Printf("Error restoring config after a credential store failure: %v", restoreErr)
+		return http.StatusInternalServerError, "Database settings saved, but the password could not be changed", err
+	}
+	config.Profiles = previous
+	return http.StatusInternalServerError, "Failed to change the database password; settings not saved", err
+}
+
+// handleBackups lists recent database backups of a deployment, newest first
+func handleBackups(w http.ResponseWriter, r *http.Request) {
+	profile := r.URL.Query().Get("profile")
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+
+	backupMutex.RLock()
+	records := []*BackupRecord{}
+	for i := len(backupHistory) - 1; i >= 0; i-- {
+		if backupHistory[i].Profile == profile {
+			records = append(records, backupHistory[i])
+		}
+	}
+	backupMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// Backup gate - what must be true about database backups before a retirement may start
+
+const (
+	defaultBackupGateMaxAgeHours = 24
+	maxBackupGateMaxAgeHours     = 720
+	maxAttestationLength         = 500
+)
+
+// BackupGateConfig is the policy the execute endpoint enforces
+type BackupGateConfig struct {
+	Required         bool `json:"required"`         // Refuse retirements without backup evidence
+	MaxAgeHours      int  `json:"maxAgeHours"`      // How recent a verified LRCleaner backup must be
+	AllowAttestation bool `json:"allowAttestation"` // Accept an operator's word for a backup taken elsewhere
+}
+
+// BackupAttestation is an operator's statement that the deployment was backed up outside LRCleaner
+type BackupAttestation struct {
+	Reference  string    `json:"reference"` // Backup job, file path or ticket identifying the backup
+	Note       string    `json:"note,omitempty"`
+	AttestedBy string    `json:"attestedBy"`
+	AttestedAt time.Time `json:"attestedAt"`
+}
+
+// BackupEvidence is what a retirement relied on: a verified LRCleaner backup or an attestation
+type BackupEvidence struct {
+	Backup      *BackupRecord      `json:"backup,omitempty"`
+	Attestation *BackupAttestation `json:"attestation,omitempty"`
+}
+
+// describe summarises the evidence for audit messages
+func (e *BackupEvidence) describe() string {
+	switch {
+	case e == nil:
+		return "no backup"
+	case e.Backup != nil:
+		return fmt.Sprintf("verified backup %s taken %s", e.Backup.ID, e.Backup.EndTime.Format(time.RFC3339))
+	default:
+		return fmt.Sprintf("backup attested by %s: %s", e.Attestation.AttestedBy, e.Attestation.Reference)
+	}
+}
+
+// validateBackupGateConfig checks a backup gate policy before it is saved
+func validateBackupGateConfig(gate BackupGateConfig) error {
+	if gate.MaxAgeHours < 1 || gate.MaxAgeHours > maxBackupGateMaxAgeHours {
+		return fmt.Errorf("maxAgeHours must be between 1 and %d", maxBackupGateMaxAgeHours)
+	}
+	return nil
+}
+
+// checkBackupGate finds the backup evidence for a retirement of deployment p. A verified
+// LRCleaner backup within the policy window is preferred over an attestation. It returns
+// nil evidence and no error when the gate is off and there is nothing to link.
+func checkBackupGate(p *Profile, attestation *BackupAttestation, username string) (*BackupEvidence, error) {
+	configMutex.RLock()
+	gate := config.BackupGate
+	configMutex.RUnlock()
+	maxAge := time.Duration(gate.MaxAgeHours) * time.Hour
+
+	latest := latestVerifiedBackup(p.Name)
+	if latest != nil && time.Since(latest.EndTime) <= maxAge {
+		return &BackupEvidence{Backup: latest}, nil
+	}
+
+	if attestation != nil && strings.TrimSpace(attestation.Reference) != "" {
+		if gate.Required && !gate.AllowAttestation {
+			return nil, fmt.Errorf("backup policy requires a verified LRCleaner backup of %s within the last %d hours; attestations are not accepted", p.Name, gate.MaxAgeHours)
+		}
+		attested := &BackupAttestation{
+			Reference:  strings.TrimSpace(attestation.Reference),
+			Note:       strings.TrimSpace(attestation.Note),
+			AttestedBy: username,
+			AttestedAt: time.Now(),
+		}
+		if utf8.RuneCountInString(attested.Reference)+utf8.RuneCountInString(attested.Note) > maxAttestationLength {
+			return nil, fmt.Errorf("backup reference and note must be at most %d characters together", maxAttestationLength)
+		}
+		return &BackupEvidence{Attestation: attested}, nil
+	}
+
+	if !gate.Required {
+		return nil, nil
+	}
+	if latest != nil {
+		return nil, fmt.Errorf("the last verified backup of %s was taken %s, more than %d hours ago; back up again before retiring", p.Name, latest.EndTime.Format(time.RFC3339), gate.MaxAgeHours)
+	}
+	if gate.AllowAttestation {
+		return nil, fmt.Errorf("backup policy requires a verified backup of %s within the last %d hours, or an attested backup reference", p.Name, gate.MaxAgeHours)
+	}
+	return nil, fmt.Errorf("backup policy requires a verified LRCleaner backup of %s within the last %d hours", p.Name, gate.MaxAgeHours)
+}
+
+// handleBackupGate reports whether a retirement of a deployment would pass the backup gate now
+func handleBackupGate(w http.ResponseWriter, r *http.Request) {
+	p, err := findProfile(r.URL.Query().Get("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	evidence, err := checkBackupGate(p, nil, currentUsername(r))
+	response := map[string]interface{}{
+		"policy":    config.BackupGate,
+		"satisfied": err == nil,
+		"evidence":  evidence,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Change ticket and justification limits
+const (
+	maxChangeTicketLength     = 64
+	maxJustificationLength    = 1000
+	maxShortDescriptionLength = 255
+)
+
+// validateChangeDetails trims and checks the change ticket and justification that every
+// retirement must carry
+func validateChangeDetails(changeTicket, justification string) (string, string, error) {
+	changeTicket = strings.TrimSpace(changeTicket)
+	justification = strings.TrimSpace(justification)
+
+	if changeTicket == "" {
+		return "", "", fmt.Errorf("change ticket ID is required")
+	}
+	if utf8.RuneCountInString(changeTicket) > maxChangeTicketLength {
+		return "", "", fmt.Errorf("change ticket ID must be at most %d characters", maxChangeTicketLength)
+	}
+	if justification == "" {
+		return "", "", fmt.Errorf("justification is required")
+	}
+	if utf8.RuneCountInString(justification) > maxJustificationLength {
+		return "", "", fmt.Errorf("justification must be at most %d characters", maxJustificationLength)
+	}
+	return changeTicket, justification, nil
+}
+
+func handleApplyMode(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Date    string `json:"date"`
+		Profile string `json:"profile"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	p, err := findProfile(request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse date
+	selectedDate, err := time.Parse("2006-01-02", request.Date)
+	if err != nil {
+		http.Error(w, "Invalid date format", http.StatusBadRequest)
+		return
+	}
+
+	// Create job
+	jobID := fmt.Sprintf("apply_%d", time.Now().Unix())
+	job := &JobStatus{
+		ID:        jobID,
+		Status:    "running",
+		Progress:  0,
+		Message:   "Analyzing hosts for retirement...",
+		Profile:   p.Name,
+		StartedBy: currentUsername(r),
+		StartTime: time.Now(),
+	}
+
+	jobsMutex.Lock()
+	jobs[jobID] = job
+	jobsMutex.Unlock()
+
+	entry := requestAudit(r, "analysis.start", "hosts")
+	entry.Profile = p.Name
+	entry.JobID = jobID
+	entry.Message = fmt.Sprintf("Host retirement analysis for activity since %s", request.Date)
+	logAudit(entry)
+
+	// Start analysis in background
+	go analyzeHostsForRetirement(p, jobID, selectedDate)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
+}
+
+func handleExecuteApply(w http.ResponseWriter, r *http.Request) {
+	var request ApplyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(request.SelectedHosts) == 0 {
+		http.Error(w, "No hosts selected", http.StatusBadRequest)
+		return
+	}
+
+	changeTicket, justification, err := validateChangeDetails(request.ChangeTicket, request.Justification)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Retirement only ever touches the deployment the hosts were analysed on
+	p, err := findProfile(request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if latestHostAnalysis(p.Name) == nil {
+		http.Error(w, fmt.Sprintf("No host analysis found for profile %s. Please run Apply Mode analysis first.", p.Name), http.StatusBadRequest)
+		return
+	}
+
+	jobID := fmt.Sprintf("execute_%d", time.Now().Unix())
+	naming := RetirementNaming{
+		Date:          time.Now(),
+		Operator:      currentUsername(r),
+		Ticket:        changeTicket,
+		Justification: justification,
+		JobID:         jobID,
+		SourceIP:      clientIP(r),
+		Profile:       p.Name,
+	}
+
+	// The backup gate is checked before anything is changed, and refusals are audited too
+	naming.Backup, err = checkBackupGate(p, request.Attestation, naming.Operator)
+	if err != nil {
+		entry := namingAudit(naming, "retirement.request", strings.Join(request.SelectedHosts, ","))
+		entry.Result = auditResultFailure
+		entry.Message = "Refused by backup policy: " + err.Error()
+		logAudit(entry)
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	// No retirement without an evidence trail
+	entry := namingAudit(naming, "retirement.request", strings.Join(request.SelectedHosts, ","))
+	entry.Message = fmt.Sprintf("Retirement of %d hosts requested with %s: %s", len(request.SelectedHosts), naming.Backup.describe(), justification)
+	entry.After = auditValue(naming.Backup)
+	if err := recordAudit(entry); err != nil {
+		http.Error(w, "Cannot start retirement: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create job
+	job := &JobStatus{
+		ID:            jobID,
+		Status:        "running",
+		Progress:      0,
+		Message:       "Starting retirement process...",
+		Profile:       p.Name,
+		StartedBy:     currentUsername(r),
+		ChangeTicket:  changeTicket,
+		Justification: justification,
+		Backup:        naming.Backup,
+		StartTime:     time.Now(),
+	}
+
+	jobsMutex.Lock()
+	jobs[jobID] = job
+	jobsMutex.Unlock()
+
+	started := jobEvent(EventRetirementStarted, job)
+	started.Summary = fmt.Sprintf("Retirement of %d hosts started: %s", len(request.SelectedHosts), justification)
+	started.Data["hosts"] = len(request.SelectedHosts)
+	notifyEvent(started)
+
+	// Start retirement in background
+	go executeRetirement(p, jobID, request.SelectedHosts, naming)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"jobId": jobID})
+}
+
+func handleRetireCollectionHosts(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Profile                 string   `json:"profile"`
+		SelectedCollectionHosts []string `json:"selectedCollectionHosts"`
+		ChangeTicket            string   `json:"changeTicket"`
+		Justification           string   `json:"justification"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(request.SelectedCollectionHosts) == 0 {
+		http.Error(w, "No collection hosts selected", http.StatusBadRequest)
+		return
+	}
+
+	changeTicket, justification, err := validateChangeDetails(request.ChangeTicket, request.Justification)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := findProfile(request.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// For now, just record the selected collection hosts
+	// In a real implementation, you would retire the collection hosts here
+	entry := requestAudit(r, "collection-host.retire.request", strings.Join(request.SelectedCollectionHosts, ","))
+	entry.Profile = p.Name
+	entry.Ticket = changeTicket
+	entry.Message = fmt.Sprintf("Retirement of %d collection hosts requested: %s", len(request.SelectedCollectionHosts), justification)
+	if err := recordAudit(entry); err != nil {
+		http.Error(w, "Cannot start retirement: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Collection host retirement initiated for %d hosts", len(request.SelectedCollectionHosts)),
+	})
+}
+
+func handleExportPDF(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["jobId"]
+
+	jobsMutex.RLock()
+	job, exists := jobs[jobID]
+	jobsMutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	if len(job.RetirementRecords) == 0 && len(job.Failures) == 0 {
+		http.Error(w, "No retirement records to export", http.StatusBadRequest)
+		return
+	}
+
+	jobsMutex.RLock()
+	snapshot := *job
+	jobsMutex.RUnlock()
+	report := buildRetirementReport(&snapshot, time.Now())
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Report_%s_%s.pdf\"", job.Profile, jobID))
+	w.Write(report)
+}
+
+// Webhooks - signed notifications to chat channels and automation when jobs finish or fail
+
+// Events a webhook can subscribe to
+const (
+	EventAnalysisCompleted   = "analysis.completed"
+	EventRetirementStarted   = "retirement.started"
+	EventRetirementCompleted = "retirement.completed" // Also sent when some changes were refused; see the failures count
+	EventRetirementFailed    = "retirement.failed"
+	EventRollbackExecuted    = "rollback.executed"
+	EventBackupFailed        = "backup.failed"
+	EventWebhookTest         = "webhook.test" // Sent by the test button to one webhook only
+)
+
+var webhookEvents = []string{EventAnalysisCompleted, EventRetirementStarted, EventRetirementCompleted, EventRetirementFailed, EventRollbackExecuted, EventBackupFailed}
+
+var webhookEventTitles = map[string]string{
+	EventAnalysisCompleted:   "Analysis completed",
+	EventRetirementStarted:   "Retirement started",
+	EventRetirementCompleted: "Retirement completed",
+	EventRetirementFailed:    "Retirement failed",
+	EventRollbackExecuted:    "Rollback executed",
+	EventBackupFailed:        "Backup failed",
+	EventWebhookTest:         "Test notification",
+}
+
+// Payload formats
+const (
+	WebhookFormatJSON  = "json"  // The LRCleaner event as it is
+	WebhookFormatSlack = "slack" // Slack incoming webhook message
+	WebhookFormatTeams = "teams" // Microsoft Teams connector MessageCard
+)
+
+const (
+	webhookSecretPrefix  = "webhook_" // Credential store key prefix of signing secrets
+	maxWebhookAttempts   = 5
+	webhookRetryDelay    = 2 * time.Second // Doubled after every failed attempt
+	webhookTimeout       = 10 * time.Second
+	maxWebhookDeliveries = 200 // Delivery log entries kept in memory
+)
+
+// Webhook is an endpoint told about job events. An empty event list subscribes to every
+// event and a webhook without a profile hears about every deployment. The signing secret
+// is kept in the OS credential store, never in the config file.
+type Webhook struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	URL       string     `json:"url"`
+	Format    string     `json:"format"`
+	Events    []string   `json:"events"`
+	Profile   string     `json:"profile,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// webhookView is a webhook as the API returns it: whether it signs, never the secret
+type webhookView struct {
+	Webhook
+	HasSecret bool `json:"hasSecret"`
+}
+
+// webhookRequest is a webhook as the settings form posts it. Secret "" leaves the stored
+// secret unchanged and "-" removes it.
+type webhookRequest struct {
+	Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookEvent is the body of a json webhook and the source of Slack and Teams messages
+type WebhookEvent struct {
+	ID        string                 `json:"id"` // The same for every webhook and every retry
+	Event     string                 `json:"event"`
+	Timestamp time.Time              `json:"timestamp"`
+	Profile   string                 `json:"profile,omitempty"`
+	JobID     string                 `json:"jobId,omitempty"`
+	User      string                 `json:"user,omitempty"`
+	Summary   string                 `json:"summary"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// WebhookDelivery is one event sent to one webhook, kept in the delivery log
+type WebhookDelivery struct {
+	ID          string     `json:"id"`
+	WebhookID   string     `json:"webhookId"`
+	WebhookName string     `json:"webhookName"`
+	Event       string     `json:"event"`
+	EventID     string     `json:"eventId"`
+	JobID       string     `json:"jobId,omitempty"`
+	Status      string     `json:"status"` // pending, delivered or failed
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"statusCode,omitempty"` // Of the last attempt
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// webhookDataLabels names the event data shown in Slack and Teams messages, in display order
+var webhookDataLabels = []struct{ Key, Label string }{
+	{"changeTicket", "Change ticket"},
+	{"deployments", "Deployments"},
+	{"logSources", "Log sources"},
+	{"hosts", "Hosts"},
+	{"agents", "Agents"},
+	{"failures", "Refused or failed"},
+	{"rollbackId", "Rollback point"},
+	{"backupId", "Backup"},
+	{"error", "Error"},
+}
+
+var (
+	webhookDeliveries []*WebhookDelivery // Oldest first
+	webhookMutex      sync.RWMutex
+
+	// webhookClient and webhookRetryWait are variables so deliveries can be pointed at a
+	// local receiver and retried without waiting. Redirects are not followed so a signed
+	// body is never sent somewhere else.
+	webhookClient = &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	webhookRetryWait = time.Sleep
+)
+
+// validateWebhook checks a webhook before it is saved
+func validateWebhook(hook Webhook) error {
+	if strings.TrimSpace(hook.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	target, err := url.Parse(hook.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	switch hook.Format {
+	case WebhookFormatJSON, WebhookFormatSlack, WebhookFormatTeams:
+	default:
+		return fmt.Errorf("format must be json, slack or teams")
+	}
+	for _, event := range hook.Events {
+		if webhookEventTitles[event] == "" || event == EventWebhookTest {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// redacted returns the webhook with its URL cut to the scheme and host. Chat webhook URLs
+// carry their own credentials, so only this form is written to the audit log.
+func (hook Webhook) redacted() Webhook {
+	if target, err := url.Parse(hook.URL); err == nil {
+		hook.URL = target.Scheme + "://" + target.Host + "/..."
+	}
+	return hook
+}
+
+// subscribes reports whether the webhook wants the event
+func (hook Webhook) subscribes(event WebhookEvent) bool {
+	if !hook.Enabled || (hook.Profile != "" && hook.Profile != event.Profile) {
+		return false
+	}
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, name := range hook.Events {
+		if name == event.Event {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyEvent sends the event to every webhook that subscribes to it and to its email
+// recipients. Deliveries run in the background so a slow or broken receiver never holds up a job.
+func notifyEvent(event WebhookEvent) {
+	event.ID = randomToken(8)
+	event.Timestamp = time.Now().UTC()
+	configMutex.RLock()
+	hooks := slices.Clone(config.Webhooks)
+	configMutex.RUnlock()
+	for _, hook := range hooks {
+		if hook.subscribes(event) {
+			go deliverWebhook(hook, event, newWebhookDelivery(hook, event), maxWebhookAttempts)
+		}
+	}
+	go sendEventEmail(event)
+}
+
+// jobEvent describes a job for a webhook, summarised by its current message
+func jobEvent(name string, job *JobStatus) WebhookEvent {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	event := WebhookEvent{
+		Event:   name,
+		Profile: job.Profile,
+		JobID:   job.ID,
+		User:    job.StartedBy,
+		Summary: job.Message,
+		Data:    map[string]interface{}{},
+	}
+	if job.ChangeTicket != "" {
+		event.Data["changeTicket"] = job.ChangeTicket
+	}
+	if len(job.Profiles) > 0 {
+		event.Data["deployments"] = strings.Join(job.Profiles, ", ")
+	}
+	switch name {
+	case EventAnalysisCompleted:
+		if len(job.Results) > 0 {
+			event.Data["logSources"] = len(job.Results)
+		}
+		if len(job.HostAnalysis) > 0 {
+			event.Data["hosts"] = len(job.HostAnalysis)
+		}
+		if job.StatusCounts != nil {
+			counts := job.StatusCounts.LogSources
+			if job.StatusCounts.Hosts != nil {
+				counts = job.StatusCounts.Hosts
+			}
+			event.Data["troubleshoot"] = counts[StatusStaleReachable]
+			event.Data["retirementCandidates"] = counts[StatusStaleUnreachable]
+		}
+	case EventRetirementCompleted, EventRetirementFailed:
+		event.Data["logSources"] = len(job.RetirementRecords)
+		event.Data["hosts"] = len(job.RetiredHosts)
+		event.Data["agents"] = len(job.RetiredAgents)
+		event.Data["failures"] = len(job.Failures)
+		if job.RollbackID != "" {
+			event.Data["rollbackId"] = job.RollbackID
+		}
+	}
+	if job.Error != "" {
+		event.Summary = job.Error
+		event.Data["error"] = job.Error
+	}
+	return event
+}
+
+// notifyAnalysisCompleted tells webhooks about an analysis job that finished without error
+func notifyAnalysisCompleted(job *JobStatus) {
+	jobsMutex.RLock()
+	completed := job.Status == "completed"
+	jobsMutex.RUnlock()
+	if completed {
+		notifyEvent(jobEvent(EventAnalysisCompleted, job))
+	}
+}
+
+// newWebhookDelivery adds a pending delivery to the log, dropping the oldest beyond the limit
+func newWebhookDelivery(hook Webhook, event WebhookEvent) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		ID:          randomToken(8),
+		WebhookID:   hook.ID,
+		WebhookName: hook.Name,
+		Event:       event.Event,
+		EventID:     event.ID,
+		JobID:       event.JobID,
+		Status:      "pending",
+		CreatedAt:   time.Now(),
+	}
+	webhookMutex.Lock()
+	webhookDeliveries = append(webhookDeliveries, delivery)
+	if len(webhookDeliveries) > maxWebhookDeliveries {
+		webhookDeliveries = webhookDeliveries[len(webhookDeliveries)-maxWebhookDeliveries:]
+	}
+	webhookMutex.Unlock()
+	return delivery
+}
+
+// deliverWebhook posts the event, retrying network errors, 408, 429 and 5xx responses with
+// exponential backoff. Any other response ends the delivery.
+func deliverWebhook(hook Webhook, event WebhookEvent, delivery *WebhookDelivery, attempts int) {
+	body, err := webhookBody(hook.Format, event)
+	var secret string
+	if err == nil {
+		secret, err = GetWebhookSecret(hook.ID)
+	}
+	if err != nil {
+		finishWebhookDelivery(delivery, "failed", fmt.Sprintf("cannot prepare delivery: %v", err))
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		code, err := postWebhook(hook.URL, delivery.ID, event.Event, body, secret)
+		message := ""
+		if err != nil {
+			message = err.Error()
+		} else if code < 200 || code > 299 {
+			message = fmt.Sprintf("receiver returned HTTP %d", code)
+		}
+		retry := err != nil || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+
+		webhookMutex.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = code
+		delivery.Error = message
+		webhookMutex.Unlock()
+
+		switch {
+		case message == "":
+			finishWebhookDelivery(delivery, "delivered", "")
+			return
+		case !retry || attempt >= attempts:
+			finishWebhookDelivery(delivery, "failed", message)
+			return
+		}
+		log.Printf("Webhook %s delivery %s attempt %d failed: %s; retrying in %v", hook.Name, delivery.ID, attempt, message, delay)
+		webhookRetryWait(delay)
+		delay *= 2
+	}
+}
+
+// finishWebhookDelivery records how a delivery ended
+func finishWebhookDelivery(delivery *WebhookDelivery, status, message string) {
+	now := time.Now()
+	webhookMutex.Lock()
+	delivery.Status = status
+	delivery.Error = message
+	delivery.CompletedAt = &now
+	webhookMutex.Unlock()
+	if status == "failed" {
+		log.Printf("Webhook %s delivery %s of %s failed: %s", delivery.WebhookName, delivery.ID, delivery.Event, message)
+	}
+}
+
+// postWebhook makes one delivery attempt and returns the response status. With a secret
+// the request carries X-LRCleaner-Signature: sha256=<hex HMAC-SHA256 of "timestamp.body">.
+func postWebhook(target, deliveryID, event string, body []byte, secret string) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LRCleaner-Webhook")
+	req.Header.Set("X-LRCleaner-Event", event)
+	req.Header.Set("X-LRCleaner-Delivery", deliveryID)
+	req.Header.Set("X-LRCleaner-Timestamp", timestamp)
+	if secret != "" {
+		req.Header.Set("X-LRCleaner-Signature", "sha256="+webhookSignature(secret, timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// The URL in a *url.Error may hold credentials; keep only the cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// webhookSignature is the hex HMAC-SHA256 of the timestamp, a dot and the body
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookFacts lists the deployment, job, user and event data as label and value pairs
+func webhookFacts(event WebhookEvent) []reportFact {
+	facts := []reportFact{}
+	for _, fact := range []reportFact{{"Deployment", event.Profile}, {"Job", event.JobID}, {"User", event.User}} {
+		if fact.Value != "" {
+			facts = append(facts, fact)
+		}
+	}
+	for _, item := range webhookDataLabels {
+		if value := fmt.Sprint(event.Data[item.Key]); event.Data[item.Key] != nil && value != "" {
+			facts = append(facts, reportFact{item.Label, value})
+		}
+	}
+	return facts
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// webhookBody renders the event in the webhook's payload format
+func webhookBody(format string, event WebhookEvent) ([]byte, error) {
+	title := "LRCleaner: " + webhookEventTitles[event.Event]
+	switch format {
+	case WebhookFormatSlack:
+		lines := []string{"*" + slackEscaper.Replace(title) + "*", slackEscaper.Replace(event.Summary)}
+		for _, fact := range webhookFacts(event) {
+			lines = append(lines, fmt.Sprintf("*%s:* %s", fact.Label, slackEscaper.Replace(fact.Value)))
+		}
+		return json.Marshal(map[string]string{"text": strings.Join(lines, "\n")})
+
+	case WebhookFormatTeams:
+		facts := []map[string]string{}
+		for _, fact := range webhookFacts(event) {
+			facts = append(facts, map[string]string{"name": fact.Label, "value": fact.Value})
+		}
+		color := "2D8CD6"
+		if event.Event == EventRetirementFailed || event.Event == EventBackupFailed {
+			color = "D32F2F"
+		}
+		return json.Marshal(map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    title,
+			"title":      title,
+			"text":       event.Summary,
+			"themeColor": color,
+			"sections":   []map[string]interface{}{{"facts": facts}},
+		})
+	}
+	return json.Marshal(event)
+}
+
+// readWebhook decodes and validates a webhook from a request body
+func readWebhook(r *http.Request) (webhookRequest, error) {
+	var request webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return request, fmt.Errorf("Invalid JSON")
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	request.URL = strings.TrimSpace(request.URL)
+	if request.Format == "" {
+		request.Format = WebhookFormatJSON
+	}
+	if request.Events == nil {
+		request.Events = []string{}
+	}
+	if err := validateWebhook(request.Webhook); err != nil {
+		return request, err
+	}
+	if request.Profile != "" && profileIndex(request.Profile) < 0 {
+		return request, fmt.Errorf("deployment profile %q not found", request.Profile)
+	}
+	return request, nil
+}
+
+// saveWebhookSecret applies the secret field of a webhook request
+func saveWebhookSecret(id, secret string) error {
+	switch secret {
+	case "":
+		return nil
+	case "-":
+		return DeleteWebhookSecret(id)
+	}
+	return StoreWebhookSecret(id, secret)
+}
+
+// webhookIndex returns the position of the webhook in config.Webhooks, or -1; configMutex
+// must be held
+func webhookIndex(id string) int {
+	for i, hook := range config.Webhooks {
+		if hook.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveWebhooksLocked writes config.json with hooks as the webhook list and makes them
+// current once the file is written; configMutex must be held
+func saveWebhooksLocked(hooks []Webhook) error {
+	next := *config
+	next.Webhooks = hooks
+	if err := writeConfigFileLocked(&next); err != nil {
+		return err
+	}
+	config.Webhooks = hooks
+	return nil
+}
+
+// webhookAudit starts an audit entry for a webhook change, noting a secret change
+func webhookAudit(r *http.Request, action string, hook Webhook, secret string) AuditEntry {
+	audit := requestAudit(r, action, hook.ID)
+	audit.Profile = hook.Profile
+	audit.Message = hook.Name
+	switch secret {
+	case "":
+	case "-":
+		audit.Message += "; signing secret removed"
+	default:
+		audit.Message += "; signing secret set"
+	}
+	return audit
+}
+
+// handleWebhooks lists webhooks (GET) or creates one (POST)
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		configMutex.RLock()
+		hooks := slices.Clone(config.Webhooks)
+		configMutex.RUnlock()
+		views := []webhookView{}
+		for _, hook := range hooks {
+			views = append(views, webhookView{Webhook: hook, HasSecret: HasWebhookSecret(hook.ID)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+
+	case "POST":
+		request, err := readWebhook(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook := request.Webhook
+		now := time.Now()
+		hook.ID = randomToken(8)
+		hook.CreatedBy = currentUsername(r)
+		hook.CreatedAt = &now
+
+		if err := saveWebhookSecret(hook.ID, request.Secret); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		configMutex.Lock()
+		err = saveWebhooksLocked(append(slices.Clone(config.Webhooks), hook))
+		configMutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving config: %v", err)
+			DeleteWebhookSecret(hook.ID)
+			http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+			return
+		}
+
+		audit := webhookAudit(r, "webhook.create", hook, request.Secret)
+		audit.After = auditValue(hook.redacted())
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhookView{Webhook: hook, HasSecret: HasWebhookSecret(hook.ID)})
+	}
+}
+
+// handleWebhook updates or deletes one webhook
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var request webhookRequest
+	if r.Method == "PUT" {
+		var err error
+		if request, err = readWebhook(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	configMutex.Lock()
+	index := webhookIndex(id)
+	if index < 0 {
+		configMutex.Unlock()
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	before := config.Webhooks[index]
+	previous := config.Webhooks
+	hooks := slices.Clone(config.Webhooks)
+	hook := request.Webhook
+	if r.Method == "PUT" {
+		hook.ID = before.ID
+		hook.CreatedBy = before.CreatedBy
+		hook.CreatedAt = before.CreatedAt
+		hooks[index] = hook
+	} else {
+		hooks = slices.Delete(hooks, index, index+1)
+	}
+	if err := saveWebhooksLocked(hooks); err != nil {
+		configMutex.Unlock()
+		log.Printf("Error saving config: %v", err)
+		http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+	// The secret changes once the webhook is saved; if the credential store refuses it the
+	// previous webhook is written back so it keeps signing with the secret it had
+	if err := saveWebhookSecret(id, request.Secret); err != nil {
+		if restoreErr := saveWebhooksLocked(previous); restoreErr != nil {
+			log.Printf("Error restoring webhook %s after a credential store failure: %v", id, restoreErr)
+		}
+		configMutex.Unlock()
+		log.Printf("Error saving webhook secret: %v", err)
+		http.Error(w, "Failed to store the signing secret; webhook not changed", http.StatusInternalServerError)
+		return
+	}
+	configMutex.Unlock()
+
+	switch r.Method {
+	case "PUT":
+		audit := webhookAudit(r, "webhook.update", hook, request.Secret)
+		audit.Before = auditValue(before.redacted())
+		audit.After = auditValue(hook.redacted())
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(webhookView{Webhook: hook, HasSecret: HasWebhookSecret(hook.ID)})
+
+	case "DELETE":
+		if err := DeleteWebhookSecret(id); err != nil {
+			log.Printf("Error deleting webhook secret: %v", err)
+		}
+
+		audit := webhookAudit(r, "webhook.delete", before, "")
+		audit.Before = auditValue(before.redacted())
+		logAudit(audit)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	}
+}
+
+// handleWebhookTest sends a test event to one webhook, enabled or not, and waits for the
+// single attempt so the settings page can show the outcome
+func handleWebhookTest(w http.ResponseWriter, r *http.Request) {
+	configMutex.RLock()
+	index := webhookIndex(mux.Vars(r)["id"])
+	var hook Webhook
+	if index >= 0 {
+		hook = config.Webhooks[index]
+	}
+	configMutex.RUnlock()
+	if index < 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	event := WebhookEvent{
+		ID:        randomToken(8),
+		Event:     EventWebhookTest,
+		Timestamp: time.Now().UTC(),
+		Profile:   hook.Profile,
+		User:      currentUsername(r),
+		Summary:   fmt.Sprintf("Test notification for webhook %s", hook.Name),
+	}
+	delivery := newWebhookDelivery(hook, event)
+	deliverWebhook(hook, event, delivery, 1)
+
+	webhookMutex.RLock()
+	result := *delivery
+	webhookMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleWebhookDeliveries returns the delivery log, newest first, optionally for one webhook
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhookID := r.URL.Query().Get("webhook")
+	deliveries := []WebhookDelivery{}
+	webhookMutex.RLock()
+	for i := len(webhookDeliveries) - 1; i >= 0; i-- {
+		if webhookID == "" || webhookDeliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, *webhookDeliveries[i])
+		}
+	}
+	webhookMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// Email notifications - job summaries and reports sent to distribution lists over SMTP
+
+// Connection security of the SMTP server
+const (
+	SMTPStartTLS = "starttls" // Plain connection upgraded with STARTTLS, usually port 587; refused if the server does not offer it
+	SMTPTLS      = "tls"      // TLS from the first byte, usually port 465
+	SMTPNone     = "none"     // No encryption, for a relay on the same host; sign-in is refused
+)
+
+// Report files an email route can attach
+const (
+	AttachCSV = "csv" // Every non-empty export sheet of the job as CSV
+	AttachPDF = "pdf" // The PDF retirement report, for retirement jobs
+)
+
+const smtpTimeout = 30 * time.Second
+
+// EmailConfig is the SMTP server notifications are sent through and who receives them.
+// The password for Username is kept in the OS credential store.
+type EmailConfig struct {
+	Enabled    bool         `json:"enabled"`
+	Host       string       `json:"host"`
+	Port       int          `json:"port"`
+	Security   string       `json:"security"`
+	Username   string       `json:"username,omitempty"`
+	From       string       `json:"from"`
+	CABundle   string       `json:"caBundle,omitempty"` // PEM file of additional trusted CAs, for an internal mail server
+	Recipients []EmailRoute `json:"recipients"`
+}
+
+// EmailRoute sends one event, or every event when Event is empty, to a distribution list.
+// A route without a profile applies to every deployment.
+type EmailRoute struct {
+	Event       string   `json:"event,omitempty"`
+	Profile     string   `json:"profile,omitempty"`
+	To          []string `json:"to"`
+	Attachments []string `json:"attachments,omitempty"` // csv, pdf
+}
+
+// emailAttachment is a file attached to a notification
+type emailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// validateEmailRoute checks one recipient rule; the caller checks its profile exists
+func validateEmailRoute(route EmailRoute) error {
+	if route.Event != "" && (webhookEventTitles[route.Event] == "" || route.Event == EventWebhookTest) {
+		return fmt.Errorf("unknown event %q", route.Event)
+	}
+	if len(route.To) == 0 {
+		return fmt.Errorf("at least one address is required")
+	}
+	for _, address := range route.To {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid address %q", address)
+		}
+	}
+	for _, attachment := range route.Attachments {
+		if attachment != AttachCSV && attachment != AttachPDF {
+			return fmt.Errorf("attachments must be csv or pdf")
+		}
+	}
+	return nil
+}
+
+// validateEmailConfig checks the SMTP settings before they are saved. Servers are only
+// checked when notifications are on, so half-filled settings can be kept switched off.
+func validateEmailConfig(c EmailConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(c.Host) == "" {
+		return fmt.Errorf("host is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	switch c.Security {
+	case SMTPStartTLS, SMTPTLS:
+	case SMTPNone:
+		if c.Username != "" {
+			return fmt.Errorf("sign-in requires starttls or tls security")
+		}
+	default:
+		return fmt.Errorf("security must be starttls, tls or none")
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("from must be an email address")
+	}
+	if c.CABundle != "" {
+		if _, err := caBundleRoots(c.CABundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// caBundleRoots is the system trust store plus the CAs in bundle
+func caBundleRoots(bundle string) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	pemData, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	if !roots.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("CA bundle %s contains no certificates", bundle)
+	}
+	return roots, nil
+}
+
+// emailRecipients lists the addresses that want the event, each once
+func emailRecipients(c EmailConfig, event WebhookEvent) (to []string, attachments map[string]bool) {
+	seen := make(map[string]bool)
+	attachments = make(map[string]bool)
+	for _, route := range c.Recipients {
+		if (route.Event != "" && route.Event != event.Event) || (route.Profile != "" && route.Profile != event.Profile) {
+			continue
+		}
+		for _, address := range route.To {
+			if key := strings.ToLower(address); !seen[key] {
+				seen[key] = true
+				to = append(to, address)
+			}
+		}
+		for _, attachment := range route.Attachments {
+			attachments[attachment] = true
+		}
+	}
+	return to, attachments
+}
+
+// sendEventEmail mails the event to every route that wants it, with the job's reports when
+// the event finishes a job. One message goes out per event so each recipient gets one copy.
+func sendEventEmail(event WebhookEvent) {
+	configMutex.RLock()
+	settings := config.Email
+	configMutex.RUnlock()
+	if !settings.Enabled {
+		return
+	}
+	to, wanted := emailRecipients(settings, event)
+	if len(to) == 0 {
+		return
+	}
+
+	var attachments []emailAttachment
+	switch event.Event {
+	case EventAnalysisCompleted, EventRetirementCompleted, EventRetirementFailed:
+		jobsMutex.RLock()
+		job, exists := jobs[event.JobID]
+		var snapshot JobStatus
+		if exists {
+			snapshot = *job
+		}
+		jobsMutex.RUnlock()
+		if exists {
+			attachments = jobAttachments(&snapshot, wanted)
+		}
+	}
+
+	subject := fmt.Sprintf("[LRCleaner] %s", webhookEventTitles[event.Event])
+	if event.Profile != "" {
+		subject += " - " + event.Profile
+	}
+	message, err := buildEmail(settings.From, to, subject, emailText(event), attachments)
+	if err == nil {
+		err = sendEmail(settings, GetSMTPPassword(), to, message)
+	}
+	if err != nil {
+		log.Printf("Email notification of %s to %s failed: %v", event.Event, strings.Join(to, ", "), err)
+		return
+	}
+	log.Printf("Emailed %s to %s with %d attachments", event.Event, strings.Join(to, ", "), len(attachments))
+}
+
+// jobAttachments builds the report files the routes asked for
+func jobAttachments(job *JobStatus, wanted map[string]bool) []emailAttachment {
+	var attachments []emailAttachment
+	if wanted[AttachCSV] {
+		for _, sheet := range buildExportSheets(job, exportFilter{}) {
+			if len(sheet.Rows) == 0 {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := writeExportCSV(&buf, sheet); err != nil {
+				log.Printf("Error writing %s CSV for job %s: %v", sheet.Key, job.ID, err)
+				continue
+			}
+			attachments = append(attachments, emailAttachment{
+				Name:        fmt.Sprintf("LRCleaner_%s_%s.csv", sheet.Key, job.ID),
+				ContentType: "text/csv",
+				Data:        buf.Bytes(),
+			})
+		}
+	}
+	if wanted[AttachPDF] && (len(job.RetirementRecords) > 0 || len(job.Failures) > 0) {
+		attachments = append(attachments, emailAttachment{
+			Name:        fmt.Sprintf("LRCleaner_Report_%s_%s.pdf", job.Profile, job.ID),
+			ContentType: "application/pdf",
+			Data:        buildRetirementReport(job, time.Now()),
+		})
+	}
+	return attachments
+}
+
+// emailText is the plain text body: the summary followed by the event details
+func emailText(event WebhookEvent) string {
+	var body strings.Builder
+	body.WriteString(event.Summary + "\r\n\r\n")
+	for _, fact := range webhookFacts(event) {
+		fmt.Fprintf(&body, "%s: %s\r\n", fact.Label, fact.Value)
+	}
+	fmt.Fprintf(&body, "Time: %s\r\n", event.Timestamp.Format(time.RFC1123))
+	body.WriteString("\r\n-- \r\nSent by LRCleaner\r\n")
+	return body.String()
+}
+
+// buildEmail writes a MIME message: a quoted-printable text part and base64 attachments
+func buildEmail(from string, to []string, subject, text string, attachments []emailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	domain := "lrcleaner"
+	if address, err := mail.ParseAddress(from); err == nil {
+		domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+	}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", randomToken(16), domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoder := quotedprintable.NewWriter(part)
+	encoder.Write([]byte(text))
+	encoder.Close()
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendEmail delivers a message through the configured server. STARTTLS is required when
+// chosen, and the server certificate is always verified.
+func sendEmail(c EmailConfig, password string, to []string, message []byte) error {
+	tlsConfig := &tls.Config{ServerName: c.Host, MinVersion: tls.VersionTLS12}
+	if c.CABundle != "" {
+		roots, err := caBundleRoots(c.CABundle)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	address := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if c.Security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot connect to %s: %v", address, err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * smtpTimeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP greeting from %s failed: %v", address, err)
+	}
+	defer client.Close()
+
+	if c.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS", address)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if c.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s does not offer sign-in", address)
+		}
+		if err := client.Auth(smtp.PlainAuth("", c.Username, password, c.Host)); err != nil {
+			return fmt.Errorf("sign-in as %s failed: %v", c.Username, err)
+		}
+	}
+
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("MAIL FROM refused: %v", err)
+	}
+	for _, recipient := range to {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q", recipient)
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("recipient %s refused: %v", address.Address, err)
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA refused: %v", err)
+	}
+	if _, err := data.Write(message); err != nil {
+		return fmt.Errorf("sending message failed: %v", err)
+	}
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("message refused: %v", err)
+	}
+	return client.Quit()
+}
+
+// handleEmailTest sends a test message to one address with the saved settings
+func handleEmailTest(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		To string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := mail.ParseAddress(request.To); err != nil {
+		http.Error(w, "to must be an email address", http.StatusBadRequest)
+		return
+	}
+	// A test may run before notifications are switched on, but not with incomplete settings
+	configMutex.RLock()
+	settings := config.Email
+	configMutex.RUnlock()
+	check := settings
+	check.Enabled = true
+	if err := validateEmailConfig(check); err != nil {
+		http.Error(w, "SMTP settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event := WebhookEvent{
+		Event:     EventWebhookTest,
+		Timestamp: time.Now().UTC(),
+		User:      currentUsername(r),
+		Summary:   "Test message from LRCleaner. Email notifications are working.",
+	}
+	message, err := buildEmail(settings.From, []string{request.To}, "[LRCleaner] Test notification", emailText(event), nil)
+	if err == nil {
+		err = sendEmail(settings, GetSMTPPassword(), []string{request.To}, message)
+	}
+
+	entry := requestAudit(r, "email.test", request.To)
+	entry.Message = fmt.Sprintf("Test message through %s:%d", settings.Host, settings.Port)
+	if err != nil {
+		entry.Result = auditResultFailure
+		entry.Message += ": " + err.Error()
+	}
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Test message sent to " + request.To})
+}
+
+// Syslog forwarding - every audit entry sent to the SIEM as RFC 5424 syslog, so LogRhythm
+// can collect LRCleaner activity as a log source of its own
+
+// Transport to the syslog collector
+const (
+	SyslogUDP = "udp" // RFC 5426; a collector that is down cannot be detected
+	SyslogTCP = "tcp" // RFC 6587
+	SyslogTLS = "tls" // RFC 5425, usually port 6514
+)
+
+// Message body after the syslog header
+const (
+	SyslogCEF  = "cef"  // ArcSight Common Event Format
+	SyslogJSON = "json" // The audit entry as written to audit.log
+)
+
+// Framing of messages on TCP and TLS streams
+const (
+	SyslogOctetCounting = "octet-counting" // Length before each message, required by RFC 5425
+	SyslogNewline       = "newline"        // Line feed after each message, for collectors that expect it
+)
+
+const (
+	syslogBufferFile    = "syslog-buffer.log"
+	syslogAppName       = "LRCleaner"
+	syslogTimeout       = 10 * time.Second
+	syslogRetryInterval = 30 * time.Second
+	maxSyslogBuffer     = 100000
+	maxSyslogUDPMessage = 8192 // Longer JSON messages leave out the before and after snapshots
+)
+
+// Syslog severities LRCleaner sends
+const (
+	syslogError  = 3
+	syslogNotice = 5
+	syslogInfo   = 6
+)
+
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig is the collector audit entries are forwarded to
+type SyslogConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Host       string `json:"host"`
+	Port       int    `json:"port"`
+	Protocol   string `json:"protocol"` // udp, tcp or tls
+	Format     string `json:"format"`   // cef or json
+	Facility   string `json:"facility"`
+	Framing    string `json:"framing"`            // TCP and TLS only
+	CABundle   string `json:"caBundle,omitempty"` // PEM file of additional trusted CAs, for TLS
+	BufferSize int    `json:"bufferSize"`         // Messages kept while the collector is unreachable; the oldest are dropped beyond it
+}
+
+// SyslogStatus reports whether forwarding is keeping up
+type SyslogStatus struct {
+	Enabled     bool       `json:"enabled"`
+	Destination string     `json:"destination,omitempty"`
+	Buffered    int        `json:"buffered"` // Messages waiting for the collector
+	Dropped     int64      `json:"dropped"`  // Messages lost to a full buffer since startup
+	LastSent    *time.Time `json:"lastSent,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+// syslogMessage is a formatted message waiting to be sent; IDs only grow
+type syslogMessage struct {
+	id   int64
+	text string
+}
+
+var (
+	syslogMutex     sync.Mutex
+	syslogPending   []syslogMessage // Oldest first
+	syslogNextID    int64
+	syslogDropped   int64
+	syslogLastSent  *time.Time
+	syslogLastError string
+	syslogWake      = make(chan struct{}, 1)
+	syslogHostname  = syslogHeaderField(localHostname(), 255)
+)
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	sdValueEscaper   = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+// validateSyslogConfig checks the collector settings before they are saved. Like email, they
+// are only checked when forwarding is on.
+func validateSyslogConfig(c SyslogConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(c.Host) == "" {
+		return fmt.Errorf("host is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if c.Protocol != SyslogUDP && c.Protocol != SyslogTCP && c.Protocol != SyslogTLS {
+		return fmt.Errorf("protocol must be udp, tcp or tls")
+	}
+	if c.Format != SyslogCEF && c.Format != SyslogJSON {
+		return fmt.Errorf("format must be cef or json")
+	}
+	if _, ok := syslogFacilities[c.Facility]; !ok {
+		return fmt.Errorf("facility must be user, daemon, auth, authpriv or local0 to local7")
+	}
+	if c.Framing != SyslogOctetCounting && c.Framing != SyslogNewline {
+		return fmt.Errorf("framing must be octet-counting or newline")
+	}
+	if c.Protocol == SyslogTLS && c.Framing != SyslogOctetCounting {
+		return fmt.Errorf("syslog over TLS requires octet-counting framing")
+	}
+	if c.BufferSize < 1 || c.BufferSize > maxSyslogBuffer {
+		return fmt.Errorf("bufferSize must be between 1 and %d", maxSyslogBuffer)
+	}
+	if c.CABundle != "" {
+		if c.Protocol != SyslogTLS {
+			return fmt.Errorf("caBundle only applies to tls")
+		}
+		if _, err := caBundleRoots(c.CABundle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localHostname is this machine's name for the syslog header
+func localHostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// syslogHeaderField keeps the printable ASCII RFC 5424 allows in header fields, "-" when nothing is left
+func syslogHeaderField(value string, limit int) string {
+	var b strings.Builder
+	for _, c := range value {
+		if c > 32 && c < 127 && b.Len() < limit {
+			b.WriteRune(c)
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+// syslogSeverity is error for failures, notice for changes to LogRhythm and informational otherwise
+func syslogSeverity(entry AuditEntry) int {
+	switch {
+	case entry.Result == auditResultFailure:
+		return syslogError
+	case strings.HasPrefix(entry.Action, "retirement.") || strings.HasPrefix(entry.Action, "rollback."):
+		return syslogNotice
+	}
+	return syslogInfo
+}
+
+// formatSyslog renders an audit entry as one RFC 5424 message without framing
+func formatSyslog(c SyslogConfig, entry AuditEntry) string {
+	severity := syslogSeverity(entry)
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s [origin software=\"%s\"] ",
+		syslogFacilities[c.Facility]*8+severity,
+		entry.Timestamp.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogHostname, syslogAppName, os.Getpid(),
+		syslogHeaderField(entry.Action, 32),
+		sdValueEscaper.Replace(syslogAppName))
+
+	if c.Format != SyslogJSON {
+		return header + cefMessage(entry, severity)
+	}
+	data, _ := json.Marshal(entry)
+	if c.Protocol == SyslogUDP && len(header)+len(data) > maxSyslogUDPMessage {
+		entry.Before, entry.After = nil, nil
+		data, _ = json.Marshal(entry)
+	}
+	return header + string(data)
+}
+
+// cefMessage renders an audit entry in Common Event Format. The before and after snapshots
+// are left out; the audit log has them.
+func cefMessage(entry AuditEntry, severity int) string {
+	name := entry.Action
+	if entry.Result == auditResultFailure {
+		name += " failed"
+	}
+	cefSeverity := map[int]int{syslogError: 7, syslogNotice: 5, syslogInfo: 3}[severity]
+
+	var extension []string
+	add := func(key, value string) {
+		if value != "" {
+			extension = append(extension, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	add("rt", strconv.FormatInt(entry.Timestamp.UnixMilli(), 10))
+	if entry.Sequence > 0 {
+		add("externalId", strconv.FormatInt(entry.Sequence, 10))
+	}
+	add("act", entry.Action)
+	add("outcome", entry.Result)
+	add("suser", entry.User)
+	if net.ParseIP(entry.SourceIP) != nil {
+		add("src", entry.SourceIP)
+	}
+	add("msg", entry.Message)
+	if entry.Profile != "" {
+		add("cs1Label", "deployment")
+		add("cs1", entry.Profile)
+	}
+	if entry.Ticket != "" {
+		add("cs2Label", "changeTicket")
+		add("cs2", entry.Ticket)
+	}
+	if entry.JobID != "" {
+		add("cs3Label", "jobId")
+		add("cs3", entry.JobID)
+	}
+	if entry.Target != "" {
+		add("cs4Label", "target")
+		add("cs4", entry.Target)
+	}
+	if entry.Hash != "" {
+		add("cs5Label", "auditHash")
+		add("cs5", entry.Hash)
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s||%s|%s|%d|%s",
+		syslogAppName, syslogAppName,
+		cefHeaderEscaper.Replace(entry.Action), cefHeaderEscaper.Replace(name),
+		cefSeverity, strings.Join(extension, " "))
+}
+
+// forwardSyslog queues an audit entry for the collector. It runs after the audit lock is
+// released, so entries written at the same moment may queue out of order; each message
+// carries its sequence number for the SIEM to order by.
+func forwardSyslog(entry AuditEntry) {
+	settings := config.Syslog
+	if !settings.Enabled {
+		return
+	}
+	text := formatSyslog(settings, entry)
+
+	syslogMutex.Lock()
+	syslogNextID++
+	syslogPending = append(syslogPending, syslogMessage{id: syslogNextID, text: text})
+	if over := len(syslogPending) - settings.BufferSize; over > 0 {
+		if syslogDropped == 0 {
+			log.Printf("WARNING: syslog buffer is full; dropping the oldest messages")
+		}
+		syslogPending = syslogPending[over:]
+		syslogDropped += int64(over)
+	}
+	syslogMutex.Unlock()
+	wakeSyslogForwarder()
+}
+
+// wakeSyslogForwarder asks the forwarder to send what is queued now
+func wakeSyslogForwarder() {
+	select {
+	case syslogWake <- struct{}{}:
+	default:
+	}
+}
+
+// dialSyslog connects to the collector; the server certificate is always verified for TLS
+func dialSyslog(c SyslogConfig) (net.Conn, error) {
+	address := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	var conn net.Conn
+	var err error
+	switch c.Protocol {
+	case SyslogTLS:
+		tlsConfig := &tls.Config{ServerName: c.Host, MinVersion: tls.VersionTLS12}
+		if c.CABundle != "" {
+			roots, rootsErr := caBundleRoots(c.CABundle)
+			if rootsErr != nil {
+				return nil, rootsErr
+			}
+			tlsConfig.RootCAs = roots
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	case SyslogTCP:
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		conn, err = dialer.Dial("udp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %v", address, err)
+	}
+	return conn, nil
+}
+
+// writeSyslog sends one message with the framing the transport needs
+func writeSyslog(conn net.Conn, c SyslogConfig, text string) error {
+	frame := text
+	if c.Protocol != SyslogUDP {
+		if c.Framing == SyslogNewline {
+			frame = text + "\n"
+		} else {
+			frame = strconv.Itoa(len(text)) + " " + text
+		}
+	}
+	conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := io.WriteString(conn, frame); err != nil {
+		return fmt.Errorf("sending to %s failed: %v", conn.RemoteAddr(), err)
+	}
+	return nil
+}
+
+// syslogConnAlive reports whether the collector still has the stream open. Collectors never
+// send anything, so any read result but a timeout means the connection is gone.
+func syslogConnAlive(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// runSyslogForwarder sends queued messages as they arrive. While the collector is unreachable
+// they stay in the buffer file and are retried every syslogRetryInterval.
+func runSyslogForwarder() {
+	var conn net.Conn
+	var dialed SyslogConfig
+	var retryAt time.Time
+	ticker := time.NewTicker(syslogRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		retry := false
+		select {
+		case <-syslogWake:
+		case <-ticker.C:
+			retry = true
+		}
+		settings := config.Syslog
+		if settings != dialed {
+			// New settings: reconnect, and try them straight away
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			dialed = settings
+			retryAt = time.Time{}
+		}
+		if !settings.Enabled {
+			continue
+		}
+		syslogMutex.Lock()
+		batch := append([]syslogMessage(nil), syslogPending...)
+		if !retry && time.Now().Before(retryAt) {
+			// Recently unreachable: keep new messages on disk until the next retry
+			saveSyslogBuffer()
+			batch = nil
+		}
+		syslogMutex.Unlock()
+		if len(batch) == 0 {
+			continue
+		}
+
+		if conn != nil && settings.Protocol != SyslogUDP && !syslogConnAlive(conn) {
+			conn.Close()
+			conn = nil
+		}
+		var err error
+		if conn == nil {
+			conn, err = dialSyslog(settings)
+		}
+		sent := int64(-1)
+		for i := 0; err == nil && i < len(batch); i++ {
+			if err = writeSyslog(conn, settings, batch[i].text); err == nil {
+				sent = batch[i].id
+			}
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			retryAt = time.Now().Add(syslogRetryInterval)
+		} else {
+			retryAt = time.Time{}
+		}
+		finishSyslogBatch(sent, err)
+	}
+}
+
+// finishSyslogBatch drops the messages up to the last one sent and records the outcome
+func finishSyslogBatch(sent int64, err error) {
+	syslogMutex.Lock()
+	defer syslogMutex.Unlock()
+
+	if sent >= 0 {
+		i := sort.Search(len(syslogPending), func(i int) bool { return syslogPending[i].id > sent })
+		syslogPending = append([]syslogMessage(nil), syslogPending[i:]...)
+		now := time.Now()
+		syslogLastSent = &now
+	}
+	if err != nil {
+		if syslogLastError == "" {
+			log.Printf("WARNING: syslog collector unreachable, buffering messages: %v", err)
+		}
+		syslogLastError = err.Error()
+		saveSyslogBuffer()
+		return
+	}
+	if syslogLastError != "" {
+		log.Printf("Syslog collector reachable again")
+		syslogLastError = ""
+	}
+	if len(syslogPending) == 0 {
+		saveSyslogBuffer()
+	}
+}
+
+// saveSyslogBuffer keeps unsent messages across restarts; called with syslogMutex held
+func saveSyslogBuffer() {
+	path := dataPath(syslogBufferFile)
+	if len(syslogPending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("WARNING: failed to remove %s: %v", path, err)
+		}
+		return
+	}
+	var b strings.Builder
+	for _, message := range syslogPending {
+		b.WriteString(message.text)
+		b.WriteByte('\n')
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(b.String()), 0600); err != nil {
+		log.Printf("WARNING: failed to write %s: %v", temp, err)
+		return
+	}
+	if err := os.Rename(temp, path); err != nil {
+		log.Printf("WARNING: failed to replace %s: %v", path, err)
+	}
+}
+
+// startSyslogForwarder reloads messages left unsent by the last run and starts sending
+func startSyslogForwarder() {
+	data, err := os.ReadFile(dataPath(syslogBufferFile))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("WARNING: failed to read %s: %v", dataPath(syslogBufferFile), err)
+	}
+	syslogMutex.Lock()
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			syslogNextID++
+			syslogPending = append(syslogPending, syslogMessage{id: syslogNextID, text: line})
+		}
+	}
+	if len(syslogPending) > 0 {
+		log.Printf("Loaded %d unsent syslog messages from %s", len(syslogPending), syslogBufferFile)
+	}
+	syslogMutex.Unlock()
+
+	go runSyslogForwarder()
+	wakeSyslogForwarder()
+}
+
+// handleSyslogStatus reports the buffer and the last delivery
+func handleSyslogStatus(w http.ResponseWriter, r *http.Request) {
+	settings := config.Syslog
+	syslogMutex.Lock()
+	status := SyslogStatus{
+		Enabled:   settings.Enabled,
+		Buffered:  len(syslogPending),
+		Dropped:   syslogDropped,
+		LastSent:  syslogLastSent,
+		LastError: syslogLastError,
+	}
+	syslogMutex.Unlock()
+	if settings.Host != "" {
+		status.Destination = fmt.Sprintf("%s://%s", settings.Protocol, net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port)))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleSyslogTest sends one message straight to the collector with the saved settings
+func handleSyslogTest(w http.ResponseWriter, r *http.Request) {
+	// A test may run before forwarding is switched on, but not with incomplete settings
+	settings := config.Syslog
+	check := settings
+	check.Enabled = true
+	if err := validateSyslogConfig(check); err != nil {
+		http.Error(w, "Syslog settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	address := net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port))
+	entry := requestAudit(r, "syslog.test", address)
+	entry.Timestamp = time.Now()
+	entry.Message = "Test message from LRCleaner. Syslog forwarding is working."
+	conn, err := dialSyslog(settings)
+	if err == nil {
+		err = writeSyslog(conn, settings, formatSyslog(settings, entry))
+		conn.Close()
+	}
+
+	message := fmt.Sprintf("Test message sent to %s over %s", address, strings.ToUpper(settings.Protocol))
+	if settings.Protocol == SyslogUDP {
+		message += "; UDP cannot confirm delivery, so check the collector received it"
+	}
+	entry.Message = message
+	if err != nil {
+		entry.Result = auditResultFailure
+		entry.Message = "Test message failed: " + err.Error()
+	}
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": message})
+}
+
+// LogRhythm cases - a case for each host that still answers ping but has stopped sending
+// logs, so it is troubleshot rather than retired
+
+const (
+	casesFile           = "cases.json"
+	caseStatusCompleted = 2 // Closed case statuses; a host whose case was closed gets a new one
+	caseStatusResolved  = 5
+	maxCaseName         = 250
+	maxCaseSummary      = 10000
+)
+
+// CaseConfig is how a deployment opens cases through the LogRhythm Case API
+type CaseConfig struct {
+	Enabled     bool `json:"enabled"`
+	AutoCreate  bool `json:"autoCreate"`            // Open or update cases whenever a host analysis finishes
+	Priority    int  `json:"priority"`              // 1 (highest) to 5
+	OwnerNumber int  `json:"ownerNumber,omitempty"` // LogRhythm person number; 0 leaves the API key's user as owner
+	DueDays     int  `json:"dueDays,omitempty"`     // Due date this many days after the case opens; 0 for none
+}
+
+// CaseLink is the case opened for a host, kept in cases.json so later analyses update it
+type CaseLink struct {
+	Profile    string    `json:"profile"`
+	HostID     string    `json:"hostId"`
+	HostName   string    `json:"hostName"`
+	CaseID     string    `json:"caseId"`
+	CaseNumber int       `json:"caseNumber"`
+	OpenedAt   time.Time `json:"openedAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// CaseResult is what happened to one host's case
+type CaseResult struct {
+	HostID     string `json:"hostId"`
+	HostName   string `json:"hostName"`
+	CaseID     string `json:"caseId,omitempty"`
+	CaseNumber int    `json:"caseNumber,omitempty"`
+	Action     string `json:"action,omitempty"` // created or updated
+	Error      string `json:"error,omitempty"`
+}
+
+// lrCase is the part of a Case API case LRCleaner reads
+type lrCase struct {
+	ID     string `json:"id"`
+	Number int    `json:"number"`
+	Status struct {
+		Number int `json:"number"`
+	} `json:"status"`
+}
+
+var (
+	caseLinks  = make(map[string]CaseLink) // Keyed by caseLinkKey
+	casesMutex sync.Mutex
+)
+
+// defaultCaseConfig leaves cases off until an admin turns them on for a deployment
+func defaultCaseConfig() CaseConfig {
+	return CaseConfig{Priority: 3}
+}
+
+// validateCaseConfig checks a deployment's case settings before they are saved
+func validateCaseConfig(c CaseConfig) error {
+	if c.Priority < 1 || c.Priority > 5 {
+		return fmt.Errorf("priority must be between 1 and 5")
+	}
+	if c.OwnerNumber < 0 {
+		return fmt.Errorf("ownerNumber must not be negative")
+	}
+	if c.DueDays < 0 || c.DueDays > 365 {
+		return fmt.Errorf("dueDays must be between 0 and 365")
+	}
+	return nil
+}
+
+func caseLinkKey(profile, hostID string) string {
+	return profile + "/" + hostID
+}
+
+// needsTroubleshooting reports whether a host answers ping although its log sources are stale
+func needsTroubleshooting(host HostAnalysis) bool {
+	return host.Status == StatusStaleReachable
+}
+
+// loadCaseLinks reads the cases opened by earlier runs
+func loadCaseLinks() {
+	casesMutex.Lock()
+	defer casesMutex.Unlock()
+
+	data, err := os.ReadFile(dataPath(casesFile))
+	if os.IsNotExist(err) {
+		return
+	}
+	var stored []CaseLink
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		log.Printf("WARNING: failed to read %s: %v; new cases will be opened", casesFile, err)
+		return
+	}
+	for _, link := range stored {
+		caseLinks[caseLinkKey(link.Profile, link.HostID)] = link
+	}
+	log.Printf("Loaded %d LogRhythm case links", len(caseLinks))
+}
+
+// saveCaseLinksLocked writes cases.json; the caller holds casesMutex
+func saveCaseLinksLocked() error {
+	stored := make([]CaseLink, 0, len(caseLinks))
+	for _, link := range caseLinks {
+		stored = append(stored, link)
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return caseLinkKey(stored[i].Profile, stored[i].HostID) < caseLinkKey(stored[j].Profile, stored[j].HostID)
+	})
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dataPath(casesFile), data, 0600)
+}
+
+// linkCases fills in the cases already opened for the hosts of an analysis
+func linkCases(profile string, hosts []HostAnalysis) {
+	casesMutex.Lock()
+	defer casesMutex.Unlock()
+
+	for i := range hosts {
+		if link, ok := caseLinks[caseLinkKey(profile, idToString(hosts[i].HostID))]; ok {
+			hosts[i].CaseID = link.CaseID
+			hosts[i].CaseNumber = link.CaseNumber
+		}
+	}
+}
+
+// caseNumberValue is a host's case number for exports, blank when it has none
+func caseNumberValue(number int) interface{} {
+	if number == 0 {
+		return ""
+	}
+	return number
+}
+
+// caseAPI calls the deployment's Case API, decoding the response into out when given.
+// The status code is returned so callers can tell a missing case from a failure.
+func caseAPI(p *Profile, method, path string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s:%d/lr-case-api%s", p.Hostname, p.Port, path), reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Case API %s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("Case API %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("Case API %s %s returned an unreadable case: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// caseSummary describes the problem for the case summary
+func caseSummary(p *Profile, host HostAnalysis) string {
+	summary := fmt.Sprintf("Host %s (ID %s) in deployment %s answers ping, but its %d log sources have stopped sending logs. "+
+		"Check the agent, the collection path and the host's logging before retiring anything. "+
+		"LRCleaner updates this case each time an analysis finds the host still silent.",
+		host.HostName, idToString(host.HostID), p.Name, len(host.LogSources))
+	if runes := []rune(summary); len(runes) > maxCaseSummary {
+		summary = string(runes[:maxCaseSummary])
+	}
+	return summary
+}
+
+// caseName is the case title for a host
+func caseName(host HostAnalysis) string {
+	name := fmt.Sprintf("LRCleaner: %s has stopped sending logs", host.HostName)
+	if runes := []rune(name); len(runes) > maxCaseName {
+		name = string(runes[:maxCaseName])
+	}
+	return name
+}
+
+// caseEvidence lists what the analysis found, as a note on the case
+func caseEvidence(host HostAnalysis, jobID, user string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LRCleaner analysis %s, %s, started by %s\n", jobID, time.Now().UTC().Format(time.RFC3339), user)
+	fmt.Fprintf(&b, "Host %s (ID %s) ping: %s\n", host.HostName, idToString(host.HostID), host.PingResult)
+	fmt.Fprintf(&b, "Stale log sources:\n")
+	for _, ls := range host.LogSources {
+		lastLog := ls.MaxLogDate
+		if lastLog == "" {
+			lastLog = "never"
+		}
+		fmt.Fprintf(&b, "- %s (ID %s, %s, collected by %s): last log %s\n",
+			ls.Name, idToString(ls.ID), ls.LogSourceType.Name, ls.SystemMonitorName, lastLog)
+	}
+	return b.String()
+}
+
+// openHostCase updates the open case for a host, or opens a new one when there is none or
+// it was closed. The owner is set and the analysis findings are added as a note either way.
+func openHostCase(p *Profile, host HostAnalysis, jobID, user string) (CaseLink, string, error) {
+	settings := p.Cases
+	hostID := idToString(host.HostID)
+	key := caseLinkKey(p.Name, hostID)
+
+	casesMutex.Lock()
+	link, known := caseLinks[key]
+	casesMutex.Unlock()
+
+	body := map[string]interface{}{
+		"name":     caseName(host),
+		"priority": settings.Priority,
+		"summary":  caseSummary(p, host),
+	}
+
+	if known {
+		var existing lrCase
+		status, err := caseAPI(p, "GET", "/cases/"+url.PathEscape(link.CaseID)+"/", nil, &existing)
+		switch {
+		case status == http.StatusNotFound:
+			known = false
+		case err != nil:
+			return link, "updated", err
+		case existing.Status.Number == caseStatusCompleted || existing.Status.Number == caseStatusResolved:
+			known = false
+		}
+	}
+
+	action := "updated"
+	if known {
+		if _, err := caseAPI(p, "PUT", "/cases/"+url.PathEscape(link.CaseID)+"/", body, nil); err != nil {
+			return link, action, err
+		}
+	} else {
+		action = "created"
+		body["externalId"] = fmt.Sprintf("lrcleaner:%s:host:%s", p.Name, hostID)
+		if settings.DueDays > 0 {
+			body["dueDate"] = time.Now().UTC().AddDate(0, 0, settings.DueDays).Format(time.RFC3339)
+		}
+		var created lrCase
+		if _, err := caseAPI(p, "POST", "/cases/", body, &created); err != nil {
+			return link, action, err
+		}
+		if created.ID == "" {
+			return link, action, fmt.Errorf("Case API did not return the new case's ID")
+		}
+		link = CaseLink{Profile: p.Name, HostID: hostID, CaseID: created.ID, CaseNumber: created.Number, OpenedAt: time.Now()}
+	}
+	link.HostName = host.HostName
+	link.UpdatedAt = time.Now()
+
+	// Keep the link before anything else can fail, so a retry updates this case
+	casesMutex.Lock()
+	caseLinks[key] = link
+	err := saveCaseLinksLocked()
+	casesMutex.Unlock()
+	if err != nil {
+		log.Printf("WARNING: failed to save %s: %v", casesFile, err)
+	}
+
+	casePath := "/cases/" + url.PathEscape(link.CaseID)
+	if settings.OwnerNumber > 0 {
+		if _, err := caseAPI(p, "PUT", casePath+"/actions/changeOwner/", map[string]int{"number": settings.OwnerNumber}, nil); err != nil {
+			return link, action, err
+		}
+	}
+	if _, err := caseAPI(p, "POST", casePath+"/evidence/note/", map[string]string{"text": caseEvidence(host, jobID, user)}, nil); err != nil {
+		return link, action, err
+	}
+	return link, action, nil
+}
+
+// openCases opens or updates a case for each host that needs troubleshooting, or only for
+// the hosts in only when it is not empty, and records the case on the host
+func openCases(p *Profile, hosts []HostAnalysis, only map[string]bool, jobID, user, sourceIP string) []CaseResult {
+	results := []CaseResult{}
+	for i := range hosts {
+		host := &hosts[i]
+		hostID := idToString(host.HostID)
+		if (len(only) > 0 && !only[hostID]) || (len(only) == 0 && !needsTroubleshooting(*host)) {
+			continue
+		}
+		result := CaseResult{HostID: hostID, HostName: host.HostName}
+		if !needsTroubleshooting(*host) {
+			result.Error = fmt.Sprintf("host is %s, not %s: %s", host.Status, StatusStaleReachable, host.StatusReason)
+			results = append(results, result)
+			continue
+		}
+
+		link, action, err := openHostCase(p, *host, jobID, user)
+		if link.CaseID != "" {
+			host.CaseID = link.CaseID
+			host.CaseNumber = link.CaseNumber
+			result.CaseID = link.CaseID
+			result.CaseNumber = link.CaseNumber
+		}
+		result.Action = action
+
+		auditAction := "case.update"
+		if action == "created" {
+			auditAction = "case.create"
+		}
+		entry := AuditEntry{
+			User:     user,
+			SourceIP: sourceIP,
+			Action:   auditAction,
+			Target:   hostID,
+			Profile:  p.Name,
+			JobID:    jobID,
+			Result:   auditResultSuccess,
+			Message:  fmt.Sprintf("Case %d for host %s", link.CaseNumber, host.HostName),
+		}
+		if err != nil {
+			log.Printf("Error opening LogRhythm case for host %s: %v", host.HostName, err)
+			result.Error = err.Error()
+			entry.Result = auditResultFailure
+			entry.Message = fmt.Sprintf("Case for host %s: %v", host.HostName, err)
+		} else {
+			log.Printf("LogRhythm case %d %s for host %s", link.CaseNumber, action, host.HostName)
+		}
+		logAudit(entry)
+		results = append(results, result)
+	}
+	return results
+}
+
+// handleJobCases opens or updates cases for the troubleshooting hosts of a finished host
+// analysis: the hosts named in the request, or all of them
+func handleJobCases(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["jobId"]
+	var request struct {
+		Hosts []string `json:"hosts"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobsMutex.RLock()
+	job, ok := jobs[jobID]
+	var hosts []HostAnalysis
+	var status, profileName string
+	if ok {
+		hosts = append(hosts, job.HostAnalysis...)
+		status = job.Status
+		profileName = job.Profile
+	}
+	jobsMutex.RUnlock()
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if status == "running" {
+		http.Error(w, "Job is still running", http.StatusConflict)
+		return
+	}
+	if len(hosts) == 0 {
+		http.Error(w, "Job has no host analysis", http.StatusBadRequest)
+		return
+	}
+	p, err := findProfile(profileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !p.Cases.Enabled {
+		http.Error(w, fmt.Sprintf("LogRhythm cases are not enabled for deployment %s", p.Name), http.StatusConflict)
+		return
+	}
+
+	only := make(map[string]bool)
+	for _, hostID := range request.Hosts {
+		only[hostID] = true
+	}
+	results := openCases(p, hosts, only, jobID, currentUsername(r), clientIP(r))
+
+	jobsMutex.Lock()
+	job.HostAnalysis = hosts
+	jobsMutex.Unlock()
+	broadcastJobUpdate(job)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cases": results})
+}
+
+// handleProfileCases saves a deployment's case settings
+func handleProfileCases(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	index := profileIndex(name)
+	if index < 0 {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	before := config.Profiles[index].Cases
+
+	var updated CaseConfig
+	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateCaseConfig(updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	config.Profiles[index].Cases = updated
+	if err := saveConfigFile(); err != nil {
+		log.Printf("Error saving config: %v", err)
+		http.Error(w, "Failed to save configuration", http.StatusInternalServerError)
+		return
+	}
+
+	entry := requestAudit(r, "cases.update", name)
+	entry.Profile = name
+	entry.Before = auditValue(before)
+	entry.After = auditValue(updated)
+	logAudit(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cases": updated})
+}
+
+// Result exports - analysis and retirement results as CSV, JSON or XLSX
+
+// exportColumn is one column of an export sheet. Key names it in the columns parameter and
+// in JSON; Header is the CSV and XLSX heading.
+type exportColumn struct {
+	Key    string
+	Header string
+}
+
+// exportSheet is one table of an export: a CSV file, a JSON array or an XLSX worksheet
+type exportSheet struct {
+	Key     string
+	Title   string
+	Columns []exportColumn
+	Rows    [][]interface{}
+}
+
+var (
+	logSourceExportColumns = []exportColumn{
+		{"deployment", "Deployment"}, {"logSourceId", "LogSourceID"}, {"logSourceName", "LogSourceName"},
+		{"logSourceType", "LogSourceType"}, {"recordStatus", "RecordStatus"}, {"maxLogDate", "MaxLogDate"},
+		{"entity", "Entity"}, {"hostId", "HostID"}, {"hostName", "HostName"}, {"pingResult", "PingResult"},
+		{"systemMonitorId", "SystemMonitorID"}, {"systemMonitorName", "SystemMonitorName"},
+		{"recommended", "Recommended"}, {"status", "Status"}, {"statusReason", "StatusReason"},
+	}
+	hostExportColumns = []exportColumn{
+		{"deployment", "Deployment"}, {"hostId", "HostID"}, {"hostName", "HostName"},
+		{"logSourceCount", "LogSourceCount"}, {"maxLogDate", "MaxLogDate"}, {"pingResult", "PingResult"},
+		{"recommended", "Recommended"}, {"status", "Status"}, {"statusReason", "StatusReason"},
+		{"caseNumber", "CaseNumber"},
+	}
+	troubleshootingExportColumns = []exportColumn{
+		{"deployment", "Deployment"}, {"hostId", "HostID"}, {"hostName", "HostName"},
+		{"logSourceId", "LogSourceID"}, {"logSourceName", "LogSourceName"}, {"logSourceType", "LogSourceType"},
+		{"maxLogDate", "MaxLogDate"}, {"systemMonitorName", "SystemMonitorName"},
+		{"statusReason", "StatusReason"}, {"caseNumber", "CaseNumber"},
+	}
+	collectionHostExportColumns = []exportColumn{
+		{"deployment", "Deployment"}, {"systemMonitorId", "SystemMonitorID"}, {"systemMonitorName", "SystemMonitorName"},
+		{"logSourceCount", "LogSourceCount"}, {"pingResult", "PingResult"}, {"recommended", "Recommended"},
+	}
+	retirementExportColumns = []exportColumn{
+		{"deployment", "Deployment"}, {"logSourceId", "LogSourceID"}, {"hostId", "HostID"}, {"hostName", "HostName"},
+		{"originalName", "OriginalName"}, {"retiredName", "RetiredName"}, {"originalStatus", "OriginalStatus"},
+		{"retiredStatus", "RetiredStatus"}, {"changeTicket", "ChangeTicket"}, {"justification", "Justification"},
+		{"timestamp", "Timestamp"},
+	}
+)
+
+// exportFilter carries the results view filters into an export. Log source rows must match
+// every filter; host rows match when the host or any of its log sources does.
+type exportFilter struct {
+	Search string // Case-insensitive text in the deployment, host or log source name
+	Ping   string // Success, Failure or Unknown
+	Type   string // Log source type
+	Host   string // Exact host name
+	Status string // Analysis status; a host matches its own or any of its log sources'
+}
+
+func parseExportFilter(r *http.Request) exportFilter {
+	query := r.URL.Query()
+	return exportFilter{
+		Search: strings.ToLower(strings.TrimSpace(query.Get("q"))),
+		Ping:   query.Get("ping"),
+		Type:   query.Get("type"),
+		Host:   query.Get("host"),
+		Status: query.Get("status"),
+	}
+}
+
+func (f exportFilter) matchHost(hostName, ping string) bool {
+	if f.Ping != "" && ping != f.Ping {
+		return false
+	}
+	return f.Host == "" || hostName == f.Host
+}
+
+func (f exportFilter) matchText(values ...string) bool {
+	if f.Search == "" {
+		return true
+	}
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), f.Search) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildExportSheets turns a job into export sheets. Log sources keep their own MaxLogDate;
+// host rows carry the newest MaxLogDate of their log sources.
+func buildExportSheets(job *JobStatus, filter exportFilter) []exportSheet {
+	logSources := exportSheet{Key: "logSources", Title: "Log Sources", Columns: logSourceExportColumns}
+	hosts := exportSheet{Key: "hosts", Title: "Hosts", Columns: hostExportColumns}
+	troubleshooting := exportSheet{Key: "troubleshooting", Title: "Troubleshooting Queue", Columns: troubleshootingExportColumns}
+
+	if len(job.Results) > 0 {
+		// Test mode results are log sources; their hosts are derived from them
+		type hostRow struct {
+			deployment, name, maxLogDate, ping string
+			id                                 interface{}
+			count                              int
+			matched                            bool
+			sources                            []LogSource // Name and status of each, to derive the host's
+		}
+		var order []string
+		byHost := make(map[string]*hostRow)
+		for _, result := range job.Results {
+			deployment := result.Profile
+			if deployment == "" {
+				deployment = job.Profile
+			}
+			key := deployment + "|" + result.HostName
+			host, ok := byHost[key]
+			if !ok {
+				host = &hostRow{deployment: deployment, name: result.HostName, id: result.HostID, ping: result.PingResult}
+				byHost[key] = host
+				order = append(order, key)
+			}
+			host.count++
+			if laterLogDate(result.MaxLogDate, host.maxLogDate) {
+				host.maxLogDate = result.MaxLogDate
+			}
+			if result.PingResult != "" {
+				host.ping = result.PingResult
+			}
+			host.sources = append(host.sources, LogSource{Name: result.Name, Status: result.Status, StatusReason: result.StatusReason})
+
+			if !filter.matchHost(result.HostName, result.PingResult) ||
+				(filter.Type != "" && result.LogSourceType != filter.Type) ||
+				(filter.Status != "" && result.Status != filter.Status) ||
+				!filter.matchText(deployment, result.HostName, result.Name) {
+				continue
+			}
+			host.matched = true
+			logSources.Rows = append(logSources.Rows, []interface{}{
+				deployment, idToString(result.ID), result.Name, result.LogSourceType, result.RecordStatus,
+				result.MaxLogDate, result.Entity, idToString(result.HostID), result.HostName, result.PingResult,
+				idToString(result.SystemMonitorID), result.SystemMonitorName, result.Recommended,
+				result.Status, result.StatusReason,
+			})
+			if result.Status == StatusStaleReachable {
+				troubleshooting.Rows = append(troubleshooting.Rows, []interface{}{
+					deployment, idToString(result.HostID), result.HostName, idToString(result.ID), result.Name,
+					result.LogSourceType, result.MaxLogDate, result.SystemMonitorName, result.StatusReason, "",
+				})
+			}
+		}
+		for _, key := range order {
+			host := byHost[key]
+			if host.matched {
+				status, reason := hostOutcome(host.sources)
+				hosts.Rows = append(hosts.Rows, []interface{}{
+					host.deployment, idToString(host.id), host.name, host.count, host.maxLogDate, host.ping,
+					status == StatusStaleUnreachable, status, reason, "",
+				})
+			}
+		}
+	}
+
+	for _, host := range job.HostAnalysis {
+		if !filter.matchHost(host.HostName, host.PingResult) {
+			continue
+		}
+		matched := false
+		for _, ls := range append(append([]LogSource{}, host.LogSources...), host.Excluded...) {
+			if (filter.Type != "" && ls.LogSourceType.Name != filter.Type) ||
+				(filter.Status != "" && ls.Status != filter.Status) ||
+				!filter.matchText(job.Profile, host.HostName, ls.Name) {
+				continue
+			}
+			matched = true
+			logSources.Rows = append(logSources.Rows, []interface{}{
+				job.Profile, idToString(ls.ID), ls.Name, ls.LogSourceType.Name, ls.RecordStatus,
+				ls.MaxLogDate, ls.Entity.Name, idToString(host.HostID), host.HostName, host.PingResult,
+				idToString(ls.SystemMonitorID), ls.SystemMonitorName, ls.Recommended, ls.Status, ls.StatusReason,
+			})
+			if ls.Status == StatusStaleReachable {
+				troubleshooting.Rows = append(troubleshooting.Rows, []interface{}{
+					job.Profile, idToString(host.HostID), host.HostName, idToString(ls.ID), ls.Name,
+					ls.LogSourceType.Name, ls.MaxLogDate, ls.SystemMonitorName, ls.StatusReason,
+					caseNumberValue(host.CaseNumber),
+				})
+			}
+		}
+		if matched {
+			hosts.Rows = append(hosts.Rows, []interface{}{
+				job.Profile, idToString(host.HostID), host.HostName, host.LogSourceCount, host.MaxLogDate,
+				host.PingResult, host.Recommended, host.Status, host.StatusReason, caseNumberValue(host.CaseNumber),
+			})
+		}
+	}
+
+	collectionHosts := exportSheet{Key: "collectionHosts", Title: "Collection Hosts", Columns: collectionHostExportColumns}
+	for _, ch := range job.CollectionHostAnalysis {
+		if (filter.Ping != "" && ch.PingResult != filter.Ping) || !filter.matchText(job.Profile, ch.SystemMonitorName) {
+			continue
+		}
+		collectionHosts.Rows = append(collectionHosts.Rows, []interface{}{
+			job.Profile, idToString(ch.SystemMonitorID), ch.SystemMonitorName, ch.LogSourceCount, ch.PingResult,
+			ch.Recommended,
+		})
+	}
+
+	retirements := exportSheet{Key: "retirementRecords", Title: "Retirement Records", Columns: retirementExportColumns}
+	for _, record := range job.RetirementRecords {
+		if (filter.Host != "" && record.HostName != filter.Host) ||
+			!filter.matchText(job.Profile, record.HostName, record.OriginalName) {
+			continue
+		}
+		retirements.Rows = append(retirements.Rows, []interface{}{
+			job.Profile, idToString(record.LogSourceID), idToString(record.HostID), record.HostName,
+			record.OriginalName, record.RetiredName, record.OriginalStatus, record.RetiredStatus,
+			record.ChangeTicket, record.Justification, record.Timestamp.Format(time.RFC3339),
+		})
+	}
+
+	return []exportSheet{logSources, hosts, collectionHosts, retirements, troubleshooting}
+}
+
+// laterLogDate reports whether a is a later MaxLogDate than b; unparseable dates lose
+func laterLogDate(a, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	if errA != nil {
+		return false
+	}
+	tb, errB := time.Parse(time.RFC3339, b)
+	return errB != nil || ta.After(tb)
+}
+
+// selectColumns keeps the listed columns of a sheet in the sheet's own order. A sheet with
+// none of the listed columns keeps all of them, so one list can serve every sheet.
+func (s exportSheet) selectColumns(keys []string) exportSheet {
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+	var keep []int
+	for i, column := range s.Columns {
+		if wanted[column.Key] {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == 0 {
+		return s
+	}
+
+	selected := exportSheet{Key: s.Key, Title: s.Title}
+	for _, i := range keep {
+		selected.Columns = append(selected.Columns, s.Columns[i])
+	}
+	for _, row := range s.Rows {
+		values := make([]interface{}, 0, len(keep))
+		for _, i := range keep {
+			values = append(values, row[i])
+		}
+		selected.Rows = append(selected.Rows, values)
+	}
+	return selected
+}
+
+// exportCell formats a value for CSV
+func exportCell(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvFormulaSafe prefixes text a spreadsheet would run as a formula with an apostrophe
+func csvFormulaSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// writeExportCSV writes one sheet as CSV. Text cells starting with =, +, -, @, tab or
+// carriage return are neutralised, since names come from the API and a spreadsheet
+// would otherwise run them as formulas; numbers are left as they are.
+func writeExportCSV(w io.Writer, sheet exportSheet) error {
+	writer := csv.NewWriter(w)
+	header := make([]string, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		header[i] = column.Header
+	}
+	writer.Write(header)
+	for _, row := range sheet.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = exportCell(value)
+			if _, ok := value.(string); ok {
+				record[i] = csvFormulaSafe(record[i])
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// exportJSON is a job export as JSON: job details and one array of objects per sheet
+func exportJSON(job *JobStatus, sheets []exportSheet) map[string]interface{} {
+	export := map[string]interface{}{
+		"jobId":      job.ID,
+		"profile":    job.Profile,
+		"profiles":   job.Profiles,
+		"dataSource": job.DataSource,
+		"exportedAt": time.Now().UTC(),
+	}
+	for _, sheet := range sheets {
+		rows := []map[string]interface{}{}
+		for _, row := range sheet.Rows {
+			object := make(map[string]interface{}, len(row))
+			for i, value := range row {
+				object[sheet.Columns[i].Key] = value
+			}
+			rows = append(rows, object)
+		}
+		export[sheet.Key] = rows
+	}
+	return export
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["jobId"]
+
+	jobsMutex.RLock()
+	job, exists := jobs[jobID]
+	jobsMutex.RUnlock()
+
+	if !exists {
+		log.Printf("Job not found: %s", jobID)
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var columns []string
+	if list := strings.TrimSpace(query.Get("columns")); list != "" {
+		columns = strings.Split(list, ",")
+	}
+
+	filter := parseExportFilter(r)
+	if filter.Status != "" && !slices.Contains(analysisStatuses, filter.Status) {
+		http.Error(w, "Status must be one of "+strings.Join(analysisStatuses, ", "), http.StatusBadRequest)
+		return
+	}
+
+	sheets := buildExportSheets(job, filter)
+	total := 0
+	for i := range sheets {
+		sheets[i] = sheets[i].selectColumns(columns)
+		total += len(sheets[i].Rows)
+	}
+	if total == 0 {
+		log.Printf("No results to export for job: %s", jobID)
+		http.Error(w, "No results to export", http.StatusBadRequest)
+		return
+	}
+
+	deploymentLabel := job.Profile
+	if len(job.Profiles) > 0 {
+		deploymentLabel = strings.Join(job.Profiles, "-")
+	}
+	filename := fmt.Sprintf("LRCleaner_Results_%s_%s", deploymentLabel, jobID)
+
+	switch format {
+	case "csv":
+		// A CSV file holds one sheet; log sources unless another is asked for
+		sheetKey := query.Get("sheet")
+		if sheetKey == "" {
+			sheetKey = "logSources"
+		}
+		var sheet *exportSheet
+		for i := range sheets {
+			if sheets[i].Key == sheetKey {
+				sheet = &sheets[i]
+			}
+		}
+		if sheet == nil {
+			http.Error(w, "Sheet must be logSources, hosts, collectionHosts, retirementRecords or troubleshooting", http.StatusBadRequest)
+			return
+		}
+		if sheetKey != "logSources" {
+			filename += "_" + sheetKey
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.csv\"", filename))
+		if err := writeExportCSV(w, *sheet); err != nil {
+			log.Printf("Error writing CSV export for job %s: %v", jobID, err)
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.json\"", filename))
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(exportJSON(job, sheets))
+	case "xlsx":
+		var buf bytes.Buffer
+		if err := writeXLSX(&buf, sheets); err != nil {
+			log.Printf("Error writing XLSX export for job %s: %v", jobID, err)
+			http.Error(w, "Failed to build spreadsheet", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.xlsx\"", filename))
+		w.Write(buf.Bytes())
+	default:
+		http.Error(w, "Format must be csv, json or xlsx", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Exported job %s as %s (%d rows)", jobID, format, total)
+}
+
+// XLSX writer - the smallest SpreadsheetML package Excel and LibreOffice open: one
+// worksheet per sheet, inline strings and a bold header row
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+%s</Types>`
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`
+
+// writeXLSX writes the sheets as an .xlsx workbook
+func writeXLSX(w io.Writer, sheets []exportSheet) error {
+	zw := zip.NewWriter(w)
+	add := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+
+	var overrides, workbookSheets, workbookRels strings.Builder
+	for i, sheet := range sheets {
+		n := i + 1
+		fmt.Fprintf(&overrides, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", n)
+		fmt.Fprintf(&workbookSheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xmlEscape(sheet.Title), n, n)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`+"\n", n, n)
+	}
+	stylesID := len(sheets) + 1
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`+"\n", stylesID)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", fmt.Sprintf(xlsxContentTypes, overrides.String())},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` +
+			workbookSheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + "\n" +
+			workbookRels.String() + `</Relationships>`},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		if err := add(part.name, part.content); err != nil {
+			return err
+		}
+	}
+	for i, sheet := range sheets {
+		if err := add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxWorksheet(sheet)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xlsxWorksheet renders one sheet with a frozen, bold header row
+func xlsxWorksheet(sheet exportSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	b.WriteString(`<sheetData>`)
+
+	header := make([]interface{}, len(sheet.Columns))
+	for i, column := range sheet.Columns {
+		header[i] = column.Header
+	}
+	writeRow := func(r int, values []interface{}, style string) {
+		fmt.Fprintf(&b, `<row r="%d">`, r)
+		for c, value := range values {
+			ref := xlsxColumnName(c) + strconv.Itoa(r)
+			switch v := value.(type) {
+			case int:
+				fmt.Fprintf(&b, `<c r="%s"%s><v>%d</v></c>`, ref, style, v)
+			case bool:
+				fmt.Fprintf(&b, `<c r="%s"%s t="b"><v>%d</v></c>`, ref, style, map[bool]int{false: 0, true: 1}[v])
+			default:
+				fmt.Fprintf(&b, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xmlEscape(exportCell(v)))
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	writeRow(1, header, ` s="1"`)
+	for i, row := range sheet.Rows {
+		writeRow(i+2, row, "")
+	}
+
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+// xlsxColumnName turns a zero-based column index into A, B, ... Z, AA, AB, ...
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// xmlEscape escapes text for XML; characters XML cannot carry become U+FFFD
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	jobID := vars["jobId"]
+
+	jobsMutex.RLock()
+	job, exists := jobs[jobID]
+	jobsMutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleJobList returns a summary of all jobs, optionally filtered by change ticket
+func handleJobList(w http.ResponseWriter, r *http.Request) {
+	ticket := strings.TrimSpace(r.URL.Query().Get("ticket"))
+
+	jobsMutex.RLock()
+	summaries := []map[string]interface{}{}
+	for _, job := range jobs {
+		if ticket != "" && !strings.EqualFold(job.ChangeTicket, ticket) {
+			continue
+		}
+		summaries = append(summaries, map[string]interface{}{
+			"id":                job.ID,
+			"status":            job.Status,
+			"message":           job.Message,
+			"profile":           job.Profile,
+			"profiles":          job.Profiles,
+			"dataSource":        job.DataSource,
+			"startedBy":         job.StartedBy,
+			"changeTicket":      job.ChangeTicket,
+			"justification":     job.Justification,
+			"retirementRecords": len(job.RetirementRecords),
+			"failures":          len(job.Failures),
+			"startTime":         job.StartTime,
+			"endTime":           job.EndTime,
+		})
+	}
+	jobsMutex.RUnlock()
+
+	// Sort by start time (newest first)
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i]["startTime"].(time.Time).After(summaries[j]["startTime"].(time.Time))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// Broadcast job update to all WebSocket connections
+func broadcastJobUpdate(job *JobStatus) {
+	wsMutex.RLock()
+	defer wsMutex.RUnlock()
+
+	log.Printf("Broadcasting job update for job %s to %d WebSocket connections", job.ID, len(wsConnections))
+
+	for conn := range wsConnections {
+		if err := conn.WriteJSON(job); err != nil {
+			log.Printf("Error broadcasting job update via WebSocket: %v", err)
+			// Remove failed connection
+			delete(wsConnections, conn)
+			conn.Close()
+		} else {
+			log.Printf("Successfully broadcasted job update to WebSocket connection")
+		}
+	}
+}
+
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	log.Printf("WebSocket connection attempt from %s", r.RemoteAddr)
 	log.Printf("WebSocket request headers: %v", r.Header)
 
@@ -1228,7 +9477,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func analyzeLogSources(jobID string, selectedDate time.Time) {
+func analyzeLogSources(p *Profile, jobID string, selectedDate time.Time) {
 	log.Printf("Starting analyzeLogSources for job: %s, date: %s", jobID, selectedDate.Format("2006-01-02"))
 
 	jobsMutex.Lock()
@@ -1244,11 +9493,12 @@ func analyzeLogSources(jobID string, selectedDate time.Time) {
 		}
 		jobsMutex.Unlock()
 		log.Printf("Completed analyzeLogSources for job: %s", jobID)
+		notifyAnalysisCompleted(job)
 	}()
 
 	// Get all log sources
 	log.Printf("Getting all log sources for job: %s", jobID)
-	allLogSources, err := getAllLogSources()
+	allLogSources, dataSource, err := getAllLogSources(p)
 	if err != nil {
 		log.Printf("Error getting log sources for job %s: %v", jobID, err)
 		jobsMutex.Lock()
@@ -1258,59 +9508,18 @@ func analyzeLogSources(jobID string, selectedDate time.Time) {
 		return
 	}
 
-	log.Printf("Retrieved %d log sources for job: %s", len(allLogSources), jobID)
+	log.Printf("Retrieved %d log sources from %s for job: %s", len(allLogSources), dataSource, jobID)
 
 	// Update progress
 	jobsMutex.Lock()
+	job.DataSource = dataSource
 	job.Progress = 25
 	job.Message = fmt.Sprintf("Found %d log sources. Filtering...", len(allLogSources))
 	jobsMutex.Unlock()
 
 	// Filter log sources
-	var filteredSources []LogSource
-	for _, ls := range allLogSources {
-		// Check date
-		if maxLogDate, err := time.Parse(time.RFC3339, ls.MaxLogDate); err == nil {
-			if maxLogDate.After(selectedDate) {
-				continue
-			}
-		}
-
-		// Check if already retired
-		if ls.RecordStatus == "Retired" {
-			continue
-		}
-
-		// Check excluded sources
-		excluded := false
-		sourceType := ls.LogSourceType.Name
-		sourceName := ls.Name
-		hostName := ls.Host.Name
-
-		// Exclude LogRhythm system monitor agents
-		if strings.HasPrefix(sourceType, "LogRhythm") {
-			excluded = true
-		}
-
-		// Exclude echo hosts and log sources
-		if containsIgnoreCase(hostName, "echo") || containsIgnoreCase(sourceName, "echo") {
-			excluded = true
-		}
-
-		// Check config excluded sources
-		if !excluded {
-			for _, pattern := range config.ExcludedLogSources {
-				if containsIgnoreCase(sourceType, pattern) {
-					excluded = true
-					break
-				}
-			}
-		}
-
-		if !excluded {
-			filteredSources = append(filteredSources, ls)
-		}
-	}
+	sources := filterStaleLogSources(p, allLogSources, selectedDate)
+	filteredSources := sources.Stale
 
 	// Update progress
 	jobsMutex.Lock()
@@ -1358,76 +9567,441 @@ func analyzeLogSources(jobID string, selectedDate time.Time) {
 		// Get ping result from our concurrent test
 		pingResult := pingResults[ls.Host.Name]
 
-		// Create result
-		result := AnalysisResult{
-			ID:            ls.ID,
-			HostID:        ls.Host.ID,
-			HostName:      ls.Host.Name,
-			Name:          ls.Name,
-			LogSourceType: ls.LogSourceType.Name,
-			MaxLogDate:    ls.MaxLogDate,
-			PingResult:    pingResult,
-		}
-
-		results = append(results, result)
+		classifyLogSource(p, &ls, pingResult)
+		filteredSources[i] = ls
+		results = append(results, newAnalysisResult(p, ls, pingResult))
 	}
+	// Excluded log sources are listed with the rule that excludes them, without a ping
+	for _, ls := range sources.Excluded {
+		results = append(results, newAnalysisResult(p, ls, ""))
+	}
+
+	counts := newStatusCounts()
+	counts.add(sources.Healthy)
+	counts.add(sources.Excluded)
+	counts.add(filteredSources)
 
 	// Update job with results
 	jobsMutex.Lock()
 	job.Results = results
+	job.StatusCounts = counts
+	jobsMutex.Unlock()
+
+	// Broadcast the update to WebSocket clients
+	broadcastJobUpdate(job)
+
+	// Complete
+	jobsMutex.Lock()
+	job.Progress = 100
+	job.Message = fmt.Sprintf("Analysis complete. Found %d sources.", len(results))
+	job.Status = "completed"
+	now := time.Now()
+	job.EndTime = &now
+	jobsMutex.Unlock()
+
+	// Broadcast the completion to WebSocket clients
+	broadcastJobUpdate(job)
+
+	// Log summary
+	successCount := 0
+	failureCount := 0
+	unknownCount := 0
+	for _, result := range results {
+		switch result.PingResult {
+		case "Success":
+			successCount++
+		case "Failure":
+			failureCount++
+		default:
+			unknownCount++
+		}
+	}
+	log.Printf("Test Mode Analysis Complete:")
+	log.Printf("  Total log sources analyzed: %d", len(results))
+	log.Printf("  Successful pings: %d", successCount)
+	log.Printf("  Failed pings: %d", failureCount)
+	log.Printf("  Unknown ping results: %d", unknownCount)
+	log.Printf("  Need troubleshooting: %d", counts.LogSources[StatusStaleReachable])
+}
+
+// analyzeDeployments runs the log source analysis against several deployments at once and
+// merges the results. A deployment that fails is reported in its summary without stopping
+// the others. Retirement is not offered from these results; it runs per deployment.
+func analyzeDeployments(profiles []*Profile, jobID string, selectedDate time.Time) {
+	log.Printf("Starting cross-deployment analysis for job: %s across %d deployments", jobID, len(profiles))
+
+	jobsMutex.Lock()
+	job := jobs[jobID]
+	job.Deployments = make([]DeploymentSummary, len(profiles))
+	for i, p := range profiles {
+		job.Deployments[i] = DeploymentSummary{Profile: p.Name, Hostname: p.Hostname, Status: "running"}
+	}
+	job.Message = fmt.Sprintf("Analyzing %d deployments...", len(profiles))
+	jobsMutex.Unlock()
+	broadcastJobUpdate(job)
+
+	resultsByProfile := make([][]AnalysisResult, len(profiles))
+	countsByProfile := make([]*StatusCounts, len(profiles))
+	finished := 0
+	var wg sync.WaitGroup
+	for i, p := range profiles {
+		wg.Add(1)
+		go func(i int, p *Profile) {
+			defer wg.Done()
+
+			summary := DeploymentSummary{Profile: p.Name, Hostname: p.Hostname, Status: "completed"}
+			allLogSources, dataSource, err := getAllLogSources(p)
+			summary.DataSource = dataSource
+			if err != nil {
+				log.Printf("Error getting log sources from %s for job %s: %v", p.Name, jobID, err)
+				summary.Status = "error"
+				summary.Error = err.Error()
+			} else {
+				sources := filterStaleLogSources(p, allLogSources, selectedDate)
+				filteredSources := sources.Stale
+
+				hostnameSet := make(map[string]bool)
+				for _, ls := range filteredSources {
+					hostnameSet[ls.Host.Name] = true
+				}
+				uniqueHostnames := make([]string, 0, len(hostnameSet))
+				for hostname := range hostnameSet {
+					uniqueHostnames = append(uniqueHostnames, hostname)
+				}
+				pingResults := pingHostsConcurrent(uniqueHostnames)
+
+				var results []AnalysisResult
+				for j := range filteredSources {
+					classifyLogSource(p, &filteredSources[j], pingResults[filteredSources[j].Host.Name])
+					results = append(results, newAnalysisResult(p, filteredSources[j], pingResults[filteredSources[j].Host.Name]))
+				}
+				for _, ls := range sources.Excluded {
+					results = append(results, newAnalysisResult(p, ls, ""))
+				}
+				resultsByProfile[i] = results
+
+				counts := newStatusCounts()
+				counts.add(sources.Healthy)
+				counts.add(sources.Excluded)
+				counts.add(filteredSources)
+				countsByProfile[i] = counts
+
+				summary.LogSources = len(allLogSources)
+				summary.Stale = len(filteredSources)
+				summary.Hosts = len(uniqueHostnames)
+				for _, hostname := range uniqueHostnames {
+					if pingResults[hostname] == "Success" {
+						summary.Reachable++
+					} else {
+						summary.Unreachable++
+					}
+				}
+			}
+
+			jobsMutex.Lock()
+			job.Deployments[i] = summary
+			finished++
+			job.Progress = finished * 100 / len(profiles)
+			job.Message = fmt.Sprintf("Analyzed %d of %d deployments...", finished, len(profiles))
+			jobsMutex.Unlock()
+			broadcastJobUpdate(job)
+		}(i, p)
+	}
+	wg.Wait()
+
+	// Merge in a stable order: deployment, host, log source name
+	var merged []AnalysisResult
+	for _, results := range resultsByProfile {
+		merged = append(merged, results...)
+	}
+	counts := newStatusCounts()
+	for _, profileCounts := range countsByProfile {
+		if profileCounts != nil {
+			counts.merge(profileCounts)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Profile != merged[j].Profile {
+			return merged[i].Profile < merged[j].Profile
+		}
+		if merged[i].HostName != merged[j].HostName {
+			return merged[i].HostName < merged[j].HostName
+		}
+		return merged[i].Name < merged[j].Name
+	})
+
+	jobsMutex.Lock()
+	failed := []string{}
+	for _, summary := range job.Deployments {
+		if summary.Status == "error" {
+			failed = append(failed, fmt.Sprintf("%s: %s", summary.Profile, summary.Error))
+		}
+	}
+	job.Results = merged
+	job.StatusCounts = counts
+	job.Progress = 100
+	now := time.Now()
+	job.EndTime = &now
+	switch {
+	case len(failed) == len(profiles):
+		job.Status = "error"
+		job.Error = "All deployments failed: " + strings.Join(failed, "; ")
+	case len(failed) > 0:
+		job.Status = "completed"
+		job.Message = fmt.Sprintf("Analysis complete. Found %d sources across %d deployments; %d failed.", len(merged), len(profiles)-len(failed), len(failed))
+	default:
+		job.Status = "completed"
+		job.Message = fmt.Sprintf("Analysis complete. Found %d sources across %d deployments.", len(merged), len(profiles))
+	}
 	jobsMutex.Unlock()
 
-	// Broadcast the update to WebSocket clients
-	broadcastJobUpdate(job)
+	broadcastJobUpdate(job)
+	log.Printf("Cross-deployment analysis complete for job %s: %d sources, %d deployments failed", jobID, len(merged), len(failed))
+	notifyAnalysisCompleted(job)
+}
+
+// Analysis outcomes - what an analysis concluded about each log source and host, and why
+
+// Analysis statuses of log sources and hosts
+const (
+	StatusHealthy          = "Healthy"           // Logged since the cutoff date; counted but not listed
+	StatusStaleReachable   = "Stale-Reachable"   // Stale, but the host answers ping: troubleshoot rather than retire
+	StatusStaleUnreachable = "Stale-Unreachable" // Stale and the host does not answer ping: a retirement candidate
+	StatusExcluded         = "Excluded"          // Stale, but an exclusion rule keeps it out of retirement
+	StatusProtected        = "Protected"         // Stale and unreachable, but the protected objects list forbids retiring it
+)
+
+// analysisStatuses is the order counts, filters and reports list statuses in
+var analysisStatuses = []string{StatusStaleReachable, StatusStaleUnreachable, StatusProtected, StatusExcluded, StatusHealthy}
+
+// StatusCounts is how many log sources and hosts an analysis gave each status. Hosts are
+// only counted by host analyses; a healthy host is one with no stale log sources at all.
+type StatusCounts struct {
+	LogSources map[string]int `json:"logSources"`
+	Hosts      map[string]int `json:"hosts,omitempty"`
+}
+
+// add counts the statuses of classified log sources
+func (c *StatusCounts) add(sources []LogSource) {
+	for _, ls := range sources {
+		c.LogSources[ls.Status]++
+	}
+}
+
+// merge adds another deployment's counts
+func (c *StatusCounts) merge(other *StatusCounts) {
+	for status, n := range other.LogSources {
+		c.LogSources[status] += n
+	}
+	for status, n := range other.Hosts {
+		if c.Hosts == nil {
+			c.Hosts = make(map[string]int)
+		}
+		c.Hosts[status] += n
+	}
+}
+
+func newStatusCounts() *StatusCounts {
+	return &StatusCounts{LogSources: make(map[string]int)}
+}
+
+// staleLogSources is a deployment's active log sources split by the cutoff date and exclusion rules
+type staleLogSources struct {
+	Stale    []LogSource // No logs since the cutoff date; classified once their hosts are pinged
+	Excluded []LogSource // Stale, but covered by an exclusion rule
+	Healthy  []LogSource // Logged since the cutoff date
+}
+
+// filterStaleLogSources splits the active log sources of a profile into healthy ones, stale
+// ones and stale ones an exclusion rule for the profile covers. Excluded and healthy log
+// sources get their status here.
+func filterStaleLogSources(p *Profile, allLogSources []LogSource, selectedDate time.Time) staleLogSources {
+	exclusions := newExclusionMatcher(p.Name)
+	var sources staleLogSources
+	for _, ls := range allLogSources {
+		// Check if already retired
+		if ls.RecordStatus == "Retired" {
+			continue
+		}
+
+		// Check date
+		if maxLogDate, err := time.Parse(time.RFC3339, ls.MaxLogDate); err == nil {
+			if maxLogDate.After(selectedDate) {
+				ls.Status = StatusHealthy
+				ls.StatusReason = "Logs received since " + selectedDate.Format("2006-01-02")
+				sources.Healthy = append(sources.Healthy, ls)
+				continue
+			}
+		}
+
+		// Set aside log sources an exclusion rule covers
+		if rule := exclusions.match(ls); rule != nil {
+			ls.Status = StatusExcluded
+			ls.StatusReason = fmt.Sprintf("Excluded by rule %s (%s %s %q)", rule.ID, rule.Field, rule.Match, rule.Pattern)
+			if rule.Reason != "" {
+				ls.StatusReason += ": " + rule.Reason
+			}
+			sources.Excluded = append(sources.Excluded, ls)
+			continue
+		}
+
+		sources.Stale = append(sources.Stale, ls)
+	}
+
+	return sources
+}
+
+// staleSince says how long a stale log source has been silent
+func staleSince(ls LogSource) string {
+	last, err := time.Parse(time.RFC3339, ls.MaxLogDate)
+	if err != nil || last.Year() < 1900 {
+		return "No logs ever received"
+	}
+	return "No logs since " + last.Format("2006-01-02")
+}
+
+// classifyLogSource sets the status of a stale log source no exclusion rule covers from the
+// ping result of its host. A host that answers needs troubleshooting whether or not it is
+// protected; only an unreachable one is a retirement candidate.
+func classifyLogSource(p *Profile, ls *LogSource, pingResult string) {
+	if pingResult == "Success" {
+		ls.Status = StatusStaleReachable
+		ls.StatusReason = fmt.Sprintf("%s, but host %s answers ping", staleSince(*ls), ls.Host.Name)
+	} else if err := checkProtected(p, analysisTarget(*ls)); err != nil {
+		ls.Status = StatusProtected
+		ls.StatusReason = err.Error()
+		if protection, ok := err.(*ProtectionError); ok {
+			ls.StatusReason = protection.Reason()
+		}
+		ls.StatusReason = strings.ToUpper(ls.StatusReason[:1]) + ls.StatusReason[1:]
+	} else {
+		ls.Status = StatusStaleUnreachable
+		ls.StatusReason = fmt.Sprintf("%s and host %s does not answer ping", staleSince(*ls), ls.Host.Name)
+	}
+	ls.Recommended = ls.Status == StatusStaleUnreachable
+}
+
+// analysisTarget describes a log source from analysis for the protected objects check
+func analysisTarget(ls LogSource) protectedTarget {
+	target := protectedTarget{
+		Kind: ProtectLogSource,
+		Self: objectRef{ID: idToString(ls.ID), Name: ls.Name},
+		Host: objectRef{ID: idToString(ls.Host.ID), Name: ls.Host.Name},
+	}
+	if ls.SystemMonitorID != nil {
+		target.Agent = objectRef{ID: idToString(ls.SystemMonitorID), Name: ls.SystemMonitorName}
+	}
+	return target
+}
+
+// hostOutcome derives a host's status and reason from its classified stale log sources. They
+// share the host's ping result, so the host takes their status unless every one is excluded;
+// a host with any protected log source is protected, since retiring it would retire that too.
+func hostOutcome(sources []LogSource) (string, string) {
+	var excluded, protected *LogSource
+	stale := 0
+	for i := range sources {
+		switch sources[i].Status {
+		case StatusExcluded:
+			if excluded == nil {
+				excluded = &sources[i]
+			}
+		case StatusProtected:
+			if protected == nil {
+				protected = &sources[i]
+			}
+			stale++
+		default:
+			stale++
+		}
+	}
+
+	switch {
+	case stale == 0 && len(sources) == 1:
+		return StatusExcluded, "Its only stale log source is excluded. " + excluded.StatusReason
+	case stale == 0 && excluded != nil:
+		return StatusExcluded, fmt.Sprintf("All %d stale log sources are excluded. %s", len(sources), excluded.StatusReason)
+	case protected != nil:
+		return StatusProtected, fmt.Sprintf("Retiring it would retire log source %q. %s", protected.Name, protected.StatusReason)
+	}
+	for _, ls := range sources {
+		switch ls.Status {
+		case StatusStaleReachable:
+			return StatusStaleReachable, fmt.Sprintf("%s stopped sending logs, but the host answers ping", countNoun(stale, "log source has", "log sources have"))
+		case StatusStaleUnreachable:
+			return StatusStaleUnreachable, fmt.Sprintf("%s stopped sending logs and the host does not answer ping", countNoun(stale, "log source has", "log sources have"))
+		}
+	}
+	return "", ""
+}
+
+// countNoun puts a count in front of the singular or plural form of a phrase
+func countNoun(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// Analysis data sources - where analysis reads the deployment's log sources from
 
-	// Complete
-	jobsMutex.Lock()
-	job.Progress = 100
-	job.Message = fmt.Sprintf("Analysis complete. Found %d sources.", len(results))
-	job.Status = "completed"
-	now := time.Now()
-	job.EndTime = &now
-	jobsMutex.Unlock()
+// Analysis data sources a profile can use
+const (
+	DataSourceAPI  = "api"  // LogRhythm Admin API, paged 1000 log sources at a time
+	DataSourceEMDB = "emdb" // Read-only queries against LogRhythmEMDB, for LRCleaner on the XM or PM
+)
 
-	// Broadcast the completion to WebSocket clients
-	broadcastJobUpdate(job)
+// DataSource reads the log sources analysis works on, with their host, entity, agent and
+// MaxLogDate filled in. Retirement and rollback always go through the REST API.
+type DataSource interface {
+	Name() string
+	LogSources() ([]LogSource, error)
+}
 
-	// Log summary
-	successCount := 0
-	failureCount := 0
-	unknownCount := 0
-	for _, result := range results {
-		switch result.PingResult {
-		case "Success":
-			successCount++
-		case "Failure":
-			failureCount++
-		default:
-			unknownCount++
-		}
+// dataSourceFor returns the data source a profile is configured to analyse with
+func dataSourceFor(p *Profile) DataSource {
+	if p.Database.AnalysisSource == DataSourceEMDB {
+		return emdbDataSource{p: p}
 	}
-	log.Printf("Test Mode Analysis Complete:")
-	log.Printf("  Total log sources analyzed: %d", len(results))
-	log.Printf("  Successful pings: %d", successCount)
-	log.Printf("  Failed pings: %d", failureCount)
-	log.Printf("  Unknown ping results: %d", unknownCount)
+	return restDataSource{p: p}
+}
+
+// getAllLogSources reads a deployment's log sources and names the data source that
+// answered. When LogRhythmEMDB cannot be queried the REST API is used instead.
+func getAllLogSources(p *Profile) ([]LogSource, string, error) {
+	source := dataSourceFor(p)
+	logSources, err := source.LogSources()
+	if err != nil && source.Name() == DataSourceEMDB {
+		log.Printf("Reading log sources of %s from LogRhythmEMDB failed, using the REST API: %v", p.Name, err)
+		source = restDataSource{p: p}
+		logSources, err = source.LogSources()
+	}
+	return logSources, source.Name(), err
+}
+
+// restDataSource pages through /lr-admin-api/logsources
+type restDataSource struct {
+	p *Profile
 }
 
-func getAllLogSources() ([]LogSource, error) {
+func (s restDataSource) Name() string { return DataSourceAPI }
+
+func (s restDataSource) LogSources() ([]LogSource, error) {
+	p := s.p
 	var allSources []LogSource
 	offset := 0
 	count := 1000
 
 	for {
 		url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?count=%d&offset=%d",
-			config.Hostname, config.Port, count, offset)
+			p.Hostname, p.Port, count, offset)
 
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
 
-		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 
 		resp, err := httpClient.Do(req)
 		if err != nil {
@@ -1487,6 +10061,141 @@ func getAllLogSources() ([]LogSource, error) {
 	return allSources, nil
 }
 
+// emdbDataSource queries LogRhythmEMDB with the profile's database settings over a
+// read-only connection
+type emdbDataSource struct {
+	p *Profile
+}
+
+func (s emdbDataSource) Name() string { return DataSourceEMDB }
+
+// emdbLogSourceQuery joins each log source to its host, entity, type and agent. RecordStatus
+// is 1 for active and 0 for retired rows in the EMDB tables.
+const emdbLogSourceQuery = `SELECT ms.MsgSourceID, ms.Name, ms.RecordStatus, ms.MaxLogDate,
+	h.HostID, h.Name, e.EntityID, e.Name, mst.FullName, sm.SystemMonitorID, sm.Name
+FROM dbo.MsgSource ms
+LEFT JOIN dbo.Host h ON h.HostID = ms.HostID
+LEFT JOIN dbo.Entity e ON e.EntityID = h.EntityID
+LEFT JOIN dbo.MsgSourceType mst ON mst.MsgSourceTypeID = ms.MsgSourceTypeID
+LEFT JOIN dbo.SystemMonitor sm ON sm.SystemMonitorID = ms.SystemMonitorID
+ORDER BY ms.MsgSourceID`
+
+// emdbHostIdentifierQuery reads the active identifiers of every host. Type is 1 for an
+// IP address, 2 for a DNS name and 3 for a Windows name.
+const emdbHostIdentifierQuery = `SELECT h.HostID, hi.Type, hi.Value
+FROM dbo.Host h
+JOIN dbo.HostIdentifier hi ON hi.HostID = h.HostID
+WHERE hi.RecordStatus = 1
+ORDER BY h.HostID, hi.Type, hi.Value`
+
+// emdbIdentifierTypes names EMDB host identifier types the way the Admin API does
+var emdbIdentifierTypes = map[int64]string{1: "IPAddress", 2: "DNSName", 3: "WindowsName"}
+
+func (s emdbDataSource) LogSources() ([]LogSource, error) {
+	db := s.p.Database
+	password := ""
+	if db.AuthMode == DBAuthSQL {
+		var err error
+		if password, err = GetDBPassword(db.KeyringKey); err != nil {
+			return nil, fmt.Errorf("no database password is stored for deployment %s", s.p.Name)
+		}
+	}
+	connectionString, err := sqlConnectionURL(db, password, emdbDatabase, true)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("sqlserver", connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	identifiers, err := emdbHostIdentifiers(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host identifiers from %s on %s: %v", emdbDatabase, db.serverName(), err)
+	}
+
+	rows, err := conn.Query(emdbLogSourceQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query log sources from %s on %s: %v", emdbDatabase, db.serverName(), err)
+	}
+	defer rows.Close()
+
+	var allSources []LogSource
+	for rows.Next() {
+		var (
+			id, recordStatus                int64
+			name                            string
+			maxLogDate                      sql.NullTime
+			hostID, entityID, agentID       sql.NullInt64
+			hostName, entityName, agentName sql.NullString
+			typeName                        sql.NullString
+		)
+		if err := rows.Scan(&id, &name, &recordStatus, &maxLogDate, &hostID, &hostName,
+			&entityID, &entityName, &typeName, &agentID, &agentName); err != nil {
+			return nil, fmt.Errorf("failed to read log source row: %v", err)
+		}
+
+		ls := LogSource{
+			ID:                id,
+			Name:              name,
+			RecordStatus:      "Active",
+			Host:              Host{ID: nullID(hostID), Name: hostName.String, Identifiers: identifiers[hostID.Int64]},
+			Entity:            Entity{ID: nullID(entityID), Name: entityName.String},
+			LogSourceType:     LogSourceType{Name: typeName.String},
+			SystemMonitorID:   nullID(agentID),
+			SystemMonitorName: agentName.String,
+		}
+		if recordStatus == 0 {
+			ls.RecordStatus = "Retired"
+		}
+		if maxLogDate.Valid {
+			ls.MaxLogDate = maxLogDate.Time.UTC().Format(time.RFC3339)
+		}
+		allSources = append(allSources, ls)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log sources: %v", err)
+	}
+
+	log.Printf("Read %d log sources from %s on %s in %v", len(allSources), emdbDatabase, db.serverName(), time.Since(start).Round(time.Millisecond))
+	return allSources, nil
+}
+
+// emdbHostIdentifiers maps each host ID to its active identifiers
+func emdbHostIdentifiers(conn *sql.DB) (map[int64][]HostIdentifier, error) {
+	rows, err := conn.Query(emdbHostIdentifierQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identifiers := make(map[int64][]HostIdentifier)
+	for rows.Next() {
+		var hostID, idType int64
+		var value string
+		if err := rows.Scan(&hostID, &idType, &value); err != nil {
+			return nil, fmt.Errorf("failed to read host identifier row: %v", err)
+		}
+		name, ok := emdbIdentifierTypes[idType]
+		if !ok {
+			name = strconv.FormatInt(idType, 10)
+		}
+		identifiers[hostID] = append(identifiers[hostID], HostIdentifier{Type: name, Value: value})
+	}
+	return identifiers, rows.Err()
+}
+
+// nullID turns a nullable EMDB key into an ID, nil when the row has none
+func nullID(id sql.NullInt64) interface{} {
+	if !id.Valid {
+		return nil
+	}
+	return id.Int64
+}
+
 // Fast ping implementation for single host
 func pingHostFast(hostname string) string {
 	// Test most common ports with short timeouts
@@ -1572,7 +10281,7 @@ func idToString(id interface{}) string {
 	}
 }
 
-func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
+func analyzeHostsForRetirement(p *Profile, jobID string, selectedDate time.Time) {
 	jobsMutex.Lock()
 	job := jobs[jobID]
 	jobsMutex.Unlock()
@@ -1588,10 +10297,11 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 
 		// Broadcast the completion to WebSocket clients
 		broadcastJobUpdate(job)
+		notifyAnalysisCompleted(job)
 	}()
 
 	// Get all log sources
-	allLogSources, err := getAllLogSources()
+	allLogSources, dataSource, err := getAllLogSources(p)
 	if err != nil {
 		jobsMutex.Lock()
 		job.Status = "error"
@@ -1602,6 +10312,7 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 
 	// Update progress
 	jobsMutex.Lock()
+	job.DataSource = dataSource
 	job.Progress = 25
 	job.Message = fmt.Sprintf("Found %d log sources. Analyzing hosts...", len(allLogSources))
 	jobsMutex.Unlock()
@@ -1610,78 +10321,45 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 	broadcastJobUpdate(job)
 
 	// Filter by date and excluded sources
-	var filteredSources []LogSource
-	for _, ls := range allLogSources {
-		// Check date
-		if maxLogDate, err := time.Parse(time.RFC3339, ls.MaxLogDate); err == nil {
-			if maxLogDate.After(selectedDate) {
-				continue
-			}
-		}
-
-		// Check if already retired
-		if ls.RecordStatus == "Retired" {
-			continue
-		}
-
-		// Check excluded sources
-		excluded := false
-		sourceType := ls.LogSourceType.Name
-		sourceName := ls.Name
-		hostName := ls.Host.Name
-
-		// Exclude LogRhythm system monitor agents
-		if strings.HasPrefix(sourceType, "LogRhythm") {
-			excluded = true
-		}
-
-		// Exclude echo hosts and log sources
-		if containsIgnoreCase(hostName, "echo") || containsIgnoreCase(sourceName, "echo") {
-			excluded = true
-		}
-
-		// Check config excluded sources
-		if !excluded {
-			for _, pattern := range config.ExcludedLogSources {
-				if containsIgnoreCase(sourceType, pattern) {
-					excluded = true
-					break
-				}
-			}
-		}
-
-		if !excluded {
-			filteredSources = append(filteredSources, ls)
-		}
-	}
+	sources := filterStaleLogSources(p, allLogSources, selectedDate)
+	filteredSources := sources.Stale
 
 	// Group by host
 	hostMap := make(map[string]*HostAnalysis)
-	for _, ls := range filteredSources {
+	hostFor := func(ls LogSource) *HostAnalysis {
 		hostID := idToString(ls.Host.ID)
-		hostName := ls.Host.Name
-
 		if hostMap[hostID] == nil {
 			hostMap[hostID] = &HostAnalysis{
 				HostID:     ls.Host.ID, // Keep original interface{} type
-				HostName:   hostName,
+				HostName:   ls.Host.Name,
 				LogSources: []LogSource{},
 			}
 		}
-
-		hostMap[hostID].LogSources = append(hostMap[hostID].LogSources, ls)
-		hostMap[hostID].LogSourceCount++
+		return hostMap[hostID]
+	}
+	for _, ls := range filteredSources {
+		host := hostFor(ls)
+		host.LogSources = append(host.LogSources, ls)
+		host.LogSourceCount++
 
 		// Update max log date
-		if hostMap[hostID].MaxLogDate == "" || ls.MaxLogDate < hostMap[hostID].MaxLogDate {
-			hostMap[hostID].MaxLogDate = ls.MaxLogDate
+		if host.MaxLogDate == "" || ls.MaxLogDate < host.MaxLogDate {
+			host.MaxLogDate = ls.MaxLogDate
 		}
 	}
+	// Excluded log sources are shown with their host but never retired with it
+	for _, ls := range sources.Excluded {
+		host := hostFor(ls)
+		host.Excluded = append(host.Excluded, ls)
+	}
 
-	// Collect unique hostnames for concurrent ping testing
+	// Collect unique hostnames for concurrent ping testing; hosts with only excluded
+	// log sources are not pinged
 	hostnames := make([]string, 0, len(hostMap))
 	for _, host := range hostMap {
-		hostnames = append(hostnames, host.HostName)
+		if len(host.LogSources) > 0 {
+			hostnames = append(hostnames, host.HostName)
+		}
 	}
 
 	log.Printf("Testing connectivity to %d hosts concurrently...", len(hostnames))
@@ -1718,36 +10396,57 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 		// Get ping result from our concurrent test
 		host.PingResult = pingResults[host.HostName]
 
-		// Determine recommendations for each log source
-		recommendedLogSources := 0
+		// Only log sources of hosts that do not answer ping are retirement candidates;
+		// those of hosts that do need troubleshooting instead
 		for i := range host.LogSources {
 			ls := &host.LogSources[i]
+			classifyLogSource(p, ls, host.PingResult)
+			log.Printf("  → Log source %s: %s (%s)", ls.Name, ls.Status, ls.StatusReason)
+		}
 
-			// Only recommend log source if ping fails (host is not reachable)
-			// If host is pingable but has old logs, recommend troubleshooting instead
-			ls.Recommended = (host.PingResult == "Failure")
+		// Recommend the host only when it is a retirement candidate as a whole
+		host.Status, host.StatusReason = hostOutcome(append(append([]LogSource{}, host.LogSources...), host.Excluded...))
+		host.Recommended = host.Status == StatusStaleUnreachable
+		log.Printf("  → Host %s: %s (%s)", host.HostName, host.Status, host.StatusReason)
 
-			if ls.Recommended {
-				recommendedLogSources++
-				log.Printf("  → Log source %s is RECOMMENDED for retirement (host not pingable)", ls.Name)
-			} else if host.PingResult == "Success" && ls.MaxLogDate != "" && parseTime(ls.MaxLogDate).Before(selectedDate) {
-				log.Printf("  → Log source %s has old logs but host is pingable - recommend troubleshooting", ls.Name)
-			} else {
-				log.Printf("  → Log source %s is NOT recommended for retirement", ls.Name)
-			}
+		hostAnalysis = append(hostAnalysis, *host)
+	}
+
+	// Count every active log source and host by status; hosts that only have healthy
+	// log sources are not listed
+	counts := newStatusCounts()
+	counts.Hosts = make(map[string]int)
+	counts.add(sources.Healthy)
+	for _, host := range hostAnalysis {
+		counts.add(host.LogSources)
+		counts.add(host.Excluded)
+		counts.Hosts[host.Status]++
+	}
+	healthyHosts := make(map[string]bool)
+	for _, ls := range sources.Healthy {
+		if hostID := idToString(ls.Host.ID); hostMap[hostID] == nil {
+			healthyHosts[hostID] = true
 		}
+	}
+	counts.Hosts[StatusHealthy] = len(healthyHosts)
 
-		// Only recommend host if ALL log sources are recommended
-		host.Recommended = (recommendedLogSources > 0) && (recommendedLogSources == len(host.LogSources))
+	// Show the cases already open for these hosts, then open or update them when asked to
+	linkCases(p.Name, hostAnalysis)
+	if p.Cases.Enabled && p.Cases.AutoCreate {
+		jobsMutex.Lock()
+		job.Message = "Opening LogRhythm cases for hosts that need troubleshooting..."
+		startedBy := job.StartedBy
+		jobsMutex.Unlock()
+		broadcastJobUpdate(job)
 
-		// Log host recommendation
-		if host.Recommended {
-			log.Printf("  → Host %s is RECOMMENDED for retirement (all %d log sources recommended)", host.HostName, recommendedLogSources)
-		} else {
-			log.Printf("  → Host %s is NOT recommended for retirement (%d/%d log sources recommended)", host.HostName, recommendedLogSources, len(host.LogSources))
+		failed := 0
+		results := openCases(p, hostAnalysis, nil, jobID, startedBy, "")
+		for _, result := range results {
+			if result.Error != "" {
+				failed++
+			}
 		}
-
-		hostAnalysis = append(hostAnalysis, *host)
+		log.Printf("LogRhythm cases: %d hosts need troubleshooting, %d cases could not be opened or updated", len(results), failed)
 	}
 
 	// Update job with host analysis
@@ -1755,6 +10454,7 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 	job.Progress = 100
 	job.Message = fmt.Sprintf("Host analysis complete. Found %d hosts.", len(hostAnalysis))
 	job.HostAnalysis = hostAnalysis
+	job.StatusCounts = counts
 	jobsMutex.Unlock()
 
 	// Broadcast the update to WebSocket clients
@@ -1770,14 +10470,15 @@ func analyzeHostsForRetirement(jobID string, selectedDate time.Time) {
 	log.Printf("Apply Mode Host Analysis Complete:")
 	log.Printf("  Total hosts analyzed: %d", len(hostAnalysis))
 	log.Printf("  Recommended for retirement: %d", recommendedCount)
+	log.Printf("  Need troubleshooting: %d", counts.Hosts[StatusStaleReachable])
 	log.Printf("  Not recommended: %d", len(hostAnalysis)-recommendedCount)
 }
 
-func analyzeCollectionHosts(jobID string) []CollectionHostAnalysis {
+func analyzeCollectionHosts(p *Profile, jobID string) []CollectionHostAnalysis {
 	log.Printf("Starting collection host analysis for job: %s", jobID)
 
 	// Get all log sources
-	allLogSources, err := getAllLogSources()
+	allLogSources, _, err := getAllLogSources(p)
 	if err != nil {
 		log.Printf("Error getting log sources for collection host analysis: %v", err)
 		return nil
@@ -1853,17 +10554,19 @@ func analyzeCollectionHosts(jobID string) []CollectionHostAnalysis {
 				log.Printf("Processing collection host %s (ID: %s) for retirement...", ch.SystemMonitorName, idToString(ch.SystemMonitorID))
 
 				// First unlicense the system monitor
-				if unlicenseSystemMonitor(ch.SystemMonitorID) {
+				if err := unlicenseSystemMonitor(p, ch.SystemMonitorID); err == nil {
 					log.Printf("  ✓ Successfully unlicensed collection host: %s", ch.SystemMonitorName)
 
 					// Then retire the system monitor
-					if retireSystemMonitor(ch.SystemMonitorID) {
+					if err := retireSystemMonitor(p, ch.SystemMonitorID); err == nil {
 						retiredCount++
 						log.Printf("  ✓ Successfully retired collection host: %s", ch.SystemMonitorName)
 					} else {
+						recordRetirementFailure(jobID, ProtectAgent, ch.SystemMonitorID, ch.SystemMonitorName, "", err)
 						log.Printf("  ✗ Failed to retire collection host: %s", ch.SystemMonitorName)
 					}
 				} else {
+					recordRetirementFailure(jobID, ProtectAgent, ch.SystemMonitorID, ch.SystemMonitorName, "", err)
 					log.Printf("  ✗ Failed to unlicense collection host: %s", ch.SystemMonitorName)
 				}
 			}
@@ -1877,15 +10580,38 @@ func analyzeCollectionHosts(jobID string) []CollectionHostAnalysis {
 	return collectionHostAnalysis
 }
 
-func executeRetirement(jobID string, selectedHosts []string) {
+// latestHostAnalysis returns the host analysis of the newest Apply Mode job run against profile
+func latestHostAnalysis(profile string) []HostAnalysis {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	var latest *JobStatus
+	for _, job := range jobs {
+		if job.Profile != profile || len(job.HostAnalysis) == 0 {
+			continue
+		}
+		if latest == nil || job.StartTime.After(latest.StartTime) {
+			latest = job
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.HostAnalysis
+}
+
+func executeRetirement(p *Profile, jobID string, selectedHosts []string, naming RetirementNaming) {
 	jobsMutex.Lock()
 	job := jobs[jobID]
 	jobsMutex.Unlock()
 
 	// Create rollback data before starting retirement
-	rollbackData := createRollbackData(jobID, selectedHosts)
+	rollbackData := createRollbackData(p, jobID, selectedHosts, naming)
 	if rollbackData != nil {
-		saveRollbackData(rollbackData)
+		saveRollbackData(p, rollbackData)
+		jobsMutex.Lock()
+		job.RollbackID = rollbackData.ID
+		jobsMutex.Unlock()
 	}
 
 	defer func() {
@@ -1895,19 +10621,16 @@ func executeRetirement(jobID string, selectedHosts []string) {
 		if job.Status == "running" {
 			job.Status = "completed"
 		}
+		event := EventRetirementCompleted
+		if job.Status == "error" {
+			event = EventRetirementFailed
+		}
 		jobsMutex.Unlock()
+		notifyEvent(jobEvent(event, job))
 	}()
 
 	// Get the host analysis from the previous job
-	var hostAnalysis []HostAnalysis
-	jobsMutex.RLock()
-	for _, otherJob := range jobs {
-		if len(otherJob.HostAnalysis) > 0 {
-			hostAnalysis = otherJob.HostAnalysis
-			break
-		}
-	}
-	jobsMutex.RUnlock()
+	hostAnalysis := latestHostAnalysis(p.Name)
 
 	if len(hostAnalysis) == 0 {
 		jobsMutex.Lock()
@@ -1948,17 +10671,6 @@ func executeRetirement(jobID string, selectedHosts []string) {
 		for j, logSource := range host.LogSources {
 			log.Printf("  → Retiring log source %d/%d: %s",
 				j+1, len(host.LogSources), logSource.Name)
-			// Create retirement record before making changes
-			record := RetirementRecord{
-				LogSourceID:    logSource.ID,
-				HostID:         host.HostID,
-				HostName:       host.HostName,
-				OriginalName:   logSource.Name,
-				RetiredName:    logSource.Name + " Retired by LRCleaner",
-				OriginalStatus: logSource.RecordStatus,
-				RetiredStatus:  "Retired",
-				Timestamp:      time.Now(),
-			}
 
 			// Check if log source is already retired
 			if logSource.RecordStatus == "Retired" {
@@ -1967,10 +10679,38 @@ func executeRetirement(jobID string, selectedHosts []string) {
 			}
 
 			// Update via API (the function now handles getting, modifying, and putting the log source)
-			success := updateLogSource(logSource.ID)
-			if success {
+			nameChange, err := updateLogSource(p, logSource.ID, naming)
+			entry := namingAudit(naming, "retirement.logsource", idToString(logSource.ID))
+			entry.Before = auditValue(map[string]interface{}{
+				"name": nameChange.Original, "recordStatus": logSource.RecordStatus, "shortDescription": nameChange.OriginalDescription})
+			if err == nil {
+				entry.After = auditValue(map[string]interface{}{
+					"name": nameChange.Retired, "recordStatus": "Retired", "shortDescription": retirementDescription(naming)})
+			} else {
+				entry.Result = auditResultFailure
+				entry.Message = fmt.Sprintf("Failed to retire log source %s on host %s: %v", logSource.Name, host.HostName, err)
+				recordRetirementFailure(jobID, ProtectLogSource, logSource.ID, logSource.Name, host.HostName, err)
+			}
+			logAudit(entry)
+			if err == nil {
 				processedLogSources++
+				// Record the exact names seen by the API rather than the analysis snapshot
+				record := RetirementRecord{
+					LogSourceID:       logSource.ID,
+					HostID:            host.HostID,
+					HostName:          host.HostName,
+					OriginalName:      nameChange.Original,
+					RetiredName:       nameChange.Retired,
+					OriginalStatus:    logSource.RecordStatus,
+					RetiredStatus:     "Retired",
+					ChangeTicket:      naming.Ticket,
+					Justification:     naming.Justification,
+					Timestamp:         time.Now(),
+					SystemMonitorID:   logSource.SystemMonitorID,
+					SystemMonitorName: logSource.SystemMonitorName,
+				}
 				retirementRecords = append(retirementRecords, record)
+				recordLogSourceRetirement(rollbackData, logSource.ID, nameChange)
 				log.Printf("    ✓ Successfully retired: %s", logSource.Name)
 			} else {
 				log.Printf("    ✗ Failed to retire: %s", logSource.Name)
@@ -1997,7 +10737,7 @@ func executeRetirement(jobID string, selectedHosts []string) {
 
 	// Retire system monitor agents that have no remaining active log sources
 	log.Printf("Checking system monitor agents for retirement...")
-	retiredAgents := 0
+	retiredAgents := make(map[string]bool)
 	uniqueAgents := make(map[string]bool)
 
 	// Collect unique system monitor agent IDs from the retirement records
@@ -2022,17 +10762,20 @@ func executeRetirement(jobID string, selectedHosts []string) {
 		log.Printf("Checking system monitor agent %s for retirement...", agentID)
 
 		// Check if agent has any remaining active log sources
-		hasActiveLogSources := checkAgentHasActiveLogSources(agentID)
+		hasActiveLogSources := checkAgentHasActiveLogSources(p, agentID)
 		log.Printf("System monitor agent %s active log sources check result: %t", agentID, hasActiveLogSources)
 
 		if !hasActiveLogSources {
 			log.Printf("System monitor agent %s has no active log sources, proceeding with retirement...", agentID)
 
 			// Retire the system monitor agent
-			if retireSystemMonitor(agentID) {
-				retiredAgents++
+			err := retireSystemMonitor(p, agentID)
+			logAudit(agentRetirementAudit(naming, agentID, err))
+			if err == nil {
+				retiredAgents[agentID] = true
 				log.Printf("  ✓ Successfully retired system monitor agent: %s", agentID)
 			} else {
+				recordRetirementFailure(jobID, ProtectAgent, agentID, "", "", err)
 				log.Printf("  ✗ Failed to retire system monitor agent: %s", agentID)
 			}
 		} else {
@@ -2042,11 +10785,11 @@ func executeRetirement(jobID string, selectedHosts []string) {
 
 	log.Printf("System Monitor Agent Retirement Summary:")
 	log.Printf("  Agents checked: %d", len(uniqueAgents))
-	log.Printf("  Agents successfully retired: %d", retiredAgents)
+	log.Printf("  Agents successfully retired: %d", len(retiredAgents))
 
 	// Check and retire hosts that have no remaining active log sources
 	log.Printf("Checking hosts for retirement...")
-	retiredHosts := 0
+	var retiredHosts []string
 	uniqueHosts := make(map[string]bool)
 
 	// Collect unique host IDs from the retirement records
@@ -2064,7 +10807,7 @@ func executeRetirement(jobID string, selectedHosts []string) {
 		log.Printf("Checking host %s for retirement...", hostID)
 
 		// Check if host has any remaining active log sources
-		hasActiveLogSources := checkHostHasActiveLogSources(hostID)
+		hasActiveLogSources := checkHostHasActiveLogSources(p, hostID)
 		log.Printf("Host %s active log sources check result: %t", hostID, hasActiveLogSources)
 
 		if !hasActiveLogSources {
@@ -2079,19 +10822,33 @@ func executeRetirement(jobID string, selectedHosts []string) {
 
 				// First unlicense the system monitor
 				log.Printf("DEBUG: Calling unlicenseSystemMonitor for agent %s", systemMonitorID)
-				if unlicenseSystemMonitor(systemMonitorID) {
+				err := unlicenseSystemMonitor(p, systemMonitorID)
+				entry := namingAudit(naming, "retirement.agent.unlicense", systemMonitorID)
+				entry.After = auditValue(map[string]interface{}{"licenseType": "None"})
+				if err != nil {
+					entry.Result = auditResultFailure
+					entry.After = nil
+					entry.Message = err.Error()
+				}
+				logAudit(entry)
+				if err == nil {
 					log.Printf("  ✓ Successfully unlicensed system monitor agent: %s", systemMonitorID)
 
 					// Then retire the system monitor
 					log.Printf("DEBUG: Calling retireSystemMonitor for agent %s", systemMonitorID)
-					if retireSystemMonitor(systemMonitorID) {
+					err := retireSystemMonitor(p, systemMonitorID)
+					logAudit(agentRetirementAudit(naming, systemMonitorID, err))
+					if err == nil {
+						retiredAgents[systemMonitorID] = true
 						log.Printf("  ✓ Successfully retired system monitor agent: %s", systemMonitorID)
 						log.Printf("DEBUG: Agent %s retirement completed successfully", systemMonitorID)
 					} else {
+						recordRetirementFailure(jobID, ProtectAgent, systemMonitorID, "", "", err)
 						log.Printf("  ✗ Failed to retire system monitor agent: %s", systemMonitorID)
 						log.Printf("DEBUG: Agent %s retirement failed, but continuing with host retirement", systemMonitorID)
 					}
 				} else {
+					recordRetirementFailure(jobID, ProtectAgent, systemMonitorID, "", "", err)
 					log.Printf("  ✗ Failed to unlicense system monitor agent: %s", systemMonitorID)
 					log.Printf("DEBUG: Agent %s unlicensing failed, but continuing with host retirement", systemMonitorID)
 				}
@@ -2108,11 +10865,25 @@ func executeRetirement(jobID string, selectedHosts []string) {
 			// Step 3: Retire the host
 			log.Printf("=== STEP 3: HOST RETIREMENT ===")
 			log.Printf("DEBUG: About to retire host %s", hostID)
-			success, removedIdentifiers := updateHost(hostID)
-			if success {
-				retiredHosts++
+			removedIdentifiers, nameChange, err := updateHost(p, hostID, naming)
+			entry := namingAudit(naming, "retirement.host", hostID)
+			entry.Before = auditValue(map[string]interface{}{
+				"name": nameChange.Original, "shortDescription": nameChange.OriginalDescription, "identifiers": removedIdentifiers})
+			if err == nil {
+				entry.After = auditValue(map[string]interface{}{
+					"name": nameChange.Retired, "recordStatus": "Retired", "shortDescription": retirementDescription(naming),
+					"removedIdentifiers": removedIdentifiers})
+			} else {
+				entry.Result = auditResultFailure
+				entry.Message = err.Error()
+				recordRetirementFailure(jobID, ProtectHost, hostID, hostNameOf(hostID, hostsToRetire), "", err)
+			}
+			logAudit(entry)
+			if err == nil {
+				retiredHosts = append(retiredHosts, hostID)
 				// Store the removed identifiers for rollback data
 				removedIdentifiersMap[idToString(hostID)] = removedIdentifiers
+				recordHostRetirement(rollbackData, hostID, nameChange, removedIdentifiers)
 				log.Printf("  ✓ Successfully retired host: %s", hostID)
 				log.Printf("  ✓ Removed %d identifiers from host: %s", len(removedIdentifiers), hostID)
 				log.Printf("DEBUG: Host %s retirement completed successfully", hostID)
@@ -2128,11 +10899,39 @@ func executeRetirement(jobID string, selectedHosts []string) {
 
 	log.Printf("Host Retirement Summary:")
 	log.Printf("  Hosts checked: %d", len(uniqueHosts))
-	log.Printf("  Hosts successfully retired: %d", retiredHosts)
+	log.Printf("  Hosts successfully retired: %d", len(retiredHosts))
+
+	// Persist the names and identifiers captured during retirement
+	if rollbackData != nil {
+		saveRollbackData(p, rollbackData)
+	}
+
+	agentIDs := make([]string, 0, len(retiredAgents))
+	for agentID := range retiredAgents {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sortIDs(agentIDs)
+	sortIDs(retiredHosts)
+
+	jobsMutex.Lock()
+	job.RetiredHosts = retiredHosts
+	job.RetiredAgents = agentIDs
+	failures := len(job.Failures)
+	jobsMutex.Unlock()
+
+	entry := namingAudit(naming, "retirement.complete", "")
+	entry.Message = fmt.Sprintf("Retired %d log sources, %d hosts and %d agents", processedLogSources, len(retiredHosts), len(agentIDs))
+	if failures > 0 {
+		entry.Message += fmt.Sprintf("; %d changes refused or failed", failures)
+	}
+	if rollbackData != nil {
+		entry.Message += fmt.Sprintf("; rollback point %s", rollbackData.ID)
+	}
+	logAudit(entry)
 
 	// Analyze collection hosts after retirement
 	log.Printf("Analyzing collection hosts after retirement...")
-	collectionHostAnalysis := analyzeCollectionHosts(jobID)
+	collectionHostAnalysis := analyzeCollectionHosts(p, jobID)
 
 	// Update job with collection host analysis
 	jobsMutex.Lock()
@@ -2143,6 +10942,70 @@ func executeRetirement(jobID string, selectedHosts []string) {
 	broadcastJobUpdate(job)
 }
 
+// agentRetirementAudit describes the retirement of a System Monitor agent
+func agentRetirementAudit(naming RetirementNaming, agentID string, err error) AuditEntry {
+	entry := namingAudit(naming, "retirement.agent", agentID)
+	entry.Before = auditValue(map[string]interface{}{"recordStatus": "Active"})
+	entry.After = auditValue(map[string]interface{}{"recordStatus": "Retired"})
+	if err != nil {
+		entry.Result = auditResultFailure
+		entry.After = nil
+		entry.Message = err.Error()
+	}
+	return entry
+}
+
+// recordRetirementFailure adds a refused or failed change to the job's results
+func recordRetirementFailure(jobID, kind string, id interface{}, name, hostName string, err error) {
+	var protectionErr *ProtectionError
+	failure := RetirementFailure{
+		Kind:      kind,
+		ID:        idToString(id),
+		Name:      name,
+		HostName:  hostName,
+		Error:     err.Error(),
+		Protected: errors.As(err, &protectionErr),
+		Timestamp: time.Now(),
+	}
+	if failure.Protected && failure.Name == "" {
+		failure.Name = protectionErr.Target.Self.Name
+	}
+
+	jobsMutex.Lock()
+	if job := jobs[jobID]; job != nil {
+		job.Failures = append(job.Failures, failure)
+	}
+	jobsMutex.Unlock()
+}
+
+// sortIDs orders LogRhythm IDs numerically, falling back to text for IDs that are not numbers
+func sortIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool { return idLess(ids[i], ids[j]) })
+}
+
+// idLess reports whether ID a sorts before b: numbers by value and before text IDs
+func idLess(a, b string) bool {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	if (errA == nil) != (errB == nil) {
+		return errA == nil
+	}
+	return a < b
+}
+
+// hostNameOf returns the analysed name of a host being retired
+func hostNameOf(hostID interface{}, hosts []HostAnalysis) string {
+	for _, host := range hosts {
+		if idToString(host.HostID) == idToString(hostID) {
+			return host.HostName
+		}
+	}
+	return ""
+}
+
 func parseTime(timeStr string) time.Time {
 	if t, err := time.Parse(time.RFC3339, timeStr); err == nil {
 		return t
@@ -2170,32 +11033,119 @@ func getSystemMonitorIDForHost(hostID interface{}, retirementRecords []Retiremen
 			}
 		}
 	}
-	log.Printf("DEBUG: No system monitor ID found for host %s", hostID)
-	return ""
+	log.Printf("DEBUG: No system monitor ID found for host %s", hostID)
+	return ""
+}
+
+func checkAgentHasActiveLogSources(p *Profile, agentID interface{}) bool {
+	// Check if the system monitor agent has any remaining active log sources (excluding LogRhythm and echo sources)
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?systemMonitorId=%s&recordStatus=active", p.Hostname, p.Port, idToString(agentID))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		log.Printf("Error creating request to check agent %s log sources: %v", idToString(agentID), err)
+		return true // Assume it has log sources if we can't check
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error checking log sources for agent %s: %v", idToString(agentID), err)
+		return true // Assume it has log sources if we can't check
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to check log sources for agent %s, status: %d", idToString(agentID), resp.StatusCode)
+		return true // Assume it has log sources if we can't check
+	}
+
+	// Parse the response - try array format first
+	var allLogSources []LogSource
+	if err := json.NewDecoder(resp.Body).Decode(&allLogSources); err != nil {
+		// Try object format
+		var responseObj map[string]interface{}
+		resp.Body.Close()
+
+		req, err = http.NewRequest("GET", url, nil)
+		if err != nil {
+			return true
+		}
+		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			return true
+		}
+		defer resp.Body.Close()
+
+		if err := json.NewDecoder(resp.Body).Decode(&responseObj); err != nil {
+			return true
+		}
+
+		if items, ok := responseObj["items"].([]interface{}); ok {
+			// Convert items to LogSource structs
+			for _, item := range items {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					var ls LogSource
+					if lsBytes, err := json.Marshal(itemMap); err == nil {
+						if err := json.Unmarshal(lsBytes, &ls); err == nil {
+							allLogSources = append(allLogSources, ls)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Apply the same filtering logic as in analyzeHostsForRetirement
+	exclusions := newExclusionMatcher(p.Name)
+	var filteredLogSources []LogSource
+	for _, ls := range allLogSources {
+		// Check if already retired
+		if ls.RecordStatus == "Retired" {
+			continue
+		}
+
+		// Skip log sources an exclusion rule covers
+		if exclusions.match(ls) != nil {
+			continue
+		}
+
+		filteredLogSources = append(filteredLogSources, ls)
+	}
+
+	hasActiveLogSources := len(filteredLogSources) > 0
+	log.Printf("System monitor agent %s has %d total log sources, %d filterable log sources (after exclusions)",
+		idToString(agentID), len(allLogSources), len(filteredLogSources))
+	return hasActiveLogSources
 }
 
-func checkAgentHasActiveLogSources(agentID interface{}) bool {
-	// Check if the system monitor agent has any remaining active log sources (excluding LogRhythm and echo sources)
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?systemMonitorId=%s&recordStatus=active", config.Hostname, config.Port, idToString(agentID))
+func checkHostHasActiveLogSources(p *Profile, hostID interface{}) bool {
+	// Check if the host has any remaining active log sources that no exclusion rule covers
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?hostId=%s&recordStatus=active", p.Hostname, p.Port, idToString(hostID))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Printf("Error creating request to check agent %s log sources: %v", idToString(agentID), err)
+		log.Printf("Error creating request to check host %s log sources: %v", idToString(hostID), err)
 		return true // Assume it has log sources if we can't check
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error checking log sources for agent %s: %v", idToString(agentID), err)
+		log.Printf("Error checking log sources for host %s: %v", idToString(hostID), err)
 		return true // Assume it has log sources if we can't check
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to check log sources for agent %s, status: %d", idToString(agentID), resp.StatusCode)
+		log.Printf("Failed to check log sources for host %s, status: %d", idToString(hostID), resp.StatusCode)
 		return true // Assume it has log sources if we can't check
 	}
 
@@ -2210,7 +11160,7 @@ func checkAgentHasActiveLogSources(agentID interface{}) bool {
 		if err != nil {
 			return true
 		}
-		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 		req.Header.Set("Content-Type", "application/json")
 
 		resp, err = httpClient.Do(req)
@@ -2239,6 +11189,7 @@ func checkAgentHasActiveLogSources(agentID interface{}) bool {
 	}
 
 	// Apply the same filtering logic as in analyzeHostsForRetirement
+	exclusions := newExclusionMatcher(p.Name)
 	var filteredLogSources []LogSource
 	for _, ls := range allLogSources {
 		// Check if already retired
@@ -2246,706 +11197,1753 @@ func checkAgentHasActiveLogSources(agentID interface{}) bool {
 			continue
 		}
 
-		// Check excluded sources
-		excluded := false
-		sourceType := ls.LogSourceType.Name
-		sourceName := ls.Name
-		hostName := ls.Host.Name
+		// Skip log sources an exclusion rule covers
+		if exclusions.match(ls) != nil {
+			continue
+		}
+
+		filteredLogSources = append(filteredLogSources, ls)
+	}
+
+	hasActiveLogSources := len(filteredLogSources) > 0
+	log.Printf("Host %s has %d total log sources, %d filterable log sources (after exclusions)",
+		idToString(hostID), len(allLogSources), len(filteredLogSources))
+	return hasActiveLogSources
+}
+
+func unlicenseSystemMonitor(p *Profile, systemMonitorID interface{}) error {
+	log.Printf("DEBUG: Starting unlicenseSystemMonitor for agent %s", idToString(systemMonitorID))
+	// First, GET the system monitor to get the complete object
+	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", p.Hostname, p.Port, idToString(systemMonitorID))
+	log.Printf("DEBUG: GET URL for agent %s: %s", idToString(systemMonitorID), getURL)
+
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		log.Printf("Error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error getting system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error getting system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+		return fmt.Errorf("failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+	}
+
+	// Parse the response
+	var systemMonitor map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&systemMonitor); err != nil {
+		log.Printf("Error decoding system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error decoding system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	// Protected agents are refused whatever the caller decided
+	if err := checkProtected(p, agentTarget(systemMonitor, systemMonitorID)); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
+
+	// Modify the system monitor object to unlicense it
+	// Set recordStatusName to "Unlicensed" (this is the LogRhythm way to unlicense)
+	log.Printf("DEBUG: Setting recordStatusName to 'Unlicensed' for agent %s", idToString(systemMonitorID))
+	systemMonitor["recordStatusName"] = "Unlicensed"
+
+	// PUT the updated system monitor back
+	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", p.Hostname, p.Port, idToString(systemMonitorID))
+	log.Printf("DEBUG: PUT URL for agent %s: %s", idToString(systemMonitorID), putURL)
+
+	jsonData, err := json.Marshal(systemMonitor)
+	if err != nil {
+		log.Printf("Error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	log.Printf("DEBUG: PUT payload for agent %s: %s", idToString(systemMonitorID), string(jsonData))
+
+	req, err = http.NewRequest("PUT", putURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("Error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error unlicensing system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error unlicensing system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("Successfully unlicensed system monitor %s", idToString(systemMonitorID))
+		log.Printf("DEBUG: Agent %s unlicensing completed successfully", idToString(systemMonitorID))
+		return nil
+	} else {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("Failed to unlicense system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+		log.Printf("DEBUG: Agent %s unlicensing failed with response: %s", idToString(systemMonitorID), string(bodyBytes))
+		return fmt.Errorf("failed to unlicense system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+	}
+}
+
+func retireSystemMonitor(p *Profile, systemMonitorID interface{}) error {
+	log.Printf("DEBUG: Starting retireSystemMonitor for agent %s", idToString(systemMonitorID))
+	// First, GET the system monitor to get the complete object
+	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", p.Hostname, p.Port, idToString(systemMonitorID))
+	log.Printf("DEBUG: GET URL for agent %s: %s", idToString(systemMonitorID), getURL)
+
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		log.Printf("Error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error getting system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error getting system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+		return fmt.Errorf("failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+	}
+
+	// Parse the response
+	var systemMonitor map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&systemMonitor); err != nil {
+		log.Printf("Error decoding system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error decoding system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	// Protected agents are refused whatever the caller decided
+	if err := checkProtected(p, agentTarget(systemMonitor, systemMonitorID)); err != nil {
+		log.Printf("%v", err)
+		return err
+	}
+
+	// Check if system monitor is already retired
+	if recordStatusName, ok := systemMonitor["recordStatusName"].(string); ok && recordStatusName == "Retired" {
+		log.Printf("System monitor %s is already retired, skipping retirement", idToString(systemMonitorID))
+		return nil // Success - already retired
+	}
+
+	// Modify the system monitor object to retire it
+	// Set recordStatusName to "Retired" and licenseType to "None"
+	log.Printf("DEBUG: Setting recordStatusName to 'Retired' and licenseType to 'None' for agent %s", idToString(systemMonitorID))
+	systemMonitor["recordStatusName"] = "Retired"
+	systemMonitor["licenseType"] = "None"
+
+	// PUT the updated system monitor back
+	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", p.Hostname, p.Port, idToString(systemMonitorID))
+	log.Printf("DEBUG: PUT URL for agent %s: %s", idToString(systemMonitorID), putURL)
+
+	jsonData, err := json.Marshal(systemMonitor)
+	if err != nil {
+		log.Printf("Error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	log.Printf("DEBUG: PUT payload for agent %s: %s", idToString(systemMonitorID), string(jsonData))
+
+	req, err = http.NewRequest("PUT", putURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("Error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err = httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error updating system monitor %s: %v", idToString(systemMonitorID), err)
+		return fmt.Errorf("error updating system monitor %s: %v", idToString(systemMonitorID), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		log.Printf("Successfully retired system monitor %s", idToString(systemMonitorID))
+		log.Printf("DEBUG: Agent %s retirement completed successfully", idToString(systemMonitorID))
+		return nil
+	} else {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("Failed to update system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+		log.Printf("DEBUG: Agent %s retirement failed with response: %s", idToString(systemMonitorID), string(bodyBytes))
+		return fmt.Errorf("failed to update system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
+	}
+}
+
+func removeHostIdentifiers(p *Profile, hostID interface{}) []HostIdentifier {
+	// First, GET the host to get all identifiers
+	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", p.Hostname, p.Port, idToString(hostID))
+
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		log.Printf("Error creating GET request for host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Error getting host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to get host %s, status: %d", idToString(hostID), resp.StatusCode)
+		return []HostIdentifier{}
+	}
+
+	// Parse the response to get identifiers
+	var host map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&host); err != nil {
+		log.Printf("Error decoding host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
+	}
+
+	// Check if host has identifiers (note: it's "hostIdentifiers" not "identifiers")
+	hostIdentifiers, hasIdentifiers := host["hostIdentifiers"]
+	if !hasIdentifiers {
+		log.Printf("Host %s has no identifiers to remove", idToString(hostID))
+		return []HostIdentifier{} // Success - no identifiers to remove
+	}
+
+	identifiersArray, ok := hostIdentifiers.([]interface{})
+	if !ok || len(identifiersArray) == 0 {
+		log.Printf("Host %s has no identifiers to remove", idToString(hostID))
+		return []HostIdentifier{} // Success - no identifiers to remove
+	}
+
+	// Find IPAddress identifiers to remove (skip those already retired)
+	var ipAddressIdentifiers []map[string]interface{}
+	for _, identifier := range identifiersArray {
+		identifierMap, ok := identifier.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		identifierType, hasType := identifierMap["type"]
+		if !hasType {
+			continue
+		}
+
+		// Only remove IPAddress identifiers
+		if identifierType == "IPAddress" {
+			// Skip IP addresses that already have a dateRetired (already retired)
+			if dateRetired, hasDateRetired := identifierMap["dateRetired"]; hasDateRetired && dateRetired != nil {
+				log.Printf("Skipping IP address %v (already retired on %v)", identifierMap["value"], dateRetired)
+				continue
+			}
+			ipAddressIdentifiers = append(ipAddressIdentifiers, identifierMap)
+		}
+	}
+
+	if len(ipAddressIdentifiers) == 0 {
+		log.Printf("Host %s has no IPAddress identifiers to remove", idToString(hostID))
+		return []HostIdentifier{} // Success - no IPAddress identifiers to remove
+	}
+
+	log.Printf("Host %s has %d IPAddress identifiers to remove", idToString(hostID), len(ipAddressIdentifiers))
 
-		// Exclude LogRhythm system monitor agents
-		if strings.HasPrefix(sourceType, "LogRhythm") {
-			excluded = true
+	// Create payload for removing IPAddress identifiers with their actual IP address values
+	var hostIdentifiersPayload []map[string]interface{}
+	var removedIdentifiers []HostIdentifier
+	for _, identifier := range ipAddressIdentifiers {
+		ipAddress, hasValue := identifier["value"]
+		if hasValue {
+			hostIdentifiersPayload = append(hostIdentifiersPayload, map[string]interface{}{
+				"type":  "IPAddress",
+				"value": ipAddress, // Use the actual IP address value, not the hostIdentifierId
+			})
+			// Track the identifiers that will be removed for rollback
+			removedIdentifiers = append(removedIdentifiers, HostIdentifier{
+				Type:  "IPAddress",
+				Value: ipAddress.(string),
+			})
 		}
+	}
 
-		// Exclude echo hosts and log sources
-		if containsIgnoreCase(hostName, "echo") || containsIgnoreCase(sourceName, "echo") {
-			excluded = true
-		}
+	// Check if we have any identifiers to remove
+	if len(hostIdentifiersPayload) == 0 {
+		log.Printf("Host %s has no IPAddress identifiers to remove (empty payload)", idToString(hostID))
+		return []HostIdentifier{} // Success - no identifiers to remove
+	}
 
-		// Check config excluded sources
-		if !excluded {
-			for _, pattern := range config.ExcludedLogSources {
-				if containsIgnoreCase(sourceType, pattern) {
-					excluded = true
-					break
-				}
-			}
-		}
+	payload := map[string]interface{}{
+		"hostIdentifiers": hostIdentifiersPayload,
+	}
 
-		if !excluded {
-			filteredLogSources = append(filteredLogSources, ls)
-		}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling identifier removal payload for host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
 	}
 
-	hasActiveLogSources := len(filteredLogSources) > 0
-	log.Printf("System monitor agent %s has %d total log sources, %d filterable log sources (after excluding LogRhythm/echo)",
-		idToString(agentID), len(allLogSources), len(filteredLogSources))
-	return hasActiveLogSources
-}
+	// DELETE the IPAddress identifiers
+	deleteURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s/identifiers",
+		p.Hostname, p.Port, idToString(hostID))
 
-func checkHostHasActiveLogSources(hostID interface{}) bool {
-	// Check if the host has any remaining active log sources (excluding LogRhythm and echo sources)
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources?hostId=%s&recordStatus=active", config.Hostname, config.Port, idToString(hostID))
+	log.Printf("DELETE URL: %s", deleteURL)
+	log.Printf("DELETE Payload: %s", string(jsonData))
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err = http.NewRequest("DELETE", deleteURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("Error creating request to check host %s log sources: %v", idToString(hostID), err)
-		return true // Assume it has log sources if we can't check
+		log.Printf("Error creating DELETE request for host identifiers %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err = httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error checking log sources for host %s: %v", idToString(hostID), err)
-		return true // Assume it has log sources if we can't check
+		log.Printf("Error removing IPAddress identifiers from host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to check log sources for host %s, status: %d", idToString(hostID), resp.StatusCode)
-		return true // Assume it has log sources if we can't check
+	// Read response body for debugging
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	log.Printf("DELETE Response Status: %d", resp.StatusCode)
+	log.Printf("DELETE Response Body: %s", string(bodyBytes))
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		log.Printf("Successfully removed %d IPAddress identifiers from host %s", len(removedIdentifiers), idToString(hostID))
+		return removedIdentifiers
+	} else {
+		log.Printf("Failed to remove IPAddress identifiers from host %s, status: %d", idToString(hostID), resp.StatusCode)
+		return []HostIdentifier{} // Return empty list on failure
 	}
+}
 
-	// Parse the response - try array format first
-	var allLogSources []LogSource
-	if err := json.NewDecoder(resp.Body).Decode(&allLogSources); err != nil {
-		// Try object format
-		var responseObj map[string]interface{}
-		resp.Body.Close()
+// Retirement Naming
 
-		req, err = http.NewRequest("GET", url, nil)
-		if err != nil {
-			return true
-		}
-		req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-		req.Header.Set("Content-Type", "application/json")
+const (
+	defaultRetirementNameTemplate = "{name} Retired by LRCleaner"
+	defaultMaxRetiredNameLength   = 100
+	legacyRetiredMarker           = "Retired by LRCleaner"
+	sampleOperatorLength          = 64 // Length of the operator email a template is checked with
+)
 
-		resp, err = httpClient.Do(req)
-		if err != nil {
-			return true
-		}
-		defer resp.Body.Close()
+var (
+	retirementPlaceholders = map[string]bool{
+		"{name}":     true,
+		"{date}":     true,
+		"{operator}": true,
+		"{ticket}":   true,
+		"{jobId}":    true,
+	}
+	placeholderPattern = regexp.MustCompile(`\{[A-Za-z]+\}`)
+	whitespaceRun      = regexp.MustCompile(`\s{2,}`)
+)
 
-		if err := json.NewDecoder(resp.Body).Decode(&responseObj); err != nil {
-			return true
+// validateRetirementConfig checks that a name template can produce a usable name
+func validateRetirementConfig(rc RetirementConfig) error {
+	if strings.Count(rc.NameTemplate, "{name}") != 1 {
+		return fmt.Errorf("name template must contain {name} exactly once")
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(rc.NameTemplate, -1) {
+		if !retirementPlaceholders[placeholder] {
+			return fmt.Errorf("unknown placeholder %s in name template", placeholder)
 		}
+	}
+	if rc.MaxNameLength <= 0 {
+		return fmt.Errorf("maximum name length must be greater than zero")
+	}
 
-		if items, ok := responseObj["items"].([]interface{}); ok {
-			// Convert items to LogSource structs
-			for _, item := range items {
-				if itemMap, ok := item.(map[string]interface{}); ok {
-					var ls LogSource
-					if lsBytes, err := json.Marshal(itemMap); err == nil {
-						if err := json.Unmarshal(lsBytes, &ls); err == nil {
-							allLogSources = append(allLogSources, ls)
-						}
-					}
-				}
-			}
-		}
+	// Render with the longest allowed ticket and a long operator email address to make sure
+	// the original name still fits; anything longer is refused per object at run time
+	sample := RetirementNaming{
+		Date:     time.Now(),
+		Operator: strings.Repeat("o", sampleOperatorLength-len("@example.com")) + "@example.com",
+		Ticket:   strings.Repeat("T", maxChangeTicketLength),
+		JobID:    fmt.Sprintf("execute_%d", time.Now().Unix()),
+	}
+	prefix, suffix := expandRetirementTemplate(rc.NameTemplate, sample)
+	if utf8.RuneCountInString(prefix+suffix) >= rc.MaxNameLength {
+		return fmt.Errorf("name template leaves no room for the original name within %d characters", rc.MaxNameLength)
 	}
+	return nil
+}
 
-	// Apply the same filtering logic as in analyzeHostsForRetirement
-	var filteredLogSources []LogSource
-	for _, ls := range allLogSources {
-		// Check if already retired
-		if ls.RecordStatus == "Retired" {
-			continue
-		}
+// expandRetirementTemplate fills in every placeholder except {name} and returns the text
+// that goes before and after the original name
+func expandRetirementTemplate(template string, naming RetirementNaming) (string, string) {
+	replacer := strings.NewReplacer(
+		"{date}", naming.Date.Format("2006-01-02"),
+		"{operator}", naming.Operator,
+		"{ticket}", naming.Ticket,
+		"{jobId}", naming.JobID,
+	)
+
+	parts := strings.SplitN(template, "{name}", 2)
+	if len(parts) != 2 {
+		parts = []string{"", " " + template}
+	}
 
-		// Check excluded sources
-		excluded := false
-		sourceType := ls.LogSourceType.Name
-		sourceName := ls.Name
-		hostName := ls.Host.Name
+	prefix := strings.TrimLeft(tidyRetirementText(replacer.Replace(parts[0])), " ")
+	suffix := strings.TrimRight(tidyRetirementText(replacer.Replace(parts[1])), " ")
+	return prefix, suffix
+}
 
-		// Exclude LogRhythm system monitor agents
-		if strings.HasPrefix(sourceType, "LogRhythm") {
-			excluded = true
-		}
+// tidyRetirementText removes the gaps left behind by empty placeholders, so that
+// "[RETIRED {date} {ticket}]" without a ticket becomes "[RETIRED 2025-01-01]"
+func tidyRetirementText(text string) string {
+	text = whitespaceRun.ReplaceAllString(text, " ")
+	text = strings.NewReplacer(" ]", "]", " )", ")", "[ ", "[", "( ", "(").Replace(text)
+	text = strings.NewReplacer("[]", "", "()", "").Replace(text)
+	return whitespaceRun.ReplaceAllString(text, " ")
+}
 
-		// Exclude echo hosts and log sources
-		if containsIgnoreCase(hostName, "echo") || containsIgnoreCase(sourceName, "echo") {
-			excluded = true
-		}
+// renderRetiredName applies the configured name template to an object's current name,
+// truncating the original name if needed so the result stays within the length limit.
+// When the template text alone leaves no room for the name the object is refused.
+func renderRetiredName(original string, naming RetirementNaming) (string, error) {
+	configMutex.RLock()
+	template := config.Retirement.NameTemplate
+	maxLength := config.Retirement.MaxNameLength
+	configMutex.RUnlock()
+	if template == "" {
+		template = defaultRetirementNameTemplate
+	}
+	if maxLength <= 0 {
+		maxLength = defaultMaxRetiredNameLength
+	}
 
-		// Check config excluded sources
-		if !excluded {
-			for _, pattern := range config.ExcludedLogSources {
-				if containsIgnoreCase(sourceType, pattern) {
-					excluded = true
-					break
-				}
-			}
-		}
+	prefix, suffix := expandRetirementTemplate(template, naming)
 
-		if !excluded {
-			filteredLogSources = append(filteredLogSources, ls)
-		}
+	name := []rune(original)
+	available := maxLength - utf8.RuneCountInString(prefix) - utf8.RuneCountInString(suffix)
+	if available < 1 {
+		return "", fmt.Errorf("retirement name template leaves no room for %q within %d characters; shorten the template", original, maxLength)
+	}
+	if len(name) > available {
+		log.Printf("Truncating name %q to %d characters to fit the retirement template", original, available)
+		name = []rune(strings.TrimRight(string(name[:available]), " "))
 	}
 
-	hasActiveLogSources := len(filteredLogSources) > 0
-	log.Printf("Host %s has %d total log sources, %d filterable log sources (after excluding LogRhythm/echo)",
-		idToString(hostID), len(allLogSources), len(filteredLogSources))
-	return hasActiveLogSources
+	return prefix + string(name) + suffix, nil
 }
 
-func unlicenseSystemMonitor(systemMonitorID interface{}) bool {
-	log.Printf("DEBUG: Starting unlicenseSystemMonitor for agent %s", idToString(systemMonitorID))
-	// First, GET the system monitor to get the complete object
-	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", config.Hostname, config.Port, idToString(systemMonitorID))
-	log.Printf("DEBUG: GET URL for agent %s: %s", idToString(systemMonitorID), getURL)
+// retirementDescription builds the short description written to retired log sources and hosts
+func retirementDescription(naming RetirementNaming) string {
+	description := fmt.Sprintf("Retired by LRCleaner on %s (ticket %s): %s",
+		naming.Date.Format("2006-01-02"), naming.Ticket, naming.Justification)
+	if runes := []rune(description); len(runes) > maxShortDescriptionLength {
+		description = string(runes[:maxShortDescriptionLength])
+	}
+	return description
+}
 
-	req, err := http.NewRequest("GET", getURL, nil)
+// hasRetiredName reports whether a name already carries a retirement marker, either the
+// legacy suffix or all of the literal text from the configured template
+func hasRetiredName(name string) bool {
+	if strings.Contains(name, legacyRetiredMarker) {
+		return true
+	}
+
+	template := config.Retirement.NameTemplate
+	if template == "" {
+		template = defaultRetirementNameTemplate
+	}
+
+	matched := false
+	for _, fragment := range placeholderPattern.Split(template, -1) {
+		fragment = strings.TrimSpace(fragment)
+		if len(fragment) < 3 {
+			continue // Too short to identify a retired name reliably
+		}
+		if !strings.Contains(name, fragment) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func updateHost(p *Profile, hostID interface{}, naming RetirementNaming) ([]HostIdentifier, NameChange, error) {
+	// Use the correct LogRhythm API for retiring hosts
+	// Based on the example, we need to use the specific host endpoint
+	hostURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", p.Hostname, p.Port, idToString(hostID))
+
+	// GET the host first to get the complete object
+	getReq, err := http.NewRequest("GET", hostURL, nil)
 	if err != nil {
-		log.Printf("Error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error creating GET request for host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error creating GET request for host %s: %v", idToString(hostID), err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
+	getReq.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
+	getReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	getResp, err := httpClient.Do(getReq)
 	if err != nil {
-		log.Printf("Error getting system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error getting host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error getting host %s: %v", idToString(hostID), err)
 	}
-	defer resp.Body.Close()
+	defer getResp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
-		return false
+	if getResp.StatusCode != http.StatusOK {
+		log.Printf("Failed to get host %s, status: %d", idToString(hostID), getResp.StatusCode)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("failed to get host %s, status: %d", idToString(hostID), getResp.StatusCode)
 	}
 
 	// Parse the response
-	var systemMonitor map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&systemMonitor); err != nil {
-		log.Printf("Error decoding system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+	var host map[string]interface{}
+	if err := json.NewDecoder(getResp.Body).Decode(&host); err != nil {
+		log.Printf("Error decoding host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error decoding host %s: %v", idToString(hostID), err)
 	}
 
-	// Modify the system monitor object to unlicense it
-	// Set recordStatusName to "Unlicensed" (this is the LogRhythm way to unlicense)
-	log.Printf("DEBUG: Setting recordStatusName to 'Unlicensed' for agent %s", idToString(systemMonitorID))
-	systemMonitor["recordStatusName"] = "Unlicensed"
+	// Protected hosts are refused before anything about them changes, identifiers included
+	if err := checkProtected(p, protectedTarget{Kind: ProtectHost, Self: selfRef(host, hostID)}); err != nil {
+		log.Printf("%v", err)
+		return []HostIdentifier{}, NameChange{}, err
+	}
 
-	// PUT the updated system monitor back
-	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", config.Hostname, config.Port, idToString(systemMonitorID))
-	log.Printf("DEBUG: PUT URL for agent %s: %s", idToString(systemMonitorID), putURL)
+	// Work out the retired name first, so a name the template leaves no room for refuses
+	// the host before its identifiers are removed
+	var nameChange NameChange
+	if name, ok := host["name"].(string); ok {
+		nameChange = NameChange{Original: name, Retired: name}
+		// Check if already retired to prevent adding the retirement marker twice
+		if !hasRetiredName(name) {
+			retired, err := renderRetiredName(name, naming)
+			if err != nil {
+				log.Printf("Refusing to retire host %s: %v", idToString(hostID), err)
+				return []HostIdentifier{}, NameChange{}, err
+			}
+			nameChange.Retired = retired
+		}
+	}
 
-	jsonData, err := json.Marshal(systemMonitor)
+	// Remove the IP address identifiers from the host
+	removedIdentifiers := removeHostIdentifiers(p, hostID)
+	if len(removedIdentifiers) == 0 {
+		log.Printf("No identifiers were removed from host %s", idToString(hostID))
+	}
+
+	// Check if host is already retired
+	if recordStatusName, ok := host["recordStatusName"].(string); ok && recordStatusName == "Retired" {
+		log.Printf("Host %s is already retired, skipping retirement", idToString(hostID))
+		return []HostIdentifier{}, NameChange{}, nil // Success - already retired
+	}
+
+	// Update the recordStatusName to "Retired"
+	host["recordStatusName"] = "Retired"
+
+	// Also update the name to indicate retirement
+	if _, ok := host["name"].(string); ok {
+		host["name"] = nameChange.Retired
+	}
+
+	// Record why the host was retired
+	originalDescription, _ := host["shortDesc"].(string)
+	nameChange.OriginalDescription = &originalDescription
+	host["shortDesc"] = retirementDescription(naming)
+
+	// Remove fields that are not allowed in PUT request
+	delete(host, "hostRoles")
+	delete(host, "hostIdentifiers")
+
+	// PUT the updated host back
+	jsonData, err := json.Marshal(host)
 	if err != nil {
-		log.Printf("Error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error marshaling updated host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error marshaling updated host %s: %v", idToString(hostID), err)
 	}
-	log.Printf("DEBUG: PUT payload for agent %s: %s", idToString(systemMonitorID), string(jsonData))
 
-	req, err = http.NewRequest("PUT", putURL, bytes.NewBuffer(jsonData))
+	log.Printf("PUT Request Data for host %s: %s", idToString(hostID), string(jsonData))
+
+	req, err := http.NewRequest("PUT", hostURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("Error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error creating PUT request for host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error creating PUT request for host %s: %v", idToString(hostID), err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err = httpClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error unlicensing system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error updating host %s: %v", idToString(hostID), err)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("error updating host %s: %v", idToString(hostID), err)
 	}
 	defer resp.Body.Close()
 
+	// Read response body for debugging
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	log.Printf("PUT Response Status: %d", resp.StatusCode)
+	log.Printf("PUT Response Body: %s", string(bodyBytes))
+
 	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully unlicensed system monitor %s", idToString(systemMonitorID))
-		log.Printf("DEBUG: Agent %s unlicensing completed successfully", idToString(systemMonitorID))
-		return true
+		log.Printf("Successfully retired host %s", idToString(hostID))
+		return removedIdentifiers, nameChange, nil
 	} else {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("Failed to unlicense system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
-		log.Printf("DEBUG: Agent %s unlicensing failed with response: %s", idToString(systemMonitorID), string(bodyBytes))
-		return false
+		log.Printf("Failed to update host %s, status: %d", idToString(hostID), resp.StatusCode)
+		return []HostIdentifier{}, NameChange{}, fmt.Errorf("failed to update host %s, status: %d", idToString(hostID), resp.StatusCode)
 	}
 }
 
-func retireSystemMonitor(systemMonitorID interface{}) bool {
-	log.Printf("DEBUG: Starting retireSystemMonitor for agent %s", idToString(systemMonitorID))
-	// First, GET the system monitor to get the complete object
-	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", config.Hostname, config.Port, idToString(systemMonitorID))
-	log.Printf("DEBUG: GET URL for agent %s: %s", idToString(systemMonitorID), getURL)
+func updateLogSource(p *Profile, logSourceID interface{}, naming RetirementNaming) (NameChange, error) {
+	// First, GET the log source to get the complete object
+	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", p.Hostname, p.Port, idToString(logSourceID))
 
 	req, err := http.NewRequest("GET", getURL, nil)
 	if err != nil {
-		log.Printf("Error creating GET request for system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error creating GET request for log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error creating GET request for log source %s: %v", idToString(logSourceID), err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error getting system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error getting log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error getting log source %s: %v", idToString(logSourceID), err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
-		return false
+		log.Printf("Failed to get log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
+		return NameChange{}, fmt.Errorf("failed to get log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
 	}
 
 	// Parse the response
-	var systemMonitor map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&systemMonitor); err != nil {
-		log.Printf("Error decoding system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+	var logSource map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&logSource); err != nil {
+		log.Printf("Error decoding log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error decoding log source %s: %v", idToString(logSourceID), err)
 	}
 
-	// Check if system monitor is already retired
-	if recordStatusName, ok := systemMonitor["recordStatusName"].(string); ok && recordStatusName == "Retired" {
-		log.Printf("System monitor %s is already retired, skipping retirement", idToString(systemMonitorID))
-		return true // Success - already retired
+	// Protected log sources, and log sources of protected hosts and agents, are refused
+	if err := checkProtected(p, logSourceTarget(logSource, logSourceID)); err != nil {
+		log.Printf("%v", err)
+		return NameChange{}, err
 	}
 
-	// Modify the system monitor object to retire it
-	// Set recordStatusName to "Retired" and licenseType to "None"
-	log.Printf("DEBUG: Setting recordStatusName to 'Retired' and licenseType to 'None' for agent %s", idToString(systemMonitorID))
-	systemMonitor["recordStatusName"] = "Retired"
-	systemMonitor["licenseType"] = "None"
+	// Modify the log source object
+	var nameChange NameChange
+	if name, ok := logSource["name"].(string); ok {
+		nameChange = NameChange{Original: name, Retired: name}
+		// Check if already retired to prevent adding the retirement marker twice
+		if !hasRetiredName(name) {
+			retired, err := renderRetiredName(name, naming)
+			if err != nil {
+				log.Printf("Refusing to retire log source %s: %v", idToString(logSourceID), err)
+				return NameChange{}, err
+			}
+			nameChange.Retired = retired
+			logSource["name"] = nameChange.Retired
+		}
+	}
 
-	// PUT the updated system monitor back
-	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", config.Hostname, config.Port, idToString(systemMonitorID))
-	log.Printf("DEBUG: PUT URL for agent %s: %s", idToString(systemMonitorID), putURL)
+	// Record why the log source was retired
+	originalDescription, _ := logSource["shortDescription"].(string)
+	nameChange.OriginalDescription = &originalDescription
+	logSource["shortDescription"] = retirementDescription(naming)
 
-	jsonData, err := json.Marshal(systemMonitor)
+	// Only set status to Retired if not already retired
+	if recordStatus, ok := logSource["recordStatus"].(string); ok {
+		if recordStatus != "Retired" {
+			logSource["recordStatus"] = "Retired"
+		}
+	} else {
+		logSource["recordStatus"] = "Retired"
+	}
+
+	// PUT the updated log source back
+	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", p.Hostname, p.Port, idToString(logSourceID))
+
+	jsonData, err := json.Marshal(logSource)
 	if err != nil {
-		log.Printf("Error marshaling updated system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error marshaling updated log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error marshaling updated log source %s: %v", idToString(logSourceID), err)
 	}
-	log.Printf("DEBUG: PUT payload for agent %s: %s", idToString(systemMonitorID), string(jsonData))
 
 	req, err = http.NewRequest("PUT", putURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Printf("Error creating PUT request for system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error creating PUT request for log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error creating PUT request for log source %s: %v", idToString(logSourceID), err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err = httpClient.Do(req)
 	if err != nil {
-		log.Printf("Error updating system monitor %s: %v", idToString(systemMonitorID), err)
-		return false
+		log.Printf("Error updating log source %s: %v", idToString(logSourceID), err)
+		return NameChange{}, fmt.Errorf("error updating log source %s: %v", idToString(logSourceID), err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully retired system monitor %s", idToString(systemMonitorID))
-		log.Printf("DEBUG: Agent %s retirement completed successfully", idToString(systemMonitorID))
-		return true
+		log.Printf("Successfully retired log source %s", idToString(logSourceID))
+		return nameChange, nil
 	} else {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		log.Printf("Failed to update system monitor %s, status: %d", idToString(systemMonitorID), resp.StatusCode)
-		log.Printf("DEBUG: Agent %s retirement failed with response: %s", idToString(systemMonitorID), string(bodyBytes))
-		return false
+		log.Printf("Failed to update log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
+		return NameChange{}, fmt.Errorf("failed to update log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
 	}
 }
 
-func removeHostIdentifiers(hostID interface{}) []HostIdentifier {
-	// First, GET the host to get all identifiers
-	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", config.Hostname, config.Port, idToString(hostID))
+// HTML reports - one file per job rendered from a report template. The built-in template is an
+// offline page with summary charts, sortable tables and the full job embedded as JSON, for
+// attaching to tickets and opening on air-gapped machines.
 
-	req, err := http.NewRequest("GET", getURL, nil)
-	if err != nil {
-		log.Printf("Error creating GET request for host %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
-	}
+var htmlReportTemplate = template.Must(template.New("report.html").
+	Funcs(reportTemplateFuncs("", true)).ParseFS(webFiles, "web/report.html"))
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
+// reportFact is a labelled value in a report's details or summary
+type reportFact struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error getting host %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
-	}
-	defer resp.Body.Close()
+// reportChart is a bar chart of counts, largest first
+type reportChart struct {
+	Title  string   `json:"title"`
+	Labels []string `json:"labels"`
+	Values []int    `json:"values"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get host %s, status: %d", idToString(hostID), resp.StatusCode)
-		return []HostIdentifier{}
-	}
+// reportTable is an exportSheet as the HTML report's script reads it
+type reportTable struct {
+	Key     string          `json:"key"`
+	Title   string          `json:"title"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
 
-	// Parse the response to get identifiers
-	var host map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&host); err != nil {
-		log.Printf("Error decoding host %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
-	}
+// reportData is what every report template is executed with; the README documents it for
+// template authors. Job is the complete job as the API returns it.
+type reportData struct {
+	Title     string        `json:"title"`
+	Kind      string        `json:"kind"` // analysis or retirement
+	Generated time.Time     `json:"generated"`
+	Details   []reportFact  `json:"details"`
+	Summary   []reportFact  `json:"summary"`
+	Charts    []reportChart `json:"charts"`
+	Tables    []reportTable `json:"tables"`
+	Job       *JobStatus    `json:"job"`
+	Rollback  *RollbackData `json:"rollback,omitempty"` // Rollback point a retirement recorded
+}
 
-	// Check if host has identifiers (note: it's "hostIdentifiers" not "identifiers")
-	hostIdentifiers, hasIdentifiers := host["hostIdentifiers"]
-	if !hasIdentifiers {
-		log.Printf("Host %s has no identifiers to remove", idToString(hostID))
-		return []HostIdentifier{} // Success - no identifiers to remove
+// buildReportData gathers the details, figures, charts and tables of an analysis or retirement job
+func buildReportData(job *JobStatus, generated time.Time) reportData {
+	deployments := job.Profile
+	if len(job.Profiles) > 0 {
+		deployments = strings.Join(job.Profiles, ", ")
+	}
+	completed := "Not finished"
+	if job.EndTime != nil {
+		completed = job.EndTime.Format("2006-01-02 15:04:05")
+	}
+	retirement := job.ChangeTicket != "" || len(job.RetirementRecords) > 0
+
+	report := reportData{
+		Title:     fmt.Sprintf("LRCleaner Analysis Report %s", job.ID),
+		Kind:      "analysis",
+		Generated: generated,
+		Job:       job,
+		Details: []reportFact{
+			{"Job ID", job.ID},
+			{"Deployment", deployments},
+			{"Status", job.Status},
+			{"Started By", job.StartedBy},
+			{"Started", job.StartTime.Format("2006-01-02 15:04:05")},
+			{"Completed", completed},
+		},
 	}
-
-	identifiersArray, ok := hostIdentifiers.([]interface{})
-	if !ok || len(identifiersArray) == 0 {
-		log.Printf("Host %s has no identifiers to remove", idToString(hostID))
-		return []HostIdentifier{} // Success - no identifiers to remove
+	if job.DataSource != "" {
+		report.Details = append(report.Details, reportFact{"Data Source", job.DataSource})
 	}
 
-	// Find IPAddress identifiers to remove (skip those already retired)
-	var ipAddressIdentifiers []map[string]interface{}
-	for _, identifier := range identifiersArray {
-		identifierMap, ok := identifier.(map[string]interface{})
-		if !ok {
+	sheets := buildExportSheets(job, exportFilter{})
+	for _, sheet := range sheets {
+		if len(sheet.Rows) == 0 {
 			continue
 		}
-
-		identifierType, hasType := identifierMap["type"]
-		if !hasType {
-			continue
+		table := reportTable{Key: sheet.Key, Title: sheet.Title, Rows: sheet.Rows}
+		for _, column := range sheet.Columns {
+			table.Columns = append(table.Columns, column.Header)
 		}
+		report.Tables = append(report.Tables, table)
+	}
 
-		// Only remove IPAddress identifiers
-		if identifierType == "IPAddress" {
-			// Skip IP addresses that already have a dateRetired (already retired)
-			if dateRetired, hasDateRetired := identifierMap["dateRetired"]; hasDateRetired && dateRetired != nil {
-				log.Printf("Skipping IP address %v (already retired on %v)", identifierMap["value"], dateRetired)
-				continue
+	if retirement {
+		report.Title = fmt.Sprintf("LRCleaner Retirement Report %s", job.ID)
+		report.Kind = "retirement"
+		rollbackID := job.RollbackID
+		if rollbackID == "" {
+			rollbackID = "None"
+		}
+		if job.RollbackID != "" {
+			rollbackMutex.RLock()
+			report.Rollback = rollbackHistory[job.RollbackID]
+			rollbackMutex.RUnlock()
+		}
+		report.Details = append(report.Details,
+			reportFact{"Change Ticket", job.ChangeTicket},
+			reportFact{"Justification", job.Justification},
+			reportFact{"Backup", job.Backup.describe()},
+			reportFact{"Rollback Point", rollbackID},
+		)
+		report.Summary = []reportFact{
+			{"Log sources retired", strconv.Itoa(len(job.RetirementRecords))},
+			{"Hosts retired", strconv.Itoa(len(job.RetiredHosts))},
+			{"Agents retired", strconv.Itoa(len(job.RetiredAgents))},
+			{"Refused or failed", strconv.Itoa(len(job.Failures))},
+		}
+		report.Charts = append(report.Charts, reportChart{
+			Title:  "Objects retired",
+			Labels: []string{"Log sources", "Hosts", "System Monitor agents"},
+			Values: []int{len(job.RetirementRecords), len(job.RetiredHosts), len(job.RetiredAgents)},
+		})
+		report.Charts = append(report.Charts, countChart("Log sources retired per host", sheets[3], "hostName"))
+	} else {
+		logSources, hosts := sheets[0], sheets[1]
+		unreachable := 0
+		if i := sheetColumn(hosts, "pingResult"); i >= 0 {
+			for _, row := range hosts.Rows {
+				if row[i] == "Failure" {
+					unreachable++
+				}
 			}
-			ipAddressIdentifiers = append(ipAddressIdentifiers, identifierMap)
+		}
+		report.Summary = []reportFact{
+			{"Stale log sources", strconv.Itoa(len(logSources.Rows))},
+			{"Hosts", strconv.Itoa(len(hosts.Rows))},
+			{"Unreachable hosts", strconv.Itoa(unreachable)},
+			{"Log sources to troubleshoot", strconv.Itoa(len(sheets[4].Rows))},
+		}
+		if len(job.CollectionHostAnalysis) > 0 {
+			report.Summary = append(report.Summary, reportFact{"Collection hosts", strconv.Itoa(len(job.CollectionHostAnalysis))})
+		}
+		report.Charts = append(report.Charts,
+			countChart("Hosts by ping result", hosts, "pingResult"),
+			countChart("Log sources by status", logSources, "status"),
+			countChart("Stale log sources by type", logSources, "logSourceType"))
+		if len(job.Deployments) > 0 {
+			report.Charts = append(report.Charts, countChart("Stale log sources by deployment", logSources, "deployment"))
 		}
 	}
 
-	if len(ipAddressIdentifiers) == 0 {
-		log.Printf("Host %s has no IPAddress identifiers to remove", idToString(hostID))
-		return []HostIdentifier{} // Success - no IPAddress identifiers to remove
+	if len(job.Failures) > 0 {
+		failures := reportTable{Key: "failures", Title: "Refused and Failed Changes",
+			Columns: []string{"Outcome", "Kind", "ID", "Name", "Host", "Error", "Timestamp"}}
+		for _, failure := range job.Failures {
+			outcome := "Failed"
+			if failure.Protected {
+				outcome = "Refused (protected)"
+			}
+			failures.Rows = append(failures.Rows, []interface{}{outcome, failure.Kind, failure.ID, failure.Name,
+				failure.HostName, failure.Error, failure.Timestamp.Format(time.RFC3339)})
+		}
+		report.Tables = append(report.Tables, failures)
 	}
+	return report
+}
 
-	log.Printf("Host %s has %d IPAddress identifiers to remove", idToString(hostID), len(ipAddressIdentifiers))
+// sheetColumn is the index of a column in a sheet, or -1
+func sheetColumn(sheet exportSheet, key string) int {
+	for i, column := range sheet.Columns {
+		if column.Key == key {
+			return i
+		}
+	}
+	return -1
+}
 
-	// Create payload for removing IPAddress identifiers with their actual IP address values
-	var hostIdentifiersPayload []map[string]interface{}
-	var removedIdentifiers []HostIdentifier
-	for _, identifier := range ipAddressIdentifiers {
-		ipAddress, hasValue := identifier["value"]
-		if hasValue {
-			hostIdentifiersPayload = append(hostIdentifiersPayload, map[string]interface{}{
-				"type":  "IPAddress",
-				"value": ipAddress, // Use the actual IP address value, not the hostIdentifierId
-			})
-			// Track the identifiers that will be removed for rollback
-			removedIdentifiers = append(removedIdentifiers, HostIdentifier{
-				Type:  "IPAddress",
-				Value: ipAddress.(string),
-			})
+// countChart charts how many rows share each value of a column, showing the most common values
+func countChart(title string, sheet exportSheet, key string) reportChart {
+	chart := reportChart{Title: title}
+	i := sheetColumn(sheet, key)
+	if i < 0 {
+		return chart
+	}
+	counts := make(map[string]int)
+	for _, row := range sheet.Rows {
+		label := exportCell(row[i])
+		if label == "" {
+			label = "(none)"
+		}
+		counts[label]++
+	}
+	for label := range counts {
+		chart.Labels = append(chart.Labels, label)
+	}
+	sort.Slice(chart.Labels, func(a, b int) bool {
+		x, y := chart.Labels[a], chart.Labels[b]
+		if counts[x] != counts[y] {
+			return counts[x] > counts[y]
 		}
+		return x < y
+	})
+	if len(chart.Labels) > pdfChartMaxBars {
+		chart.Labels = chart.Labels[:pdfChartMaxBars]
 	}
+	for _, label := range chart.Labels {
+		chart.Values = append(chart.Values, counts[label])
+	}
+	return chart
+}
 
-	// Check if we have any identifiers to remove
-	if len(hostIdentifiersPayload) == 0 {
-		log.Printf("Host %s has no IPAddress identifiers to remove (empty payload)", idToString(hostID))
-		return []HostIdentifier{} // Success - no identifiers to remove
+// Report templates - custom report layouts in the templates directory. A .html file is an
+// html/template; a .txt or .md file is a text/template. Both are executed with reportData.
+
+const (
+	defaultReportTemplatesDir = "templates"
+	builtinReportTemplate     = "default"
+)
+
+var (
+	reportTemplateNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	reportAssetNamePattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	reportTemplateDescPattern  = regexp.MustCompile(`^\s*\{\{-?\s*/\*\s*(?s:(.*?))\s*\*/\s*-?\}\}`)
+	reportTemplateFormats      = []string{"html", "txt", "md"}
+	reportTemplateContentTypes = map[string]string{
+		"html": "text/html; charset=utf-8",
+		"txt":  "text/plain; charset=utf-8",
+		"md":   "text/markdown; charset=utf-8",
 	}
+)
 
-	payload := map[string]interface{}{
-		"hostIdentifiers": hostIdentifiersPayload,
+// ReportsConfig says where custom report templates live and which one exports use by default
+type ReportsConfig struct {
+	TemplatesDir    string `json:"templatesDir"`
+	DefaultTemplate string `json:"defaultTemplate"` // Used when an export names no template
+}
+
+// ReportTemplate describes a template for the template picker
+type ReportTemplate struct {
+	Name        string `json:"name"`
+	Format      string `json:"format"` // html, txt or md
+	Description string `json:"description,omitempty"`
+	BuiltIn     bool   `json:"builtIn"`
+	Error       string `json:"error,omitempty"` // Why the template cannot be used
+}
+
+// reportTemplate is a parsed template ready to execute
+type reportTemplate interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// validateReportsConfig checks the reports settings before they are used
+func validateReportsConfig(rc ReportsConfig) error {
+	if rc.TemplatesDir == "" {
+		return fmt.Errorf("templatesDir is required")
 	}
+	if rc.DefaultTemplate != "" && !reportTemplateNamePattern.MatchString(rc.DefaultTemplate) {
+		return fmt.Errorf("defaultTemplate %q must be a template name without its extension", rc.DefaultTemplate)
+	}
+	return nil
+}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling identifier removal payload for host %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
+// reportTemplateFuncs are the functions templates can call besides the text/template built-ins.
+// asset reads a file from the templates directory, such as a logo, as a data URL.
+func reportTemplateFuncs(dir string, html bool) map[string]interface{} {
+	asset := func(name string) (string, error) {
+		if !reportAssetNamePattern.MatchString(name) {
+			return "", fmt.Errorf("asset %q must be a file name in the templates directory", name)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		return fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data)), nil
+	}
+	toJSON := func(v interface{}) (string, error) {
+		data, err := json.Marshal(v) // Escapes <, > and &, so it cannot close a script element
+		return string(data), err
 	}
 
-	// DELETE the IPAddress identifiers
-	deleteURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s/identifiers",
-		config.Hostname, config.Port, idToString(hostID))
+	funcs := map[string]interface{}{
+		"date": func(layout string, t interface{}) string {
+			switch v := t.(type) {
+			case time.Time:
+				return v.Format(layout)
+			case *time.Time:
+				if v != nil {
+					return v.Format(layout)
+				}
+			}
+			return ""
+		},
+		"id":    idToString,
+		"join":  strings.Join,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"cell":  exportCell,
+		"json":  toJSON,
+		"asset": asset,
+	}
+	if html {
+		funcs["json"] = func(v interface{}) (template.JS, error) {
+			data, err := toJSON(v)
+			return template.JS(data), err
+		}
+		funcs["asset"] = func(name string) (template.URL, error) {
+			url, err := asset(name)
+			return template.URL(url), err
+		}
+	}
+	return funcs
+}
 
-	log.Printf("DELETE URL: %s", deleteURL)
-	log.Printf("DELETE Payload: %s", string(jsonData))
+// reportTemplateFile finds a custom template's file and format
+func reportTemplateFile(name string) (string, string, error) {
+	for _, format := range reportTemplateFormats {
+		path := filepath.Join(dataPath(config.Reports.TemplatesDir), name+"."+format)
+		if _, err := os.Stat(path); err == nil {
+			return path, format, nil
+		}
+	}
+	return "", "", fmt.Errorf("report template %q not found in %s", name, dataPath(config.Reports.TemplatesDir))
+}
 
-	req, err = http.NewRequest("DELETE", deleteURL, bytes.NewBuffer(jsonData))
+// loadReportTemplate parses a template by name; an empty name is the configured default.
+// Templates are read on every use so edits show up without a restart.
+func loadReportTemplate(name string) (reportTemplate, string, error) {
+	if name == "" {
+		name = config.Reports.DefaultTemplate
+	}
+	if name == "" || name == builtinReportTemplate {
+		return htmlReportTemplate, "html", nil
+	}
+	if !reportTemplateNamePattern.MatchString(name) {
+		return nil, "", fmt.Errorf("invalid report template name %q", name)
+	}
+	path, format, err := reportTemplateFile(name)
 	if err != nil {
-		log.Printf("Error creating DELETE request for host identifiers %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
+		return nil, "", err
 	}
+	dir := dataPath(config.Reports.TemplatesDir)
+	if format == "html" {
+		tmpl, err := template.New(filepath.Base(path)).Funcs(reportTemplateFuncs(dir, true)).ParseFiles(path)
+		return tmpl, format, err
+	}
+	tmpl, err := texttemplate.New(filepath.Base(path)).Funcs(reportTemplateFuncs(dir, false)).ParseFiles(path)
+	return tmpl, format, err
+}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
+// listReportTemplates returns the built-in template and every template in the templates directory
+func listReportTemplates() []ReportTemplate {
+	templates := []ReportTemplate{{
+		Name:        builtinReportTemplate,
+		Format:      "html",
+		Description: "Offline page with summary charts, sortable tables and the job's data",
+		BuiltIn:     true,
+	}}
+	dir := dataPath(config.Reports.TemplatesDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return templates
+	}
+	seen := map[string]bool{builtinReportTemplate: true}
+	for _, entry := range entries {
+		format := strings.TrimPrefix(filepath.Ext(entry.Name()), ".")
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if entry.IsDir() || reportTemplateContentTypes[format] == "" || !reportTemplateNamePattern.MatchString(name) || seen[name] {
+			continue
+		}
+		if path, _, err := reportTemplateFile(name); err != nil || filepath.Base(path) != entry.Name() {
+			continue // Another format of the same name takes precedence
+		}
+		seen[name] = true
+		info := ReportTemplate{Name: name, Format: format}
+		if data, err := os.ReadFile(filepath.Join(dir, entry.Name())); err == nil {
+			if m := reportTemplateDescPattern.FindSubmatch(data); m != nil {
+				info.Description = strings.Join(strings.Fields(string(m[1])), " ")
+			}
+		}
+		if _, _, err := loadReportTemplate(name); err != nil {
+			info.Error = err.Error()
+		}
+		templates = append(templates, info)
+	}
+	return templates
+}
 
-	resp, err = httpClient.Do(req)
+// renderReport executes a report template for a job and returns the format it produced
+func renderReport(w io.Writer, name string, job *JobStatus, generated time.Time) (string, error) {
+	tmpl, format, err := loadReportTemplate(name)
 	if err != nil {
-		log.Printf("Error removing IPAddress identifiers from host %s: %v", idToString(hostID), err)
-		return []HostIdentifier{}
+		return "", err
 	}
-	defer resp.Body.Close()
+	return format, tmpl.Execute(w, buildReportData(job, generated))
+}
 
-	// Read response body for debugging
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	log.Printf("DELETE Response Status: %d", resp.StatusCode)
-	log.Printf("DELETE Response Body: %s", string(bodyBytes))
+func handleReportTemplates(w http.ResponseWriter, r *http.Request) {
+	defaultTemplate := config.Reports.DefaultTemplate
+	if defaultTemplate == "" {
+		defaultTemplate = builtinReportTemplate
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"templates":       listReportTemplates(),
+		"defaultTemplate": defaultTemplate,
+		"directory":       dataPath(config.Reports.TemplatesDir),
+	})
+}
 
-	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
-		log.Printf("Successfully removed %d IPAddress identifiers from host %s", len(removedIdentifiers), idToString(hostID))
-		return removedIdentifiers
+// handleExportHTML renders a job with the template named by ?template=. With ?preview=1 the report
+// is shown inline in a sandbox, so a template's scripts cannot act on the LRCleaner session.
+func handleExportHTML(w http.ResponseWriter, r *http.Request) {
+	jobID := mux.Vars(r)["jobId"]
+
+	jobsMutex.RLock()
+	job, exists := jobs[jobID]
+	var snapshot JobStatus
+	if exists {
+		snapshot = *job
+	}
+	jobsMutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	name := r.URL.Query().Get("template")
+	var buf bytes.Buffer
+	format, err := renderReport(&buf, name, &snapshot, time.Now())
+	if err != nil {
+		log.Printf("Error building report for job %s with template %q: %v", jobID, name, err)
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	deploymentLabel := snapshot.Profile
+	if len(snapshot.Profiles) > 0 {
+		deploymentLabel = strings.Join(snapshot.Profiles, "-")
+	}
+	w.Header().Set("Content-Type", reportTemplateContentTypes[format])
+	if r.URL.Query().Get("preview") != "" {
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts allow-downloads")
+		if format != "html" {
+			w.Header().Set("Content-Type", reportTemplateContentTypes["txt"])
+		}
 	} else {
-		log.Printf("Failed to remove IPAddress identifiers from host %s, status: %d", idToString(hostID), resp.StatusCode)
-		return []HostIdentifier{} // Return empty list on failure
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"LRCleaner_Report_%s_%s.%s\"", deploymentLabel, jobID, format))
 	}
+	w.Write(buf.Bytes())
 }
 
-func updateHost(hostID interface{}) (bool, []HostIdentifier) {
-	// First, remove all identifiers from the host
-	removedIdentifiers := removeHostIdentifiers(hostID)
-	if len(removedIdentifiers) == 0 {
-		log.Printf("No identifiers were removed from host %s", idToString(hostID))
+// runHTMLReport writes the report of a job saved from GET /api/jobs/{jobId}, for the -html-report flag
+func runHTMLReport(input, templateName, output string) int {
+	loaded, err := loadConfig(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	config = loaded
+	log.SetOutput(io.Discard) // Rollback points are only read to fill in .Rollback
+	loadRollbackFiles()
+	log.SetOutput(os.Stderr)
 
-	// Use the correct LogRhythm API for retiring hosts
-	// Based on the example, we need to use the specific host endpoint
-	hostURL := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", config.Hostname, config.Port, idToString(hostID))
+	data, err := os.ReadFile(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var job JobStatus
+	if err := json.Unmarshal(data, &job); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: not a job saved from GET /api/jobs/{jobId}: %v\n", input, err)
+		return 1
+	}
+	if job.ID == "" {
+		fmt.Fprintf(os.Stderr, "%s: not a job saved from GET /api/jobs/{jobId}: no id\n", input)
+		return 1
+	}
 
-	// GET the host first to get the complete object
-	getReq, err := http.NewRequest("GET", hostURL, nil)
+	var buf bytes.Buffer
+	format, err := renderReport(&buf, templateName, &job, time.Now())
 	if err != nil {
-		log.Printf("Error creating GET request for host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if output == "" {
+		output = strings.TrimSuffix(input, filepath.Ext(input)) + "." + format
 	}
+	if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", output)
+	return 0
+}
 
-	getReq.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	getReq.Header.Set("Content-Type", "application/json")
+// Retirement reports - the PDF record compliance keeps after every cleanup
+
+// buildRetirementReport renders a retirement job as a PDF: a cover page, an executive summary with
+// charts, the backup it relied on, and per-host tables. Hosts, log sources, agents and failures are
+// sorted so the same job always produces the same report.
+func buildRetirementReport(job *JobStatus, generated time.Time) []byte {
+	doc := &pdfDocument{footer: fmt.Sprintf("LRCleaner retirement report - job %s", job.ID)}
+	completed := "Not finished"
+	if job.EndTime != nil {
+		completed = job.EndTime.Format("2006-01-02 15:04:05")
+	}
+	rollbackID := job.RollbackID
+	if rollbackID == "" {
+		rollbackID = "None (rollback is disabled)"
+	}
+	backupReference := "None"
+	switch {
+	case job.Backup == nil:
+	case job.Backup.Backup != nil:
+		backupReference = fmt.Sprintf("Verified backup %s", job.Backup.Backup.ID)
+	default:
+		backupReference = fmt.Sprintf("Attested: %s", job.Backup.Attestation.Reference)
+	}
+
+	// Cover page
+	doc.newPage()
+	doc.rect(0, pdfPageHeight-220, pdfPageWidth, 220, pdfColorBrand)
+	doc.text(pdfMargin, pdfPageHeight-110, 14, false, pdfColorWhite, "LRCleaner")
+	doc.text(pdfMargin, pdfPageHeight-145, 26, true, pdfColorWhite, "Log Source Retirement Report")
+	doc.text(pdfMargin, pdfPageHeight-175, 12, false, pdfColorWhite, "Deployment "+job.Profile)
+	doc.y = pdfPageHeight - 280
+	doc.keyValues([][2]string{
+		{"Deployment", job.Profile},
+		{"Job ID", job.ID},
+		{"Change Ticket", job.ChangeTicket},
+		{"Operator", job.StartedBy},
+		{"Status", job.Status},
+		{"Started", job.StartTime.Format("2006-01-02 15:04:05")},
+		{"Completed", completed},
+		{"Rollback Point", rollbackID},
+		{"Backup Reference", backupReference},
+	})
+	doc.y -= 20
+	doc.paragraph(fmt.Sprintf("Generated by LRCleaner on %s.", generated.Format("2006-01-02 15:04:05")), pdfColorMuted)
 
-	getResp, err := httpClient.Do(getReq)
-	if err != nil {
-		log.Printf("Error getting host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
+	// Group retirement records by host, in a stable order
+	type hostGroup struct {
+		id, name string
+		records  []RetirementRecord
 	}
-	defer getResp.Body.Close()
+	groupIndex := make(map[string]int)
+	var groups []*hostGroup
+	agentNames := make(map[string]string)
+	for _, record := range job.RetirementRecords {
+		id := idToString(record.HostID)
+		i, ok := groupIndex[id]
+		if !ok {
+			i = len(groups)
+			groupIndex[id] = i
+			groups = append(groups, &hostGroup{id: id, name: record.HostName})
+		}
+		groups[i].records = append(groups[i].records, record)
+		if record.SystemMonitorID != nil && record.SystemMonitorName != "" {
+			agentNames[idToString(record.SystemMonitorID)] = record.SystemMonitorName
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := strings.ToLower(groups[i].name), strings.ToLower(groups[j].name)
+		if a != b {
+			return a < b
+		}
+		return idLess(groups[i].id, groups[j].id)
+	})
+	for _, group := range groups {
+		sort.Slice(group.records, func(i, j int) bool {
+			a, b := strings.ToLower(group.records[i].OriginalName), strings.ToLower(group.records[j].OriginalName)
+			if a != b {
+				return a < b
+			}
+			return idLess(idToString(group.records[i].LogSourceID), idToString(group.records[j].LogSourceID))
+		})
+	}
+	retiredHosts := make(map[string]bool)
+	for _, id := range job.RetiredHosts {
+		retiredHosts[id] = true
+	}
+	failures := append([]RetirementFailure(nil), job.Failures...)
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Kind != failures[j].Kind {
+			return failures[i].Kind < failures[j].Kind
+		}
+		return idLess(failures[i].ID, failures[j].ID)
+	})
 
-	if getResp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get host %s, status: %d", idToString(hostID), getResp.StatusCode)
-		return false, []HostIdentifier{}
+	// Executive summary
+	doc.newPage()
+	doc.heading("Executive Summary")
+	summary := fmt.Sprintf("%s retired %d log sources, %d host records and %d System Monitor agents in deployment %s under change ticket %s.",
+		job.StartedBy, len(job.RetirementRecords), len(job.RetiredHosts), len(job.RetiredAgents), job.Profile, job.ChangeTicket)
+	if len(failures) > 0 {
+		summary += fmt.Sprintf(" %d changes were refused or failed and are listed at the end of this report.", len(failures))
+	}
+	summary += fmt.Sprintf(" The retirement ran with %s.", job.Backup.describe())
+	if job.RollbackID != "" {
+		summary += fmt.Sprintf(" It can be undone from rollback point %s.", job.RollbackID)
+	} else {
+		summary += " No rollback point was recorded."
+	}
+	doc.paragraph(summary, pdfColorText)
+	doc.y -= 6
+	doc.keyValues([][2]string{{"Justification", job.Justification}})
+
+	doc.subheading("Objects Retired")
+	doc.barChart([]string{"Log sources", "Hosts", "System Monitor agents"},
+		[]int{len(job.RetirementRecords), len(job.RetiredHosts), len(job.RetiredAgents)})
+
+	if len(groups) > 0 {
+		doc.subheading("Log Sources Retired per Host")
+		byCount := append([]*hostGroup(nil), groups...)
+		sort.SliceStable(byCount, func(i, j int) bool { return len(byCount[i].records) > len(byCount[j].records) })
+		var labels []string
+		var values []int
+		for i, group := range byCount {
+			if i == pdfChartMaxBars {
+				break
+			}
+			labels = append(labels, group.name)
+			values = append(values, len(group.records))
+		}
+		doc.barChart(labels, values)
+		if len(byCount) > pdfChartMaxBars {
+			doc.paragraph(fmt.Sprintf("and %d more hosts.", len(byCount)-pdfChartMaxBars), pdfColorMuted)
+		}
 	}
 
-	// Parse the response
-	var host map[string]interface{}
-	if err := json.NewDecoder(getResp.Body).Decode(&host); err != nil {
-		log.Printf("Error decoding host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
+	// Backup reference
+	doc.heading("Backup Reference")
+	switch {
+	case job.Backup == nil:
+		doc.paragraph("No backup was linked to this retirement; the backup policy was not required when it ran.", pdfColorText)
+	case job.Backup.Backup != nil:
+		backup := job.Backup.Backup
+		doc.keyValues([][2]string{
+			{"Backup ID", backup.ID},
+			{"Server", backup.Server},
+			{"Taken", backup.EndTime.Format("2006-01-02 15:04:05")},
+			{"Taken By", backup.StartedBy},
+			{"Verified", exportCell(backup.Verified)},
+		})
+		var rows [][]string
+		for _, file := range backup.Files {
+			rows = append(rows, []string{file.Database, file.Path, exportCell(file.Verified)})
+		}
+		doc.table([]pdfColumn{{"Database", 0.25}, {"Backup File", 0.6}, {"Verified", 0.15}}, rows)
+	default:
+		attestation := job.Backup.Attestation
+		doc.keyValues([][2]string{
+			{"Reference", attestation.Reference},
+			{"Note", attestation.Note},
+			{"Attested By", attestation.AttestedBy},
+			{"Attested At", attestation.AttestedAt.Format("2006-01-02 15:04:05")},
+		})
 	}
 
-	// Check if host is already retired
-	if recordStatusName, ok := host["recordStatusName"].(string); ok && recordStatusName == "Retired" {
-		log.Printf("Host %s is already retired, skipping retirement", idToString(hostID))
-		return true, []HostIdentifier{} // Success - already retired
+	// Per-host tables
+	doc.heading("Retired Log Sources by Host")
+	if len(groups) == 0 {
+		doc.paragraph("No log sources were retired.", pdfColorText)
+	}
+	for _, group := range groups {
+		doc.subheading(fmt.Sprintf("%s (host ID %s)", group.name, group.id))
+		hostOutcome := "Host record kept: it still has active log sources or could not be retired."
+		if retiredHosts[group.id] {
+			hostOutcome = "Host record retired."
+		}
+		doc.paragraph(fmt.Sprintf("Log sources retired: %d. %s", len(group.records), hostOutcome), pdfColorMuted)
+		var rows [][]string
+		for _, record := range group.records {
+			rows = append(rows, []string{
+				idToString(record.LogSourceID), record.OriginalName, record.RetiredName,
+				record.OriginalStatus + " -> " + record.RetiredStatus, record.Timestamp.Format("2006-01-02 15:04:05"),
+			})
+		}
+		doc.table([]pdfColumn{{"ID", 0.09}, {"Original Name", 0.3}, {"Retired Name", 0.3}, {"Status", 0.14}, {"Retired At", 0.17}}, rows)
 	}
 
-	// Update the recordStatusName to "Retired"
-	host["recordStatusName"] = "Retired"
+	// Agents
+	doc.heading("Retired System Monitor Agents")
+	if len(job.RetiredAgents) == 0 {
+		doc.paragraph("No System Monitor agents were retired.", pdfColorText)
+	} else {
+		var rows [][]string
+		for _, id := range job.RetiredAgents {
+			rows = append(rows, []string{id, agentNames[id]})
+		}
+		doc.table([]pdfColumn{{"Agent ID", 0.2}, {"Agent Name", 0.8}}, rows)
+	}
 
-	// Also update the name to indicate retirement
-	if name, ok := host["name"].(string); ok {
-		// Check if already retired to prevent duplicate "Retired by LRCleaner" additions
-		if !strings.Contains(name, "Retired by LRCleaner") {
-			host["name"] = name + " Retired by LRCleaner"
+	if len(failures) > 0 {
+		doc.heading("Refused and Failed Changes")
+		var rows [][]string
+		for _, failure := range failures {
+			outcome := "Failed"
+			if failure.Protected {
+				outcome = "Refused (protected)"
+			}
+			rows = append(rows, []string{outcome, failure.Kind, failure.ID, failure.Name, failure.HostName, failure.Error})
 		}
+		doc.table([]pdfColumn{{"Outcome", 0.13}, {"Kind", 0.1}, {"ID", 0.08}, {"Name", 0.18}, {"Host", 0.14}, {"Error", 0.37}}, rows)
 	}
 
-	// Remove fields that are not allowed in PUT request
-	delete(host, "hostRoles")
-	delete(host, "hostIdentifiers")
+	return doc.bytes(fmt.Sprintf("LRCleaner Retirement Report %s", job.ID), generated)
+}
 
-	// PUT the updated host back
-	jsonData, err := json.Marshal(host)
-	if err != nil {
-		log.Printf("Error marshaling updated host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
-	}
+// PDF writer - A4 pages using the standard Helvetica fonts, so no font files are embedded
 
-	log.Printf("PUT Request Data for host %s: %s", idToString(hostID), string(jsonData))
+const (
+	pdfPageWidth    = 595.0
+	pdfPageHeight   = 842.0
+	pdfMargin       = 50.0
+	pdfChartMaxBars = 15
+)
 
-	req, err := http.NewRequest("PUT", hostURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error creating PUT request for host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
-	}
+// Fill colours as PDF RGB operands
+const (
+	pdfColorText   = "0 0 0"
+	pdfColorMuted  = "0.4 0.4 0.4"
+	pdfColorWhite  = "1 1 1"
+	pdfColorBrand  = "0.13 0.27 0.47"
+	pdfColorBar    = "0.25 0.5 0.8"
+	pdfColorHeader = "0.87 0.9 0.94"
+	pdfColorStripe = "0.96 0.97 0.98"
+	pdfColorRule   = "0.75 0.78 0.82"
+)
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
+// Advance widths of Helvetica and Helvetica-Bold for ASCII 32-126, in 1/1000 of the font size
+var pdfFontWidths = [2][95]int{
+	{278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584},
+	{278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584},
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error updating host %s: %v", idToString(hostID), err)
-		return false, []HostIdentifier{}
-	}
-	defer resp.Body.Close()
+// WinAnsiEncoding codes for the characters outside Latin-1 that LogRhythm names commonly contain
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
 
-	// Read response body for debugging
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	log.Printf("PUT Response Status: %d", resp.StatusCode)
-	log.Printf("PUT Response Body: %s", string(bodyBytes))
+// pdfColumn is a table column; Width is its share of the text width
+type pdfColumn struct {
+	Header string
+	Width  float64
+}
 
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully retired host %s", idToString(hostID))
-		return true, removedIdentifiers
-	} else {
-		log.Printf("Failed to update host %s, status: %d", idToString(hostID), resp.StatusCode)
-		return false, []HostIdentifier{}
-	}
+// pdfDocument lays out a report top to bottom, starting a new page when the current one is full
+type pdfDocument struct {
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	y      float64 // Top of the next block, in points from the bottom of the page
+	footer string
 }
 
-func updateLogSource(logSourceID interface{}) bool {
-	// First, GET the log source to get the complete object
-	getURL := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", config.Hostname, config.Port, idToString(logSourceID))
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+}
 
-	req, err := http.NewRequest("GET", getURL, nil)
-	if err != nil {
-		log.Printf("Error creating GET request for log source %s: %v", idToString(logSourceID), err)
-		return false
+// ensure starts a new page unless height points still fit above the footer
+func (d *pdfDocument) ensure(height float64) {
+	if d.page == nil || d.y-height < pdfMargin+20 {
+		d.newPage()
 	}
+}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
+func (d *pdfDocument) text(x, y, size float64, bold bool, color, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT %s rg /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", color, font, size, x, y, pdfString(s))
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error getting log source %s: %v", idToString(logSourceID), err)
-		return false
+func (d *pdfDocument) rect(x, y, width, height float64, color string) {
+	fmt.Fprintf(d.page, "%s rg %.2f %.2f %.2f %.2f re f\n", color, x, y, width, height)
+}
+
+func (d *pdfDocument) rule(y float64) {
+	fmt.Fprintf(d.page, "%s RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfColorRule, pdfMargin, y, pdfPageWidth-pdfMargin, y)
+}
+
+func (d *pdfDocument) heading(s string) {
+	d.ensure(80)
+	if d.y < pdfPageHeight-pdfMargin {
+		d.y -= 16
 	}
-	defer resp.Body.Close()
+	d.y -= 16
+	d.text(pdfMargin, d.y, 16, true, pdfColorBrand, s)
+	d.y -= 6
+	d.rule(d.y)
+	d.y -= 12
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Failed to get log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
-		return false
+func (d *pdfDocument) subheading(s string) {
+	d.ensure(60)
+	d.y -= 16
+	for _, line := range pdfWrap(s, pdfPageWidth-2*pdfMargin, 11, true) {
+		d.text(pdfMargin, d.y, 11, true, pdfColorText, line)
+		d.y -= 14
 	}
+	d.y += 8
+}
 
-	// Parse the response
-	var logSource map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&logSource); err != nil {
-		log.Printf("Error decoding log source %s: %v", idToString(logSourceID), err)
-		return false
+func (d *pdfDocument) paragraph(s, color string) {
+	for _, line := range pdfWrap(s, pdfPageWidth-2*pdfMargin, 10, false) {
+		d.ensure(14)
+		d.y -= 14
+		d.text(pdfMargin, d.y, 10, false, color, line)
 	}
+	d.y -= 4
+}
 
-	// Modify the log source object
-	if name, ok := logSource["name"].(string); ok {
-		// Check if already retired to prevent duplicate "Retired by LRCleaner" additions
-		if !strings.Contains(name, "Retired by LRCleaner") {
-			logSource["name"] = name + " Retired by LRCleaner"
+// keyValues lists labelled values, wrapping long values beside their label
+func (d *pdfDocument) keyValues(pairs [][2]string) {
+	const labelWidth = 130.0
+	for _, pair := range pairs {
+		lines := pdfWrap(pair[1], pdfPageWidth-2*pdfMargin-labelWidth, 10, false)
+		d.ensure(14 * float64(len(lines)))
+		d.text(pdfMargin, d.y-14, 10, true, pdfColorText, pair[0])
+		for _, line := range lines {
+			d.y -= 14
+			d.text(pdfMargin+labelWidth, d.y, 10, false, pdfColorText, line)
 		}
+		d.y -= 4
 	}
+}
 
-	// Only set status to Retired if not already retired
-	if recordStatus, ok := logSource["recordStatus"].(string); ok {
-		if recordStatus != "Retired" {
-			logSource["recordStatus"] = "Retired"
+// barChart draws one horizontal bar per label, scaled to the largest value
+func (d *pdfDocument) barChart(labels []string, values []int) {
+	const labelWidth, barHeight, gap = 150.0, 14.0, 6.0
+	maxValue := 1
+	for _, v := range values {
+		if v > maxValue {
+			maxValue = v
 		}
-	} else {
-		logSource["recordStatus"] = "Retired"
 	}
-
-	// PUT the updated log source back
-	putURL := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", config.Hostname, config.Port, idToString(logSourceID))
-
-	jsonData, err := json.Marshal(logSource)
-	if err != nil {
-		log.Printf("Error marshaling updated log source %s: %v", idToString(logSourceID), err)
-		return false
+	barSpace := pdfPageWidth - 2*pdfMargin - labelWidth - 40
+	d.y -= 6
+	for i, label := range labels {
+		d.ensure(barHeight + gap)
+		d.y -= barHeight
+		d.text(pdfMargin, d.y+4, 9, false, pdfColorText, pdfTruncate(label, labelWidth-8, 9, false))
+		width := barSpace * float64(values[i]) / float64(maxValue)
+		if values[i] > 0 && width < 1 {
+			width = 1
+		}
+		d.rect(pdfMargin+labelWidth, d.y, width, barHeight, pdfColorBar)
+		d.text(pdfMargin+labelWidth+width+6, d.y+4, 9, true, pdfColorText, strconv.Itoa(values[i]))
+		d.y -= gap
 	}
+	d.y -= 6
+}
 
-	req, err = http.NewRequest("PUT", putURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error creating PUT request for log source %s: %v", idToString(logSourceID), err)
-		return false
+// table draws rows with wrapped cells, repeating the header row on each new page
+func (d *pdfDocument) table(columns []pdfColumn, rows [][]string) {
+	const size, leading, padding = 8.0, 10.0, 3.0
+	textWidth := pdfPageWidth - 2*pdfMargin
+	drawHeader := func() {
+		d.rect(pdfMargin, d.y-leading-2*padding, textWidth, leading+2*padding, pdfColorHeader)
+		x := pdfMargin
+		for _, column := range columns {
+			d.text(x+padding, d.y-padding-size, size, true, pdfColorText, pdfTruncate(column.Header, column.Width*textWidth-2*padding, size, true))
+			x += column.Width * textWidth
+		}
+		d.y -= leading + 2*padding
+	}
+	d.ensure(3 * (leading + 2*padding))
+	drawHeader()
+	for r, row := range rows {
+		cells := make([][]string, len(columns))
+		lines := 1
+		for c, column := range columns {
+			cells[c] = pdfWrap(row[c], column.Width*textWidth-2*padding, size, false)
+			if len(cells[c]) > lines {
+				lines = len(cells[c])
+			}
+		}
+		height := float64(lines)*leading + 2*padding
+		if d.y-height < pdfMargin+20 {
+			d.newPage()
+			drawHeader()
+		}
+		if r%2 == 1 {
+			d.rect(pdfMargin, d.y-height, textWidth, height, pdfColorStripe)
+		}
+		x := pdfMargin
+		for c, column := range columns {
+			for l, line := range cells[c] {
+				d.text(x+padding, d.y-padding-size-float64(l)*leading, size, false, pdfColorText, line)
+			}
+			x += column.Width * textWidth
+		}
+		d.y -= height
 	}
+	d.rule(d.y)
+	d.y -= 8
+}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err = httpClient.Do(req)
-	if err != nil {
-		log.Printf("Error updating log source %s: %v", idToString(logSourceID), err)
-		return false
-	}
-	defer resp.Body.Close()
+// bytes assembles the pages, numbering them in the footer, into a PDF file
+func (d *pdfDocument) bytes(title string, created time.Time) []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (LRCleaner) /CreationDate (D:%s) >>",
+		pdfString(title), created.UTC().Format("20060102150405Z")))
+
+	for i, page := range d.pages {
+		d.page = page
+		footer := fmt.Sprintf("%s - page %d of %d", d.footer, i+1, len(d.pages))
+		d.text(pdfMargin, pdfMargin-20, 8, false, pdfColorMuted, pdfTruncate(footer, pdfPageWidth-2*pdfMargin, 8, false))
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		zw.Write(page.Bytes())
+		zw.Close()
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
 
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Successfully retired log source %s", idToString(logSourceID))
-		return true
-	} else {
-		log.Printf("Failed to update log source %s, status: %d", idToString(logSourceID), resp.StatusCode)
-		return false
+// pdfString encodes text as a WinAnsi PDF string body; characters it cannot carry become '?'
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < ' ':
+			b.WriteByte(' ')
+		case r < 0x7f || (r >= 0xa0 && r <= 0xff):
+			b.WriteByte(byte(r))
+		case pdfWinAnsi[r] != 0:
+			b.WriteByte(pdfWinAnsi[r])
+		default:
+			b.WriteByte('?')
+		}
 	}
+	return b.String()
 }
 
-func generateTextReport(job *JobStatus) []byte {
-	// Generate a simple text-based report
-
-	report := "LRCleaner Retirement Report\n"
-	report += "==========================\n\n"
-	report += fmt.Sprintf("Job ID: %s\n", job.ID)
-	report += fmt.Sprintf("Completed: %s\n", job.EndTime.Format("2006-01-02 15:04:05"))
-	report += fmt.Sprintf("Total Log Sources Retired: %d\n\n", len(job.RetirementRecords))
-	report += "Retirement Summary:\n"
-	report += "==================\n\n"
-
-	// Group by host
-	hostMap := make(map[string][]RetirementRecord)
-	for _, record := range job.RetirementRecords {
-		hostMap[record.HostName] = append(hostMap[record.HostName], record)
+func pdfTextWidth(s string, size float64, bold bool) float64 {
+	widths := &pdfFontWidths[0]
+	if bold {
+		widths = &pdfFontWidths[1]
 	}
-
-	for hostName, records := range hostMap {
-		report += fmt.Sprintf("Host: %s (%d log sources)\n", hostName, len(records))
-		report += "----------------------------------------\n"
-
-		for _, record := range records {
-			report += fmt.Sprintf("  Log Source ID: %s\n", idToString(record.LogSourceID))
-			report += fmt.Sprintf("  Original Name: %s\n", record.OriginalName)
-			report += fmt.Sprintf("  Retired Name: %s\n", record.RetiredName)
-			report += fmt.Sprintf("  Status: %s -> %s\n", record.OriginalStatus, record.RetiredStatus)
-			report += fmt.Sprintf("  Timestamp: %s\n\n", record.Timestamp.Format("2006-01-02 15:04:05"))
+	total := 0
+	for _, r := range s {
+		if r >= ' ' && r <= '~' {
+			total += widths[r-' ']
+		} else {
+			total += 556
 		}
-		report += "\n"
 	}
+	return float64(total) * size / 1000
+}
 
-	report += "Backup Recommendation:\n"
-	report += "=====================\n"
-	report += "Before making any changes, it is recommended to backup the LogRhythmEMDB database:\n\n"
-	report += "1. Stop LogRhythm services\n"
-	report += "2. Backup the LogRhythmEMDB database\n"
-	report += "3. Document the backup location and timestamp\n"
-	report += "4. Restart LogRhythm services\n\n"
-	report += "This backup will allow you to restore the system to its previous state if needed.\n\n"
-	report += fmt.Sprintf("Generated by LRCleaner on %s\n", time.Now().Format("2006-01-02 15:04:05"))
+// pdfWrap breaks text into lines no wider than width, splitting words that do not fit on a line
+func pdfWrap(s string, width, size float64, bold bool) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if pdfTextWidth(candidate, size, bold) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			line = ""
+			for _, r := range word {
+				if line != "" && pdfTextWidth(line+string(r), size, bold) > width {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
 
-	return []byte(report)
+// pdfTruncate shortens text to fit width, ending it with "..." when cut
+func pdfTruncate(s string, width, size float64, bold bool) string {
+	if pdfTextWidth(s, size, bold) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdfTextWidth(string(runes)+"...", size, bold) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
 }
 
 // Rollback Functions
 
-func createRollbackData(jobID string, selectedHosts []string) *RollbackData {
+func createRollbackData(p *Profile, jobID string, selectedHosts []string, naming RetirementNaming) *RollbackData {
 	if !config.Rollback.Enabled {
 		return nil
 	}
 
 	// Get the host analysis from the previous job
-	var hostAnalysis []HostAnalysis
-	jobsMutex.RLock()
-	for _, otherJob := range jobs {
-		if len(otherJob.HostAnalysis) > 0 {
-			hostAnalysis = otherJob.HostAnalysis
-			break
-		}
-	}
-	jobsMutex.RUnlock()
+	hostAnalysis := latestHostAnalysis(p.Name)
 
 	if len(hostAnalysis) == 0 {
 		log.Printf("No host analysis found for rollback data creation")
@@ -2971,12 +12969,30 @@ func createRollbackData(jobID string, selectedHosts []string) *RollbackData {
 		ID:            rollbackID,
 		Timestamp:     time.Now(),
 		OperationType: "retirement",
-		User:          "system", // TODO: Get actual user
+		User:          naming.Operator,
+		Profile:       p.Name,
 		Description:   fmt.Sprintf("Retirement of %d hosts with %d log sources", len(hostsToRetire), getTotalLogSources(hostsToRetire)),
+		ChangeTicket:  naming.Ticket,
+		Justification: naming.Justification,
 		JobID:         jobID,
 		Checksum:      "", // Will be calculated when saving
 	}
 
+	// Link the backup the retirement was allowed to run on
+	if naming.Backup != nil && naming.Backup.Backup != nil {
+		backup := naming.Backup.Backup
+		rollbackData.BackupID = backup.ID
+		rollbackData.BackupFiles = backup.Files
+		for _, file := range backup.Files {
+			if file.Database == emdbDatabase {
+				rollbackData.BackupLocation = file.Path
+			}
+		}
+	}
+	if naming.Backup != nil {
+		rollbackData.BackupAttestation = naming.Backup.Attestation
+	}
+
 	// Capture log source changes
 	for _, host := range hostsToRetire {
 		for _, logSource := range host.LogSources {
@@ -2997,7 +13013,7 @@ func createRollbackData(jobID string, selectedHosts []string) *RollbackData {
 	// Capture host changes
 	for _, host := range hostsToRetire {
 		// Get original host data
-		originalHostData := getHostData(host.HostID)
+		originalHostData := getHostData(p, host.HostID)
 
 		// Get the actually removed identifiers from the retirement process
 		removedIdentifiers := removedIdentifiersMap[idToString(host.HostID)]
@@ -3024,6 +13040,57 @@ func createRollbackData(jobID string, selectedHosts []string) *RollbackData {
 	return rollbackData
 }
 
+// recordLogSourceRetirement updates a rollback point with the names observed when a log
+// source was retired, so rollback restores exactly what was there before
+func recordLogSourceRetirement(rollbackData *RollbackData, logSourceID interface{}, nameChange NameChange) {
+	if rollbackData == nil {
+		return
+	}
+
+	rollbackMutex.Lock()
+	defer rollbackMutex.Unlock()
+
+	for i := range rollbackData.LogSourceChanges {
+		change := &rollbackData.LogSourceChanges[i]
+		if idToString(change.LogSourceID) == idToString(logSourceID) {
+			if nameChange.Original != "" {
+				change.OriginalName = nameChange.Original
+			}
+			change.CurrentName = nameChange.Retired
+			change.CurrentStatus = "Retired"
+			change.OriginalShortDescription = nameChange.OriginalDescription
+			return
+		}
+	}
+}
+
+// recordHostRetirement updates a rollback point with the host name and identifiers
+// changed when a host was retired
+func recordHostRetirement(rollbackData *RollbackData, hostID interface{}, nameChange NameChange, removedIdentifiers []HostIdentifier) {
+	if rollbackData == nil {
+		return
+	}
+
+	rollbackMutex.Lock()
+	defer rollbackMutex.Unlock()
+
+	for i := range rollbackData.HostChanges {
+		change := &rollbackData.HostChanges[i]
+		if idToString(change.HostID) == idToString(hostID) {
+			if nameChange.Original != "" {
+				change.OriginalName = nameChange.Original
+				change.CurrentName = nameChange.Retired
+			}
+			change.CurrentStatus = "Retired"
+			change.RetiredIdentifiers = removedIdentifiers
+			if nameChange.OriginalDescription != nil {
+				change.OriginalShortDescription = nameChange.OriginalDescription
+			}
+			return
+		}
+	}
+}
+
 func getTotalLogSources(hosts []HostAnalysis) int {
 	total := 0
 	for _, host := range hosts {
@@ -3032,8 +13099,8 @@ func getTotalLogSources(hosts []HostAnalysis) int {
 	return total
 }
 
-func getHostData(hostID interface{}) map[string]interface{} {
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", config.Hostname, config.Port, idToString(hostID))
+func getHostData(p *Profile, hostID interface{}) map[string]interface{} {
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", p.Hostname, p.Port, idToString(hostID))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -3041,7 +13108,7 @@ func getHostData(hostID interface{}) map[string]interface{} {
 		return make(map[string]interface{})
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -3086,22 +13153,31 @@ func extractHostIdentifiers(hostData map[string]interface{}) []HostIdentifier {
 	return identifiers
 }
 
-func saveRollbackData(rollbackData *RollbackData) {
+func saveRollbackData(p *Profile, rollbackData *RollbackData) {
 	// Create rollback directory if it doesn't exist
-	rollbackDir := config.Rollback.BackupLocation
+	rollbackDir := p.rollbackDir()
 	if err := os.MkdirAll(rollbackDir, 0755); err != nil {
 		log.Printf("Error creating rollback directory: %v", err)
 		return
 	}
 
-	// Calculate checksum
-	jsonData, err := json.Marshal(rollbackData)
+	// Calculate checksum over the data without its checksum field
+	rollbackMutex.Lock()
+	rollbackData.Checksum = ""
+	unsignedData, err := json.Marshal(rollbackData)
 	if err != nil {
+		rollbackMutex.Unlock()
 		log.Printf("Error marshaling rollback data: %v", err)
 		return
 	}
 
-	rollbackData.Checksum = calculateChecksum(jsonData)
+	rollbackData.Checksum = calculateChecksum(unsignedData)
+	jsonData, err := json.Marshal(rollbackData)
+	rollbackMutex.Unlock()
+	if err != nil {
+		log.Printf("Error marshaling rollback data: %v", err)
+		return
+	}
 
 	// Save to file
 	filename := fmt.Sprintf("LRCleaner_rollback_%s_%s.json",
@@ -3116,6 +13192,7 @@ func saveRollbackData(rollbackData *RollbackData) {
 
 	// Store in memory
 	rollbackMutex.Lock()
+	rollbackData.filePath = filepath
 	rollbackHistory[rollbackData.ID] = rollbackData
 	rollbackMutex.Unlock()
 
@@ -3133,17 +13210,28 @@ func handleRollbackHistory(w http.ResponseWriter, r *http.Request) {
 	rollbackMutex.RLock()
 	defer rollbackMutex.RUnlock()
 
-	var history []map[string]interface{}
+	// Optional filter by change ticket
+	ticket := strings.TrimSpace(r.URL.Query().Get("ticket"))
+
+	history := []map[string]interface{}{}
 	for _, rollback := range rollbackHistory {
+		if ticket != "" && !strings.EqualFold(rollback.ChangeTicket, ticket) {
+			continue
+		}
 		history = append(history, map[string]interface{}{
-			"id":             rollback.ID,
-			"timestamp":      rollback.Timestamp,
-			"operation":      rollback.OperationType,
-			"description":    rollback.Description,
-			"user":           rollback.User,
-			"logSources":     len(rollback.LogSourceChanges),
-			"hosts":          len(rollback.HostChanges),
-			"systemMonitors": len(rollback.SystemMonitorChanges),
+			"id":                rollback.ID,
+			"timestamp":         rollback.Timestamp,
+			"operation":         rollback.OperationType,
+			"description":       rollback.Description,
+			"user":              rollback.User,
+			"profile":           rollback.Profile,
+			"changeTicket":      rollback.ChangeTicket,
+			"justification":     rollback.Justification,
+			"logSources":        len(rollback.LogSourceChanges),
+			"hosts":             len(rollback.HostChanges),
+			"systemMonitors":    len(rollback.SystemMonitorChanges),
+			"backupLocation":    rollback.BackupLocation,
+			"backupAttestation": rollback.BackupAttestation,
 		})
 	}
 
@@ -3186,8 +13274,43 @@ func handleExecuteRollback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Rollback points from before profiles existed belong to the default profile
+	p, err := findProfile(rollback.Profile)
+	if err != nil {
+		http.Error(w, "Cannot execute rollback: "+err.Error(), http.StatusConflict)
+		return
+	}
+
+	entry := requestAudit(r, "rollback.execute", rollbackID)
+	entry.Profile = p.Name
+	entry.Ticket = rollback.ChangeTicket
+	entry.JobID = rollback.JobID
+	entry.Message = rollback.Description
+	if err := recordAudit(entry); err != nil {
+		http.Error(w, "Cannot execute rollback: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	// Execute rollback
-	success := executeRollback(rollback)
+	success := executeRollback(p, rollback, entry)
+
+	entry.Action = "rollback.complete"
+	event := WebhookEvent{
+		Event:   EventRollbackExecuted,
+		Profile: p.Name,
+		JobID:   rollback.JobID,
+		User:    currentUsername(r),
+		Summary: fmt.Sprintf("Rollback point %s restored: %s", rollbackID, rollback.Description),
+		Data:    map[string]interface{}{"rollbackId": rollbackID, "changeTicket": rollback.ChangeTicket},
+	}
+	if !success {
+		entry.Result = auditResultFailure
+		entry.Message = "Rollback completed with errors"
+		event.Summary = fmt.Sprintf("Rollback point %s completed with errors", rollbackID)
+		event.Data["error"] = entry.Message
+	}
+	logAudit(entry)
+	notifyEvent(event)
 
 	if success {
 		w.Header().Set("Content-Type", "application/json")
@@ -3205,15 +13328,26 @@ func handleDeleteRollback(w http.ResponseWriter, r *http.Request) {
 	defer rollbackMutex.Unlock()
 
 	if rollback, exists := rollbackHistory[rollbackID]; exists {
+		// Keep the full rollback point in the audit log since the file is about to go
+		entry := requestAudit(r, "rollback.delete", rollbackID)
+		entry.Profile = rollback.Profile
+		entry.Ticket = rollback.ChangeTicket
+		entry.JobID = rollback.JobID
+		entry.Message = rollback.Description
+		entry.Before = auditValue(rollback)
+		if err := recordAudit(entry); err != nil {
+			http.Error(w, "Cannot delete rollback: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		// Delete file
-		filename := fmt.Sprintf("LRCleaner_rollback_%s_%s.json",
-			rollback.Timestamp.Format("20060102_150405"),
-			rollback.OperationType)
-		filepath := filepath.Join(config.Rollback.BackupLocation, filename)
-		os.Remove(filepath)
+		if rollback.filePath != "" {
+			os.Remove(rollback.filePath)
+		}
 
 		// Remove from memory
 		delete(rollbackHistory, rollbackID)
+		log.Printf("Rollback %s deleted by %s", rollbackID, currentUsername(r))
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "success", "message": "Rollback deleted"})
@@ -3222,14 +13356,31 @@ func handleDeleteRollback(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func executeRollback(rollback *RollbackData) bool {
+// executeRollback restores every change in rollback, auditing each one against the request entry
+func executeRollback(p *Profile, rollback *RollbackData, request AuditEntry) bool {
 	log.Printf("Executing rollback: %s", rollback.ID)
 
 	success := true
+	audit := func(action string, id interface{}, ok bool, before, after interface{}) {
+		entry := request
+		entry.Action = action
+		entry.Target = idToString(id)
+		entry.Message = ""
+		entry.Before = auditValue(before)
+		entry.After = auditValue(after)
+		if !ok {
+			entry.Result = auditResultFailure
+		}
+		logAudit(entry)
+	}
 
 	// Rollback log sources
 	for _, logSourceChange := range rollback.LogSourceChanges {
-		if !rollbackLogSource(logSourceChange) {
+		ok := rollbackLogSource(p, logSourceChange)
+		audit("rollback.logsource", logSourceChange.LogSourceID, ok,
+			map[string]interface{}{"name": logSourceChange.CurrentName, "recordStatus": logSourceChange.CurrentStatus},
+			map[string]interface{}{"name": logSourceChange.OriginalName, "recordStatus": logSourceChange.OriginalStatus, "shortDescription": logSourceChange.OriginalShortDescription})
+		if !ok {
 			success = false
 			log.Printf("Failed to rollback log source: %s", logSourceChange.LogSourceID)
 		}
@@ -3237,7 +13388,12 @@ func executeRollback(rollback *RollbackData) bool {
 
 	// Rollback hosts
 	for _, hostChange := range rollback.HostChanges {
-		if !rollbackHost(hostChange) {
+		ok := rollbackHost(p, hostChange)
+		audit("rollback.host", hostChange.HostID, ok,
+			map[string]interface{}{"name": hostChange.CurrentName, "recordStatus": hostChange.CurrentStatus},
+			map[string]interface{}{"name": hostChange.OriginalName, "recordStatus": hostChange.OriginalStatus,
+				"shortDescription": hostChange.OriginalShortDescription, "restoredIdentifiers": hostChange.RetiredIdentifiers})
+		if !ok {
 			success = false
 			log.Printf("Failed to rollback host: %s", hostChange.HostID)
 		}
@@ -3245,7 +13401,11 @@ func executeRollback(rollback *RollbackData) bool {
 
 	// Rollback system monitors
 	for _, systemMonitorChange := range rollback.SystemMonitorChanges {
-		if !rollbackSystemMonitor(systemMonitorChange) {
+		ok := rollbackSystemMonitor(p, systemMonitorChange)
+		audit("rollback.agent", systemMonitorChange.SystemMonitorID, ok,
+			map[string]interface{}{"recordStatus": systemMonitorChange.CurrentStatus, "licenseType": systemMonitorChange.CurrentLicenseType},
+			map[string]interface{}{"recordStatus": systemMonitorChange.OriginalStatus, "licenseType": systemMonitorChange.OriginalLicenseType})
+		if !ok {
 			success = false
 			log.Printf("Failed to rollback system monitor: %s", systemMonitorChange.SystemMonitorID)
 		}
@@ -3260,9 +13420,9 @@ func executeRollback(rollback *RollbackData) bool {
 	return success
 }
 
-func rollbackLogSource(change LogSourceRollback) bool {
+func rollbackLogSource(p *Profile, change LogSourceRollback) bool {
 	// Get current log source data
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", config.Hostname, config.Port, idToString(change.LogSourceID))
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/logsources/%s", p.Hostname, p.Port, idToString(change.LogSourceID))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -3270,7 +13430,7 @@ func rollbackLogSource(change LogSourceRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -3291,13 +13451,11 @@ func rollbackLogSource(change LogSourceRollback) bool {
 		return false
 	}
 
-	// Restore original values
+	// Restore the exact name and status recorded at retirement time
 	logSource["name"] = change.OriginalName
 	logSource["recordStatus"] = change.OriginalStatus
-
-	// Remove "Retired by LRCleaner" suffix if present
-	if strings.Contains(logSource["name"].(string), "Retired by LRCleaner") {
-		logSource["name"] = strings.Replace(logSource["name"].(string), " Retired by LRCleaner", "", 1)
+	if change.OriginalShortDescription != nil {
+		logSource["shortDescription"] = *change.OriginalShortDescription
 	}
 
 	// PUT the updated log source back
@@ -3313,7 +13471,7 @@ func rollbackLogSource(change LogSourceRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err = httpClient.Do(req)
@@ -3332,9 +13490,9 @@ func rollbackLogSource(change LogSourceRollback) bool {
 	}
 }
 
-func rollbackHost(change HostRollback) bool {
+func rollbackHost(p *Profile, change HostRollback) bool {
 	// Get current host data
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", config.Hostname, config.Port, idToString(change.HostID))
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s", p.Hostname, p.Port, idToString(change.HostID))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -3342,7 +13500,7 @@ func rollbackHost(change HostRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -3363,15 +13521,10 @@ func rollbackHost(change HostRollback) bool {
 		return false
 	}
 
-	// Restore original values
+	// Restore the exact name and status recorded at retirement time
 	host["name"] = change.OriginalName
 	host["recordStatusName"] = change.OriginalStatus
 
-	// Remove "Retired by LRCleaner" suffix if present
-	if strings.Contains(host["name"].(string), "Retired by LRCleaner") {
-		host["name"] = strings.Replace(host["name"].(string), " Retired by LRCleaner", "", 1)
-	}
-
 	// Remove hostIdentifiers from the main host update request
 	// We'll add them back using the correct API endpoint after the host is updated
 	delete(host, "hostIdentifiers")
@@ -3388,6 +13541,9 @@ func rollbackHost(change HostRollback) bool {
 	// Ensure we have the required fields with correct values
 	host["name"] = change.OriginalName
 	host["recordStatusName"] = change.OriginalStatus
+	if change.OriginalShortDescription != nil {
+		host["shortDesc"] = *change.OriginalShortDescription
+	}
 
 	// PUT the updated host back
 	jsonData, err := json.Marshal(host)
@@ -3405,7 +13561,7 @@ func rollbackHost(change HostRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err = httpClient.Do(req)
@@ -3420,7 +13576,7 @@ func rollbackHost(change HostRollback) bool {
 
 		// Now restore the retired identifiers using the correct API endpoint
 		if len(change.RetiredIdentifiers) > 0 {
-			if restoreHostIdentifiers(change.HostID, change.RetiredIdentifiers) {
+			if restoreHostIdentifiers(p, change.HostID, change.RetiredIdentifiers) {
 				log.Printf("Successfully restored %d identifiers for host %s", len(change.RetiredIdentifiers), idToString(change.HostID))
 			} else {
 				log.Printf("Failed to restore identifiers for host %s, but host was updated successfully", idToString(change.HostID))
@@ -3442,7 +13598,7 @@ func rollbackHost(change HostRollback) bool {
 }
 
 // restoreHostIdentifiers adds back the retired identifiers to a host
-func restoreHostIdentifiers(hostID interface{}, identifiers []HostIdentifier) bool {
+func restoreHostIdentifiers(p *Profile, hostID interface{}, identifiers []HostIdentifier) bool {
 	if len(identifiers) == 0 {
 		return true // Nothing to restore
 	}
@@ -3467,7 +13623,7 @@ func restoreHostIdentifiers(hostID interface{}, identifiers []HostIdentifier) bo
 	}
 
 	// Use the correct LogRhythm API endpoint for updating host identifiers
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s/identifiers", config.Hostname, config.Port, idToString(hostID))
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/hosts/%s/identifiers", p.Hostname, p.Port, idToString(hostID))
 
 	log.Printf("POST URL: %s", url)
 	log.Printf("POST Payload: %s", string(jsonData))
@@ -3478,7 +13634,7 @@ func restoreHostIdentifiers(hostID interface{}, identifiers []HostIdentifier) bo
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -3502,9 +13658,9 @@ func restoreHostIdentifiers(hostID interface{}, identifiers []HostIdentifier) bo
 	}
 }
 
-func rollbackSystemMonitor(change SystemMonitorRollback) bool {
+func rollbackSystemMonitor(p *Profile, change SystemMonitorRollback) bool {
 	// Get current system monitor data
-	url := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", config.Hostname, config.Port, idToString(change.SystemMonitorID))
+	url := fmt.Sprintf("https://%s:%d/lr-admin-api/agents/%s", p.Hostname, p.Port, idToString(change.SystemMonitorID))
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -3512,7 +13668,7 @@ func rollbackSystemMonitor(change SystemMonitorRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := httpClient.Do(req)
@@ -3550,7 +13706,7 @@ func rollbackSystemMonitor(change SystemMonitorRollback) bool {
 		return false
 	}
 
-	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey())
+	req.Header.Set("Authorization", "Bearer "+GetConfigAPIKey(p.KeyringKey))
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err = httpClient.Do(req)
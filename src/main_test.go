@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/keyring"
+)
+
+// TestMain runs the tests in a scratch directory with default settings and a file
+// keyring, so users.json, audit.log and stored secrets never touch the real ones
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+
+	dir, err := os.MkdirTemp("", "lrcleaner-test")
+	if err != nil {
+		log.Fatalf("Failed to create test directory: %v", err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		log.Fatalf("Failed to enter test directory: %v", err)
+	}
+
+	keyringConfig = keyring.Config{
+		ServiceName:      credentialService,
+		AllowedBackends:  []keyring.BackendType{keyring.FileBackend},
+		FileDir:          filepath.Join(dir, "keyring"),
+		FilePasswordFunc: keyring.FixedStringPrompt("test"),
+	}
+	config = defaultConfig()
+
+	code := m.Run()
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// oidcStandIn is a minimal OpenID Connect provider: discovery, JWKS and a token
+// endpoint that checks the PKCE verifier and returns an ID token it signs itself
+type oidcStandIn struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey // Published in the JWKS as kid "k1"
+
+	signer  *rsa.PrivateKey // Key the ID token is signed with
+	kid     string          // kid in the ID token header
+	claims  func(claims map[string]interface{})
+	issuer  string // Issuer reported by discovery; defaults to the server URL
+	nonce   string // Nonce LRCleaner sent in the authorization request
+	pkce    string // code_challenge LRCleaner sent in the authorization request
+	form    url.Values
+	authKey string // Basic auth password on the token request
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	s := &oidcStandIn{key: key, signer: key, kid: "k1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := s.issuer
+		if issuer == "" {
+			issuer = s.server.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+			"jwks_uri":               s.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.form = r.PostForm
+		_, s.authKey, _ = r.BasicAuth()
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != "test-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != s.pkce {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": s.idToken(t)})
+	})
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+
+	saved := config.Auth.OIDC
+	config.Auth.OIDC = OIDCConfig{
+		Enabled:      true,
+		IssuerURL:    s.server.URL,
+		ClientID:     "lrcleaner",
+		RoleMappings: map[string]string{"LRC-Admins": RoleAdmin, "LRC-Operators": RoleOperator},
+	}
+	t.Cleanup(func() { config.Auth.OIDC = saved })
+	return s
+}
+
+// idToken signs an RS256 ID token for alice, adjusted by s.claims
+func (s *oidcStandIn) idToken(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":                s.server.URL,
+		"aud":                "lrcleaner",
+		"sub":                "0001",
+		"preferred_username": "alice",
+		"groups":             []string{"Staff", "lrc-operators"},
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"nonce":              s.nonce,
+	}
+	if s.claims != nil {
+		s.claims(claims)
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Errorf("SignPKCS1v15: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// authorize starts a sign-in and returns the state cookie LRCleaner set
+func (s *oidcStandIn) authorize(t *testing.T) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleOIDCLogin(rec, httptest.NewRequest("GET", "http://lrcleaner.test/api/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), s.server.URL+"/authorize?") {
+		t.Fatalf("login redirected to %q", rec.Header().Get("Location"))
+	}
+	query := location.Query()
+	if query.Get("client_id") != "lrcleaner" || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", location.RawQuery)
+	}
+	if query.Get("redirect_uri") != "http://lrcleaner.test"+oidcCallbackPath {
+		t.Fatalf("redirect_uri = %q", query.Get("redirect_uri"))
+	}
+	s.nonce = query.Get("nonce")
+	s.pkce = query.Get("code_challenge")
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookieName {
+			if cookie.Value != query.Get("state") {
+				t.Fatalf("state cookie %q does not match state %q", cookie.Value, query.Get("state"))
+			}
+			return cookie
+		}
+	}
+	t.Fatalf("login set no state cookie")
+	return nil
+}
+
+// callback delivers the provider's redirect back to LRCleaner
+func callback(state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://lrcleaner.test"+oidcCallbackPath+"?code=test-code&state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handleOIDCCallback(rec, req)
+	return rec
+}
+
+// ssoError returns the error the callback sent the browser back with, or "" on success
+func ssoError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback returned %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if location.Path != "/" {
+		t.Fatalf("callback redirected to %q", location)
+	}
+	return location.Query().Get("sso_error")
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	s := newOIDCStandIn(t)
+	if err := StoreOIDCClientSecret("client-secret"); err != nil {
+		t.Fatalf("StoreOIDCClientSecret: %v", err)
+	}
+	t.Cleanup(func() { DeleteOIDCClientSecret() })
+
+	cookie := s.authorize(t)
+	rec := callback(cookie.Value, cookie)
+	if message := ssoError(t, rec); message != "" {
+		t.Fatalf("sign-in failed: %s", message)
+	}
+
+	if s.form.Get("client_id") != "lrcleaner" || s.form.Get("redirect_uri") != "http://lrcleaner.test"+oidcCallbackPath {
+		t.Errorf("unexpected token request %v", s.form)
+	}
+	if s.authKey != "client-secret" {
+		t.Errorf("token request authenticated with %q, want the stored client secret", s.authKey)
+	}
+
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			session = c
+		}
+	}
+	if session == nil || session.Value == "" {
+		t.Fatalf("callback set no session cookie")
+	}
+	user := getUser("alice")
+	if user == nil || user.Source != UserSourceOIDC || user.Role != RoleOperator {
+		t.Fatalf("provisioned user = %+v, want an SSO operator", user)
+	}
+
+	// A state is good for one callback only
+	if message := ssoError(t, callback(cookie.Value, cookie)); !strings.Contains(message, "expired") {
+		t.Errorf("replayed state: got %q", message)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(s *oidcStandIn)
+		reason string
+	}{
+		{"wrong nonce", func(s *oidcStandIn) {
+			s.claims = func(c map[string]interface{}) { c["nonce"] = "replayed-nonce" }
+		}, "nonce does not match"},
+		{"wrong audience", func(s *oidcStandIn) {
+			s.claims = func(c map[string]interface{}) { c["aud"] = []string{"another-client"} }
+		}, "not issued for this client"},
+		{"expired", func(s *oidcStandIn) {
+			s.claims = func(c map[string]interface{}) { c["exp"] = time.Now().Add(-oidcClockSkew - time.Minute).Unix() }
+		}, "expired"},
+		{"wrong issuer", func(s *oidcStandIn) {
+			s.claims = func(c map[string]interface{}) { c["iss"] = "https://attacker.example" }
+		}, "issued by"},
+		{"unknown kid", func(s *oidcStandIn) {
+			s.kid = "rotated-away"
+		}, `unknown signing key "rotated-away"`},
+		{"bad signature", func(s *oidcStandIn) {
+			s.signer = otherKey
+		}, "signature is invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newOIDCStandIn(t)
+			s.claims = func(c map[string]interface{}) { c["preferred_username"] = "mallory" }
+			tt.setup(s)
+
+			cookie := s.authorize(t)
+			if message := ssoError(t, callback(cookie.Value, cookie)); !strings.Contains(message, tt.reason) {
+				t.Errorf("got %q, want an error containing %q", message, tt.reason)
+			}
+			if getUser("mallory") != nil {
+				t.Errorf("a rejected token provisioned a user")
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsStateMismatch(t *testing.T) {
+	s := newOIDCStandIn(t)
+	cookie := s.authorize(t)
+
+	if message := ssoError(t, callback(cookie.Value, nil)); !strings.Contains(message, "did not start in this browser") {
+		t.Errorf("missing cookie: got %q", message)
+	}
+	forged := &http.Cookie{Name: oidcStateCookieName, Value: "forged"}
+	if message := ssoError(t, callback("forged", forged)); !strings.Contains(message, "expired") {
+		t.Errorf("unknown state: got %q", message)
+	}
+	if message := ssoError(t, callback(cookie.Value, forged)); !strings.Contains(message, "did not start in this browser") {
+		t.Errorf("state from another browser: got %q", message)
+	}
+}
+
+func TestOIDCSendsPKCEVerifier(t *testing.T) {
+	s := newOIDCStandIn(t)
+	cookie := s.authorize(t)
+
+	oidcMutex.Lock()
+	oidcLogins[cookie.Value].Verifier = "not-the-verifier"
+	oidcMutex.Unlock()
+
+	if message := ssoError(t, callback(cookie.Value, cookie)); !strings.Contains(message, "invalid_grant") {
+		t.Errorf("got %q, want the token endpoint to reject the verifier", message)
+	}
+}
+
+func TestOIDCGroupRoleMapping(t *testing.T) {
+	cfg := OIDCConfig{RoleMappings: map[string]string{"LRC-Admins": RoleAdmin, "LRC-Operators": RoleOperator}}
+	tests := []struct {
+		groups      []string
+		defaultRole string
+		want        string
+	}{
+		{[]string{"lrc-operators"}, "", RoleOperator},
+		{[]string{"LRC-Operators", "lrc-admins"}, "", RoleAdmin},
+		{[]string{"Staff"}, "", ""},
+		{nil, RoleViewer, RoleViewer},
+		{[]string{"LRC-Operators"}, RoleViewer, RoleOperator},
+	}
+	for _, tt := range tests {
+		cfg.DefaultRole = tt.defaultRole
+		if got := mapOIDCRole(cfg, tt.groups); got != tt.want {
+			t.Errorf("mapOIDCRole(%v, default %q) = %q, want %q", tt.groups, tt.defaultRole, got, tt.want)
+		}
+	}
+
+	s := newOIDCStandIn(t)
+	s.claims = func(c map[string]interface{}) {
+		c["preferred_username"] = "bob"
+		c["groups"] = []string{"Staff"}
+	}
+	cookie := s.authorize(t)
+	if message := ssoError(t, callback(cookie.Value, cookie)); !strings.Contains(message, "not in any group") {
+		t.Errorf("unmapped groups: got %q", message)
+	}
+	if getUser("bob") != nil {
+		t.Errorf("a user in no mapped group was provisioned")
+	}
+}
+
+func TestOIDCDiscoveryChecksIssuer(t *testing.T) {
+	s := newOIDCStandIn(t)
+	s.issuer = "https://impostor.example"
+
+	if _, err := getOIDCProvider(config.Auth.OIDC); err == nil || !strings.Contains(err.Error(), "provider reports issuer") {
+		t.Errorf("getOIDCProvider: got %v, want an issuer mismatch", err)
+	}
+}
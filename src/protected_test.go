@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestProtectedObjectsAreNeverChanged runs each retirement call against a stand-in
+// LogRhythm API where everything belongs to a protected host. Every call must be refused
+// with a ProtectionError that is recorded as a protected failure, and the API must see
+// nothing but GET requests.
+func TestProtectedObjectsAreNeverChanged(t *testing.T) {
+	objects := map[string]map[string]interface{}{
+		"/lr-admin-api/hosts/1": {"id": 1, "name": "DC01", "recordStatusName": "Active"},
+		"/lr-admin-api/logsources/2": {"id": 2, "name": "DC01 Security", "recordStatus": "Active",
+			"host": map[string]interface{}{"id": 1, "name": "DC01"}, "systemMonitorId": 3, "systemMonitorName": "DC01"},
+		"/lr-admin-api/agents/3": {"id": 3, "name": "DC01", "recordStatusName": "Active",
+			"host": map[string]interface{}{"id": 1, "name": "DC01"}},
+	}
+	var mu sync.Mutex
+	var changes []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			mu.Lock()
+			changes = append(changes, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+		}
+		object, ok := objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(object)
+	}))
+	defer server.Close()
+
+	savedConfig, savedClient := config, httpClient
+	t.Cleanup(func() { config, httpClient = savedConfig, savedClient; os.Remove(configPath) })
+	config = defaultConfig()
+	config.Protected = []ProtectedEntry{{ID: "dc", Kind: ProtectHost, Match: MatchExact, Pattern: "DC01", Reason: "Domain controller"}}
+	httpClient = server.Client()
+
+	address, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(address.Port())
+	p := &Profile{Name: "Primary", Hostname: address.Hostname(), Port: port}
+
+	const jobID = "protected-test"
+	jobsMutex.Lock()
+	jobs[jobID] = &JobStatus{}
+	jobsMutex.Unlock()
+	t.Cleanup(func() { jobsMutex.Lock(); delete(jobs, jobID); jobsMutex.Unlock() })
+
+	calls := []struct {
+		kind string
+		id   int
+		call func() error
+	}{
+		{ProtectHost, 1, func() error { _, _, err := updateHost(p, 1, RetirementNaming{}); return err }},
+		{ProtectLogSource, 2, func() error { _, err := updateLogSource(p, 2, RetirementNaming{}); return err }},
+		{ProtectAgent, 3, func() error { return unlicenseSystemMonitor(p, 3) }},
+		{ProtectAgent, 3, func() error { return retireSystemMonitor(p, 3) }},
+	}
+	for i, tc := range calls {
+		err := tc.call()
+		var protection *ProtectionError
+		if !errors.As(err, &protection) {
+			t.Errorf("call %d (%s %d): got %v, want a ProtectionError", i+1, tc.kind, tc.id, err)
+			continue
+		}
+		recordRetirementFailure(jobID, tc.kind, tc.id, "", "DC01", err)
+	}
+
+	mu.Lock()
+	if len(changes) > 0 {
+		t.Errorf("protected objects were changed: %v", changes)
+	}
+	mu.Unlock()
+
+	jobsMutex.Lock()
+	failures := jobs[jobID].Failures
+	jobsMutex.Unlock()
+	if len(failures) != len(calls) {
+		t.Fatalf("%d failures recorded, want %d", len(failures), len(calls))
+	}
+	for _, failure := range failures {
+		if !failure.Protected || failure.Name == "" {
+			t.Errorf("failure for %s %s not recorded as protected: %+v", failure.Kind, failure.ID, failure)
+		}
+	}
+}
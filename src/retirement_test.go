@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestValidateRetirementConfigUsesLongestValues(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		length   int
+		valid    bool
+	}{
+		{"{name} Retired by LRCleaner", 100, true},
+		{"[RETIRED {date} {ticket}] {name}", 100, true},
+		{"[RETIRED {date} {ticket}] {name}", 85, false}, // Fits a 10 character ticket, not a 64 character one
+		{"{name} [{operator} {ticket}]", 100, false},
+		{"{name} [{operator} {ticket}]", 200, true},
+		{"{name} {name}", 100, false},
+		{"{name} {unknown}", 100, false},
+	} {
+		err := validateRetirementConfig(RetirementConfig{NameTemplate: test.template, MaxNameLength: test.length})
+		if (err == nil) != test.valid {
+			t.Errorf("%q within %d: error %v, want valid %v", test.template, test.length, err, test.valid)
+		}
+	}
+}
+
+func TestRenderRetiredNameRespectsLimit(t *testing.T) {
+	saved := config.Retirement
+	t.Cleanup(func() { config.Retirement = saved })
+	config.Retirement = RetirementConfig{NameTemplate: "[RETIRED {ticket}] {name}", MaxNameLength: 40}
+	date := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	name, err := renderRetiredName("web01 Syslog", RetirementNaming{Date: date, Ticket: "CHG7"})
+	if err != nil || name != "[RETIRED CHG7] web01 Syslog" {
+		t.Errorf("short name = %q, %v", name, err)
+	}
+
+	name, err = renderRetiredName("ファイアウォール "+strings.Repeat("x", 60), RetirementNaming{Date: date, Ticket: "CHG7"})
+	if err != nil || utf8.RuneCountInString(name) > 40 || !strings.HasPrefix(name, "[RETIRED CHG7] ファイアウォール") || !utf8.ValidString(name) {
+		t.Errorf("long name = %q (%d characters), %v", name, utf8.RuneCountInString(name), err)
+	}
+
+	// A ticket that fills the limit on its own must refuse the object, not drop its name
+	name, err = renderRetiredName("web01 Syslog", RetirementNaming{Date: date, Ticket: strings.Repeat("T", 30)})
+	if err == nil || name != "" {
+		t.Errorf("no room for the name: got %q, %v; want an error", name, err)
+	}
+}
@@ -7,8 +7,19 @@ let hostAnalysis = [];
 let selectedHosts = [];
 let selectedLogSources = [];
 let retirementRecords = [];
+// Retirement changes the execution layer refused (protected objects) or the API rejected
+let retirementFailures = [];
 let collectionHostAnalysis = [];
 let selectedCollectionHosts = [];
+let currentUser = null;
+// Deployment profile used for new analyses and settings; remembered per browser
+let currentProfile = localStorage.getItem('lrcleanerProfile') || '';
+// Deployment profile the displayed results came from; retirements always go there
+let resultsProfile = '';
+// Deployments covered by a cross-deployment analysis; empty for single-deployment results
+let resultsDeployments = [];
+
+const ROLE_RANK = { viewer: 1, operator: 2, admin: 3 };
 
 // Debug: Check if script is loading
 console.log('LRCleaner script loaded - version 2');
@@ -81,10 +92,21 @@ function navigateToSection(navId) {
             // Handle rollback functionality
             handleRollback();
             break;
+        case 'auditNav':
+            // Show the audit log
+            handleAuditLog();
+            break;
         case 'settingsNav':
             // Show settings section
             showSettingsSection();
             break;
+        case 'userNav':
+            // Account settings live in the settings section
+            showSettingsSection();
+            break;
+        case 'logoutNav':
+            logout();
+            break;
         default:
             console.log('Unknown navigation:', navId);
     }
@@ -95,12 +117,14 @@ function showAnalysisSection() {
     const settingsSection = document.getElementById('settingsSection');
     const analysisSection = document.getElementById('analysisSection');
     const rollbackSection = document.getElementById('rollbackSection');
+    const auditSection = document.getElementById('auditSection');
     const controlSection = document.querySelector('.control-section');
     const resultsSection = document.querySelector('.results-section');
     const progressSection = document.getElementById('progressSection');
     
     if (settingsSection) settingsSection.style.display = 'none';
     if (rollbackSection) rollbackSection.style.display = 'none';
+    if (auditSection) auditSection.style.display = 'none';
     if (analysisSection) analysisSection.style.display = 'block';
     if (controlSection) controlSection.style.display = 'block';
     if (resultsSection) resultsSection.style.display = 'block';
@@ -114,11 +138,13 @@ function showSettingsSection() {
     const settingsSection = document.getElementById('settingsSection');
     const analysisSection = document.getElementById('analysisSection');
     const rollbackSection = document.getElementById('rollbackSection');
+    const auditSection = document.getElementById('auditSection');
     const controlSection = document.querySelector('.control-section');
     const resultsSection = document.querySelector('.results-section');
     
     if (analysisSection) analysisSection.style.display = 'none';
     if (rollbackSection) rollbackSection.style.display = 'none';
+    if (auditSection) auditSection.style.display = 'none';
     if (controlSection) controlSection.style.display = 'none';
     if (resultsSection) resultsSection.style.display = 'none';
     if (settingsSection) settingsSection.style.display = 'block';
@@ -131,6 +157,7 @@ function showRollbackSection() {
     const settingsSection = document.getElementById('settingsSection');
     const analysisSection = document.getElementById('analysisSection');
     const rollbackSection = document.getElementById('rollbackSection');
+    const auditSection = document.getElementById('auditSection');
     const controlSection = document.querySelector('.control-section');
     const resultsSection = document.querySelector('.results-section');
     
@@ -138,11 +165,31 @@ function showRollbackSection() {
     if (settingsSection) settingsSection.style.display = 'none';
     if (controlSection) controlSection.style.display = 'none';
     if (resultsSection) resultsSection.style.display = 'none';
+    if (auditSection) auditSection.style.display = 'none';
     if (rollbackSection) rollbackSection.style.display = 'block';
     
     console.log('Showing rollback section');
 }
 
+function showAuditSection() {
+    // Hide other sections and show the audit log
+    const settingsSection = document.getElementById('settingsSection');
+    const analysisSection = document.getElementById('analysisSection');
+    const rollbackSection = document.getElementById('rollbackSection');
+    const auditSection = document.getElementById('auditSection');
+    const controlSection = document.querySelector('.control-section');
+    const resultsSection = document.querySelector('.results-section');
+    
+    if (analysisSection) analysisSection.style.display = 'none';
+    if (settingsSection) settingsSection.style.display = 'none';
+    if (rollbackSection) rollbackSection.style.display = 'none';
+    if (controlSection) controlSection.style.display = 'none';
+    if (resultsSection) resultsSection.style.display = 'none';
+    if (auditSection) auditSection.style.display = 'block';
+    
+    console.log('Showing audit section');
+}
+
 
 function handleRetirement() {
     // TODO: Implement retirement functionality
@@ -150,6 +197,12 @@ function handleRetirement() {
     alert('Retirement functionality will be implemented soon!');
 }
 
+function handleAuditLog() {
+    showAuditSection();
+    loadAuditLog();
+    verifyAuditLog();
+}
+
 function handleRollback() {
     // Show rollback section
     showRollbackSection();
@@ -173,7 +226,7 @@ function handleBackupAcknowledge() {
 function handleBackupSkip() {
     const backupStatus = document.getElementById('backupStatus');
     if (backupStatus) {
-        backupStatus.innerHTML = '<i class="fas fa-exclamation-triangle"></i> Database backup skipped. Proceeding without backup is not recommended.';
+        backupStatus.innerHTML = '<i class="fas fa-exclamation-triangle"></i> Database backup skipped. Proceeding without backup is not recommended, and retirement is refused if the backup policy requires one.';
         backupStatus.className = 'status-message error';
     }
     
@@ -210,16 +263,12 @@ function initializeApp() {
     // Show analysis section by default
     showAnalysisSection();
     
-    // Load current configuration
-    loadConfiguration();
-    
     // Setup event listeners
     console.log('Setting up event listeners...');
     setupEventListeners();
     
-    // Connect to WebSocket
-    console.log('About to connect to WebSocket...');
-    connectWebSocket();
+    // Everything else needs a signed-in user
+    checkSession();
     
     console.log('initializeApp completed');
     
@@ -247,6 +296,22 @@ function setupEventListeners() {
         });
     });
     
+    // Authentication forms
+    const loginForm = document.getElementById('loginForm');
+    if (loginForm) {
+        loginForm.addEventListener('submit', handleLoginSubmit);
+    }
+    
+    const changePasswordForm = document.getElementById('changePasswordForm');
+    if (changePasswordForm) {
+        changePasswordForm.addEventListener('submit', handleChangePasswordSubmit);
+    }
+    
+    const createUserForm = document.getElementById('createUserForm');
+    if (createUserForm) {
+        createUserForm.addEventListener('submit', handleCreateUserSubmit);
+    }
+    
     // Settings button (if it exists)
     const settingsBtn = document.getElementById('settingsBtn');
     if (settingsBtn) {
@@ -345,8 +410,12 @@ function setupEventListeners() {
     if (clearBtn) clearBtn.addEventListener('click', clearResults);
     
     // Test modal
+    const compareDeploymentsBtn = document.getElementById('compareDeploymentsBtn');
+    if (compareDeploymentsBtn) compareDeploymentsBtn.addEventListener('click', openTestModal);
     const startTestBtn = document.getElementById('startTestBtn');
     if (startTestBtn) startTestBtn.addEventListener('click', startTestMode);
+    const testAllProfiles = document.getElementById('testAllProfiles');
+    if (testAllProfiles) testAllProfiles.addEventListener('change', toggleAllTestProfiles);
     
     // Apply modal
     const performBackupBtn = document.getElementById('performBackupBtn');
@@ -362,6 +431,18 @@ function setupEventListeners() {
     const cancelBackupBtn = document.getElementById('cancelBackupBtn');
     if (cancelBackupBtn) cancelBackupBtn.addEventListener('click', closeAllModals);
     
+    // Export modal
+    const exportFormat = document.getElementById('exportFormat');
+    if (exportFormat) exportFormat.addEventListener('change', updateExportForm);
+    const confirmExportBtn = document.getElementById('confirmExportBtn');
+    if (confirmExportBtn) confirmExportBtn.addEventListener('click', confirmExport);
+    const cancelExportBtn = document.getElementById('cancelExportBtn');
+    if (cancelExportBtn) cancelExportBtn.addEventListener('click', closeAllModals);
+    const exportTemplate = document.getElementById('exportTemplate');
+    if (exportTemplate) exportTemplate.addEventListener('change', updateExportTemplateDescription);
+    const previewReportBtn = document.getElementById('previewReportBtn');
+    if (previewReportBtn) previewReportBtn.addEventListener('click', previewReport);
+    
     // Apply config modal
     const startApplyBtn = document.getElementById('startApplyBtn');
     if (startApplyBtn) startApplyBtn.addEventListener('click', startApplyMode);
@@ -382,6 +463,11 @@ function setupEventListeners() {
     const executeRetirementBtn = document.getElementById('executeRetirementBtn');
     if (executeRetirementBtn) executeRetirementBtn.addEventListener('click', executeRetirement);
     
+    const openCasesBtn = document.getElementById('openCasesBtn');
+    if (openCasesBtn) openCasesBtn.addEventListener('click', openTroubleshootingCases);
+    const exportTroubleshootingBtn = document.getElementById('exportTroubleshootingBtn');
+    if (exportTroubleshootingBtn) exportTroubleshootingBtn.addEventListener('click', exportTroubleshootingQueue);
+    
     const cancelRetirementBtn = document.getElementById('cancelRetirementBtn');
     if (cancelRetirementBtn) cancelRetirementBtn.addEventListener('click', cancelRetirement);
     
@@ -407,6 +493,8 @@ function setupEventListeners() {
     
     const hostPingFilter = document.getElementById('hostPingFilter');
     if (hostPingFilter) hostPingFilter.addEventListener('change', filterHostResults);
+    const hostStatusFilter = document.getElementById('hostStatusFilter');
+    if (hostStatusFilter) hostStatusFilter.addEventListener('change', filterHostResults);
     
     const hostLogSourceTypeFilter = document.getElementById('hostLogSourceTypeFilter');
     if (hostLogSourceTypeFilter) hostLogSourceTypeFilter.addEventListener('change', filterHostResults);
@@ -420,6 +508,8 @@ function setupEventListeners() {
     // Congratulations modal
     const exportPDFBtn = document.getElementById('exportPDFBtn');
     if (exportPDFBtn) exportPDFBtn.addEventListener('click', exportPDFReport);
+    const exportReportBtn = document.getElementById('exportReportBtn');
+    if (exportReportBtn) exportReportBtn.addEventListener('click', exportReport);
     
     const undoChangesBtn = document.getElementById('undoChangesBtn');
     if (undoChangesBtn) undoChangesBtn.addEventListener('click', undoChanges);
@@ -512,6 +602,8 @@ function setupEventListeners() {
     
     const pingFilter = document.getElementById('pingFilter');
     if (pingFilter) pingFilter.addEventListener('change', filterResults);
+    const statusFilter = document.getElementById('statusFilter');
+    if (statusFilter) statusFilter.addEventListener('change', filterResults);
     
     const logSourceTypeFilter = document.getElementById('logSourceTypeFilter');
     if (logSourceTypeFilter) logSourceTypeFilter.addEventListener('change', filterResults);
@@ -529,16 +621,129 @@ function setupEventListeners() {
     const cleanupRollbackBtn = document.getElementById('cleanupRollbackBtn');
     if (cleanupRollbackBtn) cleanupRollbackBtn.addEventListener('click', cleanupRollbackHistory);
     
+    const rollbackTicketFilter = document.getElementById('rollbackTicketFilter');
+    if (rollbackTicketFilter) rollbackTicketFilter.addEventListener('change', loadRollbackHistory);
+    
+    // Change details modal
+    const confirmChangeDetailsBtn = document.getElementById('confirmChangeDetailsBtn');
+    if (confirmChangeDetailsBtn) confirmChangeDetailsBtn.addEventListener('click', confirmChangeDetails);
+    
+    const cancelChangeDetailsBtn = document.getElementById('cancelChangeDetailsBtn');
+    if (cancelChangeDetailsBtn) cancelChangeDetailsBtn.addEventListener('click', closeAllModals);
+    
     // Rollback configuration form
     const rollbackConfigForm = document.getElementById('rollbackConfigForm');
     if (rollbackConfigForm) rollbackConfigForm.addEventListener('submit', handleRollbackConfigSubmit);
+    
+    // Retirement naming form
+    const retirementNamingForm = document.getElementById('retirementNamingForm');
+    if (retirementNamingForm) retirementNamingForm.addEventListener('submit', handleRetirementNamingSubmit);
+    
+    // Backup policy form
+    const backupGateForm = document.getElementById('backupGateForm');
+    if (backupGateForm) backupGateForm.addEventListener('submit', handleBackupGateSubmit);
+    
+    // Audit log controls
+    const auditFilterForm = document.getElementById('auditFilterForm');
+    if (auditFilterForm) auditFilterForm.addEventListener('submit', function(e) {
+        e.preventDefault();
+        loadAuditLog();
+    });
+    
+    const verifyAuditBtn = document.getElementById('verifyAuditBtn');
+    if (verifyAuditBtn) verifyAuditBtn.addEventListener('click', verifyAuditLog);
+    
+    const exportAuditCsvBtn = document.getElementById('exportAuditCsvBtn');
+    if (exportAuditCsvBtn) exportAuditCsvBtn.addEventListener('click', () => exportAuditLog('csv'));
+    
+    const exportAuditJsonBtn = document.getElementById('exportAuditJsonBtn');
+    if (exportAuditJsonBtn) exportAuditJsonBtn.addEventListener('click', () => exportAuditLog('json'));
+    
+    // Web server form
+    const serverConfigForm = document.getElementById('serverConfigForm');
+    if (serverConfigForm) serverConfigForm.addEventListener('submit', handleServerConfigSubmit);
+    
+    // Deployment profiles
+    const profileSwitcher = document.getElementById('profileSwitcher');
+    if (profileSwitcher) profileSwitcher.addEventListener('change', handleProfileSwitch);
+    
+    const profileForm = document.getElementById('profileForm');
+    if (profileForm) profileForm.addEventListener('submit', handleProfileFormSubmit);
+    
+    const profileFormReset = document.getElementById('profileFormReset');
+    if (profileFormReset) profileFormReset.addEventListener('click', resetProfileForm);
+    
+    // Exclusion rules
+    const exclusionForm = document.getElementById('exclusionForm');
+    if (exclusionForm) exclusionForm.addEventListener('submit', handleExclusionFormSubmit);
+    const exclusionFormReset = document.getElementById('exclusionFormReset');
+    if (exclusionFormReset) exclusionFormReset.addEventListener('click', resetExclusionForm);
+    const previewExclusionsBtn = document.getElementById('previewExclusionsBtn');
+    if (previewExclusionsBtn) previewExclusionsBtn.addEventListener('click', previewExclusions);
+    const previewExclusionRuleBtn = document.getElementById('previewExclusionRuleBtn');
+    if (previewExclusionRuleBtn) previewExclusionRuleBtn.addEventListener('click', previewExclusionRule);
+    
+    // Database credentials
+    const databaseForm = document.getElementById('databaseForm');
+    if (databaseForm) databaseForm.addEventListener('submit', handleDatabaseFormSubmit);
+    const casesForm = document.getElementById('casesForm');
+    if (casesForm) casesForm.addEventListener('submit', handleCasesFormSubmit);
+    const dbAuthMode = document.getElementById('dbAuthMode');
+    if (dbAuthMode) dbAuthMode.addEventListener('change', updateDatabaseFormFields);
+    const dbEncrypt = document.getElementById('dbEncrypt');
+    if (dbEncrypt) dbEncrypt.addEventListener('change', updateDatabaseFormFields);
+    const clearDbPasswordBtn = document.getElementById('clearDbPasswordBtn');
+    if (clearDbPasswordBtn) clearDbPasswordBtn.addEventListener('click', clearDatabasePassword);
+    
+    // Protected objects
+    const protectedForm = document.getElementById('protectedForm');
+    if (protectedForm) protectedForm.addEventListener('submit', handleProtectedFormSubmit);
+    const protectedFormReset = document.getElementById('protectedFormReset');
+    if (protectedFormReset) protectedFormReset.addEventListener('click', resetProtectedForm);
+    
+    // Webhooks
+    const webhookForm = document.getElementById('webhookForm');
+    if (webhookForm) webhookForm.addEventListener('submit', handleWebhookFormSubmit);
+    const webhookFormReset = document.getElementById('webhookFormReset');
+    if (webhookFormReset) webhookFormReset.addEventListener('click', resetWebhookForm);
+    const refreshWebhookDeliveriesBtn = document.getElementById('refreshWebhookDeliveriesBtn');
+    if (refreshWebhookDeliveriesBtn) refreshWebhookDeliveriesBtn.addEventListener('click', loadWebhookDeliveries);
+    
+    // LogRhythm API TLS form
+    const apiTlsConfigForm = document.getElementById('apiTlsConfigForm');
+    if (apiTlsConfigForm) apiTlsConfigForm.addEventListener('submit', handleAPITLSConfigSubmit);
+    
+    // Single sign-on form
+    const ssoConfigForm = document.getElementById('ssoConfigForm');
+    if (ssoConfigForm) ssoConfigForm.addEventListener('submit', handleSSOConfigSubmit);
+    
+    // Email notifications
+    const emailConfigForm = document.getElementById('emailConfigForm');
+    if (emailConfigForm) emailConfigForm.addEventListener('submit', handleEmailConfigSubmit);
+    const addEmailRecipientsBtn = document.getElementById('addEmailRecipientsBtn');
+    if (addEmailRecipientsBtn) addEmailRecipientsBtn.addEventListener('click', () => addEmailRecipientRow());
+    const sendTestEmailBtn = document.getElementById('sendTestEmailBtn');
+    if (sendTestEmailBtn) sendTestEmailBtn.addEventListener('click', sendTestEmail);
+    
+    // Syslog forwarding
+    const syslogConfigForm = document.getElementById('syslogConfigForm');
+    if (syslogConfigForm) syslogConfigForm.addEventListener('submit', handleSyslogConfigSubmit);
+    const sendTestSyslogBtn = document.getElementById('sendTestSyslogBtn');
+    if (sendTestSyslogBtn) sendTestSyslogBtn.addEventListener('click', sendTestSyslog);
+    const refreshSyslogStatusBtn = document.getElementById('refreshSyslogStatusBtn');
+    if (refreshSyslogStatusBtn) refreshSyslogStatusBtn.addEventListener('click', loadSyslogStatus);
 }
 
 function loadConfiguration() {
     console.log('loadConfiguration called - fetching /api/config');
-    fetch('/api/config')
+    fetch(`/api/config${profileQuery()}`)
         .then(response => {
             console.log('loadConfiguration response status:', response.status);
+            if (response.status === 404 && currentProfile) {
+                // The remembered profile was deleted; fall back to the default
+                setCurrentProfile('');
+                return loadConfiguration();
+            }
             if (!response.ok) {
                 console.log('HTTP error status:', response.status);
                 throw new Error(`HTTP error! status: ${response.status}`);
@@ -552,9 +757,47 @@ function loadConfiguration() {
                 return;
             }
             
+            setCurrentProfile(config.profile);
+            displayProfiles(config.profiles || [], config.profile);
+            displayConfigStatus(config);
+            loadExclusions();
+            loadProtected();
+            if (hasRole('admin')) loadWebhooks();
             document.getElementById('hostname').value = config.hostname || '';
             document.getElementById('port').value = config.port || 8501;
             
+            if (config.retirement) {
+                document.getElementById('retirementNameTemplate').value = config.retirement.nameTemplate || '';
+                document.getElementById('retirementMaxNameLength').value = config.retirement.maxNameLength || 100;
+            }
+            
+            if (config.backupGate) {
+                document.getElementById('backupGateRequired').checked = config.backupGate.required;
+                document.getElementById('backupGateMaxAgeHours').value = config.backupGate.maxAgeHours || 24;
+                document.getElementById('backupGateAllowAttestation').checked = config.backupGate.allowAttestation;
+            }
+            
+            if (config.server) {
+                displayServerConfig(config.server, config.serverCertificate);
+            }
+            
+            if (config.apiTls) {
+                displayAPITLSConfig(config.apiTls);
+            }
+            
+            if (config.auth) {
+                loadedAuthConfig = config.auth;
+                displaySSOConfig(config.auth.oidc || {}, config.hasOidcClientSecret);
+            }
+            
+            if (config.email) {
+                displayEmailConfig(config.email, config.hasSmtpPassword);
+            }
+            
+            if (config.syslog && hasRole('admin')) {
+                displaySyslogConfig(config.syslog);
+            }
+            
             // Handle API key from credential store
             const apiKeyInput = document.getElementById('apiKey');
             const clearApiKeyBtn = document.getElementById('clearApiKeyBtn');
@@ -620,6 +863,7 @@ function handleConfigSubmit(e) {
     
     const formData = new FormData(e.target);
     const config = {
+        profile: currentProfile,
         hostname: formData.get('hostname'),
         apiKey: formData.get('apiKey'),
         port: parseInt(formData.get('port'))
@@ -728,7 +972,7 @@ function handleTestConnection() {
     
     // If user entered a new API key, store it first
     if (config.apiKey && config.apiKey !== '***STORED***') {
-        fetch('/api/api-key', {
+        fetch(`/api/api-key${profileQuery()}`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
@@ -780,6 +1024,7 @@ function testConnectionWithStoredKey(config, originalText) {
             'Content-Type': 'application/json'
         },
         body: JSON.stringify({
+            profile: currentProfile,
             hostname: config.hostname,
             port: config.port
             // No API key in body - will use stored key
@@ -791,6 +1036,7 @@ function testConnectionWithStoredKey(config, originalText) {
     })
     .then(data => {
         console.log('Test connection data:', data);
+        displayCertificateDetails(data.certificate);
         if (data.success) {
             showToast(data.message, 'success');
             // Update status message in the modal
@@ -833,7 +1079,7 @@ function resetTestButton(testBtn, originalText) {
 
 function handleClearApiKey() {
     if (confirm('Are you sure you want to clear the stored API key? This will require you to enter it again.')) {
-        fetch('/api/api-key', {
+        fetch(`/api/api-key${profileQuery()}`, {
             method: 'DELETE',
             headers: {
                 'Content-Type': 'application/json'
@@ -880,7 +1126,7 @@ function handleToggleApiKeyVisibility() {
     
     // If the field shows ***STORED*** and we're trying to show it, fetch the actual API key
     if (apiKeyInput.value === '***STORED***' && isHidden) {
-        fetch('/api/api-key/value')
+        fetch(`/api/api-key/value${profileQuery()}`)
             .then(response => response.json())
             .then(data => {
                 if (data.apiKey) {
@@ -928,12 +1174,16 @@ function showMainContent() {
     document.getElementById('mainContent').style.display = 'block';
     const settingsSection = document.getElementById('settingsSection');
     const rollbackSection = document.getElementById('rollbackSection');
+    const auditSection = document.getElementById('auditSection');
     if (settingsSection) {
         settingsSection.style.display = 'none';
     }
     if (rollbackSection) {
         rollbackSection.style.display = 'none';
     }
+    if (auditSection) {
+        auditSection.style.display = 'none';
+    }
     console.log('Main content should now be visible');
 }
 
@@ -948,11 +1198,13 @@ function showSettingsSection() {
         // Hide other sections
         const analysisSection = document.getElementById('analysisSection');
         const rollbackSection = document.getElementById('rollbackSection');
+        const auditSection = document.getElementById('auditSection');
         const controlSection = document.querySelector('.control-section');
         const resultsSection = document.querySelector('.results-section');
         
         if (analysisSection) analysisSection.style.display = 'none';
         if (rollbackSection) rollbackSection.style.display = 'none';
+        if (auditSection) auditSection.style.display = 'none';
         if (controlSection) controlSection.style.display = 'none';
         if (resultsSection) resultsSection.style.display = 'none';
     } else {
@@ -962,6 +1214,10 @@ function showSettingsSection() {
 
 
 function openTestModal() {
+    renderTestProfiles();
+    const testDate = document.getElementById('testDate');
+    const mainDate = document.getElementById('mainDate');
+    if (testDate && mainDate && !testDate.value) testDate.value = mainDate.value;
     document.getElementById('testModal').style.display = 'block';
 }
 
@@ -1003,6 +1259,12 @@ function startTestMode() {
         return;
     }
     
+    const profiles = selectedTestProfiles();
+    if (profiles.length === 0) {
+        showToast('Please select at least one deployment', 'error');
+        return;
+    }
+    
     closeAllModals();
     hideHostSelectionControls();
     showLoadingOverlay();
@@ -1013,24 +1275,28 @@ function startTestMode() {
         headers: {
             'Content-Type': 'application/json'
         },
-        body: JSON.stringify({ date: date })
+        body: JSON.stringify({ date: date, profiles: profiles })
     })
     .then(response => {
         console.log('Response received:', response.status, response.statusText);
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
         return response.json();
     })
     .then(data => {
         console.log('Response data:', data);
         currentJobId = data.jobId;
+        resultsProfile = profiles.length === 1 ? profiles[0] : '';
         console.log('Current job ID set to:', currentJobId);
         showProgressSection();
         hideLoadingOverlay();
-        showToast('Analysis started', 'success');
+        showToast(profiles.length > 1 ? `Analysis started across ${profiles.length} deployments` : 'Analysis started', 'success');
     })
     .catch(error => {
         console.error('Error starting test mode:', error);
         hideLoadingOverlay();
-        showToast('Error starting analysis', 'error');
+        showToast(error.message || 'Error starting analysis', 'error');
     });
 }
 
@@ -1050,11 +1316,17 @@ function startApplyMode() {
         headers: {
             'Content-Type': 'application/json'
         },
-        body: JSON.stringify({ date: date })
+        body: JSON.stringify({ date: date, profile: currentProfile })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
     })
-    .then(response => response.json())
     .then(data => {
         currentJobId = data.jobId;
+        resultsProfile = currentProfile;
         showProgressSection();
         hideLoadingOverlay();
         showToast('Host analysis started', 'success');
@@ -1062,7 +1334,7 @@ function startApplyMode() {
     .catch(error => {
         console.error('Error starting apply mode:', error);
         hideLoadingOverlay();
-        showToast('Error starting host analysis', 'error');
+        showToast(error.message || 'Error starting host analysis', 'error');
     });
 }
 
@@ -1072,50 +1344,170 @@ function handleApply() {
     showToast('Apply functionality will be implemented soon!', 'info');
 }
 
+// Log source columns an export can carry, in export order
+const exportColumnOptions = [
+    { key: 'deployment', label: 'Deployment' },
+    { key: 'logSourceId', label: 'Log Source ID' },
+    { key: 'logSourceName', label: 'Log Source Name' },
+    { key: 'logSourceType', label: 'Log Source Type' },
+    { key: 'recordStatus', label: 'Record Status' },
+    { key: 'maxLogDate', label: 'Max Log Date' },
+    { key: 'entity', label: 'Entity' },
+    { key: 'hostId', label: 'Host ID' },
+    { key: 'hostName', label: 'Host Name' },
+    { key: 'pingResult', label: 'Ping Result' },
+    { key: 'systemMonitorId', label: 'System Monitor ID' },
+    { key: 'systemMonitorName', label: 'System Monitor' },
+    { key: 'recommended', label: 'Recommended' }
+];
+
 function handleExport() {
-    console.log('handleExport called');
-    console.log('currentJobId:', currentJobId);
-    
     if (!currentJobId) {
-        console.log('No currentJobId, showing warning');
         showToast('No results to export', 'warning');
         return;
     }
     
-    console.log('Creating download link for job:', currentJobId);
-    const link = document.createElement('a');
-    link.href = `/api/export/${currentJobId}`;
-    link.download = `lrcleaner_results_${currentJobId}.csv`;
-    console.log('Download URL:', link.href);
-    document.body.appendChild(link);
-    link.click();
-    document.body.removeChild(link);
+    const container = document.getElementById('exportColumns');
+    if (!container.children.length) {
+        exportColumnOptions.forEach(column => {
+            const label = document.createElement('label');
+            label.className = 'checkbox-label';
+            label.innerHTML = `<input type="checkbox" value="${column.key}" checked><span class="checkmark"></span> ${column.label}`;
+            container.appendChild(label);
+        });
+    }
+    updateExportForm();
+    loadReportTemplates();
     
-    // Also try a direct fetch to see what the server returns
-    fetch(`/api/export/${currentJobId}`)
-        .then(response => {
-            console.log('Export response status:', response.status);
-            console.log('Export response headers:', response.headers);
-            if (!response.ok) {
-                console.error('Export failed:', response.status, response.statusText);
-                return response.text().then(text => {
-                    console.error('Error response body:', text);
-                });
-            }
-            return response.text().then(text => {
-                console.log('Export response preview:', text.substring(0, 200));
-            });
-        })
-        .catch(error => {
-            console.error('Export request failed:', error);
+    closeAllModals();
+    document.getElementById('exportModal').style.display = 'block';
+}
+
+let reportTemplates = [];
+
+function loadReportTemplates() {
+    fetch('/api/report-templates')
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(data => {
+        reportTemplates = data.templates || [];
+        const select = document.getElementById('exportTemplate');
+        const selected = select.value || data.defaultTemplate;
+        select.innerHTML = '';
+        reportTemplates.forEach(template => {
+            const option = document.createElement('option');
+            option.value = template.name;
+            option.textContent = `${template.name} (${template.format})${template.error ? ' - unavailable' : ''}`;
+            option.disabled = !!template.error;
+            select.appendChild(option);
         });
+        select.value = selected;
+        updateExportTemplateDescription();
+    })
+    .catch(error => showToast(`Failed to load report templates: ${error.message}`, 'error'));
+}
+
+function updateExportTemplateDescription() {
+    const name = document.getElementById('exportTemplate').value;
+    const template = reportTemplates.find(t => t.name === name);
+    document.getElementById('exportTemplateDescription').textContent = template ? (template.error || template.description || '') : '';
+}
+
+// reportURL is the export URL of the current job rendered with the chosen template
+function reportURL(preview) {
+    const params = new URLSearchParams();
+    const template = document.getElementById('exportTemplate').value;
+    if (template) params.set('template', template);
+    if (preview) params.set('preview', '1');
+    return `/api/export/html/${currentJobId}?${params}`;
+}
+
+function previewReport() {
+    if (!currentJobId) {
+        showToast('No results to preview', 'warning');
+        return;
+    }
+    window.open(reportURL(true), '_blank');
+}
+
+function updateExportForm() {
+    const format = document.getElementById('exportFormat').value;
+    document.getElementById('exportSheetGroup').style.display = format === 'csv' ? '' : 'none';
+    // A report always holds the whole job; the template decides what to show
+    document.getElementById('exportColumnsGroup').style.display = format === 'html' ? 'none' : '';
+    document.getElementById('exportFiltersGroup').style.display = format === 'html' ? 'none' : '';
+    document.getElementById('exportTemplateGroup').style.display = format === 'html' ? '' : 'none';
+    document.getElementById('previewReportBtn').style.display = format === 'html' ? '' : 'none';
+}
+
+// exportFilterParams reads the filters of whichever results view is showing
+function exportFilterParams() {
+    const hostView = allResults.length === 0 && hostAnalysis && hostAnalysis.length > 0;
+    const value = id => document.getElementById(hostView ? 'host' + id.charAt(0).toUpperCase() + id.slice(1) : id).value;
+    const params = {
+        q: value('searchInput').trim(),
+        ping: value('pingFilter'),
+        status: value('statusFilter'),
+        type: value('logSourceTypeFilter'),
+        host: value('logSourceNameFilter')
+    };
+    return Object.fromEntries(Object.entries(params).filter(([, v]) => v));
+}
+
+function confirmExport() {
+    const format = document.getElementById('exportFormat').value;
+    const columns = Array.from(document.querySelectorAll('#exportColumns input:checked')).map(input => input.value);
+    if (format !== 'html' && columns.length === 0) {
+        showToast('Choose at least one column', 'error');
+        return;
+    }
+    
+    const params = new URLSearchParams({ format: format });
+    if (format === 'csv') {
+        params.set('sheet', document.getElementById('exportSheet').value);
+    }
+    if (columns.length < exportColumnOptions.length) {
+        params.set('columns', columns.join(','));
+    }
+    if (document.getElementById('exportApplyFilters').checked) {
+        Object.entries(exportFilterParams()).forEach(([key, value]) => params.set(key, value));
+    }
     
-    showToast('Export started', 'success');
+    const url = format === 'html' ? reportURL(false) : `/api/export/${currentJobId}?${params}`;
+    fetch(url)
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.blob().then(blob => ({ blob, response }));
+    })
+    .then(({ blob, response }) => {
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename="([^"]+)"/);
+        const link = document.createElement('a');
+        link.href = URL.createObjectURL(blob);
+        link.download = match ? match[1] : `lrcleaner_results_${currentJobId}.${format}`;
+        document.body.appendChild(link);
+        link.click();
+        document.body.removeChild(link);
+        URL.revokeObjectURL(link.href);
+        closeAllModals();
+        showToast('Export downloaded', 'success');
+    })
+    .catch(error => showToast(`Export failed: ${error.message}`, 'error'));
 }
 
 function clearResults() {
     allResults = [];
+    resultsDeployments = [];
     updateResultsTable();
+    displayDeploymentSummaries(null);
+    displayDataSource(null);
+    displayStatusCounts(null);
     currentJobId = null;
     hideProgressSection();
     showToast('Results cleared', 'success');
@@ -1268,6 +1660,10 @@ function executeCollectionHostRetirement() {
         return;
     }
     
+    openChangeDetailsModal(submitCollectionHostRetirement);
+}
+
+function submitCollectionHostRetirement(changeDetails) {
     closeAllModals();
     showLoadingOverlay();
     
@@ -1277,10 +1673,18 @@ function executeCollectionHostRetirement() {
             'Content-Type': 'application/json'
         },
         body: JSON.stringify({
-            selectedCollectionHosts: selectedCollectionHosts
+            profile: resultsProfile,
+            selectedCollectionHosts: selectedCollectionHosts,
+            changeTicket: changeDetails.changeTicket,
+            justification: changeDetails.justification
         })
     })
-    .then(response => response.json())
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
+    })
     .then(data => {
         hideLoadingOverlay();
         showToast(data.message || 'Collection host retirement initiated', 'success');
@@ -1288,10 +1692,104 @@ function executeCollectionHostRetirement() {
     .catch(error => {
         hideLoadingOverlay();
         console.error('Error retiring collection hosts:', error);
-        showToast('Error retiring collection hosts', 'error');
+        showToast(error.message || 'Error retiring collection hosts', 'error');
     });
 }
 
+// Change Details Functions
+let pendingChangeDetailsCallback = null;
+
+let pendingBackupGate = null;
+
+// openChangeDetailsModal asks for the change ticket and justification. With backupProfile it
+// also shows whether that deployment passes the backup policy and takes an attestation.
+function openChangeDetailsModal(callback, backupProfile) {
+    pendingChangeDetailsCallback = callback;
+    pendingBackupGate = null;
+    closeAllModals();
+    
+    const backupSection = document.getElementById('changeBackupSection');
+    backupSection.style.display = backupProfile ? '' : 'none';
+    document.getElementById('backupReference').value = '';
+    document.getElementById('backupAttestationNote').value = '';
+    if (backupProfile) {
+        loadBackupGate(backupProfile);
+    }
+    
+    document.getElementById('changeDetailsModal').style.display = 'block';
+    document.getElementById('changeTicket').focus();
+}
+
+function loadBackupGate(profile) {
+    const status = document.getElementById('changeBackupStatus');
+    const attestationFields = document.getElementById('changeAttestationFields');
+    status.className = 'status-message';
+    status.textContent = 'Checking backup policy...';
+    attestationFields.style.display = 'none';
+    
+    fetch(`/api/backup/gate?profile=${encodeURIComponent(profile)}`)
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(gate => {
+        pendingBackupGate = gate;
+        const backup = gate.evidence && gate.evidence.backup;
+        if (backup) {
+            const files = backup.files.map(file => file.path).join(', ');
+            status.className = 'status-message success';
+            status.innerHTML = `<i class="fas fa-check-circle"></i> Verified backup ${backup.id} from ${formatDate(backup.endTime)} will be linked: ${files}`;
+            return;
+        }
+        status.className = gate.satisfied ? 'status-message' : 'status-message error';
+        status.textContent = gate.satisfied
+            ? `No verified LRCleaner backup in the last ${gate.policy.maxAgeHours} hours. A backup is not required, but you can record one taken elsewhere.`
+            : gate.error;
+        attestationFields.style.display = (!gate.policy.required || gate.policy.allowAttestation) ? '' : 'none';
+    })
+    .catch(error => {
+        status.className = 'status-message error';
+        status.textContent = `Could not check the backup policy: ${error.message}`;
+    });
+}
+
+function confirmChangeDetails() {
+    const changeTicket = document.getElementById('changeTicket').value.trim();
+    const justification = document.getElementById('changeJustification').value.trim();
+    const backupReference = document.getElementById('backupReference').value.trim();
+    
+    if (!changeTicket) {
+        showToast('Please enter a change ticket ID', 'error');
+        return;
+    }
+    if (!justification) {
+        showToast('Please enter a justification', 'error');
+        return;
+    }
+    if (pendingBackupGate && !pendingBackupGate.satisfied && !backupReference) {
+        showToast(pendingBackupGate.policy.allowAttestation
+            ? 'Back up the database first or enter a backup reference'
+            : 'Back up the database from LRCleaner before retiring', 'error');
+        return;
+    }
+    
+    const details = { changeTicket: changeTicket, justification: justification };
+    if (backupReference) {
+        details.backupAttestation = {
+            reference: backupReference,
+            note: document.getElementById('backupAttestationNote').value.trim()
+        };
+    }
+    
+    const callback = pendingChangeDetailsCallback;
+    pendingChangeDetailsCallback = null;
+    if (callback) {
+        callback(details);
+    }
+}
+
 // Apply Mode Host Management Functions
 function toggleApplyHostDetails(hostId) {
     const detailsRow = document.getElementById(hostId);
@@ -1337,6 +1835,7 @@ function populateHostFilterDropdowns(uniqueLogSourceTypes, uniqueLogSourceNames)
 function filterHostResults() {
     const searchTerm = document.getElementById('hostSearchInput').value.toLowerCase();
     const pingFilter = document.getElementById('hostPingFilter').value;
+    const statusFilter = document.getElementById('hostStatusFilter').value;
     const logSourceTypeFilter = document.getElementById('hostLogSourceTypeFilter').value;
     const logSourceNameFilter = document.getElementById('hostLogSourceNameFilter').value;
     
@@ -1372,7 +1871,7 @@ function filterHostResults() {
                 }
             }
             
-            const shouldShow = matchesSearch && matchesPing && matchesLogSourceType && matchesLogSourceName;
+            const shouldShow = matchesSearch && matchesPing && matchesStatus(item, statusFilter) && matchesLogSourceType && matchesLogSourceName;
             item.style.display = shouldShow ? '' : 'none';
             
             // Also hide/show the corresponding details row
@@ -1394,6 +1893,7 @@ function filterHostResults() {
 function clearHostFilters() {
     document.getElementById('hostSearchInput').value = '';
     document.getElementById('hostPingFilter').value = '';
+    document.getElementById('hostStatusFilter').value = '';
     document.getElementById('hostLogSourceTypeFilter').value = '';
     document.getElementById('hostLogSourceNameFilter').value = '';
     filterHostResults();
@@ -1433,8 +1933,12 @@ function connectWebSocket() {
     
     websocket.onclose = function() {
         console.log('WebSocket disconnected');
-        // Reconnect after 5 seconds
-        setTimeout(connectWebSocket, 5000);
+        // Reconnect after 5 seconds while still signed in
+        setTimeout(function() {
+            if (currentUser) {
+                connectWebSocket();
+            }
+        }, 5000);
     };
     
     websocket.onerror = function(error) {
@@ -1462,6 +1966,11 @@ function updateJobProgress(job) {
             progressFill.style.width = `${job.progress}%`;
         }
         
+        resultsDeployments = job.profiles || [];
+        displayDeploymentSummaries(job.deployments);
+        displayDataSource(job.dataSource);
+        displayStatusCounts(job.statusCounts);
+        
         if (job.results) {
             console.log('Job has results:', job.results.length, 'items');
             allResults = job.results;
@@ -1493,8 +2002,9 @@ function updateJobProgress(job) {
             }
         }
         
-        if (job.retirementRecords) {
-            retirementRecords = job.retirementRecords;
+        if (job.retirementRecords || job.failures) {
+            retirementRecords = job.retirementRecords || [];
+            retirementFailures = job.failures || [];
             if (job.status === 'completed') {
                 showCongratulationsModal();
             }
@@ -1505,6 +2015,7 @@ function updateJobProgress(job) {
                 jobStatus.textContent = `Completed at ${new Date(job.endTime).toLocaleString()}`;
             }
             document.getElementById('exportBtn').disabled = false;
+            document.getElementById('exportTroubleshootingBtn').disabled = false;
         } else if (job.status === 'error') {
             if (jobStatus) {
                 jobStatus.textContent = `Error: ${job.error}`;
@@ -1534,16 +2045,23 @@ function updateResultsTable() {
     const uniqueLogSourceTypes = new Set();
     const uniqueLogSourceNames = new Set();
     
+    // Host names can repeat across deployments, so cross-deployment results group per deployment
+    const multiDeployment = resultsDeployments.length > 1;
     allResults.forEach(result => {
-        if (!hostGroups[result.hostName]) {
-            hostGroups[result.hostName] = {
+        const groupKey = multiDeployment ? `${result.profile}|${result.hostName}` : result.hostName;
+        if (!hostGroups[groupKey]) {
+            hostGroups[groupKey] = {
                 hostName: result.hostName,
+                profile: result.profile || '',
                 pingResult: result.pingResult,
                 hostId: result.hostId,
                 logSources: []
             };
         }
-        hostGroups[result.hostName].logSources.push(result);
+        hostGroups[groupKey].logSources.push(result);
+        if (!hostGroups[groupKey].pingResult && result.pingResult) {
+            hostGroups[groupKey].pingResult = result.pingResult;
+        }
         
         // Collect unique values for filters
         if (result.logSourceType) {
@@ -1567,15 +2085,19 @@ function updateResultsTable() {
         const isExpanded = false;
         
         // Create host summary row
+        const status = groupStatus(hostGroup.logSources);
         const summaryRow = document.createElement('tr');
         summaryRow.className = 'host-summary-row';
+        summaryRow.setAttribute('data-statuses', rowStatuses(status, hostGroup.logSources));
         summaryRow.innerHTML = `
             <td colspan="5" class="host-summary-cell">
                 <div class="host-summary-content" onclick="toggleHostDetails('${hostId}')">
                     <span class="expand-icon" id="icon-${hostId}">▶</span>
+                    ${multiDeployment ? `<span class="deployment-name">${hostGroup.profile}</span>` : ''}
                     <span class="host-name">${hostGroup.hostName}</span>
                     <span class="ping-status ping-${(hostGroup.pingResult || 'unknown').toLowerCase()}">${hostGroup.pingResult || 'Unknown'}</span>
                     <span class="log-source-count">${hostGroup.logSources.length} log source${hostGroup.logSources.length !== 1 ? 's' : ''}</span>
+                    ${statusBadge(status, '')}
                 </div>
             </td>
         `;
@@ -1596,21 +2118,25 @@ function updateResultsTable() {
         detailsTable.innerHTML = `
             <thead>
                 <tr>
+                    ${multiDeployment ? '<th>Deployment</th>' : ''}
                     <th>Log Source ID</th>
                     <th>Log Source Name</th>
                     <th>Log Source Type</th>
                     <th>Last Log Message</th>
                     <th>Ping Result</th>
+                    <th>Status</th>
                 </tr>
             </thead>
             <tbody>
                 ${hostGroup.logSources.map(source => `
                     <tr>
+                        ${multiDeployment ? `<td class="deployment-name-cell">${source.profile || ''}</td>` : ''}
                         <td class="log-source-id">${source.id}</td>
                         <td class="log-source-name">${source.name || 'N/A'}</td>
                         <td class="log-source-type">${source.logSourceType?.name || source.logSourceType || 'N/A'}</td>
                         <td class="last-log-date">${formatDate(source.maxLogDate)}</td>
                         <td class="ping-result ping-${(hostGroup.pingResult || 'unknown').toLowerCase()}">${hostGroup.pingResult || 'Unknown'}</td>
+                        ${statusCell(source)}
                     </tr>
                 `).join('')}
             </tbody>
@@ -1626,6 +2152,7 @@ function updateResultsTable() {
 function filterResults() {
     const searchTerm = document.getElementById('searchInput').value.toLowerCase();
     const pingFilter = document.getElementById('pingFilter').value;
+    const statusFilter = document.getElementById('statusFilter').value;
     const logSourceTypeFilter = document.getElementById('logSourceTypeFilter').value;
     const logSourceNameFilter = document.getElementById('logSourceNameFilter').value;
     
@@ -1640,10 +2167,11 @@ function filterResults() {
         // Handle host summary rows (expandable rows)
         if (row.classList.contains('host-summary-row')) {
             const hostName = row.querySelector('.host-name')?.textContent || '';
+            const deploymentName = row.querySelector('.deployment-name')?.textContent || '';
             const pingStatus = row.querySelector('.ping-status')?.textContent || '';
             const logSourceCount = row.querySelector('.log-source-count')?.textContent.toLowerCase() || '';
             
-            const matchesSearch = hostName.toLowerCase().includes(searchTerm) || logSourceCount.includes(searchTerm);
+            const matchesSearch = hostName.toLowerCase().includes(searchTerm) || deploymentName.toLowerCase().includes(searchTerm) || logSourceCount.includes(searchTerm);
             const matchesPing = !pingFilter || pingStatus === pingFilter;
             
             // For host name filter, directly compare with the host name in the row
@@ -1663,7 +2191,7 @@ function filterResults() {
                 }
             }
             
-            const shouldShow = matchesSearch && matchesPing && matchesLogSourceType && matchesLogSourceName;
+            const shouldShow = matchesSearch && matchesPing && matchesStatus(row, statusFilter) && matchesLogSourceType && matchesLogSourceName;
             row.style.display = shouldShow ? '' : 'none';
             
             // Also hide/show the corresponding details row
@@ -1722,6 +2250,7 @@ function getHostGroupByHostId(hostId) {
 function clearFilters() {
     document.getElementById('searchInput').value = '';
     document.getElementById('pingFilter').value = '';
+    document.getElementById('statusFilter').value = '';
     document.getElementById('logSourceTypeFilter').value = '';
     document.getElementById('logSourceNameFilter').value = '';
     filterResults();
@@ -1803,6 +2332,26 @@ function openApplyModal() {
 
 function openBackupModal() {
     closeAllModals();
+    
+    // Show how the backup will sign in; a stored password makes typing one optional
+    const profile = loadedProfiles.find(p => p.name === (resultsProfile || currentProfile));
+    const database = profileDatabase(profile);
+    const windowsAuth = database.authMode === 'windows';
+    document.getElementById('backupServer').textContent = databaseServerName(database);
+    document.getElementById('backupDatabases').textContent = database.backup.databases.join(', ');
+    const locationInput = document.getElementById('backupLocation');
+    locationInput.value = '';
+    locationInput.placeholder = `Default: ${database.backup.directory}`;
+    document.getElementById('backupSignIn').textContent = windowsAuth
+        ? 'Windows integrated authentication'
+        : database.username;
+    document.getElementById('backupPasswordGroup').style.display = windowsAuth ? 'none' : '';
+    const passwordInput = document.getElementById('backupPassword');
+    passwordInput.value = '';
+    passwordInput.placeholder = profile && profile.hasDbPassword
+        ? 'Leave blank to use the stored password'
+        : `Enter the password for ${database.username}`;
+    
     document.getElementById('backupModal').style.display = 'block';
 }
 
@@ -1819,9 +2368,12 @@ function backToBackupModal() {
 function executeBackup() {
     const password = document.getElementById('backupPassword').value;
     const location = document.getElementById('backupLocation').value;
+    const profileName = resultsProfile || currentProfile;
+    const profile = loadedProfiles.find(p => p.name === profileName);
+    const database = profileDatabase(profile);
     
-    if (!password) {
-        showToast('Please enter the password for logrhythmadmin', 'error');
+    if (!password && database.authMode === 'sql' && !(profile && profile.hasDbPassword)) {
+        showToast(`Please enter the password for ${database.username}`, 'error');
         return;
     }
     
@@ -1834,14 +2386,21 @@ function executeBackup() {
             'Content-Type': 'application/json'
         },
         body: JSON.stringify({ 
+            profile: profileName,
             password: password,
-            location: location || 'C:\\LogRhythm\\Backup'
+            location: location
         })
     })
-    .then(response => response.json())
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
     .then(data => {
         if (data.success) {
-            showToast('Database backup completed successfully', 'success');
+            const files = data.backup.files.map(file => file.path).join(', ');
+            showToast(`${data.message}: ${files}`, 'success');
             openApplyConfigModal();
         } else {
             showToast(data.error || 'Backup failed', 'error');
@@ -1851,7 +2410,7 @@ function executeBackup() {
     .catch(error => {
         console.error('Error performing backup:', error);
         hideLoadingOverlay();
-        showToast('Error performing database backup', 'error');
+        showToast(`Error performing database backup: ${error.message}`, 'error');
     });
 }
 
@@ -1926,13 +2485,14 @@ function updateResultsTableForApplyMode() {
     // Create expandable rows for each host
     hostAnalysis.forEach((host, index) => {
         const hostId = `apply-host-${index}`;
-        const isRecommended = host.recommended;
         const isSelected = selectedHosts.includes(host.hostId);
         
         // Create host summary row
+        const excluded = host.excludedLogSources || [];
         const summaryRow = document.createElement('tr');
         summaryRow.className = 'host-summary-row';
         summaryRow.setAttribute('data-host-id', host.hostId);
+        summaryRow.setAttribute('data-statuses', rowStatuses(host.status, host.logSources.concat(excluded)));
         summaryRow.innerHTML = `
             <td class="host-checkbox-cell">
                 <label class="checkbox-label">
@@ -1946,9 +2506,10 @@ function updateResultsTableForApplyMode() {
                     <span class="expand-icon" id="icon-${hostId}">▶</span>
                     <span class="host-name">${host.hostName}</span>
                     <span class="ping-status ping-${(host.pingResult || 'unknown').toLowerCase()}">${host.pingResult || 'Unknown'}</span>
-                    <span class="log-source-count">${host.logSourceCount} log source${host.logSourceCount !== 1 ? 's' : ''}</span>
+                    <span class="log-source-count">${host.logSourceCount} log source${host.logSourceCount !== 1 ? 's' : ''}${excluded.length ? ` + ${excluded.length} excluded` : ''}</span>
                     <span class="max-log-date">Last log: ${formatDate(host.maxLogDate)}</span>
-                    ${isRecommended ? '<span class="recommended-badge">Recommended</span>' : ''}
+                    ${statusBadge(host.status, host.statusReason)}
+                    ${caseBadge(host)}
                 </div>
             </td>
         `;
@@ -1975,6 +2536,7 @@ function updateResultsTableForApplyMode() {
                     <th>Log Source Type</th>
                     <th>Last Log Message</th>
                     <th>Ping Result</th>
+                    <th>Status</th>
                 </tr>
             </thead>
             <tbody>
@@ -1994,6 +2556,18 @@ function updateResultsTableForApplyMode() {
                             '<span style="color: #ff6b6b; font-weight: bold;">NEVER RECEIVED LOGS</span>' : 
                             formatDate(source.maxLogDate)}</td>
                         <td class="ping-result ping-${(host.pingResult || 'unknown').toLowerCase()}">${host.pingResult || 'Unknown'}</td>
+                        ${statusCell(source)}
+                    </tr>
+                `).join('')}
+                ${excluded.map(source => `
+                    <tr class="excluded-log-source">
+                        <td></td>
+                        <td class="log-source-id">${source.id}</td>
+                        <td class="log-source-name">${source.name || 'N/A'}</td>
+                        <td class="log-source-type">${source.logSourceType?.name || 'N/A'}</td>
+                        <td class="last-log-date">${formatDate(source.maxLogDate)}</td>
+                        <td class="ping-result ping-${(host.pingResult || 'unknown').toLowerCase()}">${host.pingResult || 'Unknown'}</td>
+                        ${statusCell(source)}
                     </tr>
                 `).join('')}
             </tbody>
@@ -2035,13 +2609,14 @@ function populateHostList() {
     // Create expandable rows for each host
     hostAnalysis.forEach((host, index) => {
         const hostId = `apply-host-${index}`;
-        const isRecommended = host.recommended;
         const isSelected = selectedHosts.includes(host.hostId);
         
         // Create host summary row
+        const excluded = host.excludedLogSources || [];
         const summaryRow = document.createElement('div');
         summaryRow.className = 'host-item host-summary-row';
         summaryRow.setAttribute('data-host-id', host.hostId);
+        summaryRow.setAttribute('data-statuses', rowStatuses(host.status, host.logSources.concat(excluded)));
         summaryRow.innerHTML = `
             <div class="host-info">
                 <label class="checkbox-label">
@@ -2054,9 +2629,10 @@ function populateHostList() {
                         <span class="expand-icon" id="apply-icon-${hostId}">▶</span>
                         <span class="host-name">${host.hostName}</span>
                         <span class="ping-status ping-${(host.pingResult || 'unknown').toLowerCase()}">${host.pingResult || 'Unknown'}</span>
-                        <span class="log-source-count">${host.logSourceCount} log source${host.logSourceCount !== 1 ? 's' : ''}</span>
+                        <span class="log-source-count">${host.logSourceCount} log source${host.logSourceCount !== 1 ? 's' : ''}${excluded.length ? ` + ${excluded.length} excluded` : ''}</span>
                         <span class="max-log-date">Last log: ${formatDate(host.maxLogDate)}</span>
-                        ${isRecommended ? '<span class="recommended-badge">Recommended</span>' : ''}
+                        ${statusBadge(host.status, host.statusReason)}
+                        ${caseBadge(host)}
                 </div>
                 </div>
             </div>
@@ -2087,16 +2663,18 @@ function populateHostList() {
                     <th>Log Source Type</th>
                     <th>Last Log Message</th>
                     <th>Ping Result</th>
+                    <th>Status</th>
                 </tr>
             </thead>
             <tbody>
-                ${host.logSources.map(source => `
+                ${host.logSources.concat(excluded).map(source => `
                     <tr>
                         <td class="log-source-id">${source.id}</td>
                         <td class="log-source-name">${source.name || 'N/A'}</td>
                         <td class="log-source-type">${source.logSourceType?.name || source.logSourceType || 'N/A'}</td>
                         <td class="last-log-date">${formatDate(source.maxLogDate)}</td>
                         <td class="ping-result ping-${(host.pingResult || 'unknown').toLowerCase()}">${host.pingResult || 'Unknown'}</td>
+                        ${statusCell(source)}
                     </tr>
                 `).join('')}
             </tbody>
@@ -2221,6 +2799,7 @@ function updateHostSummary() {
     
     const totalHosts = hostAnalysis.length;
     const recommendedHosts = hostAnalysis.filter(h => h.recommended).length;
+    const troubleshootHosts = hostAnalysis.filter(needsTroubleshooting).length;
     const totalLogSources = selectedHosts.reduce((total, hostId) => {
         const host = hostAnalysis.find(h => String(h.hostId) === String(hostId));
         return total + (host ? host.logSourceCount : 0);
@@ -2229,6 +2808,7 @@ function updateHostSummary() {
     summary.innerHTML = `
         <strong>Summary:</strong> ${totalHosts} total hosts | 
         ${recommendedHosts} recommended | 
+        ${troubleshootHosts} need troubleshooting | 
         ${selectedHosts.length} hosts selected | 
         ${selectedLogSources.length} log sources selected | 
         ${totalLogSources + selectedLogSources.length} total items will be retired
@@ -2383,6 +2963,10 @@ function executeRetirement() {
         return;
     }
     
+    openChangeDetailsModal(submitRetirement, resultsProfile);
+}
+
+function submitRetirement(changeDetails) {
     closeAllModals();
     showLoadingOverlay();
     
@@ -2391,9 +2975,20 @@ function executeRetirement() {
         headers: {
             'Content-Type': 'application/json'
         },
-        body: JSON.stringify({ selectedHosts: selectedHosts })
+        body: JSON.stringify({
+            profile: resultsProfile,
+            selectedHosts: selectedHosts,
+            changeTicket: changeDetails.changeTicket,
+            justification: changeDetails.justification,
+            backupAttestation: changeDetails.backupAttestation
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
     })
-    .then(response => response.json())
     .then(data => {
         currentJobId = data.jobId;
         showProgressSection();
@@ -2403,7 +2998,7 @@ function executeRetirement() {
     .catch(error => {
         console.error('Error executing retirement:', error);
         hideLoadingOverlay();
-        showToast('Error starting retirement process', 'error');
+        showToast(error.message || 'Error starting retirement process', 'error');
     });
 }
 
@@ -2454,6 +3049,24 @@ function populateRetirementSummary() {
             </ul>
         </div>
     `;
+    
+    if (retirementFailures.length > 0) {
+        const refused = retirementFailures.filter(f => f.protected).length;
+        const failures = document.createElement('div');
+        failures.className = 'retirement-failures';
+        const heading = document.createElement('h6');
+        heading.textContent = `Not Retired: ${refused} refused as protected, ${retirementFailures.length - refused} failed`;
+        failures.appendChild(heading);
+        const list = document.createElement('ul');
+        retirementFailures.forEach(failure => {
+            const item = document.createElement('li');
+            if (failure.protected) item.className = 'failure-protected';
+            item.textContent = failure.error;
+            list.appendChild(item);
+        });
+        failures.appendChild(list);
+        summary.appendChild(failures);
+    }
 }
 
 function exportReport() {
@@ -2465,7 +3078,7 @@ function exportReport() {
     // Create download link
     const link = document.createElement('a');
     link.href = `/api/export/pdf/${currentJobId}`;
-    link.download = `LRCleaner_Report_${currentJobId}.txt`;
+    link.download = `LRCleaner_Report_${currentJobId}.pdf`;
     document.body.appendChild(link);
     link.click();
     document.body.removeChild(link);
@@ -2478,7 +3091,11 @@ function exportReport() {
 function loadRollbackHistory() {
     console.log('Loading rollback history...');
     
-    fetch('/api/rollback/history')
+    const ticketFilter = document.getElementById('rollbackTicketFilter');
+    const ticket = ticketFilter ? ticketFilter.value.trim() : '';
+    const url = ticket ? `/api/rollback/history?ticket=${encodeURIComponent(ticket)}` : '/api/rollback/history';
+    
+    fetch(url)
         .then(response => response.json())
         .then(history => {
             console.log('Rollback history loaded:', history);
@@ -2516,6 +3133,18 @@ function displayRollbackHistory(history) {
                         <span class="rollback-user">
                             <i class="fas fa-user"></i> ${rollback.user}
                         </span>
+                        ${rollback.profile ? `<span class="rollback-profile" title="Deployment profile">
+                            <i class="fas fa-server"></i> ${rollback.profile}
+                        </span>` : ''}
+                        ${rollback.changeTicket ? `<span class="rollback-ticket" title="${rollback.justification || ''}">
+                            <i class="fas fa-ticket-alt"></i> ${rollback.changeTicket}
+                        </span>` : ''}
+                        ${rollback.backupLocation ? `<span class="rollback-backup" title="Verified database backup taken before this change">
+                            <i class="fas fa-database"></i> ${rollback.backupLocation}
+                        </span>` : ''}
+                        ${rollback.backupAttestation ? `<span class="rollback-backup" title="Backup attested by ${rollback.backupAttestation.attestedBy}${rollback.backupAttestation.note ? ': ' + rollback.backupAttestation.note : ''}">
+                            <i class="fas fa-user-check"></i> ${rollback.backupAttestation.reference}
+                        </span>` : ''}
                     </div>
                 </div>
                 <div class="rollback-stats">
@@ -2743,4 +3372,2334 @@ function handleRollbackConfigSubmit(e) {
     });
 }
 
+function handleRetirementNamingSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const formData = new FormData(e.target);
+    const retirement = {
+        nameTemplate: formData.get('retirementNameTemplate'),
+        maxNameLength: parseInt(formData.get('retirementMaxNameLength'))
+    };
+    
+    if (!retirement.nameTemplate || !retirement.nameTemplate.includes('{name}')) {
+        showToast('Name template must include {name}', 'error');
+        return;
+    }
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            retirement: retirement
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
+    })
+    .then(data => {
+        console.log('Retirement naming saved:', data);
+        showToast('Retirement naming saved successfully!', 'success');
+    })
+    .catch(error => {
+        console.error('Error saving retirement naming:', error);
+        showToast(error.message || 'Error saving retirement naming', 'error');
+    });
+}
+
+function handleBackupGateSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const backupGate = {
+        required: document.getElementById('backupGateRequired').checked,
+        maxAgeHours: parseInt(document.getElementById('backupGateMaxAgeHours').value, 10),
+        allowAttestation: document.getElementById('backupGateAllowAttestation').checked
+    };
+    
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            backupGate: backupGate
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => showToast('Backup policy saved', 'success'))
+    .catch(error => showToast(`Failed to save backup policy: ${error.message}`, 'error'));
+}
+
+// Authentication Functions
+
+// Wrap fetch so an expired session sends the user back to the sign-in screen
+const originalFetch = window.fetch.bind(window);
+window.fetch = function(input, init) {
+    return originalFetch(input, init).then(response => {
+        const url = typeof input === 'string' ? input : input.url;
+        if (response.status === 401 && !url.includes('/api/auth/')) {
+            showLogin('Your session has expired. Please sign in again.');
+        } else if (response.status === 403 && !url.includes('/api/auth/')) {
+            showToast('You do not have permission to perform this action', 'error');
+        }
+        return response;
+    });
+};
+
+function hasRole(role) {
+    return currentUser !== null && (ROLE_RANK[currentUser.role] || 0) >= (ROLE_RANK[role] || 0);
+}
+
+function checkSession() {
+    loadAuthProviders();
+    
+    // Errors from the single sign-on callback arrive as a query parameter
+    const params = new URLSearchParams(window.location.search);
+    const ssoError = params.get('sso_error');
+    if (ssoError) {
+        window.history.replaceState(null, '', window.location.pathname);
+    }
+    
+    fetch('/api/auth/me')
+        .then(response => response.ok ? response.json() : null)
+        .then(user => {
+            if (user) {
+                startSession(user);
+            } else {
+                showLogin(ssoError ? `Single sign-on failed: ${ssoError}` : '');
+            }
+        })
+        .catch(error => {
+            console.error('Error checking session:', error);
+            showLogin('Unable to reach the LRCleaner server');
+        });
+}
+
+function startSession(user) {
+    currentUser = user;
+    
+    const loginOverlay = document.getElementById('loginOverlay');
+    if (loginOverlay) loginOverlay.style.display = 'none';
+    
+    const currentUserLabel = document.getElementById('currentUserLabel');
+    if (currentUserLabel) {
+        currentUserLabel.textContent = `${user.username} (${user.role})`;
+    }
+    
+    // Single sign-on users have no local password to change
+    const isSSOUser = user.source === 'oidc';
+    const changePasswordForm = document.getElementById('changePasswordForm');
+    if (changePasswordForm) changePasswordForm.style.display = isSSOUser ? 'none' : 'block';
+    const ssoAccountNote = document.getElementById('ssoAccountNote');
+    if (ssoAccountNote) ssoAccountNote.style.display = isSSOUser ? 'block' : 'none';
+    
+    applyRoleVisibility();
+    loadConfiguration();
+    if (hasRole('admin')) {
+        loadUsers();
+    }
+    
+    if (!websocket || websocket.readyState === WebSocket.CLOSED) {
+        connectWebSocket();
+    }
+}
+
+function showLogin(message) {
+    currentUser = null;
+    
+    if (websocket) {
+        websocket.close();
+    }
+    
+    const loginStatus = document.getElementById('loginStatus');
+    if (loginStatus) {
+        loginStatus.textContent = message;
+        loginStatus.className = message ? 'status-message error' : 'status-message';
+    }
+    
+    const loginOverlay = document.getElementById('loginOverlay');
+    if (loginOverlay) loginOverlay.style.display = 'flex';
+    
+    const loginUsername = document.getElementById('loginUsername');
+    if (loginUsername) loginUsername.focus();
+}
+
+// Hide controls the signed-in role cannot use; the server enforces the same rules
+function applyRoleVisibility() {
+    document.querySelectorAll('[data-min-role]').forEach(element => {
+        element.classList.toggle('role-hidden', !hasRole(element.dataset.minRole));
+    });
+}
+
+function handleLoginSubmit(e) {
+    e.preventDefault();
+    
+    const username = document.getElementById('loginUsername').value.trim();
+    const passwordInput = document.getElementById('loginPassword');
+    
+    fetch('/api/auth/login', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username: username, password: passwordInput.value })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(user => {
+        passwordInput.value = '';
+        startSession(user);
+        showAnalysisSection();
+    })
+    .catch(error => {
+        const loginStatus = document.getElementById('loginStatus');
+        if (loginStatus) {
+            loginStatus.textContent = error.message || 'Sign in failed';
+            loginStatus.className = 'status-message error';
+        }
+    });
+}
+
+function logout() {
+    fetch('/api/auth/logout', { method: 'POST' })
+        .catch(error => console.error('Error signing out:', error))
+        .finally(() => showLogin(''));
+}
+
+function handleChangePasswordSubmit(e) {
+    e.preventDefault();
+    
+    const currentPassword = document.getElementById('currentPassword');
+    const newPassword = document.getElementById('newPassword');
+    
+    fetch('/api/auth/password', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            currentPassword: currentPassword.value,
+            newPassword: newPassword.value
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        currentPassword.value = '';
+        newPassword.value = '';
+        showToast('Password changed', 'success');
+    })
+    .catch(error => showToast(`Failed to change password: ${error.message}`, 'error'));
+}
+
+// User Management Functions
+
+function loadUsers() {
+    fetch('/api/users')
+        .then(response => {
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}`);
+            }
+            return response.json();
+        })
+        .then(displayUsers)
+        .catch(error => console.error('Error loading users:', error));
+}
+
+function displayUsers(users) {
+    const usersBody = document.getElementById('usersBody');
+    if (!usersBody) return;
+    
+    usersBody.innerHTML = '';
+    users.forEach(user => {
+        const row = document.createElement('tr');
+        const lastLogin = user.lastLogin && !user.lastLogin.startsWith('0001')
+            ? new Date(user.lastLogin).toLocaleString()
+            : 'Never';
+        
+        const nameCell = document.createElement('td');
+        nameCell.textContent = user.username;
+        
+        const roleCell = document.createElement('td');
+        const roleSelect = document.createElement('select');
+        ['viewer', 'operator', 'admin'].forEach(role => {
+            const option = document.createElement('option');
+            option.value = role;
+            option.textContent = role.charAt(0).toUpperCase() + role.slice(1);
+            option.selected = role === user.role;
+            roleSelect.appendChild(option);
+        });
+        roleSelect.addEventListener('change', () => updateUser(user.username, { role: roleSelect.value }));
+        roleCell.appendChild(roleSelect);
+        
+        const sourceCell = document.createElement('td');
+        sourceCell.textContent = user.source === 'oidc' ? 'SSO' : 'Local';
+        
+        const statusCell = document.createElement('td');
+        statusCell.textContent = user.disabled ? 'Disabled' : 'Active';
+        
+        const lastLoginCell = document.createElement('td');
+        lastLoginCell.textContent = lastLogin;
+        
+        const actionsCell = document.createElement('td');
+        const toggleBtn = document.createElement('button');
+        toggleBtn.className = 'btn btn-secondary btn-sm';
+        toggleBtn.textContent = user.disabled ? 'Enable' : 'Disable';
+        toggleBtn.addEventListener('click', () => updateUser(user.username, { disabled: !user.disabled }));
+        
+        const resetBtn = document.createElement('button');
+        resetBtn.className = 'btn btn-secondary btn-sm';
+        resetBtn.textContent = 'Reset Password';
+        resetBtn.disabled = user.source === 'oidc';
+        resetBtn.addEventListener('click', () => {
+            const password = prompt(`New password for ${user.username} (at least 12 characters):`);
+            if (password) {
+                updateUser(user.username, { password: password });
+            }
+        });
+        
+        const deleteBtn = document.createElement('button');
+        deleteBtn.className = 'btn btn-danger btn-sm';
+        deleteBtn.textContent = 'Delete';
+        deleteBtn.addEventListener('click', () => deleteUser(user.username));
+        
+        actionsCell.append(toggleBtn, ' ', resetBtn, ' ', deleteBtn);
+        row.append(nameCell, roleCell, sourceCell, statusCell, lastLoginCell, actionsCell);
+        usersBody.appendChild(row);
+    });
+}
+
+function handleCreateUserSubmit(e) {
+    e.preventDefault();
+    
+    const usernameInput = document.getElementById('newUserUsername');
+    const passwordInput = document.getElementById('newUserPassword');
+    const roleSelect = document.getElementById('newUserRole');
+    
+    fetch('/api/users', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+            username: usernameInput.value.trim(),
+            password: passwordInput.value,
+            role: roleSelect.value
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        usernameInput.value = '';
+        passwordInput.value = '';
+        showToast('User created', 'success');
+        loadUsers();
+    })
+    .catch(error => showToast(`Failed to create user: ${error.message}`, 'error'));
+}
+
+function updateUser(username, changes) {
+    fetch(`/api/users/${encodeURIComponent(username)}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(changes)
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        showToast(`User ${username} updated`, 'success');
+    })
+    .catch(error => showToast(`Failed to update user: ${error.message}`, 'error'))
+    .finally(loadUsers);
+}
+
+function deleteUser(username) {
+    if (!confirm(`Delete user ${username}? This cannot be undone.`)) {
+        return;
+    }
+    
+    fetch(`/api/users/${encodeURIComponent(username)}`, { method: 'DELETE' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            showToast(`User ${username} deleted`, 'success');
+            loadUsers();
+        })
+        .catch(error => showToast(`Failed to delete user: ${error.message}`, 'error'));
+}
+
+
+// Web Server Functions
+
+function displayServerConfig(server, certificate) {
+    const tls = server.tls || {};
+    document.getElementById('serverBindAddress').value = server.bindAddress || '';
+    document.getElementById('serverTlsEnabled').checked = !!tls.enabled;
+    document.getElementById('serverCertFile').value = tls.certFile || '';
+    document.getElementById('serverKeyFile').value = tls.keyFile || '';
+    document.getElementById('serverHstsMaxAge').value = tls.hstsMaxAge || 0;
+    
+    const info = document.getElementById('serverCertificateInfo');
+    if (!info) return;
+    if (certificate) {
+        const expires = new Date(certificate.notAfter).toLocaleDateString();
+        const kind = certificate.selfSigned ? 'Self-signed certificate' : 'Certificate';
+        info.textContent = `${kind} ${certificate.subject}, expires ${expires}. SHA-256 fingerprint: ${certificate.fingerprint}`;
+        info.className = 'status-message success';
+    } else {
+        info.textContent = 'This server is running without HTTPS. The API key and passwords are sent in clear text.';
+        info.className = 'status-message error';
+    }
+}
+
+function handleServerConfigSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const server = {
+        bindAddress: document.getElementById('serverBindAddress').value.trim(),
+        tls: {
+            enabled: document.getElementById('serverTlsEnabled').checked,
+            certFile: document.getElementById('serverCertFile').value.trim(),
+            keyFile: document.getElementById('serverKeyFile').value.trim(),
+            hstsMaxAge: parseInt(document.getElementById('serverHstsMaxAge').value) || 0
+        }
+    };
+    
+    if (!server.tls.enabled && !confirm('Disabling HTTPS sends the API key and passwords in clear text. Continue?')) {
+        return;
+    }
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            server: server
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('Server settings saved. Restart LRCleaner to apply them.', 'success');
+    })
+    .catch(error => {
+        console.error('Error saving server settings:', error);
+        showToast(error.message || 'Error saving server settings', 'error');
+    });
+}
+
+// Deployment Profile Functions
+
+function profileQuery() {
+    return currentProfile ? `?profile=${encodeURIComponent(currentProfile)}` : '';
+}
+
+function setCurrentProfile(name) {
+    currentProfile = name || '';
+    if (currentProfile) {
+        localStorage.setItem('lrcleanerProfile', currentProfile);
+    } else {
+        localStorage.removeItem('lrcleanerProfile');
+    }
+    const label = document.getElementById('configProfileName');
+    if (label) label.textContent = currentProfile;
+}
+
+// Last profile list from the server, used to fill the edit form
+let loadedProfiles = [];
+
+function displayProfiles(profiles, selected) {
+    loadedProfiles = profiles;
+    
+    const switcher = document.getElementById('profileSwitcher');
+    if (switcher) {
+        switcher.innerHTML = '';
+        profiles.forEach(profile => {
+            const option = document.createElement('option');
+            option.value = profile.name;
+            option.textContent = profile.default ? `${profile.name} (default)` : profile.name;
+            option.selected = profile.name === selected;
+            switcher.appendChild(option);
+        });
+    }
+    
+    const tbody = document.querySelector('#profilesTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    profiles.forEach(profile => {
+        const row = document.createElement('tr');
+        [
+            profile.default ? `${profile.name} (default)` : profile.name,
+            `${profile.hostname}:${profile.port}`,
+            profile.hasApiKey ? 'Stored' : 'Missing',
+            profile.rollbackDir || ''
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        
+        const actions = document.createElement('td');
+        const editBtn = document.createElement('button');
+        editBtn.className = 'btn btn-secondary btn-sm';
+        editBtn.innerHTML = '<i class="fas fa-edit"></i> Edit';
+        editBtn.addEventListener('click', () => editProfile(profile.name));
+        actions.appendChild(editBtn);
+        if (profiles.length > 1) {
+            const deleteBtn = document.createElement('button');
+            deleteBtn.className = 'btn btn-danger btn-sm';
+            deleteBtn.innerHTML = '<i class="fas fa-trash"></i> Delete';
+            deleteBtn.addEventListener('click', () => deleteProfile(profile.name));
+            actions.appendChild(deleteBtn);
+        }
+        row.appendChild(actions);
+        tbody.appendChild(row);
+    });
+    
+    displayDatabaseCredentials();
+    displayCaseSettings();
+}
+
+function handleProfileSwitch(e) {
+    setCurrentProfile(e.target.value);
+    showToast(`Switched to deployment ${currentProfile}`, 'info');
+    loadConfiguration();
+}
+
+function editProfile(name) {
+    const profile = loadedProfiles.find(p => p.name === name);
+    if (!profile) return;
+    
+    const nameInput = document.getElementById('profileName');
+    nameInput.value = profile.name;
+    nameInput.disabled = true;
+    document.getElementById('profileHostname').value = profile.hostname;
+    document.getElementById('profilePort').value = profile.port;
+    document.getElementById('profileRollbackDir').value = profile.rollbackDir || '';
+    document.getElementById('profileDefault').checked = profile.default;
+    // API keys are managed in the Configuration card once a profile exists
+    document.getElementById('profileApiKeyGroup').style.display = 'none';
+}
+
+function resetProfileForm() {
+    document.getElementById('profileForm').reset();
+    document.getElementById('profileName').disabled = false;
+    document.getElementById('profileApiKeyGroup').style.display = '';
+}
+
+function handleProfileFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const nameInput = document.getElementById('profileName');
+    const editing = nameInput.disabled;
+    const name = nameInput.value.trim();
+    const profile = {
+        hostname: document.getElementById('profileHostname').value.trim(),
+        port: parseInt(document.getElementById('profilePort').value),
+        rollbackDir: document.getElementById('profileRollbackDir').value.trim(),
+        default: document.getElementById('profileDefault').checked
+    };
+    if (!editing) {
+        profile.name = name;
+        profile.apiKey = document.getElementById('profileApiKey').value.trim();
+    }
+    
+    fetch(editing ? `/api/profiles/${encodeURIComponent(name)}` : '/api/profiles', {
+        method: editing ? 'PUT' : 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(profile)
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast(editing ? `Profile ${name} updated` : `Profile ${name} created`, 'success');
+        resetProfileForm();
+        loadConfiguration();
+    })
+    .catch(error => showToast(`Failed to save profile: ${error.message}`, 'error'));
+}
+
+function deleteProfile(name) {
+    if (!confirm(`Delete deployment profile ${name} and its stored API key? Its rollback points stay on disk.`)) {
+        return;
+    }
+    
+    fetch(`/api/profiles/${encodeURIComponent(name)}`, { method: 'DELETE' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            showToast(`Profile ${name} deleted`, 'success');
+            if (currentProfile === name) {
+                setCurrentProfile('');
+            }
+            loadConfiguration();
+        })
+        .catch(error => showToast(`Failed to delete profile: ${error.message}`, 'error'));
+}
+
+// Exclusion Rule Functions
+
+let loadedExclusions = [];
+
+function loadExclusions() {
+    fetch('/api/exclusions')
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(rules => displayExclusions(rules || [], {}))
+        .catch(error => console.error('Error loading exclusion rules:', error));
+}
+
+function exclusionExpired(rule) {
+    return rule.expiresAt && new Date(rule.expiresAt) <= new Date();
+}
+
+// displayExclusions lists the rules that apply to the current deployment; counts maps
+// rule IDs to their preview results once "Count Excluded Sources" has run
+function displayExclusions(rules, counts) {
+    loadedExclusions = rules;
+    
+    const profileSelect = document.getElementById('exclusionProfile');
+    if (profileSelect) {
+        const selected = profileSelect.value;
+        profileSelect.innerHTML = '<option value="">All deployments</option>';
+        loadedProfiles.forEach(profile => {
+            const option = document.createElement('option');
+            option.value = profile.name;
+            option.textContent = profile.name;
+            profileSelect.appendChild(option);
+        });
+        profileSelect.value = selected;
+    }
+    
+    const tbody = document.querySelector('#exclusionsTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    const visible = rules.filter(rule => !rule.profile || rule.profile === currentProfile);
+    if (visible.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="8" class="no-results">No exclusion rules apply to this deployment.</td></tr>';
+        return;
+    }
+    
+    visible.forEach(rule => {
+        const row = document.createElement('tr');
+        if (exclusionExpired(rule)) row.className = 'exclusion-expired';
+        const preview = counts[rule.id];
+        let excludes = '';
+        if (preview) {
+            excludes = preview.expired ? 'Expired' : String(preview.count);
+        }
+        [
+            rule.profile || 'All deployments',
+            rule.field,
+            rule.match,
+            rule.pattern,
+            rule.reason || '',
+            rule.expiresAt ? `${new Date(rule.expiresAt).toLocaleDateString()}${exclusionExpired(rule) ? ' (expired)' : ''}` : 'Never',
+            excludes
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        if (preview && preview.examples) {
+            row.lastChild.title = preview.examples.join('\n');
+        }
+        
+        const actions = document.createElement('td');
+        if (hasRole('admin')) {
+            const editBtn = document.createElement('button');
+            editBtn.className = 'btn btn-secondary btn-sm';
+            editBtn.innerHTML = '<i class="fas fa-edit"></i> Edit';
+            editBtn.addEventListener('click', () => editExclusion(rule.id));
+            actions.appendChild(editBtn);
+            const deleteBtn = document.createElement('button');
+            deleteBtn.className = 'btn btn-danger btn-sm';
+            deleteBtn.innerHTML = '<i class="fas fa-trash"></i> Delete';
+            deleteBtn.addEventListener('click', () => deleteExclusion(rule.id));
+            actions.appendChild(deleteBtn);
+        }
+        row.appendChild(actions);
+        tbody.appendChild(row);
+    });
+}
+
+function readExclusionForm() {
+    const expires = document.getElementById('exclusionExpires').value;
+    return {
+        profile: document.getElementById('exclusionProfile').value,
+        field: document.getElementById('exclusionField').value,
+        match: document.getElementById('exclusionMatch').value,
+        pattern: document.getElementById('exclusionPattern').value.trim(),
+        reason: document.getElementById('exclusionReason').value.trim(),
+        // The rule stays in force through the chosen day
+        expiresAt: expires ? new Date(`${expires}T23:59:59`).toISOString() : null
+    };
+}
+
+function editExclusion(id) {
+    const rule = loadedExclusions.find(r => r.id === id);
+    if (!rule) return;
+    
+    document.getElementById('exclusionFormTitle').textContent = 'Edit Rule';
+    document.getElementById('exclusionId').value = rule.id;
+    document.getElementById('exclusionProfile').value = rule.profile || '';
+    document.getElementById('exclusionField').value = rule.field;
+    document.getElementById('exclusionMatch').value = rule.match;
+    document.getElementById('exclusionPattern').value = rule.pattern;
+    document.getElementById('exclusionReason').value = rule.reason || '';
+    const expires = rule.expiresAt ? new Date(rule.expiresAt) : null;
+    document.getElementById('exclusionExpires').value = expires
+        ? `${expires.getFullYear()}-${String(expires.getMonth() + 1).padStart(2, '0')}-${String(expires.getDate()).padStart(2, '0')}`
+        : '';
+    document.getElementById('exclusionRulePreview').textContent = '';
+}
+
+function resetExclusionForm() {
+    document.getElementById('exclusionForm').reset();
+    document.getElementById('exclusionId').value = '';
+    document.getElementById('exclusionFormTitle').textContent = 'New Rule';
+    document.getElementById('exclusionRulePreview').textContent = '';
+}
+
+function handleExclusionFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const id = document.getElementById('exclusionId').value;
+    fetch(id ? `/api/exclusions/${encodeURIComponent(id)}` : '/api/exclusions', {
+        method: id ? 'PUT' : 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(readExclusionForm())
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast(id ? 'Exclusion rule updated' : 'Exclusion rule created', 'success');
+        resetExclusionForm();
+        loadExclusions();
+    })
+    .catch(error => showToast(`Failed to save exclusion rule: ${error.message}`, 'error'));
+}
+
+function deleteExclusion(id) {
+    const rule = loadedExclusions.find(r => r.id === id);
+    if (!rule || !confirm(`Delete the exclusion rule for ${rule.field} ${rule.match} "${rule.pattern}"? Matching log sources will appear in analysis again.`)) {
+        return;
+    }
+    
+    fetch(`/api/exclusions/${encodeURIComponent(id)}`, { method: 'DELETE' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(() => {
+            showToast('Exclusion rule deleted', 'success');
+            loadExclusions();
+        })
+        .catch(error => showToast(`Failed to delete exclusion rule: ${error.message}`, 'error'));
+}
+
+function requestExclusionPreview(rule) {
+    return fetch('/api/exclusions/preview', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({ profile: currentProfile, rule: rule })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    });
+}
+
+function previewExclusions() {
+    const summary = document.getElementById('exclusionPreviewSummary');
+    summary.textContent = 'Counting...';
+    requestExclusionPreview(null)
+        .then(data => {
+            const counts = {};
+            (data.rules || []).forEach(preview => { counts[preview.ruleId] = preview; });
+            displayExclusions(loadedExclusions, counts);
+            summary.textContent = `${data.logSources} active log sources in ${data.profile}`;
+        })
+        .catch(error => {
+            summary.textContent = '';
+            showToast(`Failed to count excluded sources: ${error.message}`, 'error');
+        });
+}
+
+function previewExclusionRule() {
+    const target = document.getElementById('exclusionRulePreview');
+    target.textContent = 'Counting...';
+    requestExclusionPreview(readExclusionForm())
+        .then(data => {
+            const preview = (data.rules || [])[0] || { count: 0 };
+            let text = preview.expired
+                ? 'This rule has expired and excludes nothing.'
+                : `Would exclude ${preview.count} of ${data.logSources} active log sources in ${data.profile}.`;
+            if (preview.examples && preview.examples.length > 0) {
+                text += ` For example: ${preview.examples.join(', ')}`;
+            }
+            target.textContent = text;
+        })
+        .catch(error => {
+            target.textContent = '';
+            showToast(`Failed to preview rule: ${error.message}`, 'error');
+        });
+}
+
+// Database Credential Functions
+
+// displayDatabaseCredentials fills the credentials form for the current deployment
+function displayDatabaseCredentials() {
+    const form = document.getElementById('databaseForm');
+    const profile = loadedProfiles.find(p => p.name === currentProfile);
+    if (!form || !profile) return;
+    
+    const database = profileDatabase(profile);
+    const backup = database.backup;
+    document.getElementById('databaseProfileName').textContent = profile.name;
+    document.getElementById('dbServer').value = database.server || 'localhost';
+    document.getElementById('dbAnalysisSource').value = database.analysisSource || 'api';
+    document.getElementById('dbInstance').value = database.instance || '';
+    document.getElementById('dbPort').value = database.port || '';
+    document.getElementById('dbEncrypt').value = database.encrypt;
+    document.getElementById('dbCAFile').value = database.caFile || '';
+    document.getElementById('dbTrustServerCertificate').checked = !!database.trustServerCertificate;
+    document.getElementById('dbAuthMode').value = database.authMode;
+    document.getElementById('dbUsername').value = database.username || '';
+    document.getElementById('dbPassword').value = '';
+    document.getElementById('dbPasswordStatus').textContent = profile.hasDbPassword
+        ? 'A password is stored in the OS credential store.'
+        : 'No password stored; backups will ask for one.';
+    document.getElementById('dbBackupAlarms').checked = backup.databases.includes('LogRhythm_Alarms');
+    document.getElementById('dbBackupCMDB').checked = backup.databases.includes('LogRhythm_CMDB');
+    document.getElementById('dbBackupDirectory').value = backup.directory || '';
+    document.getElementById('dbBackupCopyOnly').checked = backup.copyOnly;
+    document.getElementById('dbBackupCompression').checked = backup.compression;
+    updateDatabaseFormFields();
+}
+
+// profileDatabase returns a profile's database settings, with the defaults older servers implied
+function profileDatabase(profile) {
+    const database = (profile && profile.database) || {};
+    return {
+        server: 'localhost',
+        analysisSource: 'api',
+        authMode: 'sql',
+        username: 'logrhythmadmin',
+        ...database,
+        encrypt: database.encrypt || 'required',
+        backup: {
+            databases: ['LogRhythmEMDB'],
+            directory: 'C:\\LogRhythm\\Backup',
+            copyOnly: true,
+            compression: false,
+            ...(database.backup || {})
+        }
+    };
+}
+
+function databaseServerName(database) {
+    let name = database.server;
+    if (database.instance) name += `\\${database.instance}`;
+    if (database.port) name += `,${database.port}`;
+    return name;
+}
+
+function updateDatabaseFormFields() {
+    const sqlAuth = document.getElementById('dbAuthMode').value === 'sql';
+    document.querySelectorAll('#databaseForm .db-sql-auth').forEach(element => {
+        element.style.display = sqlAuth ? '' : 'none';
+    });
+    const encrypted = document.getElementById('dbEncrypt').value !== 'disabled';
+    document.querySelectorAll('#databaseForm .db-tls').forEach(element => {
+        element.style.display = encrypted ? '' : 'none';
+    });
+}
+
+function databaseFormValues() {
+    const databases = ['LogRhythmEMDB'];
+    ['dbBackupAlarms', 'dbBackupCMDB'].forEach(id => {
+        const checkbox = document.getElementById(id);
+        if (checkbox.checked) databases.push(checkbox.value);
+    });
+    return {
+        server: document.getElementById('dbServer').value.trim(),
+        instance: document.getElementById('dbInstance').value.trim(),
+        port: parseInt(document.getElementById('dbPort').value, 10) || 0,
+        encrypt: document.getElementById('dbEncrypt').value,
+        caFile: document.getElementById('dbCAFile').value.trim(),
+        trustServerCertificate: document.getElementById('dbTrustServerCertificate').checked,
+        analysisSource: document.getElementById('dbAnalysisSource').value,
+        authMode: document.getElementById('dbAuthMode').value,
+        username: document.getElementById('dbUsername').value.trim(),
+        backup: {
+            databases: databases,
+            directory: document.getElementById('dbBackupDirectory').value.trim(),
+            copyOnly: document.getElementById('dbBackupCopyOnly').checked,
+            compression: document.getElementById('dbBackupCompression').checked
+        }
+    };
+}
+
+function saveDatabaseCredentials(body, successMessage) {
+    return fetch(`/api/profiles/${encodeURIComponent(currentProfile)}/database`, {
+        method: 'PUT',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify(body)
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast(successMessage, 'success');
+        loadConfiguration();
+    })
+    .catch(error => showToast(`Failed to save database settings: ${error.message}`, 'error'));
+}
+
+function handleDatabaseFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    saveDatabaseCredentials({
+        ...databaseFormValues(),
+        password: document.getElementById('dbPassword').value
+    }, 'Database settings saved');
+}
+
+function clearDatabasePassword() {
+    if (!confirm(`Remove the stored database password for ${currentProfile}? Backups will ask for it again.`)) {
+        return;
+    }
+    saveDatabaseCredentials({
+        ...databaseFormValues(),
+        clearPassword: true
+    }, 'Stored database password removed');
+}
+
+// Analysis Status Functions
+const analysisStatusLabels = {
+    'Stale-Reachable': 'Troubleshoot',
+    'Stale-Unreachable': 'Retire Candidate',
+    'Protected': 'Protected',
+    'Excluded': 'Excluded',
+    'Healthy': 'Healthy'
+};
+
+function escapeHTML(text) {
+    return String(text || '').replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+}
+
+// statusBadge shows an analysis status with its reason on hover
+function statusBadge(status, reason) {
+    if (!status) {
+        return '';
+    }
+    return `<span class="status-badge status-${status.toLowerCase()}" title="${escapeHTML(reason)}">${analysisStatusLabels[status] || status}</span>`;
+}
+
+// statusCell is the status column of a log source row: the badge and the reason under it
+function statusCell(source) {
+    return `<td class="analysis-status">${statusBadge(source.status, source.statusReason)}<div class="status-reason">${escapeHTML(source.statusReason)}</div></td>`;
+}
+
+// groupStatus derives a host's status from its log sources the way the server does for hosts
+function groupStatus(sources) {
+    const stale = sources.filter(source => source.status !== 'Excluded');
+    if (stale.length === 0) {
+        return sources.length > 0 ? 'Excluded' : '';
+    }
+    if (stale.some(source => source.status === 'Stale-Reachable')) {
+        return 'Stale-Reachable';
+    }
+    return stale.some(source => source.status === 'Protected') ? 'Protected' : 'Stale-Unreachable';
+}
+
+// rowStatuses lists a host's own status and its log sources', which the status filter matches
+function rowStatuses(status, sources) {
+    return Array.from(new Set([status, ...sources.map(source => source.status)].filter(Boolean))).join(' ');
+}
+
+// matchesStatus reports whether a host row carries the filtered status
+function matchesStatus(row, statusFilter) {
+    return !statusFilter || (row.getAttribute('data-statuses') || '').split(' ').includes(statusFilter);
+}
+
+// displayStatusCounts shows how many log sources and hosts got each status; clicking a
+// count filters the results by it
+function displayStatusCounts(counts) {
+    const container = document.getElementById('statusCounts');
+    if (!container) {
+        return;
+    }
+    if (!counts) {
+        container.style.display = 'none';
+        container.innerHTML = '';
+        return;
+    }
+    
+    const row = (label, values) => `
+        <div class="status-counts-row">
+            <span class="status-counts-label">${label}</span>
+            ${Object.keys(analysisStatusLabels).map(status => `
+                <button type="button" class="status-count status-${status.toLowerCase()}" data-status="${status}" ${status === 'Healthy' ? 'disabled title="Healthy log sources and hosts are counted but not listed"' : ''}>
+                    ${analysisStatusLabels[status]}: <strong>${(values || {})[status] || 0}</strong>
+                </button>
+            `).join('')}
+        </div>
+    `;
+    container.innerHTML = row('Log sources', counts.logSources) + (counts.hosts ? row('Hosts', counts.hosts) : '');
+    container.querySelectorAll('.status-count:not([disabled])').forEach(button => {
+        button.addEventListener('click', () => {
+            document.getElementById('statusFilter').value = button.dataset.status;
+            filterResults();
+        });
+    });
+    container.style.display = 'block';
+}
+
+// exportTroubleshootingQueue downloads the log sources whose hosts answer ping but have
+// stopped sending logs, as a CSV work list
+function exportTroubleshootingQueue() {
+    if (!currentJobId) {
+        showToast('Run an analysis first', 'error');
+        return;
+    }
+    const sources = allResults.length > 0 ? allResults : (hostAnalysis || []).flatMap(host => host.logSources || []);
+    if (!sources.some(source => source.status === 'Stale-Reachable')) {
+        showToast('No log sources need troubleshooting', 'info');
+        return;
+    }
+    fetch(`/api/export/${currentJobId}?format=csv&sheet=troubleshooting`)
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.blob().then(blob => ({ blob, response }));
+    })
+    .then(({ blob, response }) => {
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename="([^"]+)"/);
+        const link = document.createElement('a');
+        link.href = URL.createObjectURL(blob);
+        link.download = match ? match[1] : `lrcleaner_troubleshooting_${currentJobId}.csv`;
+        document.body.appendChild(link);
+        link.click();
+        document.body.removeChild(link);
+        URL.revokeObjectURL(link.href);
+        showToast('Troubleshooting queue downloaded', 'success');
+    })
+    .catch(error => showToast(`Export failed: ${error.message}`, 'error'));
+}
+
+// LogRhythm Case Functions
+
+// displayCaseSettings fills the case form for the current deployment
+function displayCaseSettings() {
+    const form = document.getElementById('casesForm');
+    const profile = loadedProfiles.find(p => p.name === currentProfile);
+    if (!form || !profile) return;
+    
+    const cases = profile.cases || { priority: 3 };
+    document.getElementById('casesProfileName').textContent = profile.name;
+    document.getElementById('casesEnabled').checked = !!cases.enabled;
+    document.getElementById('casesAutoCreate').checked = !!cases.autoCreate;
+    document.getElementById('casesPriority').value = cases.priority || 3;
+    document.getElementById('casesOwnerNumber').value = cases.ownerNumber || '';
+    document.getElementById('casesDueDays').value = cases.dueDays || '';
+}
+
+function handleCasesFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    fetch(`/api/profiles/${encodeURIComponent(currentProfile)}/cases`, {
+        method: 'PUT',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            enabled: document.getElementById('casesEnabled').checked,
+            autoCreate: document.getElementById('casesAutoCreate').checked,
+            priority: parseInt(document.getElementById('casesPriority').value, 10),
+            ownerNumber: parseInt(document.getElementById('casesOwnerNumber').value, 10) || 0,
+            dueDays: parseInt(document.getElementById('casesDueDays').value, 10) || 0
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('Case settings saved', 'success');
+        loadConfiguration();
+    })
+    .catch(error => showToast(`Failed to save case settings: ${error.message}`, 'error'));
+}
+
+// needsTroubleshooting matches the server: the host answers ping but its log sources are stale
+function needsTroubleshooting(host) {
+    return host.status === 'Stale-Reachable';
+}
+
+function caseBadge(host) {
+    return host.caseNumber ? `<span class="case-badge" title="LogRhythm case ${host.caseId}">Case ${host.caseNumber}</span>` : '';
+}
+
+// openTroubleshootingCases opens or updates cases for the selected hosts that need
+// troubleshooting, or for all of them when none are selected
+function openTroubleshootingCases() {
+    if (!currentJobId) {
+        showToast('Run a host analysis first', 'error');
+        return;
+    }
+    const hosts = selectedHosts
+        .map(hostId => hostAnalysis.find(h => String(h.hostId) === String(hostId)))
+        .filter(host => host && needsTroubleshooting(host))
+        .map(host => String(host.hostId));
+    if (selectedHosts.length > 0 && hosts.length === 0) {
+        showToast('None of the selected hosts need troubleshooting', 'error');
+        return;
+    }
+    
+    fetch(`/api/jobs/${currentJobId}/cases`, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({ hosts: hosts })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(data => {
+        const results = data.cases || [];
+        results.forEach(result => {
+            const host = hostAnalysis.find(h => String(h.hostId) === result.hostId);
+            if (host && result.caseNumber) {
+                host.caseId = result.caseId;
+                host.caseNumber = result.caseNumber;
+            }
+        });
+        updateResultsTableForApplyMode();
+        
+        const failed = results.filter(result => result.error);
+        if (results.length === 0) {
+            showToast('No hosts need troubleshooting', 'info');
+        } else if (failed.length > 0) {
+            showToast(`${failed.length} of ${results.length} cases failed: ${failed[0].hostName}: ${failed[0].error}`, 'error');
+        } else {
+            showToast(`${results.length} LogRhythm cases opened or updated`, 'success');
+        }
+    })
+    .catch(error => showToast(`Failed to open cases: ${error.message}`, 'error'));
+}
+
+// Protected Object Functions
+
+let loadedProtected = [];
+
+function loadProtected() {
+    fetch('/api/protected')
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(entries => displayProtected(entries || []))
+        .catch(error => console.error('Error loading protected objects:', error));
+}
+
+const PROTECTED_KIND_LABELS = { host: 'Host', agent: 'Agent', logsource: 'Log source' };
+
+// displayProtected lists the entries that apply to the current deployment
+function displayProtected(entries) {
+    loadedProtected = entries;
+    
+    const profileSelect = document.getElementById('protectedProfile');
+    if (profileSelect) {
+        const selected = profileSelect.value;
+        profileSelect.innerHTML = '<option value="">All deployments</option>';
+        loadedProfiles.forEach(profile => {
+            const option = document.createElement('option');
+            option.value = profile.name;
+            option.textContent = profile.name;
+            profileSelect.appendChild(option);
+        });
+        profileSelect.value = selected;
+    }
+    
+    const tbody = document.querySelector('#protectedTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    const visible = entries.filter(entry => !entry.profile || entry.profile === currentProfile);
+    if (visible.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="7" class="no-results">No protected objects for this deployment.</td></tr>';
+        return;
+    }
+    
+    visible.forEach(entry => {
+        const row = document.createElement('tr');
+        [
+            entry.profile || 'All deployments',
+            PROTECTED_KIND_LABELS[entry.kind] || entry.kind,
+            entry.match,
+            entry.pattern,
+            entry.reason,
+            entry.createdBy || ''
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        
+        const actions = document.createElement('td');
+        if (hasRole('admin')) {
+            const editBtn = document.createElement('button');
+            editBtn.className = 'btn btn-secondary btn-sm';
+            editBtn.innerHTML = '<i class="fas fa-edit"></i> Edit';
+            editBtn.addEventListener('click', () => editProtected(entry.id));
+            actions.appendChild(editBtn);
+            const deleteBtn = document.createElement('button');
+            deleteBtn.className = 'btn btn-danger btn-sm';
+            deleteBtn.innerHTML = '<i class="fas fa-trash"></i> Delete';
+            deleteBtn.addEventListener('click', () => deleteProtected(entry.id));
+            actions.appendChild(deleteBtn);
+        }
+        row.appendChild(actions);
+        tbody.appendChild(row);
+    });
+}
+
+function editProtected(id) {
+    const entry = loadedProtected.find(e => e.id === id);
+    if (!entry) return;
+    
+    document.getElementById('protectedFormTitle').textContent = 'Edit Protected Object';
+    document.getElementById('protectedId').value = entry.id;
+    document.getElementById('protectedProfile').value = entry.profile || '';
+    document.getElementById('protectedKind').value = entry.kind;
+    document.getElementById('protectedMatch').value = entry.match;
+    document.getElementById('protectedPattern').value = entry.pattern;
+    document.getElementById('protectedReason').value = entry.reason;
+}
+
+function resetProtectedForm() {
+    document.getElementById('protectedForm').reset();
+    document.getElementById('protectedId').value = '';
+    document.getElementById('protectedFormTitle').textContent = 'New Protected Object';
+}
+
+function handleProtectedFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const id = document.getElementById('protectedId').value;
+    fetch(id ? `/api/protected/${encodeURIComponent(id)}` : '/api/protected', {
+        method: id ? 'PUT' : 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: document.getElementById('protectedProfile').value,
+            kind: document.getElementById('protectedKind').value,
+            match: document.getElementById('protectedMatch').value,
+            pattern: document.getElementById('protectedPattern').value.trim(),
+            reason: document.getElementById('protectedReason').value.trim()
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast(id ? 'Protected object updated' : 'Protected object added', 'success');
+        resetProtectedForm();
+        loadProtected();
+    })
+    .catch(error => showToast(`Failed to save protected object: ${error.message}`, 'error'));
+}
+
+function deleteProtected(id) {
+    const entry = loadedProtected.find(e => e.id === id);
+    if (!entry || !confirm(`Remove protection from ${PROTECTED_KIND_LABELS[entry.kind] || entry.kind} ${entry.match} "${entry.pattern}"? Matching objects can then be retired.`)) {
+        return;
+    }
+    
+    fetch(`/api/protected/${encodeURIComponent(id)}`, { method: 'DELETE' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(() => {
+            showToast('Protected object removed', 'success');
+            loadProtected();
+        })
+        .catch(error => showToast(`Failed to remove protected object: ${error.message}`, 'error'));
+}
+
+// Webhook Functions
+
+let loadedWebhooks = [];
+
+const WEBHOOK_FORMAT_LABELS = { json: 'JSON', slack: 'Slack', teams: 'Teams' };
+
+function loadWebhooks() {
+    fetch('/api/webhooks')
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(webhooks => displayWebhooks(webhooks || []))
+        .catch(error => console.error('Error loading webhooks:', error));
+    loadWebhookDeliveries();
+}
+
+function displayWebhooks(webhooks) {
+    loadedWebhooks = webhooks;
+    
+    const profileSelect = document.getElementById('webhookProfile');
+    if (profileSelect) {
+        const selected = profileSelect.value;
+        profileSelect.innerHTML = '<option value="">All deployments</option>';
+        loadedProfiles.forEach(profile => {
+            const option = document.createElement('option');
+            option.value = profile.name;
+            option.textContent = profile.name;
+            profileSelect.appendChild(option);
+        });
+        profileSelect.value = selected;
+    }
+    
+    const tbody = document.querySelector('#webhooksTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    if (webhooks.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="7" class="no-results">No webhooks configured.</td></tr>';
+        return;
+    }
+    
+    webhooks.forEach(webhook => {
+        const row = document.createElement('tr');
+        [
+            webhook.name,
+            webhook.profile || 'All deployments',
+            WEBHOOK_FORMAT_LABELS[webhook.format] || webhook.format,
+            webhook.events && webhook.events.length > 0 ? webhook.events.join(', ') : 'All events',
+            webhook.hasSecret ? 'Yes' : 'No',
+            webhook.enabled ? 'Yes' : 'No'
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        
+        const actions = document.createElement('td');
+        const editBtn = document.createElement('button');
+        editBtn.className = 'btn btn-secondary btn-sm';
+        editBtn.innerHTML = '<i class="fas fa-edit"></i> Edit';
+        editBtn.addEventListener('click', () => editWebhook(webhook.id));
+        actions.appendChild(editBtn);
+        const testBtn = document.createElement('button');
+        testBtn.className = 'btn btn-secondary btn-sm';
+        testBtn.innerHTML = '<i class="fas fa-paper-plane"></i> Test';
+        testBtn.addEventListener('click', () => testWebhook(webhook.id));
+        actions.appendChild(testBtn);
+        const deleteBtn = document.createElement('button');
+        deleteBtn.className = 'btn btn-danger btn-sm';
+        deleteBtn.innerHTML = '<i class="fas fa-trash"></i> Delete';
+        deleteBtn.addEventListener('click', () => deleteWebhook(webhook.id));
+        actions.appendChild(deleteBtn);
+        row.appendChild(actions);
+        tbody.appendChild(row);
+    });
+}
+
+function editWebhook(id) {
+    const webhook = loadedWebhooks.find(w => w.id === id);
+    if (!webhook) return;
+    
+    document.getElementById('webhookFormTitle').textContent = 'Edit Webhook';
+    document.getElementById('webhookId').value = webhook.id;
+    document.getElementById('webhookName').value = webhook.name;
+    document.getElementById('webhookUrl').value = webhook.url;
+    document.getElementById('webhookFormat').value = webhook.format;
+    document.getElementById('webhookProfile').value = webhook.profile || '';
+    document.querySelectorAll('#webhookEvents input[type="checkbox"]').forEach(checkbox => {
+        checkbox.checked = (webhook.events || []).includes(checkbox.value);
+    });
+    document.getElementById('webhookSecret').value = '';
+    document.getElementById('webhookSecret').placeholder = webhook.hasSecret ? 'Leave empty to keep the stored secret' : 'No secret stored; requests are unsigned';
+    document.getElementById('webhookClearSecret').checked = false;
+    document.getElementById('webhookEnabled').checked = webhook.enabled;
+}
+
+function resetWebhookForm() {
+    document.getElementById('webhookForm').reset();
+    document.getElementById('webhookId').value = '';
+    document.getElementById('webhookSecret').placeholder = 'Leave empty to send unsigned requests';
+    document.getElementById('webhookFormTitle').textContent = 'New Webhook';
+}
+
+function handleWebhookFormSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const id = document.getElementById('webhookId').value;
+    const events = Array.from(document.querySelectorAll('#webhookEvents input[type="checkbox"]:checked')).map(checkbox => checkbox.value);
+    // "-" asks the server to remove the stored secret
+    const secret = document.getElementById('webhookClearSecret').checked ? '-' : document.getElementById('webhookSecret').value;
+    fetch(id ? `/api/webhooks/${encodeURIComponent(id)}` : '/api/webhooks', {
+        method: id ? 'PUT' : 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            name: document.getElementById('webhookName').value.trim(),
+            url: document.getElementById('webhookUrl').value.trim(),
+            format: document.getElementById('webhookFormat').value,
+            profile: document.getElementById('webhookProfile').value,
+            events: events,
+            enabled: document.getElementById('webhookEnabled').checked,
+            secret: secret
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast(id ? 'Webhook updated' : 'Webhook added', 'success');
+        resetWebhookForm();
+        loadWebhooks();
+    })
+    .catch(error => showToast(`Failed to save webhook: ${error.message}`, 'error'));
+}
+
+function deleteWebhook(id) {
+    const webhook = loadedWebhooks.find(w => w.id === id);
+    if (!webhook || !confirm(`Delete webhook "${webhook.name}"? Its signing secret is removed too.`)) {
+        return;
+    }
+    
+    fetch(`/api/webhooks/${encodeURIComponent(id)}`, { method: 'DELETE' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(() => {
+            showToast('Webhook deleted', 'success');
+            loadWebhooks();
+        })
+        .catch(error => showToast(`Failed to delete webhook: ${error.message}`, 'error'));
+}
+
+function testWebhook(id) {
+    fetch(`/api/webhooks/${encodeURIComponent(id)}/test`, { method: 'POST' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(delivery => {
+            if (delivery.status === 'delivered') {
+                showToast(`Test notification delivered (HTTP ${delivery.statusCode})`, 'success');
+            } else {
+                showToast(`Test notification failed: ${delivery.error}`, 'error');
+            }
+            loadWebhookDeliveries();
+        })
+        .catch(error => showToast(`Failed to test webhook: ${error.message}`, 'error'));
+}
+
+function loadWebhookDeliveries() {
+    fetch('/api/webhooks/deliveries')
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(deliveries => displayWebhookDeliveries(deliveries || []))
+        .catch(error => console.error('Error loading webhook deliveries:', error));
+}
+
+function displayWebhookDeliveries(deliveries) {
+    const tbody = document.querySelector('#webhookDeliveriesTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    if (deliveries.length === 0) {
+        tbody.innerHTML = '<tr><td colspan="6" class="no-results">No deliveries since the server started.</td></tr>';
+        return;
+    }
+    
+    deliveries.forEach(delivery => {
+        const row = document.createElement('tr');
+        if (delivery.status === 'failed') row.className = 'delivery-failed';
+        [
+            formatDate(delivery.createdAt),
+            delivery.webhookName,
+            delivery.event,
+            delivery.status,
+            delivery.attempts,
+            delivery.error || (delivery.statusCode ? `HTTP ${delivery.statusCode}` : '')
+        ].forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        tbody.appendChild(row);
+    });
+}
+
+// Email Notification Functions
+
+const EMAIL_EVENT_LABELS = {
+    '': 'All events',
+    'analysis.completed': 'Analysis completed',
+    'retirement.started': 'Retirement started',
+    'retirement.completed': 'Retirement completed',
+    'retirement.failed': 'Retirement failed',
+    'rollback.executed': 'Rollback executed',
+    'backup.failed': 'Backup failed'
+};
+
+function displayEmailConfig(email, hasPassword) {
+    document.getElementById('emailEnabled').checked = !!email.enabled;
+    document.getElementById('smtpHost').value = email.host || '';
+    document.getElementById('smtpPort').value = email.port || 587;
+    document.getElementById('smtpSecurity').value = email.security || 'starttls';
+    document.getElementById('smtpUsername').value = email.username || '';
+    document.getElementById('smtpCaBundle').value = email.caBundle || '';
+    document.getElementById('emailFrom').value = email.from || '';
+    
+    const password = document.getElementById('smtpPassword');
+    password.value = '';
+    password.placeholder = hasPassword
+        ? 'Stored - leave blank to keep, enter - to remove'
+        : 'Leave blank for a relay without sign-in';
+    
+    const tbody = document.querySelector('#emailRecipientsTable tbody');
+    if (!tbody) return;
+    tbody.innerHTML = '';
+    (email.recipients || []).forEach(addEmailRecipientRow);
+}
+
+// addEmailRecipientRow adds an editable recipient list to the table
+function addEmailRecipientRow(route) {
+    route = route || {};
+    const tbody = document.querySelector('#emailRecipientsTable tbody');
+    const row = document.createElement('tr');
+    
+    const eventSelect = document.createElement('select');
+    eventSelect.className = 'email-event';
+    Object.keys(EMAIL_EVENT_LABELS).forEach(value => {
+        const option = document.createElement('option');
+        option.value = value;
+        option.textContent = EMAIL_EVENT_LABELS[value];
+        eventSelect.appendChild(option);
+    });
+    eventSelect.value = route.event || '';
+    
+    const profileSelect = document.createElement('select');
+    profileSelect.className = 'email-profile';
+    profileSelect.innerHTML = '<option value="">All deployments</option>';
+    loadedProfiles.forEach(profile => {
+        const option = document.createElement('option');
+        option.value = profile.name;
+        option.textContent = profile.name;
+        profileSelect.appendChild(option);
+    });
+    profileSelect.value = route.profile || '';
+    
+    const addresses = document.createElement('input');
+    addresses.type = 'text';
+    addresses.className = 'email-to';
+    addresses.placeholder = 'soc@example.com, change-board@example.com';
+    addresses.value = (route.to || []).join(', ');
+    
+    const attachments = route.attachments || [];
+    const csv = document.createElement('input');
+    csv.type = 'checkbox';
+    csv.className = 'email-csv';
+    csv.checked = attachments.includes('csv');
+    const pdf = document.createElement('input');
+    pdf.type = 'checkbox';
+    pdf.className = 'email-pdf';
+    pdf.checked = attachments.includes('pdf');
+    
+    const removeBtn = document.createElement('button');
+    removeBtn.type = 'button';
+    removeBtn.className = 'btn btn-danger btn-sm';
+    removeBtn.innerHTML = '<i class="fas fa-trash"></i> Remove';
+    removeBtn.addEventListener('click', () => row.remove());
+    
+    [eventSelect, profileSelect, addresses, csv, pdf, removeBtn].forEach(control => {
+        const cell = document.createElement('td');
+        cell.appendChild(control);
+        row.appendChild(cell);
+    });
+    tbody.appendChild(row);
+}
+
+function handleEmailConfigSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const recipients = Array.from(document.querySelectorAll('#emailRecipientsTable tbody tr')).map(row => {
+        const attachments = [];
+        if (row.querySelector('.email-csv').checked) attachments.push('csv');
+        if (row.querySelector('.email-pdf').checked) attachments.push('pdf');
+        return {
+            event: row.querySelector('.email-event').value,
+            profile: row.querySelector('.email-profile').value,
+            to: row.querySelector('.email-to').value.split(/[,;]/).map(address => address.trim()).filter(Boolean),
+            attachments: attachments
+        };
+    });
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            email: {
+                enabled: document.getElementById('emailEnabled').checked,
+                host: document.getElementById('smtpHost').value.trim(),
+                port: parseInt(document.getElementById('smtpPort').value) || 0,
+                security: document.getElementById('smtpSecurity').value,
+                username: document.getElementById('smtpUsername').value.trim(),
+                caBundle: document.getElementById('smtpCaBundle').value.trim(),
+                from: document.getElementById('emailFrom').value.trim(),
+                recipients: recipients
+            },
+            smtpPassword: document.getElementById('smtpPassword').value
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('Email settings saved successfully!', 'success');
+        loadConfiguration();
+    })
+    .catch(error => showToast(`Failed to save email settings: ${error.message}`, 'error'));
+}
+
+function sendTestEmail() {
+    const to = document.getElementById('emailTestTo').value.trim();
+    if (!to) {
+        showToast('Enter an address for the test message', 'error');
+        return;
+    }
+    
+    fetch('/api/email/test', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({ to: to })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(result => {
+        if (result.success) {
+            showToast(result.message, 'success');
+        } else {
+            showToast(`Test message failed: ${result.error}`, 'error');
+        }
+    })
+    .catch(error => showToast(`Failed to send test message: ${error.message}`, 'error'));
+}
+
+// Syslog Forwarding Functions
+
+function displaySyslogConfig(syslog) {
+    document.getElementById('syslogEnabled').checked = !!syslog.enabled;
+    document.getElementById('syslogHost').value = syslog.host || '';
+    document.getElementById('syslogPort').value = syslog.port || 514;
+    document.getElementById('syslogProtocol').value = syslog.protocol || 'udp';
+    document.getElementById('syslogFraming').value = syslog.framing || 'octet-counting';
+    document.getElementById('syslogFormat').value = syslog.format || 'cef';
+    document.getElementById('syslogFacility').value = syslog.facility || 'local0';
+    document.getElementById('syslogCaBundle').value = syslog.caBundle || '';
+    document.getElementById('syslogBufferSize').value = syslog.bufferSize || 10000;
+    loadSyslogStatus();
+}
+
+function loadSyslogStatus() {
+    fetch('/api/syslog/status')
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(displaySyslogStatus)
+        .catch(error => console.error('Error loading syslog status:', error));
+}
+
+function displaySyslogStatus(status) {
+    const info = document.getElementById('syslogStatus');
+    if (!info) return;
+    if (!status.enabled) {
+        info.textContent = status.buffered > 0
+            ? `Syslog forwarding is off. ${status.buffered} buffered messages will be sent when it is switched on.`
+            : 'Syslog forwarding is off.';
+        info.className = 'status-message info';
+        return;
+    }
+    const parts = [`Forwarding to ${status.destination}.`];
+    if (status.lastSent) parts.push(`Last sent ${new Date(status.lastSent).toLocaleString()}.`);
+    if (status.buffered > 0) parts.push(`${status.buffered} messages buffered.`);
+    if (status.dropped > 0) parts.push(`${status.dropped} dropped because the buffer was full.`);
+    if (status.lastError) parts.push(`Collector unreachable: ${status.lastError}`);
+    info.textContent = parts.join(' ');
+    info.className = status.lastError || status.dropped > 0 ? 'status-message error' : 'status-message success';
+}
+
+function handleSyslogConfigSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            syslog: {
+                enabled: document.getElementById('syslogEnabled').checked,
+                host: document.getElementById('syslogHost').value.trim(),
+                port: parseInt(document.getElementById('syslogPort').value) || 0,
+                protocol: document.getElementById('syslogProtocol').value,
+                framing: document.getElementById('syslogFraming').value,
+                format: document.getElementById('syslogFormat').value,
+                facility: document.getElementById('syslogFacility').value,
+                caBundle: document.getElementById('syslogCaBundle').value.trim(),
+                bufferSize: parseInt(document.getElementById('syslogBufferSize').value) || 0
+            }
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('Syslog settings saved successfully!', 'success');
+        loadConfiguration();
+    })
+    .catch(error => showToast(`Failed to save syslog settings: ${error.message}`, 'error'));
+}
+
+function sendTestSyslog() {
+    fetch('/api/syslog/test', { method: 'POST' })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(result => {
+            if (result.success) {
+                showToast(result.message, 'success');
+            } else {
+                showToast(`Test message failed: ${result.error}`, 'error');
+            }
+            loadSyslogStatus();
+        })
+        .catch(error => showToast(`Failed to send test message: ${error.message}`, 'error'));
+}
+
+// Configuration File Functions
+
+function displayConfigStatus(config) {
+    const container = document.getElementById('configStatus');
+    if (!container) return;
+    const issues = config.configIssues || [];
+    const overrides = config.envOverrides || [];
+    container.innerHTML = '';
+    if (issues.length === 0 && overrides.length === 0) {
+        container.style.display = 'none';
+        return;
+    }
+    
+    if (issues.length > 0) {
+        const heading = document.createElement('strong');
+        heading.textContent = `Problems found in ${config.configPath} (schema version ${config.schemaVersion}):`;
+        container.appendChild(heading);
+        const list = document.createElement('ul');
+        issues.forEach(issue => {
+            const item = document.createElement('li');
+            item.textContent = issue;
+            list.appendChild(item);
+        });
+        container.appendChild(list);
+    }
+    if (overrides.length > 0) {
+        const note = document.createElement('p');
+        note.textContent = `Set by environment variables, which take precedence over ${config.configPath}: ${overrides.join(', ')}`;
+        container.appendChild(note);
+    }
+    container.className = issues.length > 0 ? 'config-status config-status-error' : 'config-status';
+    container.style.display = 'block';
+}
+
+// Cross-Deployment Analysis Functions
+
+function renderTestProfiles() {
+    const container = document.getElementById('testProfiles');
+    if (!container) return;
+    container.innerHTML = '';
+    loadedProfiles.forEach(profile => {
+        const label = document.createElement('label');
+        label.className = 'checkbox-label';
+        const checkbox = document.createElement('input');
+        checkbox.type = 'checkbox';
+        checkbox.value = profile.name;
+        checkbox.checked = profile.name === currentProfile;
+        checkbox.addEventListener('change', syncAllTestProfiles);
+        label.appendChild(checkbox);
+        label.appendChild(document.createTextNode(` ${profile.name} (${profile.hostname})`));
+        container.appendChild(label);
+    });
+    syncAllTestProfiles();
+}
+
+function toggleAllTestProfiles(event) {
+    document.querySelectorAll('#testProfiles input[type="checkbox"]').forEach(checkbox => {
+        checkbox.checked = event.target.checked;
+    });
+}
+
+function syncAllTestProfiles() {
+    const all = document.getElementById('testAllProfiles');
+    const checkboxes = document.querySelectorAll('#testProfiles input[type="checkbox"]');
+    if (all) all.checked = checkboxes.length > 0 && Array.from(checkboxes).every(checkbox => checkbox.checked);
+}
+
+function selectedTestProfiles() {
+    const checkboxes = document.querySelectorAll('#testProfiles input[type="checkbox"]');
+    if (checkboxes.length === 0) return [currentProfile];
+    return Array.from(checkboxes).filter(checkbox => checkbox.checked).map(checkbox => checkbox.value);
+}
+
+function displayDeploymentSummaries(deployments) {
+    const container = document.getElementById('deploymentSummaries');
+    if (!container) return;
+    if (!deployments || deployments.length === 0) {
+        container.style.display = 'none';
+        container.innerHTML = '';
+        return;
+    }
+    
+    container.innerHTML = '';
+    deployments.forEach(summary => {
+        const card = document.createElement('div');
+        card.className = `deployment-summary deployment-${summary.status}`;
+        const title = document.createElement('strong');
+        title.textContent = `${summary.profile} (${summary.hostname})`;
+        card.appendChild(title);
+        const detail = document.createElement('div');
+        if (summary.status === 'error') {
+            detail.textContent = `Failed: ${summary.error}`;
+        } else if (summary.status === 'running') {
+            detail.textContent = 'Analyzing...';
+        } else {
+            detail.textContent = `${summary.stale} of ${summary.logSources} log sources stale on ${summary.hosts} hosts (${summary.reachable} reachable, ${summary.unreachable} unreachable), read from ${dataSourceLabel(summary.dataSource)}`;
+        }
+        card.appendChild(detail);
+        container.appendChild(card);
+    });
+    container.style.display = 'flex';
+}
+
+function dataSourceLabel(source) {
+    return source === 'emdb' ? 'LogRhythmEMDB (read-only)' : 'the LogRhythm Admin API';
+}
+
+// displayDataSource shows where the analysis read its log sources from
+function displayDataSource(source) {
+    const info = document.getElementById('dataSourceInfo');
+    if (!info) return;
+    if (!source) {
+        info.style.display = 'none';
+        return;
+    }
+    const profile = loadedProfiles.find(p => p.name === resultsProfile);
+    const configured = profileDatabase(profile).analysisSource;
+    info.innerHTML = `<i class="fas fa-database"></i> Log sources read from ${dataSourceLabel(source)}`;
+    if (configured === 'emdb' && source !== 'emdb') {
+        info.innerHTML += ' because LogRhythmEMDB could not be queried; see the server log';
+    }
+    info.style.display = '';
+}
+
+// LogRhythm API TLS Functions
+
+function displayAPITLSConfig(apiTls) {
+    document.getElementById('apiTlsMode').value = apiTls.mode || 'insecure';
+    document.getElementById('apiTlsCaBundle').value = apiTls.caBundle || '';
+    document.getElementById('apiTlsPins').value = (apiTls.pinnedFingerprints || []).join('\n');
+    document.getElementById('apiTlsClientCert').value = apiTls.clientCertFile || '';
+    document.getElementById('apiTlsClientKey').value = apiTls.clientKeyFile || '';
+}
+
+function readAPITLSForm() {
+    return {
+        mode: document.getElementById('apiTlsMode').value,
+        caBundle: document.getElementById('apiTlsCaBundle').value.trim(),
+        pinnedFingerprints: document.getElementById('apiTlsPins').value
+            .split('\n').map(line => line.trim()).filter(line => line),
+        clientCertFile: document.getElementById('apiTlsClientCert').value.trim(),
+        clientKeyFile: document.getElementById('apiTlsClientKey').value.trim()
+    };
+}
+
+function handleAPITLSConfigSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const apiTls = readAPITLSForm();
+    if (apiTls.mode === 'insecure' && !confirm('Insecure mode accepts any certificate, so the API key can be intercepted. Continue?')) {
+        return;
+    }
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            apiTls: apiTls
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('LogRhythm API TLS settings saved', 'success');
+    })
+    .catch(error => {
+        console.error('Error saving API TLS settings:', error);
+        showToast(error.message || 'Error saving API TLS settings', 'error');
+    });
+}
+
+// Shows the certificate chain reported by a connection test
+function displayCertificateDetails(certificate) {
+    const container = document.getElementById('apiCertificateDetails');
+    if (!container) return;
+    container.innerHTML = '';
+    if (!certificate) {
+        container.style.display = 'none';
+        return;
+    }
+    container.style.display = 'block';
+    
+    const heading = document.createElement('h4');
+    heading.textContent = `Server certificate (${certificate.mode} mode, ${certificate.tlsVersion}, ${certificate.cipherSuite})`;
+    container.appendChild(heading);
+    
+    (certificate.chain || []).forEach((cert, index) => {
+        const item = document.createElement('div');
+        item.className = 'certificate-item';
+        const names = (cert.dnsNames || []).concat(cert.ipAddresses || []).join(', ');
+        const lines = [
+            `${index === 0 ? 'Server' : 'Issuer ' + index}: ${cert.subject}`,
+            `Issued by: ${cert.issuer}`,
+            `Valid: ${new Date(cert.notBefore).toLocaleDateString()} - ${new Date(cert.notAfter).toLocaleDateString()}`,
+            `SHA-256: ${cert.fingerprint}`
+        ];
+        if (names) lines.splice(2, 0, `Names: ${names}`);
+        lines.forEach(line => {
+            const div = document.createElement('div');
+            div.textContent = line;
+            item.appendChild(div);
+        });
+        container.appendChild(item);
+    });
+    
+    (certificate.errors || []).forEach(message => {
+        const div = document.createElement('div');
+        div.className = certificate.trusted ? 'status-success' : 'status-error';
+        div.textContent = message;
+        container.appendChild(div);
+    });
+    
+    if (certificate.needsTrust) {
+        const trustBtn = document.createElement('button');
+        trustBtn.type = 'button';
+        trustBtn.className = 'btn btn-warning';
+        trustBtn.innerHTML = '<i class="fas fa-thumbtack"></i> Trust This Certificate';
+        trustBtn.addEventListener('click', () => trustAPICertificate(certificate.fingerprint));
+        container.appendChild(trustBtn);
+    }
+}
+
+function trustAPICertificate(fingerprint) {
+    if (!confirm(`Trust the LogRhythm API certificate with SHA-256 fingerprint\n${fingerprint}?\n\nCompare it with the certificate on the LogRhythm server before continuing.`)) {
+        return;
+    }
+    
+    fetch('/api/tls/trust', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({ fingerprint: fingerprint })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text.trim()); });
+        }
+        return response.json();
+    })
+    .then(data => {
+        showToast(data.message, 'success');
+        handleTestConnection();
+    })
+    .catch(error => showToast(`Failed to trust certificate: ${error.message}`, 'error'));
+}
+
+// Single Sign-On Functions
+
+// Last auth settings from the server; fields without a form control are sent back unchanged
+let loadedAuthConfig = {};
+
+function loadAuthProviders() {
+    fetch('/api/auth/providers')
+        .then(response => response.json())
+        .then(providers => {
+            const ssoLogin = document.getElementById('ssoLogin');
+            const ssoLoginLabel = document.getElementById('ssoLoginLabel');
+            const oidc = providers.oidc || {};
+            if (ssoLogin) ssoLogin.style.display = oidc.enabled ? 'block' : 'none';
+            if (ssoLoginLabel) ssoLoginLabel.textContent = oidc.displayName || 'Single Sign-On';
+        })
+        .catch(error => console.error('Error loading sign-in providers:', error));
+}
+
+function displaySSOConfig(oidc, hasClientSecret) {
+    document.getElementById('ssoEnabled').checked = !!oidc.enabled;
+    document.getElementById('ssoDisplayName').value = oidc.displayName || '';
+    document.getElementById('ssoIssuerUrl').value = oidc.issuerUrl || '';
+    document.getElementById('ssoClientId').value = oidc.clientId || '';
+    document.getElementById('ssoRedirectUrl').value = oidc.redirectUrl || '';
+    document.getElementById('ssoUsernameClaim').value = oidc.usernameClaim || '';
+    document.getElementById('ssoGroupsClaim').value = oidc.groupsClaim || '';
+    document.getElementById('ssoDefaultRole').value = oidc.defaultRole || '';
+    
+    const mappings = oidc.roleMappings || {};
+    document.getElementById('ssoRoleMappings').value = Object.keys(mappings)
+        .sort()
+        .map(group => `${group} = ${mappings[group]}`)
+        .join('\n');
+    
+    const clientSecret = document.getElementById('ssoClientSecret');
+    clientSecret.value = '';
+    clientSecret.placeholder = hasClientSecret
+        ? 'Stored - leave blank to keep, enter - to remove'
+        : 'Leave blank for a public client';
+    
+    const defaultRedirect = document.getElementById('ssoDefaultRedirect');
+    if (defaultRedirect) {
+        defaultRedirect.textContent = `${window.location.origin}/api/auth/oidc/callback`;
+    }
+}
+
+function handleSSOConfigSubmit(e) {
+    e.preventDefault();
+    e.stopPropagation();
+    
+    const roleMappings = {};
+    const lines = document.getElementById('ssoRoleMappings').value.split('\n');
+    for (const line of lines) {
+        if (!line.trim()) continue;
+        const separator = line.lastIndexOf('=');
+        if (separator < 0) {
+            showToast(`Role mapping "${line.trim()}" must look like group = role`, 'error');
+            return;
+        }
+        roleMappings[line.slice(0, separator).trim()] = line.slice(separator + 1).trim().toLowerCase();
+    }
+    
+    const auth = Object.assign({}, loadedAuthConfig);
+    auth.oidc = Object.assign({}, loadedAuthConfig.oidc, {
+        enabled: document.getElementById('ssoEnabled').checked,
+        displayName: document.getElementById('ssoDisplayName').value.trim(),
+        issuerUrl: document.getElementById('ssoIssuerUrl').value.trim(),
+        clientId: document.getElementById('ssoClientId').value.trim(),
+        redirectUrl: document.getElementById('ssoRedirectUrl').value.trim(),
+        usernameClaim: document.getElementById('ssoUsernameClaim').value.trim(),
+        groupsClaim: document.getElementById('ssoGroupsClaim').value.trim(),
+        roleMappings: roleMappings,
+        defaultRole: document.getElementById('ssoDefaultRole').value
+    });
+    
+    // The config endpoint also saves the connection settings, so send the current values
+    fetch('/api/config', {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        body: JSON.stringify({
+            profile: currentProfile,
+            hostname: document.getElementById('hostname').value,
+            port: parseInt(document.getElementById('port').value),
+            auth: auth,
+            oidcClientSecret: document.getElementById('ssoClientSecret').value
+        })
+    })
+    .then(response => {
+        if (!response.ok) {
+            return response.text().then(text => { throw new Error(text); });
+        }
+        return response.json();
+    })
+    .then(() => {
+        showToast('Single sign-on settings saved successfully!', 'success');
+        loadConfiguration();
+        loadAuthProviders();
+    })
+    .catch(error => {
+        console.error('Error saving single sign-on settings:', error);
+        showToast(error.message || 'Error saving single sign-on settings', 'error');
+    });
+}
+
+// Audit Log Functions
+
+function auditFilterQuery() {
+    const params = new URLSearchParams();
+    const fields = {
+        user: 'auditUserFilter',
+        action: 'auditActionFilter',
+        ticket: 'auditTicketFilter',
+        q: 'auditTextFilter',
+        from: 'auditFromDate',
+        to: 'auditToDate'
+    };
+    Object.keys(fields).forEach(key => {
+        const element = document.getElementById(fields[key]);
+        if (element && element.value.trim()) {
+            params.set(key, element.value.trim());
+        }
+    });
+    return params;
+}
+
+function loadAuditLog() {
+    const auditBody = document.getElementById('auditBody');
+    if (!auditBody) return;
+    
+    fetch(`/api/audit?${auditFilterQuery().toString()}`)
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => { throw new Error(text.trim()); });
+            }
+            return response.json();
+        })
+        .then(data => {
+            const auditCount = document.getElementById('auditCount');
+            if (auditCount) {
+                auditCount.textContent = data.total > data.entries.length
+                    ? `Showing newest ${data.entries.length} of ${data.total} entries`
+                    : `${data.total} entries`;
+            }
+            displayAuditEntries(data.entries);
+        })
+        .catch(error => {
+            console.error('Error loading audit log:', error);
+            showToast(`Failed to load audit log: ${error.message}`, 'error');
+        });
+}
+
+function displayAuditEntries(entries) {
+    const auditBody = document.getElementById('auditBody');
+    auditBody.innerHTML = '';
+    
+    if (entries.length === 0) {
+        const row = document.createElement('tr');
+        const cell = document.createElement('td');
+        cell.colSpan = 8;
+        cell.textContent = 'No audit entries match the filters';
+        row.appendChild(cell);
+        auditBody.appendChild(row);
+        return;
+    }
+    
+    entries.forEach(entry => {
+        const row = document.createElement('tr');
+        if (entry.result === 'failure') {
+            row.classList.add('audit-failure');
+        }
+        
+        const values = [
+            entry.sequence,
+            new Date(entry.timestamp).toLocaleString(),
+            entry.user,
+            entry.sourceIp || '',
+            entry.action,
+            entry.target || '',
+            entry.ticket || '',
+            entry.result
+        ];
+        values.forEach(value => {
+            const cell = document.createElement('td');
+            cell.textContent = value;
+            row.appendChild(cell);
+        });
+        
+        // Show message and before/after on click
+        const details = [];
+        if (entry.message) details.push(entry.message);
+        if (entry.jobId) details.push(`Job: ${entry.jobId}`);
+        if (entry.before) details.push(`Before: ${JSON.stringify(entry.before, null, 2)}`);
+        if (entry.after) details.push(`After: ${JSON.stringify(entry.after, null, 2)}`);
+        details.push(`Hash: ${entry.hash}`);
+        
+        const detailsRow = document.createElement('tr');
+        detailsRow.className = 'audit-details';
+        detailsRow.style.display = 'none';
+        const detailsCell = document.createElement('td');
+        detailsCell.colSpan = 8;
+        const pre = document.createElement('pre');
+        pre.textContent = details.join('\n');
+        detailsCell.appendChild(pre);
+        detailsRow.appendChild(detailsCell);
+        
+        row.addEventListener('click', () => {
+            detailsRow.style.display = detailsRow.style.display === 'none' ? 'table-row' : 'none';
+        });
+        
+        auditBody.appendChild(row);
+        auditBody.appendChild(detailsRow);
+    });
+}
+
+function verifyAuditLog() {
+    const auditIntegrity = document.getElementById('auditIntegrity');
+    if (!auditIntegrity) return;
+    
+    fetch('/api/audit/verify')
+        .then(response => response.json())
+        .then(result => {
+            const icon = document.createElement('i');
+            icon.className = result.valid ? 'fas fa-check-circle' : 'fas fa-exclamation-triangle';
+            auditIntegrity.innerHTML = '';
+            auditIntegrity.append(icon, result.valid
+                ? ` Hash chain verified (${result.entries} entries)`
+                : ` Tampering detected: ${result.message}`);
+            auditIntegrity.className = result.valid ? 'status-message success' : 'status-message error';
+        })
+        .catch(error => console.error('Error verifying audit log:', error));
+}
+
+function exportAuditLog(format) {
+    const params = auditFilterQuery();
+    params.set('format', format);
+    window.location.href = `/api/audit/export?${params.toString()}`;
+}
+
 // Host Selection Functions
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/99designs/keyring"
+	"github.com/gorilla/mux"
+)
+
+// webhookReceiver is a local webhook endpoint that answers with a scripted list of status
+// codes, repeating the last one, and keeps every request it gets
+type webhookReceiver struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	codes    []int
+	requests []receivedWebhook
+	waits    []time.Duration // Retry waits LRCleaner asked for
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newWebhookReceiver(t *testing.T, codes ...int) *webhookReceiver {
+	t.Helper()
+	rec := &webhookReceiver{codes: codes}
+	rec.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		code := rec.codes[min(len(rec.requests), len(rec.codes)-1)]
+		rec.requests = append(rec.requests, receivedWebhook{header: r.Header.Clone(), body: body})
+		rec.mu.Unlock()
+		if code == http.StatusFound {
+			w.Header().Set("Location", "http://elsewhere.invalid/")
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(rec.server.Close)
+
+	client, wait := webhookClient, webhookRetryWait
+	stub := *webhookClient
+	stub.Transport = rec.server.Client().Transport
+	webhookClient = &stub
+	webhookRetryWait = func(d time.Duration) {
+		rec.mu.Lock()
+		rec.waits = append(rec.waits, d)
+		rec.mu.Unlock()
+	}
+	t.Cleanup(func() { webhookClient, webhookRetryWait = client, wait })
+	return rec
+}
+
+// hook returns an enabled webhook pointed at the receiver
+func (rec *webhookReceiver) hook(id, format string) Webhook {
+	return Webhook{ID: id, Name: "Test " + id, URL: rec.server.URL + "/hook", Format: format, Enabled: true}
+}
+
+// deliver sends a retirement event to the hook and returns the finished delivery
+func deliver(hook Webhook, attempts int) WebhookDelivery {
+	event := WebhookEvent{
+		ID:        randomToken(8),
+		Event:     EventRetirementCompleted,
+		Timestamp: time.Now().UTC(),
+		Profile:   "Primary",
+		JobID:     "job1",
+		User:      "alice",
+		Summary:   "Retired 3 log sources on <Primary>",
+		Data:      map[string]interface{}{"logSources": 3, "changeTicket": "CHG-7"},
+	}
+	delivery := newWebhookDelivery(hook, event)
+	deliverWebhook(hook, event, delivery, attempts)
+	webhookMutex.RLock()
+	defer webhookMutex.RUnlock()
+	return *delivery
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusOK)
+	hook := rec.hook("signed", WebhookFormatJSON)
+	if err := StoreWebhookSecret(hook.ID, "s3cret"); err != nil {
+		t.Fatalf("StoreWebhookSecret: %v", err)
+	}
+	t.Cleanup(func() { DeleteWebhookSecret(hook.ID) })
+
+	delivery := deliver(hook, maxWebhookAttempts)
+	if delivery.Status != "delivered" || delivery.Attempts != 1 || delivery.StatusCode != http.StatusOK {
+		t.Fatalf("delivery = %+v, want delivered on the first attempt", delivery)
+	}
+	if len(rec.requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(rec.requests))
+	}
+	got := rec.requests[0]
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(got.header.Get("X-LRCleaner-Timestamp") + "."))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.header.Get("X-LRCleaner-Signature") != want {
+		t.Errorf("X-LRCleaner-Signature = %q, want %q", got.header.Get("X-LRCleaner-Signature"), want)
+	}
+	if got.header.Get("X-LRCleaner-Event") != EventRetirementCompleted || got.header.Get("X-LRCleaner-Delivery") != delivery.ID {
+		t.Errorf("event headers = %q, %q", got.header.Get("X-LRCleaner-Event"), got.header.Get("X-LRCleaner-Delivery"))
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(got.body, &event); err != nil || event.ID != delivery.EventID || event.JobID != "job1" {
+		t.Errorf("body = %s (%v), want the event", got.body, err)
+	}
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusNoContent)
+	if delivery := deliver(rec.hook("unsigned", WebhookFormatJSON), maxWebhookAttempts); delivery.Status != "delivered" {
+		t.Fatalf("delivery = %+v, want delivered", delivery)
+	}
+	if signature := rec.requests[0].header.Get("X-LRCleaner-Signature"); signature != "" {
+		t.Errorf("X-LRCleaner-Signature = %q without a secret", signature)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	for _, code := range []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable,
+		http.StatusTooManyRequests, http.StatusRequestTimeout} {
+		rec := newWebhookReceiver(t, code)
+		delivery := deliver(rec.hook("retry", WebhookFormatJSON), maxWebhookAttempts)
+
+		if delivery.Status != "failed" || delivery.Attempts != maxWebhookAttempts || delivery.StatusCode != code {
+			t.Errorf("HTTP %d: delivery = %+v, want failed after %d attempts", code, delivery, maxWebhookAttempts)
+		}
+		if len(rec.requests) != maxWebhookAttempts {
+			t.Errorf("HTTP %d: receiver got %d requests, want %d", code, len(rec.requests), maxWebhookAttempts)
+		}
+		want := []time.Duration{webhookRetryDelay, 2 * webhookRetryDelay, 4 * webhookRetryDelay, 8 * webhookRetryDelay}
+		if len(rec.waits) != len(want) {
+			t.Fatalf("HTTP %d: waited %v, want %v", code, rec.waits, want)
+		}
+		for i := range want {
+			if rec.waits[i] != want[i] {
+				t.Errorf("HTTP %d: waited %v, want %v", code, rec.waits, want)
+				break
+			}
+		}
+		// Every retry carries the same delivery and event
+		for _, req := range rec.requests[1:] {
+			if req.header.Get("X-LRCleaner-Delivery") != delivery.ID || string(req.body) != string(rec.requests[0].body) {
+				t.Errorf("HTTP %d: retry changed the delivery or body", code)
+			}
+		}
+	}
+}
+
+func TestWebhookRetrySucceeds(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	delivery := deliver(rec.hook("recover", WebhookFormatJSON), maxWebhookAttempts)
+	if delivery.Status != "delivered" || delivery.Attempts != 3 || delivery.Error != "" {
+		t.Fatalf("delivery = %+v, want delivered on the third attempt", delivery)
+	}
+}
+
+func TestWebhookClientErrorsAreNotRetried(t *testing.T) {
+	for _, code := range []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusGone, http.StatusFound} {
+		rec := newWebhookReceiver(t, code)
+		delivery := deliver(rec.hook("refused", WebhookFormatJSON), maxWebhookAttempts)
+
+		if delivery.Status != "failed" || delivery.Attempts != 1 || delivery.StatusCode != code {
+			t.Errorf("HTTP %d: delivery = %+v, want failed after one attempt", code, delivery)
+		}
+		if len(rec.requests) != 1 || len(rec.waits) != 0 {
+			t.Errorf("HTTP %d: %d requests and waits %v, want one request and no retry", code, len(rec.requests), rec.waits)
+		}
+	}
+}
+
+func TestWebhookDeliveryLog(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusOK)
+	hook := rec.hook("logged", WebhookFormatJSON)
+	first := deliver(hook, maxWebhookAttempts)
+	rec.mu.Lock()
+	rec.codes = []int{http.StatusForbidden}
+	rec.mu.Unlock()
+	second := deliver(hook, maxWebhookAttempts)
+	deliver(rec.hook("other", WebhookFormatJSON), maxWebhookAttempts)
+
+	w := httptest.NewRecorder()
+	handleWebhookDeliveries(w, httptest.NewRequest("GET", "/api/webhooks/deliveries?webhook=logged", nil))
+	var deliveries []WebhookDelivery
+	if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil {
+		t.Fatalf("decode deliveries: %v", err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d deliveries for the webhook, want 2", len(deliveries))
+	}
+
+	// Newest first
+	if got := deliveries[0]; got.ID != second.ID || got.Status != "failed" || got.Attempts != 1 ||
+		got.StatusCode != http.StatusForbidden || got.Error != "receiver returned HTTP 403" || got.CompletedAt == nil {
+		t.Errorf("newest delivery = %+v", got)
+	}
+	if got := deliveries[1]; got.ID != first.ID || got.Status != "delivered" || got.Attempts != 2 ||
+		got.StatusCode != http.StatusOK || got.Error != "" || got.Event != EventRetirementCompleted || got.JobID != "job1" {
+		t.Errorf("oldest delivery = %+v", got)
+	}
+}
+
+func TestWebhookChatPayloads(t *testing.T) {
+	rec := newWebhookReceiver(t, http.StatusOK)
+
+	deliver(rec.hook("slack", WebhookFormatSlack), 1)
+	var slack map[string]interface{}
+	if err := json.Unmarshal(rec.requests[0].body, &slack); err != nil {
+		t.Fatalf("Slack body is not JSON: %v", err)
+	}
+	text, ok := slack["text"].(string)
+	if !ok || len(slack) != 1 {
+		t.Fatalf("Slack body = %v, want only a text field", slack)
+	}
+	for _, want := range []string{"*LRCleaner: Retirement completed*", "Retired 3 log sources on &lt;Primary&gt;",
+		"*Deployment:* Primary", "*Change ticket:* CHG-7", "*Log sources:* 3"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Slack text %q is missing %q", text, want)
+		}
+	}
+
+	deliver(rec.hook("teams", WebhookFormatTeams), 1)
+	var teams struct {
+		Type       string `json:"@type"`
+		Context    string `json:"@context"`
+		Summary    string `json:"summary"`
+		Title      string `json:"title"`
+		Text       string `json:"text"`
+		ThemeColor string `json:"themeColor"`
+		Sections   []struct {
+			Facts []struct{ Name, Value string } `json:"facts"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal(rec.requests[1].body, &teams); err != nil {
+		t.Fatalf("Teams body is not JSON: %v", err)
+	}
+	if teams.Type != "MessageCard" || teams.Context != "https://schema.org/extensions" ||
+		teams.Title != "LRCleaner: Retirement completed" || teams.Summary != teams.Title || teams.ThemeColor == "" {
+		t.Errorf("Teams card = %+v", teams)
+	}
+	if len(teams.Sections) != 1 {
+		t.Fatalf("Teams card has %d sections, want 1", len(teams.Sections))
+	}
+	facts := map[string]string{}
+	for _, fact := range teams.Sections[0].Facts {
+		facts[fact.Name] = fact.Value
+	}
+	if facts["Deployment"] != "Primary" || facts["Job"] != "job1" || facts["User"] != "alice" || facts["Log sources"] != "3" {
+		t.Errorf("Teams facts = %v", facts)
+	}
+
+	for _, req := range rec.requests {
+		if req.header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", req.header.Get("Content-Type"))
+		}
+	}
+}
+
+// TestWebhookUpdateKeepsSecretAndSettingsInStep checks that an edit whose signing secret
+// the credential store refuses leaves the saved webhook as it was
+func TestWebhookUpdateKeepsSecretAndSettingsInStep(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved; os.Remove(configPath) })
+	config = defaultConfig()
+	config.Webhooks = []Webhook{{ID: "edit", Name: "Before", URL: "https://hooks.example.com/a", Format: WebhookFormatJSON, Events: []string{}, Enabled: true}}
+	if err := saveConfigFile(); err != nil {
+		t.Fatalf("saveConfigFile: %v", err)
+	}
+	t.Cleanup(func() { DeleteWebhookSecret("edit") })
+
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("PUT", "/api/webhooks/edit", strings.NewReader(body)), map[string]string{"id": "edit"})
+		handleWebhook(w, r)
+		return w.Code
+	}
+
+	if code := put(`{"name": "After", "url": "https://hooks.example.com/b", "secret": "first", "enabled": true}`); code != http.StatusOK {
+		t.Fatalf("update: HTTP %d, want 200", code)
+	}
+	if secret, _ := GetWebhookSecret("edit"); secret != "first" || config.Webhooks[0].Name != "After" {
+		t.Fatalf("after update: name %q, secret %q", config.Webhooks[0].Name, secret)
+	}
+	onDisk, _ := os.ReadFile(configPath)
+
+	working := keyringConfig
+	keyringConfig.AllowedBackends = []keyring.BackendType{keyring.PassBackend} // Not available here
+	code := put(`{"name": "Broken", "url": "https://hooks.example.com/c", "secret": "second", "enabled": true}`)
+	keyringConfig = working
+	if code != http.StatusInternalServerError {
+		t.Fatalf("credential store failure: HTTP %d, want 500", code)
+	}
+	if config.Webhooks[0].Name != "After" || config.Webhooks[0].URL != "https://hooks.example.com/b" {
+		t.Errorf("running webhook changed although its secret was refused: %+v", config.Webhooks[0])
+	}
+	if now, _ := os.ReadFile(configPath); string(now) != string(onDisk) {
+		t.Errorf("config.json not restored after the secret was refused")
+	}
+	if secret, _ := GetWebhookSecret("edit"); secret != "first" {
+		t.Errorf("secret = %q, want the previous one", secret)
+	}
+}